@@ -0,0 +1,240 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// agentSocketEnv holds the socket of a running agent. When it is set,
+// commands use the agent's keys instead of the config's.
+const agentSocketEnv = "GO_SEND_AGENT_SOCK"
+
+// agentTimeout bounds a single request to the agent.
+const agentTimeout = 10 * time.Second
+
+// Operations understood by the agent, one per KeyStore method.
+const (
+	agentOpCheck      = "check"
+	agentOpSign       = "sign"
+	agentOpStreamKeys = "stream_keys"
+	agentOpUnwrapKey  = "unwrap_key"
+)
+
+// agentRequest is one request to the agent, sent as a line of JSON.
+type agentRequest struct {
+	Op        string `json:"op"`
+	Username  string `json:"username"`
+	Message   []byte `json:"message,omitempty"`
+	PublicKey []byte `json:"public_key,omitempty"`
+	Wrapped   []byte `json:"wrapped,omitempty"`
+}
+
+// agentResponse answers an agentRequest with either a result or an error.
+type agentResponse struct {
+	Result []byte `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+	agentCmd.Flags().String("socket", "", "Socket to listen on (default: agent.sock next to the config file)")
+}
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Keep private keys unlocked in memory for other commands",
+	Run: func(cmd *cobra.Command, args []string) {
+		socket, _ := cmd.Flags().GetString("socket")
+		if socket == "" {
+			socket = filepath.Join(filepath.Dir(cfgFile), "agent.sock")
+		}
+		if err := unlockKeys(); err != nil {
+			fmt.Println("Error unlocking private keys:", err)
+			return
+		}
+
+		// Replace a socket left behind by an agent that did not exit cleanly
+		if conn, err := net.Dial("unix", socket); err == nil {
+			_ = conn.Close()
+			fmt.Printf("An agent is already listening on %s\n", socket)
+			return
+		}
+		_ = os.Remove(socket)
+
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			fmt.Println("Error starting agent:", err)
+			return
+		}
+		if err := os.Chmod(socket, 0600); err != nil {
+			_ = l.Close()
+			fmt.Println("Error starting agent:", err)
+			return
+		}
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		go func() {
+			<-stop
+			_ = l.Close()
+		}()
+
+		fmt.Printf("%s=%s; export %s;\n", agentSocketEnv, socket, agentSocketEnv)
+		fmt.Println("Agent running. Press Ctrl+C to stop.")
+		if err := serveAgent(l, configKeyStore{cfg: cfg}); err != nil {
+			fmt.Println("Agent stopped:", err)
+		}
+	},
+}
+
+// serveAgent answers requests on l using keys until l is closed.
+func serveAgent(l net.Listener, keys KeyStore) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go handleAgentConn(conn, keys)
+	}
+}
+
+// handleAgentConn answers requests on conn until the client disconnects.
+func handleAgentConn(conn net.Conn, keys KeyStore) {
+	defer func() { _ = conn.Close() }()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var req agentRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		if err := enc.Encode(handleAgentRequest(keys, req)); err != nil {
+			return
+		}
+	}
+}
+
+// handleAgentRequest performs a single request with keys.
+func handleAgentRequest(keys KeyStore, req agentRequest) agentResponse {
+	var (
+		result []byte
+		err    error
+	)
+	switch req.Op {
+	case agentOpCheck:
+		err = keys.Check(req.Username)
+	case agentOpSign:
+		result, err = keys.Sign(req.Username, req.Message)
+	case agentOpStreamKeys, agentOpUnwrapKey:
+		if len(req.PublicKey) != 32 {
+			err = errors.New("invalid public key length")
+			break
+		}
+		var pub [32]byte
+		copy(pub[:], req.PublicKey)
+		var keyList []*[32]byte
+		if req.Op == agentOpStreamKeys {
+			keyList, err = keys.StreamKeys(req.Username, &pub)
+		} else {
+			var key *[32]byte
+			key, err = keys.UnwrapKey(req.Username, req.Wrapped, &pub)
+			keyList = []*[32]byte{key}
+		}
+		if err == nil {
+			// Several keys are sent one after another
+			for _, key := range keyList {
+				result = append(result, key[:]...)
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown operation %q", req.Op)
+	}
+	if err != nil {
+		return agentResponse{Error: err.Error()}
+	}
+	return agentResponse{Result: result}
+}
+
+// agentKeyStore forwards key operations to an agent listening on socket.
+type agentKeyStore struct {
+	socket string
+}
+
+// call sends req to the agent and returns its result.
+func (a agentKeyStore) call(req agentRequest) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", a.socket, agentTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach agent at %s: %w", a.socket, err)
+	}
+	defer func() { _ = conn.Close() }()
+	if err := conn.SetDeadline(time.Now().Add(agentTimeout)); err != nil {
+		return nil, err
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("agent request failed: %w", err)
+	}
+	var resp agentResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("agent request failed: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("agent: %s", resp.Error)
+	}
+	return resp.Result, nil
+}
+
+// callKeys sends req to the agent and returns its result as a list of keys.
+func (a agentKeyStore) callKeys(req agentRequest) ([]*[32]byte, error) {
+	result, err := a.call(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 || len(result)%32 != 0 {
+		return nil, errors.New("agent returned an invalid key")
+	}
+	keys := make([]*[32]byte, 0, len(result)/32)
+	for ; len(result) > 0; result = result[32:] {
+		var key [32]byte
+		copy(key[:], result)
+		keys = append(keys, &key)
+	}
+	return keys, nil
+}
+
+func (a agentKeyStore) Check(username string) error {
+	_, err := a.call(agentRequest{Op: agentOpCheck, Username: username})
+	return err
+}
+
+func (a agentKeyStore) Sign(username string, message []byte) ([]byte, error) {
+	return a.call(agentRequest{Op: agentOpSign, Username: username, Message: message})
+}
+
+func (a agentKeyStore) StreamKeys(username string, peerPub *[32]byte) ([]*[32]byte, error) {
+	return a.callKeys(agentRequest{Op: agentOpStreamKeys, Username: username, PublicKey: peerPub[:]})
+}
+
+func (a agentKeyStore) UnwrapKey(username string, wrapped []byte, ephemeralPub *[32]byte) (*[32]byte, error) {
+	keys, err := a.callKeys(agentRequest{Op: agentOpUnwrapKey, Username: username, PublicKey: ephemeralPub[:], Wrapped: wrapped})
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != 1 {
+		return nil, errors.New("agent returned an invalid key")
+	}
+	return keys[0], nil
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+)
+
+func TestAgentKeyStore(t *testing.T) {
+	idKeys, _ := crypto.GenerateIdentityKeyPair()
+	exKeys, _ := crypto.GenerateExchangeKeyPair()
+	keys := configKeyStore{cfg: &Config{
+		IdentityPrivateKeys: map[string][]byte{"alice": idKeys.Private},
+		ExchangePrivateKeys: map[string][]byte{"alice": exKeys.Private[:]},
+	}}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- serveAgent(l, keys) }()
+	agent := agentKeyStore{socket: socket}
+
+	if err := agent.Check("alice"); err != nil {
+		t.Errorf("Check failed: %v", err)
+	}
+	if err := agent.Check("bob"); err == nil {
+		t.Error("Expected an error for a user without keys")
+	}
+
+	sig, err := agent.Sign("alice", []byte("nonce"))
+	if err != nil || !crypto.Verify(idKeys.Public, []byte("nonce"), sig) {
+		t.Errorf("Agent signature does not verify (%v)", err)
+	}
+
+	contentKey, _ := crypto.GenerateSymmetricKey()
+	ephemeralPub, wrapped, err := crypto.WrapKey(contentKey, exKeys.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := agent.UnwrapKey("alice", wrapped, ephemeralPub)
+	if err != nil || !bytes.Equal(got[:], contentKey) {
+		t.Errorf("UnwrapKey = %x (%v), want %x", got, err, contentKey)
+	}
+	if _, err := agent.UnwrapKey("alice", wrapped[1:], ephemeralPub); err == nil {
+		t.Error("Expected an error for a corrupted wrapped key")
+	}
+
+	peer, _ := crypto.GenerateExchangeKeyPair()
+	streamKeys, err := agent.StreamKeys("alice", peer.Public)
+	if err != nil || len(streamKeys) != 1 || *streamKeys[0] != *crypto.StreamKey(exKeys.Public, peer.Private) {
+		t.Errorf("StreamKeys does not match the peer's (%v)", err)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("serveAgent returned %v after close", err)
+	}
+	if err := agent.Check("alice"); err == nil {
+		t.Error("Expected an error once the agent has stopped")
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/VinMeld/go-send/pkg/gosend"
+)
+
+// retryDelay is the pause before retrying a failed chunk upload, multiplied
+// by the attempt number.
+var retryDelay = time.Second
+
+// apiClient returns a client for the server as the current user. It keeps
+// session tokens and pending uploads in the global config, checks senders
+// against the address book, and prints progress messages.
+func apiClient() *gosend.Client {
+	c := gosend.New(cfg.ServerURL, cfg.CurrentUsername, currentKeyStore())
+	c.Device = deviceLabel()
+	c.Tokens = configTokens{}
+	c.Uploads = configUploads{}
+	c.VerifySender = verifySender
+	c.RetryDelay = retryDelay
+	c.Logf = func(format string, args ...any) {
+		fmt.Printf(format+"\n", args...)
+	}
+	return c
+}
+
+// configTokens keeps session tokens in the global config.
+type configTokens struct{}
+
+func (configTokens) LoadTokens(username string) (gosend.Tokens, bool) {
+	access, ok := cfg.SessionTokens[username]
+	return gosend.Tokens{Access: access, Refresh: cfg.RefreshTokens[username]}, ok
+}
+
+func (configTokens) SaveTokens(username string, tokens gosend.Tokens) error {
+	cfg.SessionTokens[username] = tokens.Access
+	if tokens.Refresh != "" {
+		cfg.RefreshTokens[username] = tokens.Refresh
+	} else {
+		delete(cfg.RefreshTokens, username)
+	}
+	return SaveConfigGlobal()
+}
+
+func (configTokens) DeleteTokens(username string) error {
+	forgetSession(username)
+	return SaveConfigGlobal()
+}
+
+// forgetSession drops the tokens of a user's session.
+func forgetSession(username string) {
+	delete(cfg.SessionTokens, username)
+	delete(cfg.RefreshTokens, username)
+}
+
+// configUploads keeps pending uploads in the global config.
+type configUploads struct{}
+
+func (configUploads) LoadUpload(key string) (gosend.PendingUpload, bool) {
+	upload, ok := cfg.PendingUploads[key]
+	return upload, ok
+}
+
+func (configUploads) SaveUpload(key string, upload gosend.PendingUpload) error {
+	if cfg.PendingUploads == nil {
+		cfg.PendingUploads = make(map[string]gosend.PendingUpload)
+	}
+	cfg.PendingUploads[key] = upload
+	return SaveConfigGlobal()
+}
+
+func (configUploads) DeleteUpload(key string) error {
+	delete(cfg.PendingUploads, key)
+	return SaveConfigGlobal()
+}
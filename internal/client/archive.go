@@ -0,0 +1,314 @@
+package client
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// archiveMIMEType is the MIME type of archives sent by send-file.
+const archiveMIMEType = "application/x-tar"
+
+// expandPaths resolves the paths given to send-file. Arguments containing
+// glob characters are expanded and must match something; others must exist.
+// The result is sorted and free of duplicates.
+func expandPaths(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "*?[") {
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", arg)
+			}
+			paths = append(paths, matches...)
+			continue
+		}
+		if _, err := os.Lstat(arg); err != nil {
+			return nil, err
+		}
+		paths = append(paths, arg)
+	}
+	slices.Sort(paths)
+	return slices.Compact(paths), nil
+}
+
+// archiveName returns the name an archive of paths is sent under: the
+// directory name for a single directory, or a generic name otherwise.
+func archiveName(paths []string) string {
+	if len(paths) == 1 {
+		if abs, err := filepath.Abs(paths[0]); err == nil {
+			if base := filepath.Base(abs); base != string(filepath.Separator) {
+				return base + ".tar"
+			}
+		}
+	}
+	return "files.tar"
+}
+
+// writeArchive writes paths to w as a tar stream. A single directory is
+// archived by its contents; otherwise each path is stored under its base
+// name. Permissions and modification times are kept, while ownership and
+// access times are dropped so that archiving the same files twice produces
+// the same bytes and an interrupted upload can be resumed.
+func writeArchive(w io.Writer, paths []string) error {
+	tw := tar.NewWriter(w)
+	seen := make(map[string]bool)
+	add := func(name, file string, info fs.FileInfo) error {
+		if seen[name] {
+			return fmt.Errorf("duplicate name %q in archive", name)
+		}
+		seen[name] = true
+		return writeArchiveEntry(tw, name, file, info)
+	}
+
+	for _, root := range paths {
+		rootInfo, err := os.Lstat(root)
+		if err != nil {
+			return err
+		}
+		prefix := filepath.Base(root)
+		if len(paths) == 1 && rootInfo.IsDir() {
+			prefix = ""
+		}
+		err = filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, file)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(filepath.Join(prefix, rel))
+			if name == "." {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return add(name, file, info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// writeArchiveEntry writes one file, directory or symlink to tw. Other file
+// types are skipped with a warning.
+func writeArchiveEntry(tw *tar.Writer, name, file string, info fs.FileInfo) error {
+	var link string
+	switch {
+	case info.Mode().IsRegular(), info.IsDir():
+	case info.Mode()&fs.ModeSymlink != 0:
+		var err error
+		if link, err = os.Readlink(file); err != nil {
+			return err
+		}
+	default:
+		fmt.Printf("Warning: Skipping %s: unsupported file type\n", file)
+		return nil
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	hdr.Format = tar.FormatPAX
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := io.CopyN(tw, f, hdr.Size); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
+
+// archiveEntryPath validates the name of an archive entry and returns it as
+// a clean relative slash path. Absolute names and names leaving the root
+// are rejected.
+func archiveEntryPath(name string) (string, error) {
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("archive entry %q contains control characters", name)
+	}
+	slashed := strings.ReplaceAll(name, `\`, "/")
+	if path.IsAbs(slashed) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("archive entry %q has an absolute path", name)
+	}
+	clean := path.Clean(slashed)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("archive entry %q leaves the extraction directory", name)
+	}
+	return clean, nil
+}
+
+// checkNoSymlinkParents ensures that no directory between root and the entry
+// at name is a symlink, so an earlier symlink entry cannot redirect a later
+// entry outside root.
+func checkNoSymlinkParents(root, name string) error {
+	dir := root
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("archive entry %q is inside a symlink", name)
+		}
+	}
+	return nil
+}
+
+// checkSymlinkTarget ensures that the symlink entry at name points inside
+// the extraction root. A ".." is only allowed at the start of the target,
+// where it climbs the real directories above the link: after any other
+// component it could undo a symlink, such as "l2/.." with l2 pointing to
+// ".", and lead anywhere.
+func checkSymlinkTarget(name, linkname string) error {
+	slashed := strings.ReplaceAll(linkname, `\`, "/")
+	if path.IsAbs(slashed) || filepath.IsAbs(linkname) || filepath.VolumeName(linkname) != "" {
+		return fmt.Errorf("symlink %q points to an absolute path", name)
+	}
+	descended := false
+	for _, part := range strings.Split(slashed, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if descended {
+				return fmt.Errorf("symlink %q climbs out of a path it entered", name)
+			}
+		default:
+			descended = true
+		}
+	}
+	resolved := path.Clean(path.Join(path.Dir(name), slashed))
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return fmt.Errorf("symlink %q points outside the extraction directory", name)
+	}
+	return nil
+}
+
+// extractArchive unpacks the tar stream r into the existing directory root.
+// Entries may not use absolute paths, leave root, or be written through a
+// symlink, and symlinks may only point inside root. Existing files are never
+// overwritten. Only regular files, directories and symlinks are supported.
+func extractArchive(r io.Reader, root string) error {
+	tr := tar.NewReader(r)
+	var dirs []*tar.Header
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name, err := archiveEntryPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			continue
+		}
+		if err := checkNoSymlinkParents(root, name); err != nil {
+			return err
+		}
+		target := filepath.Join(root, filepath.FromSlash(name))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+			if info, err := os.Lstat(target); err != nil || !info.IsDir() {
+				return fmt.Errorf("archive entry %q is not a directory", hdr.Name)
+			}
+			dirs = append(dirs, hdr)
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			if err := extractFile(tr, target, hdr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := checkSymlinkTarget(name, hdr.Linkname); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("archive entry %q has unsupported type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+
+	// Directories get their permissions and times last, deepest first, as
+	// adding entries changes a directory's modification time
+	for i := len(dirs) - 1; i >= 0; i-- {
+		name, _ := archiveEntryPath(dirs[i].Name)
+		target := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.Chmod(target, dirs[i].FileInfo().Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(target, dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFile writes the current entry of tr to a new file at target.
+func extractFile(tr *tar.Reader, target string, hdr *tar.Header) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, tr); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Chmod(hdr.FileInfo().Mode().Perm()); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
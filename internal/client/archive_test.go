@@ -0,0 +1,201 @@
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "photos")
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	files := map[string]string{
+		"a.txt":       "alpha",
+		"sub/b.txt":   "beta",
+		"sub/c/d.bin": "delta",
+	}
+	for name, content := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(src, "a.txt"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/b.txt", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	var first, second bytes.Buffer
+	if err := writeArchive(&first, []string{src}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeArchive(&second, []string{src}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("Archiving the same files twice should give the same bytes")
+	}
+	if got := archiveName([]string{src}); got != "photos.tar" {
+		t.Errorf("archiveName = %q, want photos.tar", got)
+	}
+
+	dest := t.TempDir()
+	if err := extractArchive(&first, dest); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dest, filepath.FromSlash(name))
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != content {
+			t.Errorf("%s = %q (%v), want %q", name, got, err, content)
+		}
+		if info, err := os.Stat(path); err != nil || !info.ModTime().Equal(mtime) {
+			t.Errorf("%s lost its modification time", name)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dest, "a.txt")); err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf("a.txt lost its permissions: %v", info.Mode())
+	}
+	if link, err := os.Readlink(filepath.Join(dest, "link")); err != nil || link != "sub/b.txt" {
+		t.Errorf("link = %q (%v), want sub/b.txt", link, err)
+	}
+}
+
+func TestArchiveSeveralPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"one.txt", "two.txt", "three.md"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths, err := expandPaths([]string{filepath.Join(dir, "*.txt"), filepath.Join(dir, "one.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("expandPaths = %v, want one.txt and two.txt", paths)
+	}
+	if _, err := expandPaths([]string{filepath.Join(dir, "*.png")}); err == nil {
+		t.Error("A pattern matching nothing should be an error")
+	}
+	if got := archiveName(paths); got != "files.tar" {
+		t.Errorf("archiveName = %q, want files.tar", got)
+	}
+
+	var buf bytes.Buffer
+	if err := writeArchive(&buf, paths); err != nil {
+		t.Fatal(err)
+	}
+	dest := t.TempDir()
+	if err := extractArchive(&buf, dest); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"one.txt", "two.txt"} {
+		if got, err := os.ReadFile(filepath.Join(dest, name)); err != nil || string(got) != name {
+			t.Errorf("%s = %q (%v)", name, got, err)
+		}
+	}
+
+	// Two paths with the same base name cannot share an archive
+	other := filepath.Join(t.TempDir(), "one.txt")
+	if err := os.WriteFile(other, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeArchive(&bytes.Buffer{}, []string{paths[0], other}); err == nil {
+		t.Error("Duplicate names should be an error")
+	}
+}
+
+func TestExtractArchiveRejectsUnsafeEntries(t *testing.T) {
+	type entry struct {
+		name, link string
+		typ        byte
+	}
+	file := func(name string) entry { return entry{name: name, typ: tar.TypeReg} }
+	symlink := func(name, link string) entry { return entry{name: name, link: link, typ: tar.TypeSymlink} }
+
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{"parent directory", []entry{file("../evil.txt")}},
+		{"nested parent directory", []entry{file("a/../../evil.txt")}},
+		{"absolute path", []entry{file("/tmp/evil.txt")}},
+		{"backslash parent directory", []entry{file(`..\evil.txt`)}},
+		{"absolute symlink", []entry{symlink("link", "/etc/passwd")}},
+		{"escaping symlink", []entry{symlink("a/link", "../../outside")}},
+		{"chained symlinks", []entry{symlink("l2", "."), symlink("l1", "l2/..")}},
+		{"chained symlinks in reverse", []entry{symlink("l1", "l2/.."), symlink("l2", ".")}},
+		{"write through symlink", []entry{symlink("link", "."), file("link/evil.txt")}},
+		{"overwrite symlink", []entry{symlink("link", "target"), file("link")}},
+		{"hard link", []entry{{name: "hard", link: "target", typ: tar.TypeLink}}},
+		{"device", []entry{{name: "dev", typ: tar.TypeChar}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for _, e := range tt.entries {
+				hdr := &tar.Header{Name: e.name, Linkname: e.link, Typeflag: e.typ, Mode: 0644}
+				if err := tw.WriteHeader(hdr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			parent := t.TempDir()
+			dest := filepath.Join(parent, "dest")
+			if err := os.Mkdir(dest, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := extractArchive(&buf, dest); err == nil {
+				t.Fatal("Expected unsafe archive to be rejected")
+			}
+			entries, _ := os.ReadDir(parent)
+			for _, e := range entries {
+				if e.Name() != "dest" {
+					t.Errorf("Unexpected file %s outside the extraction directory", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestExtractArchiveKeepsRelativeSymlinks(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "a/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "b/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "a/up", Linkname: "../b", Typeflag: tar.TypeSymlink},
+		{Name: "a/here", Linkname: "./x/y", Typeflag: tar.TypeSymlink},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	if err := extractArchive(&buf, dest); err != nil {
+		t.Fatalf("Expected links inside the archive to be extracted, got %v", err)
+	}
+	if link, err := os.Readlink(filepath.Join(dest, "a", "up")); err != nil || link != "../b" {
+		t.Errorf("Expected the link to be kept, got %q (%v)", link, err)
+	}
+}
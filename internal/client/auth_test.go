@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,14 +17,15 @@ import (
 func TestLogin(t *testing.T) {
 	// Setup Mock Server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/auth/challenge" {
+		if r.URL.Path == "/v1/auth/challenge" {
 			_ = json.NewEncoder(w).Encode(models.AuthChallenge{
 				Username: "alice",
 				Nonce:    "test-nonce",
+				Origin:   "http://" + r.Host,
 			})
 			return
 		}
-		if r.URL.Path == "/auth/login" {
+		if r.URL.Path == "/v1/auth/login" {
 			var resp models.AuthResponse
 			_ = json.NewDecoder(r.Body).Decode(&resp)
 			if resp.Nonce != "test-nonce" {
@@ -31,9 +33,10 @@ func TestLogin(t *testing.T) {
 				return
 			}
 			_ = json.NewEncoder(w).Encode(models.Session{
-				Token:     "session-token",
-				Username:  "alice",
-				ExpiresAt: time.Now().Add(1 * time.Hour),
+				Token:        "session-token",
+				RefreshToken: "refresh-token",
+				Username:     "alice",
+				ExpiresAt:    time.Now().Add(1 * time.Hour),
 			})
 			return
 		}
@@ -53,6 +56,7 @@ func TestLogin(t *testing.T) {
 		Users:               make(map[string]models.User),
 		IdentityPrivateKeys: make(map[string][]byte),
 		SessionTokens:       make(map[string]string),
+		RefreshTokens:       make(map[string]string),
 		ServerURL:           server.URL,
 	}
 	cfgFile = configPath
@@ -68,16 +72,43 @@ func TestLogin(t *testing.T) {
 		t.Fatalf("Login failed: %v", err)
 	}
 
-	if cfg.SessionTokens["alice"] != "session-token" {
-		t.Errorf("Expected session token 'session-token', got %s", cfg.SessionTokens["alice"])
+	if cfg.SessionTokens["alice"] != "session-token" || cfg.RefreshTokens["alice"] != "refresh-token" {
+		t.Errorf("Expected the session's tokens to be stored, got %s and %s", cfg.SessionTokens["alice"], cfg.RefreshTokens["alice"])
 	}
 
-	// Test GetAuthHeader
-	header, err := GetAuthHeader()
-	if err != nil {
-		t.Fatalf("GetAuthHeader failed: %v", err)
+}
+
+func TestLoginChecksChallenge(t *testing.T) {
+	origin := "https://send.example.com"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth/challenge" {
+			_ = json.NewEncoder(w).Encode(models.AuthChallenge{Username: "alice", Nonce: "test-nonce", Origin: origin})
+			return
+		}
+		t.Errorf("Unexpected request to %s", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	idKey, _ := crypto.GenerateIdentityKeyPair()
+	cfg = &Config{
+		Users:               map[string]models.User{"alice": {Username: "alice", IdentityPublicKey: idKey.Public}},
+		IdentityPrivateKeys: map[string][]byte{"alice": idKey.Private},
+		SessionTokens:       make(map[string]string),
+		RefreshTokens:       make(map[string]string),
+		ServerURL:           server.URL,
+		CurrentUsername:     "alice",
+	}
+	cfgFile = filepath.Join(t.TempDir(), "config.json")
+
+	// A challenge relayed from another server must not be signed
+	if err := Login(); err == nil || !strings.Contains(err.Error(), "not "+server.URL) {
+		t.Errorf("Expected a challenge for another origin to be refused, got %v", err)
 	}
-	if header != "Bearer session-token" {
-		t.Errorf("Expected Bearer token, got %s", header)
+
+	cfg.CurrentUsername = "bob"
+	origin = server.URL
+	if err := Login(); err == nil || !strings.Contains(err.Error(), `user "alice"`) {
+		t.Errorf("Expected a challenge for another user to be refused, got %v", err)
 	}
 }
@@ -13,6 +13,7 @@ import (
 
 	"github.com/VinMeld/go-send/internal/crypto"
 	"github.com/VinMeld/go-send/internal/models"
+	"github.com/VinMeld/go-send/internal/transport"
 )
 
 // Helper to setup test config
@@ -25,7 +26,7 @@ func setupTestConfig(t *testing.T) (string, *httptest.Server) {
 	// Mock Server
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
-		case "/users":
+		case "/v1/users":
 			if r.Method == "POST" {
 				w.WriteHeader(http.StatusCreated)
 			} else if r.Method == "GET" {
@@ -38,11 +39,11 @@ func setupTestConfig(t *testing.T) (string, *httptest.Server) {
 				users := []models.User{user}
 				_ = json.NewEncoder(w).Encode(users)
 			}
-		case "/auth/challenge":
-			_ = json.NewEncoder(w).Encode(models.AuthChallenge{Nonce: "nonce"})
-		case "/auth/login":
+		case "/v1/auth/challenge":
+			_ = json.NewEncoder(w).Encode(models.AuthChallenge{Username: r.URL.Query().Get("username"), Nonce: "nonce", Origin: "http://" + r.Host})
+		case "/v1/auth/login":
 			_ = json.NewEncoder(w).Encode(models.Session{Token: "token"})
-		case "/files":
+		case "/v1/files":
 			if r.Method == "POST" {
 				w.WriteHeader(http.StatusCreated)
 			} else if r.Method == "GET" {
@@ -52,7 +53,29 @@ func setupTestConfig(t *testing.T) (string, *httptest.Server) {
 				}
 				_ = json.NewEncoder(w).Encode(files)
 			}
-		case "/files/download":
+		case "/v1/files/stream":
+			if r.Method == "POST" {
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+			// Return dummy file
+			meta := models.FileMetadata{ID: "file1", FileName: "test.txt", EncryptedKey: make([]byte, 32)}
+			header, _ := transport.EncodeMetadata(meta)
+			w.Header().Set(transport.MetadataHeader, header)
+			_, _ = w.Write([]byte("encrypted"))
+		case "/v1/uploads":
+			if r.Method == "POST" {
+				w.WriteHeader(http.StatusCreated)
+				_ = json.NewEncoder(w).Encode(models.UploadSession{ID: "upload1", ChunkSize: 1024})
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		case "/v1/uploads/chunk":
+			_ = json.NewEncoder(w).Encode(models.UploadChunk{})
+		case "/v1/uploads/complete":
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode([]models.FileMetadata{{ID: "file1"}})
+		case "/v1/files/download":
 			// Return dummy file
 			meta := models.FileMetadata{ID: "file1", FileName: "test.txt", EncryptedKey: make([]byte, 32)}
 			resp := models.UploadRequest{Metadata: meta, EncryptedContent: []byte("encrypted")}
@@ -239,3 +262,21 @@ func TestCryptoHelpers(t *testing.T) {
 		t.Error("Verify failed")
 	}
 }
+
+func TestFormatTTL(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{-time.Minute, "<1m"},
+		{20 * time.Second, "<1m"},
+		{45 * time.Minute, "45m"},
+		{3*time.Hour + 5*time.Minute, "3h5m"},
+		{50 * time.Hour, "2d2h"},
+	}
+	for _, tt := range tests {
+		if got := formatTTL(tt.d); got != tt.want {
+			t.Errorf("formatTTL(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
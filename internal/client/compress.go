@@ -0,0 +1,150 @@
+package client
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+)
+
+// Compression modes accepted by send-file --compress.
+const (
+	compressNone = "none"
+	compressAuto = "auto" // gzip, unless the file is already compressed
+	compressGzip = "gzip"
+)
+
+// compressedExtensions are formats that are already compressed, which
+// compressing again would only slow down.
+var compressedExtensions = map[string]bool{
+	".gz": true, ".tgz": true, ".zip": true, ".zst": true, ".xz": true,
+	".bz2": true, ".7z": true, ".rar": true, ".lz4": true, ".br": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".heic": true, ".avif": true, ".mp3": true, ".aac": true, ".ogg": true,
+	".opus": true, ".flac": true, ".mp4": true, ".mkv": true, ".mov": true,
+	".webm": true, ".avi": true, ".pdf": true, ".docx": true, ".xlsx": true,
+	".pptx": true, ".odt": true, ".jar": true, ".apk": true,
+}
+
+// alreadyCompressed guesses from its name and MIME type whether a file is
+// in a compressed format.
+func alreadyCompressed(name, mimeType string) bool {
+	if compressedExtensions[strings.ToLower(filepath.Ext(name))] {
+		return true
+	}
+	mediaType, _, _ := strings.Cut(mimeType, ";")
+	switch mediaType {
+	case "image/svg+xml", "image/bmp", "audio/wav", "audio/x-wav":
+		return false
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd":
+		return true
+	}
+	for _, prefix := range []string{"image/", "audio/", "video/"} {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressSource compresses file for sending according to mode and records
+// the algorithm in info. The file to upload is returned, which is file
+// itself if compression is off, skipped for an already compressed format,
+// or would not make the file smaller. cleanup removes any temporary file.
+func compressSource(file *os.File, info *crypto.FileInfo, mode string) (*os.File, func(), error) {
+	noop := func() {}
+	switch mode {
+	case compressNone:
+		return file, noop, nil
+	case compressAuto:
+		if alreadyCompressed(info.Name, info.MIMEType) {
+			return file, noop, nil
+		}
+	case compressGzip:
+	default:
+		return nil, nil, fmt.Errorf("unknown compression %q (use %s, %s or %s)", mode, compressNone, compressAuto, compressGzip)
+	}
+
+	tmp, err := os.CreateTemp("", "go-send-*.gz")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}
+	// The gzip header is left empty so that compressing the same file again
+	// gives the same bytes and an interrupted upload can be resumed
+	bw := bufio.NewWriter(tmp)
+	zw := gzip.NewWriter(bw)
+	_, err = io.Copy(zw, file)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	var size int64
+	if err == nil {
+		size, err = tmp.Seek(0, io.SeekCurrent)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to compress file: %w", err)
+	}
+	if size >= info.Size {
+		cleanup()
+		return file, noop, nil
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	fmt.Printf("Compressed %d bytes to %d.\n", info.Size, size)
+	info.Compression = compressGzip
+	return tmp, cleanup, nil
+}
+
+// decompressFile replaces the compressed file at path with its contents.
+// Output beyond size, the uncompressed size recorded by the sender, is
+// refused, so a small file cannot expand without bound.
+func decompressFile(path, algorithm string, size int64) error {
+	if algorithm != compressGzip {
+		return fmt.Errorf("unsupported compression %q", algorithm)
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+	zr, err := gzip.NewReader(bufio.NewReader(src))
+	if err != nil {
+		return fmt.Errorf("failed to decompress file: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(dst, io.LimitReader(zr, size+1))
+	if err == nil && n != size {
+		err = fmt.Errorf("decompressed to %d bytes, expected %d", n, size)
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to decompress file: %w", err)
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+)
+
+func TestAlreadyCompressed(t *testing.T) {
+	tests := []struct {
+		name, mimeType string
+		want           bool
+	}{
+		{"app.log", "text/plain; charset=utf-8", false},
+		{"dump.json", "application/json", false},
+		{"logs.tar", archiveMIMEType, false},
+		{"logs.tar.gz", "application/gzip", true},
+		{"photo.JPG", "", true},
+		{"clip", "video/mp4", true},
+		{"drawing.svg", "image/svg+xml", false},
+	}
+	for _, tt := range tests {
+		if got := alreadyCompressed(tt.name, tt.mimeType); got != tt.want {
+			t.Errorf("alreadyCompressed(%q, %q) = %v, want %v", tt.name, tt.mimeType, got, tt.want)
+		}
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte(strings.Repeat(`{"level":"info","msg":"request served"}`+"\n", 1000))
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	compress := func(mode string) ([]byte, crypto.FileInfo) {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = file.Close() }()
+		info := crypto.FileInfo{Name: "app.log", Size: int64(len(content)), MIMEType: "text/plain"}
+		out, cleanup, err := compressSource(file, &info, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanup()
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(out); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes(), info
+	}
+
+	if data, info := compress(compressNone); info.Compression != "" || !bytes.Equal(data, content) {
+		t.Error("Compression none should send the file unchanged")
+	}
+	data, info := compress(compressAuto)
+	if info.Compression != compressGzip || len(data) >= len(content) {
+		t.Fatalf("Expected gzip compression, got %q with %d bytes", info.Compression, len(data))
+	}
+	if again, _ := compress(compressAuto); !bytes.Equal(again, data) {
+		t.Error("Compressing the same file twice should give the same bytes")
+	}
+
+	compressed := filepath.Join(dir, "received")
+	if err := os.WriteFile(compressed, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := decompressFile(compressed, info.Compression, info.Size); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(compressed); err != nil || !bytes.Equal(got, content) {
+		t.Error("Decompressed content mismatch")
+	}
+
+	// Output larger than the size recorded by the sender is refused
+	if err := os.WriteFile(compressed, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := decompressFile(compressed, info.Compression, info.Size/2); err == nil {
+		t.Error("Expected oversized output to be refused")
+	}
+	if err := decompressFile(compressed, "lzma", info.Size); err == nil {
+		t.Error("Expected unknown algorithm to be refused")
+	}
+
+	if _, _, err := compressSource(nil, &info, "zip"); err == nil {
+		t.Error("Expected unknown mode to be refused")
+	}
+}
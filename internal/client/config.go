@@ -2,21 +2,45 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
 
+	"github.com/VinMeld/go-send/internal/crypto"
 	"github.com/VinMeld/go-send/internal/models"
 	"github.com/VinMeld/go-send/internal/transport"
+	"github.com/VinMeld/go-send/pkg/gosend"
 )
 
 type Config struct {
-	CurrentUsername     string                 `json:"current_username"`
-	Users               map[string]models.User `json:"users"`                 // Known users (address book)
-	IdentityPrivateKeys map[string][]byte      `json:"identity_private_keys"` // Map username -> Ed25519 private key
-	ExchangePrivateKeys map[string][]byte      `json:"exchange_private_keys"` // Map username -> X25519 private key
-	SessionTokens       map[string]string      `json:"session_tokens"`        // Map username -> session token
-	ServerURL           string                 `json:"server_url"`
-	LastListedFiles     []string               `json:"last_listed_files,omitempty"` // Cache for index-based access
+	CurrentUsername     string                          `json:"current_username"`
+	Users               map[string]models.User          `json:"users"`                           // Known users (address book), pinned on first use
+	VerifiedUsers       map[string]string               `json:"verified_users,omitempty"`        // Map username -> fingerprint verified with verify-user
+	StrictVerification  bool                            `json:"strict_verification,omitempty"`   // Only send to verified users
+	KeyLogs             map[string]KeyLogState          `json:"key_logs,omitempty"`              // Map server URL -> key log key and newest tree head seen
+	IdentityPrivateKeys map[string][]byte               `json:"identity_private_keys,omitempty"` // Map username -> Ed25519 private key
+	ExchangePrivateKeys map[string][]byte               `json:"exchange_private_keys,omitempty"` // Map username -> X25519 private key
+	RetiredExchangeKeys map[string][][]byte             `json:"retired_exchange_keys,omitempty"` // X25519 private keys replaced by rotate-keys, oldest first
+	PendingKeys         map[string]PendingKeys          `json:"pending_keys,omitempty"`          // Keys from a rotate-keys the server may not have accepted yet
+	Keystore            *crypto.Keystore                `json:"keystore,omitempty"`              // Private keys sealed under a passphrase
+	SessionTokens       map[string]string               `json:"session_tokens"`                  // Map username -> access token
+	RefreshTokens       map[string]string               `json:"refresh_tokens,omitempty"`        // Map username -> refresh token
+	ServerURL           string                          `json:"server_url"`
+	DeviceLabel         string                          `json:"device_label,omitempty"`      // Names this client's sessions; defaults to the host name
+	LastListedFiles     []string                        `json:"last_listed_files,omitempty"` // Cache for index-based access
+	PendingUploads      map[string]gosend.PendingUpload `json:"pending_uploads,omitempty"`   // Unfinished uploads, for resuming
+
+	keystoreKey *[32]byte // Set once the keystore is unlocked
+}
+
+// PendingKeys are the keys generated by rotate-keys. They are saved before
+// the server is told about them and kept until the rotation is known to
+// have been accepted, so that a lost response cannot lose the keys.
+type PendingKeys struct {
+	IdentityPublicKey  []byte `json:"identity_public_key"`
+	IdentityPrivateKey []byte `json:"identity_private_key"`
+	ExchangePublicKey  []byte `json:"exchange_public_key"`
+	ExchangePrivateKey []byte `json:"exchange_private_key"`
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -25,9 +49,15 @@ func LoadConfig(path string) (*Config, error) {
 		if os.IsNotExist(err) {
 			return &Config{
 				Users:               make(map[string]models.User),
+				VerifiedUsers:       make(map[string]string),
+				KeyLogs:             make(map[string]KeyLogState),
 				IdentityPrivateKeys: make(map[string][]byte),
 				ExchangePrivateKeys: make(map[string][]byte),
+				RetiredExchangeKeys: make(map[string][][]byte),
+				PendingKeys:         make(map[string]PendingKeys),
 				SessionTokens:       make(map[string]string),
+				RefreshTokens:       make(map[string]string),
+				PendingUploads:      make(map[string]gosend.PendingUpload),
 				ServerURL:           transport.DefaultServerURL,
 			}, nil
 		}
@@ -41,25 +71,56 @@ func LoadConfig(path string) (*Config, error) {
 	if cfg.Users == nil {
 		cfg.Users = make(map[string]models.User)
 	}
+	if cfg.VerifiedUsers == nil {
+		cfg.VerifiedUsers = make(map[string]string)
+	}
+	if cfg.KeyLogs == nil {
+		cfg.KeyLogs = make(map[string]KeyLogState)
+	}
 	if cfg.IdentityPrivateKeys == nil {
 		cfg.IdentityPrivateKeys = make(map[string][]byte)
 	}
 	if cfg.ExchangePrivateKeys == nil {
 		cfg.ExchangePrivateKeys = make(map[string][]byte)
 	}
+	if cfg.RetiredExchangeKeys == nil {
+		cfg.RetiredExchangeKeys = make(map[string][][]byte)
+	}
+	if cfg.PendingKeys == nil {
+		cfg.PendingKeys = make(map[string]PendingKeys)
+	}
 	if cfg.SessionTokens == nil {
 		cfg.SessionTokens = make(map[string]string)
 	}
+	if cfg.RefreshTokens == nil {
+		cfg.RefreshTokens = make(map[string]string)
+	}
+	if cfg.PendingUploads == nil {
+		cfg.PendingUploads = make(map[string]gosend.PendingUpload)
+	}
 	return &cfg, nil
 }
 
+// SaveConfig writes cfg to path. When cfg has a keystore, private keys are
+// only written sealed inside it, and are resealed first if it is unlocked.
 func SaveConfig(path string, cfg *Config) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
 	}
 
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	out := *cfg
+	if cfg.Keystore != nil {
+		if cfg.keystoreKey != nil {
+			if err := cfg.sealKeys(); err != nil {
+				return err
+			}
+		} else if cfg.hasPrivateKeys() {
+			return errors.New("cannot save private keys while the keystore is locked")
+		}
+		out.IdentityPrivateKeys, out.ExchangePrivateKeys, out.RetiredExchangeKeys, out.PendingKeys = nil, nil, nil, nil
+	}
+	data, err := json.MarshalIndent(&out, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -2,7 +2,6 @@ package client
 
 import (
 	"fmt"
-	"net/http"
 
 	"github.com/spf13/cobra"
 )
@@ -23,31 +22,10 @@ var deleteFileCmd = &cobra.Command{
 			return
 		}
 
-		authHeader, err := GetAuthHeader()
-		if err != nil {
-			fmt.Println("Authentication error:", err)
-			return
-		}
-
-		req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/files?id=%s", cfg.ServerURL, fileID), nil)
-		if err != nil {
-			fmt.Println("Error creating request:", err)
-			return
-		}
-		req.Header.Set("Authorization", authHeader)
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
+		if err := apiClient().Delete(cmd.Context(), fileID); err != nil {
 			fmt.Println("Error deleting file:", err)
 			return
 		}
-		defer func() { _ = resp.Body.Close() }()
-
-		if resp.StatusCode != http.StatusOK {
-			fmt.Println("Server returned error:", resp.Status)
-			return
-		}
 
 		fmt.Println("File deleted successfully!")
 	},
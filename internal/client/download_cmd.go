@@ -1,19 +1,25 @@
 package client
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 
-	"github.com/VinMeld/go-send/internal/crypto"
 	"github.com/VinMeld/go-send/internal/models"
+	"github.com/VinMeld/go-send/pkg/gosend"
 	"github.com/spf13/cobra"
 )
 
 func init() {
 	rootCmd.AddCommand(downloadFileCmd)
+	downloadFileCmd.Flags().StringP("output", "o", "", "Write the file to this path instead of the sender's file name")
+	downloadFileCmd.Flags().StringP("dir", "d", ".", "Directory to save the file in")
+	downloadFileCmd.Flags().BoolP("force", "f", false, "Overwrite an existing file instead of saving under a new name")
+	downloadFileCmd.Flags().BoolP("extract", "x", false, "Unpack an archive of several files into a new directory")
+	downloadFileCmd.Flags().Bool("allow-unverified", false, "Accept a file that is unsigned or whose sender's keys cannot be found")
 }
 
 var downloadFileCmd = &cobra.Command{
@@ -24,6 +30,17 @@ var downloadFileCmd = &cobra.Command{
 		input := args[0]
 		var fileID string
 
+		var out outputOptions
+		out.Path, _ = cmd.Flags().GetString("output")
+		out.Dir, _ = cmd.Flags().GetString("dir")
+		out.Force, _ = cmd.Flags().GetBool("force")
+		out.Extract, _ = cmd.Flags().GetBool("extract")
+		allow, _ := cmd.Flags().GetBool("allow-unverified")
+		if out.Path != "" && cmd.Flags().Changed("dir") {
+			fmt.Println("--output and --dir cannot be used together")
+			return
+		}
+
 		// Check if input is an index
 		if index, err := strconv.Atoi(input); err == nil {
 			if index > 0 && index <= len(cfg.LastListedFiles) {
@@ -41,71 +58,216 @@ var downloadFileCmd = &cobra.Command{
 			return
 		}
 
-		// Fetch File
-		authHeader, err := GetAuthHeader()
-		if err != nil {
-			fmt.Println("Authentication error:", err)
+		// Decrypt with the recipient private key
+		if err := currentKeyStore().Check(cfg.CurrentUsername); err != nil {
+			fmt.Println("Error:", err)
 			return
 		}
 
-		httpReq, err := http.NewRequest("GET", fmt.Sprintf("%s/files/download?id=%s", cfg.ServerURL, fileID), nil)
+		outputFile, err := downloadFile(cmd.Context(), fileID, out, allow)
 		if err != nil {
-			fmt.Println("Error creating request:", err)
+			fmt.Println("Error downloading file:", err)
+			if errors.Is(err, errUnverifiedSender) {
+				fmt.Println("Run the same command with --allow-unverified to accept it anyway.")
+			} else if errors.Is(err, gosend.ErrInterrupted) {
+				fmt.Println("Run the same command again to resume the download.")
+			}
 			return
 		}
-		httpReq.Header.Set("Authorization", authHeader)
 
-		client := &http.Client{}
-		resp, err := client.Do(httpReq)
-		if err != nil {
-			fmt.Println("Error fetching file:", err)
-			return
-		}
-		defer func() { _ = resp.Body.Close() }()
+		fmt.Printf("File downloaded and decrypted to %s\n", outputFile)
+	},
+}
 
-		if resp.StatusCode != http.StatusOK {
-			fmt.Println("Server returned error:", resp.Status)
-			return
-		}
+// partialPath returns the path of the partial file for a download into dir.
+// It holds plaintext that has already been authenticated. File IDs can come
+// from the server, so only UUIDs are accepted, to keep the path in dir.
+func partialPath(dir, fileID string) (string, error) {
+	if !validFileID(fileID) {
+		return "", fmt.Errorf("invalid file ID %q", fileID)
+	}
+	return filepath.Join(dir, "."+fileID+".part"), nil
+}
 
-		var req models.UploadRequest // Reusing this struct as response wrapper
-		if err := json.NewDecoder(resp.Body).Decode(&req); err != nil {
-			fmt.Println("Error decoding response:", err)
-			return
+// validFileID reports whether id is made of the characters of a UUID.
+func validFileID(id string) bool {
+	if id == "" || len(id) > 36 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F' || c == '-') {
+			return false
 		}
+	}
+	return true
+}
 
-		// Decrypt
-		// 1. Get Recipient Private Key
-		privKeyBytes, ok := cfg.ExchangePrivateKeys[cfg.CurrentUsername]
-		if !ok {
-			fmt.Println("Exchange private key not found for current user")
-			return
-		}
-		var recipientPriv [32]byte
-		copy(recipientPriv[:], privKeyBytes)
+// partFile is a partial file whose download progress is checkpointed in a
+// file next to it.
+type partFile struct {
+	*os.File
+	checkpoint string
+}
 
-		// 2. Get Sender Public Key (Ephemeral) from Metadata
-		var senderPub [32]byte
-		if len(req.Metadata.EncryptedKey) != 32 {
-			fmt.Println("Invalid ephemeral public key length in metadata")
-			return
+// openPart opens the partial file at path, creating it if needed.
+func openPart(path string) (*partFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &partFile{File: f, checkpoint: path + ".state"}, nil
+}
+
+func (p *partFile) LoadCheckpoint() ([]byte, error) {
+	data, err := os.ReadFile(p.checkpoint)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (p *partFile) SaveCheckpoint(data []byte) error {
+	return os.WriteFile(p.checkpoint, data, 0600)
+}
+
+// remove deletes the partial file and its checkpoint.
+func (p *partFile) remove() {
+	_ = os.Remove(p.Name())
+	_ = os.Remove(p.checkpoint)
+}
+
+// errUnverifiedSender is returned by verifySender for files that are
+// unsigned or whose sender's keys cannot be found. Unlike a signature that
+// does not verify, these are accepted with --allow-unverified.
+var errUnverifiedSender = errors.New("sender cannot be verified")
+
+// verifySender checks the sender's signature over the transfer manifest of
+// a downloaded file against the address book. Files without a signature and
+// senders whose key cannot be found are refused with errUnverifiedSender.
+func verifySender(ctx context.Context, meta models.FileMetadata, ciphertextHash []byte) error {
+	if len(meta.Signature) == 0 {
+		return fmt.Errorf("%w: %w: file is not signed, so it cannot be shown to come from '%s'", gosend.ErrBadSignature, errUnverifiedSender, meta.Sender)
+	}
+	sender, err := lookupUser(meta.Sender)
+	if err != nil {
+		return fmt.Errorf("%w: '%s': %v", errUnverifiedSender, meta.Sender, err)
+	}
+	if gosend.VerifyManifest(sender.IdentityPublicKey, meta, ciphertextHash) {
+		return nil
+	}
+	// The sender may have rotated their keys since we learned them. The key
+	// is chosen by when the server received the file, not when the sender
+	// claims to have signed it
+	key, err := rotatedIdentityKey(sender, meta.Timestamp)
+	if err != nil {
+		return fmt.Errorf("%w: cannot check key history of '%s': %v", errUnverifiedSender, meta.Sender, err)
+	}
+	if key == nil || !gosend.VerifyManifest(key, meta, ciphertextHash) {
+		return fmt.Errorf("%w: file claims to be from '%s'", gosend.ErrBadSignature, meta.Sender)
+	}
+	return nil
+}
+
+// allowUnverified wraps verify so that files it cannot verify, because they
+// are unsigned or the sender's keys cannot be found, are accepted with a
+// warning. Signatures that do not verify are still refused.
+func allowUnverified(verify func(context.Context, models.FileMetadata, []byte) error) func(context.Context, models.FileMetadata, []byte) error {
+	return func(ctx context.Context, meta models.FileMetadata, ciphertextHash []byte) error {
+		err := verify(ctx, meta, ciphertextHash)
+		if errors.Is(err, errUnverifiedSender) {
+			fmt.Printf("WARNING: %v\n", err)
+			return nil
 		}
-		copy(senderPub[:], req.Metadata.EncryptedKey)
+		return err
+	}
+}
 
-		// 3. Decrypt Content
-		decrypted, err := crypto.Decrypt(req.EncryptedContent, &senderPub, &recipientPriv)
-		if err != nil {
-			fmt.Println("Error decrypting file:", err)
-			return
+// downloadFile downloads and decrypts a file to the location chosen by out
+// and returns its path. Authenticated plaintext is written to a partial file
+// in the output directory as it arrives; if a partial file from an earlier
+// attempt exists, the download resumes at its last checkpoint. The
+// partial file is only renamed into place once the final chunk has been
+// authenticated and the sender's signature has been checked. The download is
+// then acknowledged if the file has a download limit. With allow set, files
+// that are unsigned or whose sender cannot be verified are accepted with a
+// warning.
+func downloadFile(ctx context.Context, fileID string, out outputOptions, allow bool) (string, error) {
+	client := apiClient()
+	if allow {
+		client.VerifySender = allowUnverified(client.VerifySender)
+	}
+	partPath, err := partialPath(out.dir(), fileID)
+	if err != nil {
+		return "", err
+	}
+	part, err := openPart(partPath)
+	if err != nil {
+		return "", err
+	}
+
+	d, err := client.ResumeDownload(ctx, fileID, part, func(d *gosend.Download) error {
+		// Refuse unusable names before downloading anything
+		if out.Path == "" {
+			_, err := sanitizeFileName(d.Info.Name)
+			return err
 		}
+		return nil
+	})
+	if err != nil {
+		stat, serr := part.Stat()
+		_ = part.Close()
+		// Nothing after a failed chunk can be trusted, and an empty
+		// partial file has nothing to resume
+		if errors.Is(err, gosend.ErrCorrupt) || errors.Is(err, gosend.ErrBadSignature) || (serr == nil && stat.Size() == 0) {
+			part.remove()
+		}
+		return "", err
+	}
+	if err := part.Chmod(0644); err != nil {
+		_ = part.Close()
+		return "", err
+	}
+	if err := part.Close(); err != nil {
+		return "", err
+	}
+	// The download is complete, so there is nothing left to resume
+	_ = os.Remove(part.checkpoint)
 
-		// Save to Disk
-		outputFile := req.Metadata.FileName
-		if err := os.WriteFile(outputFile, decrypted, 0644); err != nil {
-			fmt.Println("Error saving file:", err)
-			return
+	meta, info := d.Metadata, d.Info
+	if info.Compression != "" {
+		if err := decompressFile(partPath, info.Compression, info.Size); err != nil {
+			// The sender's content is unusable, so there is nothing to resume
+			_ = os.Remove(partPath)
+			return "", err
 		}
+	}
 
-		fmt.Printf("File downloaded and decrypted to %s\n", outputFile)
-	},
+	if info.Message != "" {
+		fmt.Printf("Message from %s: %s\n", meta.Sender, info.Message)
+	}
+	var outputFile string
+	if info.Archive && out.Extract {
+		outputFile, err = out.extract(partPath, info.Name)
+	} else {
+		outputFile, err = out.place(partPath, info.Name)
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.Archive && !out.Extract {
+		fmt.Println("This file is an archive of several files. Download it with --extract to unpack it.")
+	}
+
+	// Only the recipient's downloads count against a download limit, and
+	// only once the file is safely in place
+	if meta.DownloadsRemaining > 0 && meta.Recipient == cfg.CurrentUsername {
+		if err := client.Acknowledge(ctx, fileID); err != nil {
+			fmt.Printf("Warning: Failed to acknowledge download: %v\n", err)
+		} else if meta.DownloadsRemaining == 1 {
+			fmt.Println("File deleted from server after its last download.")
+		} else {
+			fmt.Printf("%d download(s) left on server.\n", meta.DownloadsRemaining-1)
+		}
+	}
+	return outputFile, nil
 }
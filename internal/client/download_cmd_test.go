@@ -0,0 +1,21 @@
+package client
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPartialPath(t *testing.T) {
+	dir := t.TempDir()
+	got, err := partialPath(dir, "0c3bdf83-05c0-4b4b-91c2-90f696b8e023")
+	if err != nil || got != filepath.Join(dir, ".0c3bdf83-05c0-4b4b-91c2-90f696b8e023.part") {
+		t.Errorf("Unexpected partial path %q (%v)", got, err)
+	}
+
+	// IDs chosen by a malicious server must not leave the directory
+	for _, id := range []string{"", "../../.bashrc", "/etc/passwd", "a/b", "..", "0c3bdf83\x00", "0c3bdf83-05c0-4b4b-91c2-90f696b8e023-0c3bdf83"} {
+		if got, err := partialPath(dir, id); err == nil {
+			t.Errorf("Expected ID %q to be refused, got %q", id, got)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/models"
+	"github.com/VinMeld/go-send/pkg/gosend"
+)
+
+// KeyLogState is what the client remembers about a server's key
+// transparency log: the key that signs its tree heads, pinned on first use,
+// and the newest tree head seen.
+type KeyLogState struct {
+	PublicKey []byte                `json:"public_key"`
+	Head      models.SignedTreeHead `json:"head"`
+}
+
+// errNoKeyLog is returned by verifyKeyLog for servers that do not publish a
+// key log.
+var errNoKeyLog = errors.New("server does not publish a key log")
+
+// verifyKeyLog checks that the keys of user are the latest keys recorded
+// for them in the server's key log, and that the log is an extension of the
+// one seen before. A server that shows different clients different keys
+// must either fork its log or leave the keys out of it; clients that compare
+// tree heads catch the first, and this check catches the second.
+func verifyKeyLog(user models.User) error {
+	state, pinned := cfg.KeyLogs[cfg.ServerURL]
+	proof, err := fetchKeyLogProof(user.Username)
+	if errors.Is(err, errNoKeyLog) && pinned {
+		return fmt.Errorf("the key log has no entry for %s", user.Username)
+	}
+	if err != nil {
+		return err
+	}
+
+	head := proof.TreeHead
+	if pinned && !bytes.Equal(head.LogPublicKey, state.PublicKey) {
+		return errors.New("the key log is signed with a different key than before")
+	}
+	if !verifyTreeHead(head) {
+		return errors.New("invalid signature on key log tree head")
+	}
+
+	entry := proof.Entry
+	if entry.Username != user.Username || !sameKeys(user, models.User{
+		IdentityPublicKey: entry.IdentityPublicKey,
+		ExchangePublicKey: entry.ExchangePublicKey,
+	}) {
+		return fmt.Errorf("the keys of %s are not their latest keys in the key log", user.Username)
+	}
+	leaf := crypto.KeyLogEntry{
+		Username:          entry.Username,
+		Version:           entry.Version,
+		IdentityPublicKey: entry.IdentityPublicKey,
+		ExchangePublicKey: entry.ExchangePublicKey,
+	}
+	if !crypto.VerifyMerkleInclusion(leaf.LeafHash(), proof.LeafIndex, head.TreeSize, proof.Proof, head.RootHash) {
+		return fmt.Errorf("invalid key log inclusion proof for %s", user.Username)
+	}
+
+	if pinned {
+		if err := checkConsistency(state.Head, head); err != nil {
+			return err
+		}
+	}
+	cfg.KeyLogs[cfg.ServerURL] = KeyLogState{PublicKey: head.LogPublicKey, Head: head}
+	if err := SaveConfigGlobal(); err != nil {
+		fmt.Printf("Warning: Failed to save key log state: %v\n", err)
+	}
+	return nil
+}
+
+// verifyTreeHead checks the signature on a tree head.
+func verifyTreeHead(head models.SignedTreeHead) bool {
+	return crypto.VerifyTreeHead(ed25519.PublicKey(head.LogPublicKey), crypto.TreeHead{
+		TreeSize:  head.TreeSize,
+		RootHash:  head.RootHash,
+		Timestamp: head.Timestamp,
+	}, head.Signature)
+}
+
+// checkConsistency checks that the log described by next is an extension of
+// the one described by prev.
+func checkConsistency(prev, next models.SignedTreeHead) error {
+	switch {
+	case next.TreeSize < prev.TreeSize:
+		return fmt.Errorf("the key log shrank from %d to %d entries", prev.TreeSize, next.TreeSize)
+	case next.TreeSize == prev.TreeSize:
+		if !bytes.Equal(next.RootHash, prev.RootHash) {
+			return errors.New("the key log has been rewritten")
+		}
+		return nil
+	}
+
+	proof, err := apiClient().KeyLogConsistency(context.Background(), prev.TreeSize, next.TreeSize)
+	if err != nil {
+		return fmt.Errorf("failed to fetch consistency proof: %w", err)
+	}
+	if !crypto.VerifyMerkleConsistency(prev.TreeSize, next.TreeSize, prev.RootHash, next.RootHash, proof.Proof) {
+		return errors.New("the key log has been rewritten")
+	}
+	return nil
+}
+
+// fetchKeyLogProof returns the server's proof that the latest keys of
+// username are in its key log.
+func fetchKeyLogProof(username string) (models.KeyLogProof, error) {
+	proof, err := apiClient().ProveKeys(context.Background(), username)
+	if errors.Is(err, gosend.ErrNotFound) {
+		return models.KeyLogProof{}, errNoKeyLog
+	}
+	if err != nil {
+		return models.KeyLogProof{}, err
+	}
+	return proof, nil
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/models"
+)
+
+func TestVerifyKeyLog(t *testing.T) {
+	alice := models.User{Username: "alice", IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)}
+	entry := crypto.KeyLogEntry{Username: "alice", IdentityPublicKey: alice.IdentityPublicKey, ExchangePublicKey: alice.ExchangePublicKey}
+	logKey, _ := crypto.GenerateIdentityKeyPair()
+
+	// A log holding alice's entry followed by others; leaves can be
+	// replaced to play a misbehaving server
+	leaves := [][]byte{entry.LeafHash(), crypto.MerkleLeafHash([]byte("bob"))}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/log/proof":
+			head := crypto.TreeHead{TreeSize: uint64(len(leaves)), RootHash: crypto.MerkleRoot(leaves), Timestamp: time.Now()}
+			_ = json.NewEncoder(w).Encode(models.KeyLogProof{
+				Entry: models.KeyLogEntry{Username: entry.Username, IdentityPublicKey: entry.IdentityPublicKey, ExchangePublicKey: entry.ExchangePublicKey},
+				Proof: crypto.MerkleInclusionProof(leaves, 0),
+				TreeHead: models.SignedTreeHead{
+					TreeSize:     head.TreeSize,
+					RootHash:     head.RootHash,
+					Timestamp:    head.Timestamp,
+					Signature:    crypto.SignTreeHead(logKey.Private, head),
+					LogPublicKey: logKey.Public,
+				},
+			})
+		case "/v1/log/consistency":
+			first, _ := strconv.Atoi(r.URL.Query().Get("first"))
+			second, _ := strconv.Atoi(r.URL.Query().Get("second"))
+			_ = json.NewEncoder(w).Encode(models.ConsistencyProof{
+				Proof: crypto.MerkleConsistencyProof(leaves[:second], first),
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg = &Config{ServerURL: server.URL, KeyLogs: make(map[string]KeyLogState)}
+	cfgFile = filepath.Join(t.TempDir(), "config.json")
+
+	if err := verifyKeyLog(alice); err != nil {
+		t.Fatalf("verifyKeyLog failed: %v", err)
+	}
+	if state := cfg.KeyLogs[server.URL]; state.Head.TreeSize != 2 {
+		t.Errorf("Expected the tree head to be cached, got %+v", state)
+	}
+
+	leaves = append(leaves, crypto.MerkleLeafHash([]byte("carol")))
+	if err := verifyKeyLog(alice); err != nil {
+		t.Fatalf("verifyKeyLog failed after the log grew: %v", err)
+	}
+
+	mallory := alice
+	mallory.ExchangePublicKey = []byte("mallory")
+	if err := verifyKeyLog(mallory); err == nil || !strings.Contains(err.Error(), "not their latest keys") {
+		t.Errorf("Expected keys missing from the log to be refused, got %v", err)
+	}
+
+	leaves[1] = crypto.MerkleLeafHash([]byte("forged"))
+	if err := verifyKeyLog(alice); err == nil || !strings.Contains(err.Error(), "rewritten") {
+		t.Errorf("Expected a rewritten log to be detected, got %v", err)
+	}
+
+	leaves = leaves[:1]
+	if err := verifyKeyLog(alice); err == nil || !strings.Contains(err.Error(), "shrank") {
+		t.Errorf("Expected a shrunk log to be detected, got %v", err)
+	}
+
+	logKey, _ = crypto.GenerateIdentityKeyPair()
+	if err := verifyKeyLog(alice); err == nil || !strings.Contains(err.Error(), "different key") {
+		t.Errorf("Expected a new log key to be refused, got %v", err)
+	}
+}
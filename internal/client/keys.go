@@ -0,0 +1,156 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/pkg/gosend"
+)
+
+// KeyStore performs the operations that need a user's private keys, so
+// that commands never have to hold the keys themselves.
+type KeyStore interface {
+	gosend.Keys
+	// Check makes the user's keys ready for use, asking for a passphrase
+	// if needed, and fails if the store does not have them.
+	Check(username string) error
+}
+
+// configKeyStore uses the plaintext private keys of a config.
+type configKeyStore struct {
+	cfg *Config
+}
+
+func (s configKeyStore) Check(username string) error {
+	if _, ok := s.cfg.IdentityPrivateKeys[username]; !ok {
+		return fmt.Errorf("identity private key not found for user %s", username)
+	}
+	if _, ok := s.cfg.ExchangePrivateKeys[username]; !ok {
+		return fmt.Errorf("exchange private key not found for user %s", username)
+	}
+	return nil
+}
+
+func (s configKeyStore) Sign(username string, message []byte) ([]byte, error) {
+	priv, ok := s.cfg.IdentityPrivateKeys[username]
+	if !ok || len(priv) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("identity private key not found for user %s", username)
+	}
+	return crypto.Sign(priv, message), nil
+}
+
+// exchangeKey returns the user's X25519 private key.
+func (s configKeyStore) exchangeKey(username string) (*[32]byte, error) {
+	priv, ok := s.cfg.ExchangePrivateKeys[username]
+	if !ok || len(priv) != 32 {
+		return nil, fmt.Errorf("exchange private key not found for user %s", username)
+	}
+	var key [32]byte
+	copy(key[:], priv)
+	return &key, nil
+}
+
+// StreamKeys derives a key from the user's current exchange key, then from
+// each key retired by rotate-keys, newest first, so that files sent before
+// a rotation can still be read.
+func (s configKeyStore) StreamKeys(username string, peerPub *[32]byte) ([]*[32]byte, error) {
+	priv, err := s.exchangeKey(username)
+	if err != nil {
+		return nil, err
+	}
+	keys := []*[32]byte{crypto.StreamKey(peerPub, priv)}
+	retired := s.cfg.RetiredExchangeKeys[username]
+	for i := len(retired) - 1; i >= 0; i-- {
+		if len(retired[i]) != 32 {
+			continue
+		}
+		var old [32]byte
+		copy(old[:], retired[i])
+		keys = append(keys, crypto.StreamKey(peerPub, &old))
+	}
+	return keys, nil
+}
+
+// UnwrapKey tries the user's current exchange key first, then the keys
+// retired by rotate-keys, newest first, so that files sent before a
+// rotation can still be read.
+func (s configKeyStore) UnwrapKey(username string, wrapped []byte, ephemeralPub *[32]byte) (*[32]byte, error) {
+	priv, err := s.exchangeKey(username)
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.UnwrapKey(wrapped, ephemeralPub, priv)
+	if err == nil {
+		return key, nil
+	}
+	retired := s.cfg.RetiredExchangeKeys[username]
+	for i := len(retired) - 1; i >= 0; i-- {
+		if len(retired[i]) != 32 {
+			continue
+		}
+		var old [32]byte
+		copy(old[:], retired[i])
+		if key, rerr := crypto.UnwrapKey(wrapped, ephemeralPub, &old); rerr == nil {
+			return key, nil
+		}
+	}
+	return nil, err
+}
+
+// encryptedKeyStore uses the private keys sealed in the global config's
+// keystore, unlocking it with the passphrase on first use.
+type encryptedKeyStore struct{}
+
+func (encryptedKeyStore) keys() (configKeyStore, error) {
+	if err := unlockKeys(); err != nil {
+		return configKeyStore{}, fmt.Errorf("failed to unlock private keys: %w", err)
+	}
+	return configKeyStore{cfg: cfg}, nil
+}
+
+func (s encryptedKeyStore) Check(username string) error {
+	keys, err := s.keys()
+	if err != nil {
+		return err
+	}
+	return keys.Check(username)
+}
+
+func (s encryptedKeyStore) Sign(username string, message []byte) ([]byte, error) {
+	keys, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+	return keys.Sign(username, message)
+}
+
+func (s encryptedKeyStore) StreamKeys(username string, peerPub *[32]byte) ([]*[32]byte, error) {
+	keys, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+	return keys.StreamKeys(username, peerPub)
+}
+
+func (s encryptedKeyStore) UnwrapKey(username string, wrapped []byte, ephemeralPub *[32]byte) (*[32]byte, error) {
+	keys, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+	return keys.UnwrapKey(username, wrapped, ephemeralPub)
+}
+
+// currentKeyStore returns the key store for the global config: the agent
+// at GO_SEND_AGENT_SOCK if it is set, otherwise the config's keystore, or
+// its plaintext keys if it has none.
+func currentKeyStore() KeyStore {
+	if socket := os.Getenv(agentSocketEnv); socket != "" {
+		return agentKeyStore{socket: socket}
+	}
+	if cfg.Keystore != nil {
+		return encryptedKeyStore{}
+	}
+	return configKeyStore{cfg: cfg}
+}
@@ -0,0 +1,244 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+)
+
+const (
+	// passphraseEnv supplies the keystore passphrase instead of a prompt,
+	// for scripts and automation.
+	passphraseEnv = "GO_SEND_PASSPHRASE"
+	// newPassphraseEnv supplies the new passphrase for
+	// 'config change-passphrase'.
+	newPassphraseEnv = "GO_SEND_NEW_PASSPHRASE"
+)
+
+// keystoreKDFParams are the KDF parameters new keystores are created with.
+var keystoreKDFParams = crypto.DefaultKDFParams
+
+// errNoPassphrase is returned when a passphrase is needed but there is
+// neither a terminal to prompt on nor GO_SEND_PASSPHRASE.
+var errNoPassphrase = fmt.Errorf("passphrase required: set %s or run in a terminal", passphraseEnv)
+
+// keyring is the plaintext sealed in a config's keystore.
+type keyring struct {
+	IdentityPrivateKeys map[string][]byte      `json:"identity_private_keys"`
+	ExchangePrivateKeys map[string][]byte      `json:"exchange_private_keys"`
+	RetiredExchangeKeys map[string][][]byte    `json:"retired_exchange_keys,omitempty"`
+	PendingKeys         map[string]PendingKeys `json:"pending_keys,omitempty"`
+}
+
+// hasPrivateKeys reports whether the config holds any plaintext private keys.
+func (c *Config) hasPrivateKeys() bool {
+	return len(c.IdentityPrivateKeys) > 0 || len(c.ExchangePrivateKeys) > 0 || len(c.RetiredExchangeKeys) > 0 || len(c.PendingKeys) > 0
+}
+
+// locked reports whether the config's private keys are sealed in a
+// keystore that has not been unlocked yet.
+func (c *Config) locked() bool {
+	return c.Keystore != nil && c.keystoreKey == nil
+}
+
+// unlock opens the keystore with passphrase and loads the private keys.
+func (c *Config) unlock(passphrase []byte) error {
+	key, err := c.Keystore.DeriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	plaintext, err := c.Keystore.Open(key)
+	if err != nil {
+		return err
+	}
+	var keys keyring
+	if err := json.Unmarshal(plaintext, &keys); err != nil {
+		return fmt.Errorf("invalid keystore contents: %w", err)
+	}
+	if keys.IdentityPrivateKeys != nil {
+		c.IdentityPrivateKeys = keys.IdentityPrivateKeys
+	}
+	if keys.ExchangePrivateKeys != nil {
+		c.ExchangePrivateKeys = keys.ExchangePrivateKeys
+	}
+	if keys.RetiredExchangeKeys != nil {
+		c.RetiredExchangeKeys = keys.RetiredExchangeKeys
+	}
+	if keys.PendingKeys != nil {
+		c.PendingKeys = keys.PendingKeys
+	}
+	c.keystoreKey = key
+	return nil
+}
+
+// setPassphrase protects the private keys with a new passphrase, replacing
+// any existing keystore. The config must not be locked.
+func (c *Config) setPassphrase(passphrase []byte) error {
+	if c.locked() {
+		return errors.New("private keys are locked")
+	}
+	if len(passphrase) == 0 {
+		return errors.New("passphrase must not be empty")
+	}
+	ks, err := crypto.NewKeystore(keystoreKDFParams)
+	if err != nil {
+		return err
+	}
+	key, err := ks.DeriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	c.Keystore, c.keystoreKey = ks, key
+	return c.sealKeys()
+}
+
+// sealKeys seals the current private keys into the unlocked keystore.
+func (c *Config) sealKeys() error {
+	plaintext, err := json.Marshal(keyring{
+		IdentityPrivateKeys: c.IdentityPrivateKeys,
+		ExchangePrivateKeys: c.ExchangePrivateKeys,
+		RetiredExchangeKeys: c.RetiredExchangeKeys,
+		PendingKeys:         c.PendingKeys,
+	})
+	if err != nil {
+		return err
+	}
+	return c.Keystore.Seal(plaintext, c.keystoreKey)
+}
+
+// readLine reads up to the end of a line without buffering past it.
+func readLine(r io.Reader) ([]byte, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			switch buf[0] {
+			case '\n':
+				return line, nil
+			case '\r':
+			default:
+				line = append(line, buf[0])
+			}
+		}
+		if err == io.EOF && len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// promptPassphrase asks for a passphrase on the terminal.
+func promptPassphrase(prompt string) ([]byte, error) {
+	if !isTerminal(os.Stdin) {
+		return nil, errNoPassphrase
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := readPassword(os.Stdin)
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
+}
+
+// newPassphrase returns the passphrase in env, or asks for a new one twice.
+func newPassphrase(env, prompt string) ([]byte, error) {
+	if p := os.Getenv(env); p != "" {
+		return []byte(p), nil
+	}
+	passphrase, err := promptPassphrase(prompt)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	confirm, err := promptPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, confirm) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// unlockKeys makes the private keys of the global config available,
+// unlocking its keystore with GO_SEND_PASSPHRASE or a prompted passphrase.
+// Configs from before the keystore hold plaintext keys, which are
+// encrypted here so that only the commands using them ask for a passphrase.
+func unlockKeys() error {
+	if !cfg.locked() {
+		return migrateKeys()
+	}
+	return unlockKeystore()
+}
+
+// unlockKeystore unlocks the keystore of the global config, if it has one.
+func unlockKeystore() error {
+	if !cfg.locked() {
+		return nil
+	}
+	if p := os.Getenv(passphraseEnv); p != "" {
+		return cfg.unlock([]byte(p))
+	}
+	for attempt := 0; attempt < 3; attempt++ {
+		passphrase, err := promptPassphrase("Passphrase: ")
+		if err != nil {
+			return err
+		}
+		err = cfg.unlock(passphrase)
+		if !errors.Is(err, crypto.ErrWrongPassphrase) {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Wrong passphrase, try again.")
+	}
+	return crypto.ErrWrongPassphrase
+}
+
+// warnedPlaintextKeys limits the unencrypted keys warning to once per run.
+var warnedPlaintextKeys bool
+
+// protectKeys moves plaintext private keys in the global config into a new
+// keystore, taking the passphrase from GO_SEND_PASSPHRASE or asking for a
+// new one. It reports whether it did. Without either source of passphrase
+// the keys are left as they are, with a warning.
+func protectKeys() (bool, error) {
+	if cfg.Keystore != nil || !cfg.hasPrivateKeys() {
+		return false, nil
+	}
+	passphrase, err := newPassphrase(passphraseEnv, "Choose a passphrase to encrypt your private keys: ")
+	if errors.Is(err, errNoPassphrase) {
+		if !warnedPlaintextKeys {
+			fmt.Fprintf(os.Stderr, "Warning: Private keys are stored unencrypted. Set %s or run in a terminal to encrypt them.\n", passphraseEnv)
+			warnedPlaintextKeys = true
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := cfg.setPassphrase(passphrase); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// migrateKeys encrypts plaintext private keys in the global config with
+// protectKeys and saves the result.
+func migrateKeys() error {
+	migrated, err := protectKeys()
+	if err != nil || !migrated {
+		return err
+	}
+	if err := SaveConfigGlobal(); err != nil {
+		return err
+	}
+	fmt.Println("Private keys are now encrypted with your passphrase.")
+	return nil
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+)
+
+// fastKDF keeps keystore tests quick.
+func fastKDF(t *testing.T) {
+	old := keystoreKDFParams
+	keystoreKDFParams = crypto.KDFParams{Time: 1, Memory: 1024, Threads: 1}
+	t.Cleanup(func() { keystoreKDFParams = old })
+}
+
+func TestKeystoreCommands(t *testing.T) {
+	fastKDF(t)
+	tmpDir, ts := setupTestConfig(t)
+	defer ts.Close()
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	configPath := filepath.Join(tmpDir, "config.json")
+
+	t.Setenv(passphraseEnv, "first passphrase")
+	if _, err := runCmd(t, tmpDir, "config", "init", "--user", "alice", "--server", ts.URL); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	// Private keys are only stored sealed
+	data, _ := os.ReadFile(configPath)
+	loaded, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Keystore == nil || !loaded.locked() || len(loaded.IdentityPrivateKeys) != 0 {
+		t.Fatal("Expected private keys in a locked keystore")
+	}
+	if err := loaded.unlock([]byte("wrong")); !errors.Is(err, crypto.ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+	if err := loaded.unlock([]byte("first passphrase")); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	idKey := loaded.IdentityPrivateKeys["alice"]
+	if len(idKey) == 0 || bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString(idKey))) {
+		t.Fatal("Identity key missing from keystore or stored in plaintext")
+	}
+
+	// Saving a locked config keeps the keystore as it is
+	if output, err := runCmd(t, tmpDir, "set-server", ts.URL); err != nil || !strings.Contains(output, "Server URL set") {
+		t.Fatalf("set-server failed: %v %s", err, output)
+	}
+
+	t.Setenv(newPassphraseEnv, "second passphrase")
+	if output, err := runCmd(t, tmpDir, "config", "change-passphrase"); err != nil || !strings.Contains(output, "Passphrase changed") {
+		t.Fatalf("change-passphrase failed: %v %s", err, output)
+	}
+	if output, _ := runCmd(t, tmpDir, "set-user", "alice"); !strings.Contains(output, "Error unlocking") {
+		t.Errorf("Expected the old passphrase to be refused, got: %s", output)
+	}
+	t.Setenv(passphraseEnv, "second passphrase")
+	if output, _ := runCmd(t, tmpDir, "set-user", "alice"); !strings.Contains(output, "Current user set to alice") {
+		t.Errorf("Expected the new passphrase to unlock, got: %s", output)
+	}
+	loaded, _ = LoadConfig(configPath)
+	if err := loaded.unlock([]byte("second passphrase")); err != nil || !bytes.Equal(loaded.IdentityPrivateKeys["alice"], idKey) {
+		t.Errorf("Keys changed with the passphrase (%v)", err)
+	}
+}
+
+func TestKeystoreMigration(t *testing.T) {
+	fastKDF(t)
+	tmpDir, ts := setupTestConfig(t)
+	defer ts.Close()
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	configPath := filepath.Join(tmpDir, "config.json")
+
+	// A config from before the keystore, with plaintext keys
+	plain, _ := LoadConfig(configPath)
+	plain.CurrentUsername = "alice"
+	plain.IdentityPrivateKeys["alice"] = []byte("identity key")
+	plain.ExchangePrivateKeys["alice"] = []byte("exchange key")
+	if err := SaveConfig(configPath, plain); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a passphrase the keys are left alone
+	if _, err := runCmd(t, tmpDir, "set-user", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := LoadConfig(configPath); loaded.Keystore != nil {
+		t.Fatal("Keys should stay unencrypted without a passphrase")
+	}
+
+	// Commands that do not use the keys leave them alone too
+	t.Setenv(passphraseEnv, "secret")
+	if output, err := runCmd(t, tmpDir, "config", "path"); err != nil || strings.Contains(output, "now encrypted") {
+		t.Fatalf("Expected no migration, got: %v %s", err, output)
+	}
+	if loaded, _ := LoadConfig(configPath); loaded.Keystore != nil {
+		t.Fatal("Keys encrypted by a command that does not use them")
+	}
+
+	if output, err := runCmd(t, tmpDir, "set-user", "alice"); err != nil || !strings.Contains(output, "now encrypted") {
+		t.Fatalf("Expected migration, got: %v %s", err, output)
+	}
+	data, _ := os.ReadFile(configPath)
+	if strings.Contains(string(data), "identity_private_keys") {
+		t.Error("Plaintext keys left in config after migration")
+	}
+	loaded, _ := LoadConfig(configPath)
+	if err := loaded.unlock([]byte("secret")); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if string(loaded.IdentityPrivateKeys["alice"]) != "identity key" || string(loaded.ExchangePrivateKeys["alice"]) != "exchange key" {
+		t.Error("Keys lost in migration")
+	}
+}
+
+func TestConfigProtect(t *testing.T) {
+	fastKDF(t)
+	tmpDir, ts := setupTestConfig(t)
+	defer ts.Close()
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	configPath := filepath.Join(tmpDir, "config.json")
+
+	if output, _ := runCmd(t, tmpDir, "config", "protect"); !strings.Contains(output, "No private keys") {
+		t.Errorf("Expected nothing to encrypt, got: %s", output)
+	}
+
+	plain, _ := LoadConfig(configPath)
+	plain.IdentityPrivateKeys["alice"] = []byte("identity key")
+	plain.ExchangePrivateKeys["alice"] = []byte("exchange key")
+	if err := SaveConfig(configPath, plain); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(passphraseEnv, "secret")
+	if output, err := runCmd(t, tmpDir, "config", "protect"); err != nil || !strings.Contains(output, "now encrypted") {
+		t.Fatalf("Expected migration, got: %v %s", err, output)
+	}
+	loaded, _ := LoadConfig(configPath)
+	if err := loaded.unlock([]byte("secret")); err != nil || string(loaded.IdentityPrivateKeys["alice"]) != "identity key" {
+		t.Fatalf("Keys not encrypted with the passphrase (%v)", err)
+	}
+	if output, _ := runCmd(t, tmpDir, "config", "protect"); !strings.Contains(output, "already encrypted") {
+		t.Errorf("Expected keys to be reported as encrypted, got: %s", output)
+	}
+}
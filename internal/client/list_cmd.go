@@ -1,12 +1,10 @@
 package client
 
 import (
-	"encoding/json"
 	"fmt"
-	"net/http"
 	"time"
 
-	"github.com/VinMeld/go-send/internal/models"
+	"github.com/VinMeld/go-send/internal/crypto"
 	"github.com/spf13/cobra"
 )
 
@@ -23,37 +21,12 @@ var listFilesCmd = &cobra.Command{
 			return
 		}
 
-		authHeader, err := GetAuthHeader()
-		if err != nil {
-			fmt.Println("Authentication error:", err)
-			return
-		}
-
-		httpReq, err := http.NewRequest("GET", fmt.Sprintf("%s/files?recipient=%s", cfg.ServerURL, cfg.CurrentUsername), nil)
-		if err != nil {
-			fmt.Println("Error creating request:", err)
-			return
-		}
-		httpReq.Header.Set("Authorization", authHeader)
-
-		client := &http.Client{}
-		resp, err := client.Do(httpReq)
+		client := apiClient()
+		files, err := client.List(cmd.Context())
 		if err != nil {
 			fmt.Println("Error fetching files:", err)
 			return
 		}
-		defer func() { _ = resp.Body.Close() }()
-
-		if resp.StatusCode != http.StatusOK {
-			fmt.Println("Server returned error:", resp.Status)
-			return
-		}
-
-		var files []models.FileMetadata
-		if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
-			fmt.Println("Error decoding response:", err)
-			return
-		}
 
 		// Update cache
 		cfg.LastListedFiles = make([]string, 0, len(files))
@@ -64,9 +37,55 @@ var listFilesCmd = &cobra.Command{
 			fmt.Println("Warning: Failed to save file list cache:", err)
 		}
 
+		decrypt := true
+		if err := currentKeyStore().Check(cfg.CurrentUsername); err != nil {
+			fmt.Println("Warning: File names cannot be decrypted:", err)
+			decrypt = false
+		}
+
 		fmt.Printf("Files for %s:\n", cfg.CurrentUsername)
 		for i, f := range files {
-			fmt.Printf("%d - [%s] %s (from %s) - %s\n", i+1, f.ID, f.FileName, f.Sender, f.Timestamp.Format(time.RFC822))
+			// Without keys, only names sent in plaintext can be shown
+			info := crypto.FileInfo{Name: "<encrypted>"}
+			if decrypt || len(f.EncryptedMetadata) == 0 {
+				if opened, err := client.FileInfo(f); err == nil {
+					info = opened
+				}
+			}
+			details := ""
+			if info.Size > 0 || info.MIMEType != "" {
+				details = fmt.Sprintf("%d bytes, %s, ", info.Size, info.MIMEType)
+			}
+			expiry := ""
+			if !f.ExpiresAt.IsZero() {
+				expiry = ", expires in " + formatTTL(time.Until(f.ExpiresAt))
+			}
+			if f.DownloadsRemaining > 0 {
+				expiry += fmt.Sprintf(", %d download(s) left", f.DownloadsRemaining)
+			}
+			fmt.Printf("%d - [%s] %s (%sfrom %s) - %s%s\n", i+1, f.ID, info.Name, details, f.Sender, f.Timestamp.Format(time.RFC822), expiry)
+			if info.Message != "" {
+				fmt.Printf("    Message: %s\n", info.Message)
+			}
 		}
 	},
 }
+
+// formatTTL formats the time left before a file expires, to the minute.
+func formatTTL(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d <= 0 {
+		return "<1m"
+	}
+	days := d / (24 * time.Hour)
+	hours := (d % (24 * time.Hour)) / time.Hour
+	minutes := (d % time.Hour) / time.Minute
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
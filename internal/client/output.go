@@ -0,0 +1,185 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// maxFileNameLength is the longest file name accepted from a sender, in bytes.
+const maxFileNameLength = 255
+
+// maxRenameAttempts bounds how many numbered names are tried when the
+// output file already exists.
+const maxRenameAttempts = 1000
+
+// reservedNames are device names Windows will not create files under, with
+// or without an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeFileName reduces a file name chosen by the sender to a single safe
+// path component. Directory parts are stripped, whichever separator they
+// use, and names with control characters or reserved meanings are rejected.
+func sanitizeFileName(name string) (string, error) {
+	base := name
+	if i := strings.LastIndexAny(base, `/\`); i >= 0 {
+		base = base[i+1:]
+	}
+	// Windows ignores trailing dots and spaces, so "CON." is "CON"
+	base = strings.TrimRight(base, ". ")
+
+	switch {
+	case base == "":
+		return "", fmt.Errorf("invalid file name %q", name)
+	case len(base) > maxFileNameLength:
+		return "", fmt.Errorf("file name too long (%d bytes)", len(base))
+	case strings.IndexFunc(base, unicode.IsControl) >= 0:
+		return "", fmt.Errorf("file name %q contains control characters", name)
+	case strings.ContainsAny(base, `:*?"<>|`):
+		return "", fmt.Errorf("file name %q contains reserved characters", name)
+	}
+	stem, _, _ := strings.Cut(base, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		return "", fmt.Errorf("file name %q is reserved", name)
+	}
+	return base, nil
+}
+
+// outputOptions controls where a downloaded file is written.
+type outputOptions struct {
+	Dir   string // Directory for the sender's file name; defaults to "."
+	Path  string // Exact output path, overriding Dir and the sender's name
+	Force bool   // Overwrite an existing file instead of renaming
+	// Extract unpacks archives into a new directory instead of saving them
+	Extract bool
+}
+
+// dir returns the directory the output file will be placed in.
+func (o outputOptions) dir() string {
+	if o.Path != "" {
+		return filepath.Dir(o.Path)
+	}
+	if o.Dir != "" {
+		return o.Dir
+	}
+	return "."
+}
+
+// place moves the finished file at tmpPath into its final location and
+// returns that path. name is the sender's file name, which is sanitized and
+// renamed to "name (N).ext" if taken. An exact Path is never renamed. Unless
+// Force is set, an existing file is never overwritten: the final name is
+// reserved with an exclusive create before the rename replaces it, so the
+// whole file appears at once.
+func (o outputOptions) place(tmpPath, name string) (string, error) {
+	if o.Path != "" {
+		return o.Path, o.claim(tmpPath, o.Path)
+	}
+
+	base, err := sanitizeFileName(name)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < maxRenameAttempts; i++ {
+		path := filepath.Join(o.dir(), numberedName(base, i))
+		err := o.claim(tmpPath, path)
+		if err == nil {
+			return path, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free file name for %q in %s", base, o.dir())
+}
+
+// numberedName returns name for i == 0, and "name (i).ext" otherwise.
+func numberedName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+}
+
+// extract unpacks the archive at tmpPath into a new directory and returns
+// its path. The directory is Path if set, or else the sanitized archive name
+// without its .tar extension, numbered if taken. Existing directories are
+// never extracted into, even with Force. The archive is removed once it has
+// been extracted; if extraction fails, everything extracted is removed and
+// the archive is kept, so it can be extracted again or saved as it is.
+func (o outputOptions) extract(tmpPath, name string) (string, error) {
+	dir, err := o.makeDir(name)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		_ = os.Remove(dir)
+		return "", err
+	}
+	err = extractArchive(bufio.NewReader(f), dir)
+	_ = f.Close()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to extract archive: %w", err)
+	}
+	return dir, os.Remove(tmpPath)
+}
+
+// makeDir creates the empty directory an archive is extracted into.
+func (o outputOptions) makeDir(name string) (string, error) {
+	if o.Path != "" {
+		return o.Path, os.Mkdir(o.Path, 0755)
+	}
+
+	base, err := sanitizeFileName(name)
+	if err != nil {
+		return "", err
+	}
+	if stem := strings.TrimSuffix(base, ".tar"); stem != "" {
+		base = stem
+	}
+	for i := 0; i < maxRenameAttempts; i++ {
+		path := filepath.Join(o.dir(), numberedName(base, i))
+		err := os.Mkdir(path, 0755)
+		if err == nil {
+			return path, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free directory name for %q in %s", base, o.dir())
+}
+
+// claim renames tmpPath to path, failing with os.ErrExist if path exists
+// and Force is not set.
+func (o outputOptions) claim(tmpPath, path string) error {
+	if !o.Force {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			if errors.Is(err, os.ErrExist) {
+				return fmt.Errorf("%s: %w (use --force to overwrite)", path, os.ErrExist)
+			}
+			return err
+		}
+		_ = f.Close()
+		if err := os.Rename(tmpPath, path); err != nil {
+			_ = os.Remove(path)
+			return err
+		}
+		return nil
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // empty if the name is rejected
+	}{
+		{"report.pdf", "report.pdf"},
+		{"../../.bashrc", ".bashrc"},
+		{"/etc/passwd", "passwd"},
+		{`..\..\Windows\win.ini`, "win.ini"},
+		{"dir/", ""},
+		{"..", ""},
+		{".", ""},
+		{"", ""},
+		{"name. ", "name"},
+		{"bad\nname.txt", ""},
+		{"bell\x07.txt", ""},
+		{"a:b.txt", ""},
+		{"CON", ""},
+		{"con.txt", ""},
+		{"LPT1.tar.gz", ""},
+		{"CONSOLE.txt", "CONSOLE.txt"},
+		{strings.Repeat("a", 256), ""},
+	}
+	for _, tt := range tests {
+		got, err := sanitizeFileName(tt.name)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("sanitizeFileName(%q) = %q, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("sanitizeFileName(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestOutputPlace(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	read := func(path string) string {
+		data, _ := os.ReadFile(path)
+		return string(data)
+	}
+
+	// Sender-chosen names are confined to the directory
+	out := outputOptions{Dir: dir}
+	path, err := out.place(write(".tmp1", "first"), "../notes.txt")
+	if err != nil || path != filepath.Join(dir, "notes.txt") || read(path) != "first" {
+		t.Fatalf("Unexpected placement %q (%v)", path, err)
+	}
+
+	// An existing file is kept and the new one renamed
+	path, err = out.place(write(".tmp2", "second"), "notes.txt")
+	if err != nil || path != filepath.Join(dir, "notes (1).txt") || read(path) != "second" {
+		t.Fatalf("Unexpected placement %q (%v)", path, err)
+	}
+	if read(filepath.Join(dir, "notes.txt")) != "first" {
+		t.Error("Existing file was overwritten")
+	}
+
+	// Force overwrites
+	out.Force = true
+	path, err = out.place(write(".tmp3", "third"), "notes.txt")
+	if err != nil || path != filepath.Join(dir, "notes.txt") || read(path) != "third" {
+		t.Fatalf("Unexpected forced placement %q (%v)", path, err)
+	}
+
+	// An exact path is never renamed
+	exact := outputOptions{Path: filepath.Join(dir, "notes.txt")}
+	tmp := write(".tmp4", "fourth")
+	if _, err := exact.place(tmp, "ignored.txt"); err == nil {
+		t.Error("Expected an existing output path to be refused")
+	}
+	if read(filepath.Join(dir, "notes.txt")) != "third" {
+		t.Error("Existing output path was overwritten")
+	}
+	exact.Force = true
+	if path, err := exact.place(tmp, "ignored.txt"); err != nil || read(path) != "fourth" {
+		t.Errorf("Expected forced exact placement, got %q (%v)", path, err)
+	}
+
+	// Rejected names leave the temporary file alone
+	tmp = write(".tmp5", "fifth")
+	if _, err := out.place(tmp, "NUL"); err == nil {
+		t.Error("Expected reserved name to be refused")
+	}
+	if read(tmp) != "fifth" {
+		t.Error("Temporary file should be kept")
+	}
+}
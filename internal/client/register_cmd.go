@@ -1,11 +1,7 @@
 package client
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 
 	"github.com/spf13/cobra"
 )
@@ -27,6 +23,10 @@ var registerCmd = &cobra.Command{
 		}
 
 		// Get Keys
+		if err := unlockKeys(); err != nil {
+			fmt.Println("Error unlocking private keys:", err)
+			return
+		}
 		if _, ok := cfg.IdentityPrivateKeys[cfg.CurrentUsername]; !ok {
 			fmt.Printf("Identity private key for user '%s' not found.\n", cfg.CurrentUsername)
 			fmt.Println("Please run 'go-send config init --user <username>' to generate keys.")
@@ -54,33 +54,8 @@ var registerCmd = &cobra.Command{
 
 		fmt.Printf("Registering user %s...\n", cfg.CurrentUsername)
 
-		data, err := json.Marshal(user)
-		if err != nil {
-			fmt.Println("Error marshaling user:", err)
-			return
-		}
-
-		req, err := http.NewRequest("POST", cfg.ServerURL+"/users", bytes.NewBuffer(data))
-		if err != nil {
-			fmt.Println("Error creating request:", err)
-			return
-		}
-		req.Header.Set("Content-Type", "application/json")
-		if token != "" {
-			req.Header.Set("X-Registration-Token", token)
-		}
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Println("Error registering user:", err)
-			return
-		}
-		defer func() { _ = resp.Body.Close() }()
-
-		if resp.StatusCode != http.StatusCreated {
-			body, _ := io.ReadAll(resp.Body)
-			fmt.Printf("Registration failed: %s\n", string(body))
+		if err := apiClient().Register(cmd.Context(), user, token); err != nil {
+			fmt.Println("Registration failed:", err)
 			return
 		}
 
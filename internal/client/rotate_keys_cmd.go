@@ -0,0 +1,228 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/models"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(rotateKeysCmd)
+}
+
+var rotateKeysCmd = &cobra.Command{
+	Use:   "rotate-keys",
+	Short: "Replace the current user's keys with new ones",
+	Long: `Generate new identity and exchange keys for the current user and register
+them with the server, signed by the old identity key. The old exchange key is
+kept so that files sent before the rotation can still be downloaded.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		me := cfg.CurrentUsername
+		if me == "" {
+			fmt.Println("No current user set. Use 'config init' first.")
+			return
+		}
+		if err := unlockKeys(); err != nil {
+			fmt.Println("Error unlocking private keys:", err)
+			return
+		}
+		keys := configKeyStore{cfg: cfg}
+		if err := keys.Check(me); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+
+		history, err := fetchKeyHistory(me)
+		if err != nil {
+			fmt.Println("Error fetching key history:", err)
+			return
+		}
+
+		// The new keys are saved before the server hears of them, so a lost
+		// response leaves them here to finish the rotation with
+		pending, ok := cfg.PendingKeys[me]
+		if ok && len(history) > 0 && bytes.Equal(history[len(history)-1].IdentityPublicKey, pending.IdentityPublicKey) {
+			fmt.Println("Finishing a key rotation the server has already accepted.")
+			finishRotation(me, pending, history[len(history)-1].Version)
+			return
+		}
+		if !ok {
+			if pending, err = newPendingKeys(); err != nil {
+				fmt.Println("Error generating keys:", err)
+				return
+			}
+			cfg.PendingKeys[me] = pending
+			if err := SaveConfigGlobal(); err != nil {
+				fmt.Println("Error saving new keys:", err)
+				return
+			}
+		}
+
+		// Rotation ends all sessions, so start a fresh one to make sure the
+		// request is not refused for an expired token.
+		if err := Login(); err != nil {
+			fmt.Println("Login failed:", err)
+			return
+		}
+
+		k := crypto.KeyRotation{
+			Username:             me,
+			Version:              int64(len(history)) + 1,
+			OldIdentityPublicKey: ed25519.PrivateKey(cfg.IdentityPrivateKeys[me]).Public().(ed25519.PublicKey),
+			IdentityPublicKey:    pending.IdentityPublicKey,
+			ExchangePublicKey:    pending.ExchangePublicKey,
+			RotatedAt:            time.Now(),
+		}
+		signature, err := keys.Sign(me, k.Bytes())
+		if err != nil {
+			fmt.Println("Error signing key rotation:", err)
+			return
+		}
+		if err := apiClient().RotateKeys(cmd.Context(), models.KeyRotation{
+			Username:             k.Username,
+			Version:              k.Version,
+			OldIdentityPublicKey: k.OldIdentityPublicKey,
+			IdentityPublicKey:    k.IdentityPublicKey,
+			ExchangePublicKey:    k.ExchangePublicKey,
+			RotatedAt:            k.RotatedAt,
+			Signature:            signature,
+		}); err != nil {
+			fmt.Println("Key rotation failed:", err)
+			fmt.Println("The new keys are saved. Run rotate-keys again to finish the rotation.")
+			return
+		}
+		finishRotation(me, pending, k.Version)
+	},
+}
+
+// newPendingKeys generates the keys for a key rotation.
+func newPendingKeys() (PendingKeys, error) {
+	idKeys, err := crypto.GenerateIdentityKeyPair()
+	if err != nil {
+		return PendingKeys{}, err
+	}
+	exKeys, err := crypto.GenerateExchangeKeyPair()
+	if err != nil {
+		return PendingKeys{}, err
+	}
+	return PendingKeys{
+		IdentityPublicKey:  idKeys.Public,
+		IdentityPrivateKey: idKeys.Private,
+		ExchangePublicKey:  exKeys.Public[:],
+		ExchangePrivateKey: exKeys.Private[:],
+	}, nil
+}
+
+// finishRotation makes the pending keys of a rotation the server has
+// accepted the current user's keys, retiring the old exchange key, and logs
+// in with them.
+func finishRotation(me string, pending PendingKeys, version int64) {
+	cfg.RetiredExchangeKeys[me] = append(cfg.RetiredExchangeKeys[me], cfg.ExchangePrivateKeys[me])
+	cfg.IdentityPrivateKeys[me] = pending.IdentityPrivateKey
+	cfg.ExchangePrivateKeys[me] = pending.ExchangePrivateKey
+	cfg.Users[me] = models.User{
+		Username:          me,
+		IdentityPublicKey: pending.IdentityPublicKey,
+		ExchangePublicKey: pending.ExchangePublicKey,
+	}
+	delete(cfg.PendingKeys, me)
+	forgetSession(me)
+	if err := SaveConfigGlobal(); err != nil {
+		fmt.Println("Error saving config:", err)
+		return
+	}
+
+	fmt.Printf("Keys rotated for %s (version %d)\n", me, version)
+	fmt.Printf("Identity Public Key: %s\n", base64.StdEncoding.EncodeToString(pending.IdentityPublicKey))
+	fmt.Printf("Exchange Public Key: %s\n", base64.StdEncoding.EncodeToString(pending.ExchangePublicKey))
+	if os.Getenv(agentSocketEnv) != "" {
+		fmt.Println("Restart the agent to use the new keys.")
+	}
+	if err := Login(); err != nil {
+		fmt.Printf("Warning: Login with the new keys failed: %v\n", err)
+	}
+}
+
+// fetchKeyHistory returns the key rotations of username recorded by the
+// server, oldest first.
+func fetchKeyHistory(username string) ([]models.KeyRotation, error) {
+	return apiClient().KeyHistory(context.Background(), username)
+}
+
+// verifyKeyChain returns the rotations in history that follow on from the
+// trusted identity key of user, checking that each is signed by the key
+// before it. Rotations before the trusted key are ignored: nothing already
+// trusted vouches for them.
+func verifyKeyChain(user models.User, history []models.KeyRotation) ([]models.KeyRotation, error) {
+	var chain []models.KeyRotation
+	current := user.IdentityPublicKey
+	for _, r := range history {
+		if len(chain) == 0 && !bytes.Equal(r.OldIdentityPublicKey, current) {
+			continue
+		}
+		if r.Username != user.Username || !bytes.Equal(r.OldIdentityPublicKey, current) {
+			return nil, errors.New("key history is broken")
+		}
+		if len(chain) > 0 {
+			prev := chain[len(chain)-1]
+			if r.Version != prev.Version+1 || r.RotatedAt.Before(prev.RotatedAt) {
+				return nil, errors.New("key history is out of order")
+			}
+		}
+		if !crypto.VerifyKeyRotation(crypto.KeyRotation{
+			Username:             r.Username,
+			Version:              r.Version,
+			OldIdentityPublicKey: r.OldIdentityPublicKey,
+			IdentityPublicKey:    r.IdentityPublicKey,
+			ExchangePublicKey:    r.ExchangePublicKey,
+			RotatedAt:            r.RotatedAt,
+		}, r.Signature) {
+			return nil, fmt.Errorf("invalid signature on key rotation %d", r.Version)
+		}
+		chain = append(chain, r)
+		current = r.IdentityPublicKey
+	}
+	return chain, nil
+}
+
+// rotatedIdentityKey follows the key history of a user in the address book
+// and returns the identity key that was current at uploadedAt, the time the
+// server recorded a file. The time in the manifest is chosen by whoever
+// signed it, so it cannot pick the key: a retired key could be used to
+// forge a file dated before its rotation. The address book is updated to
+// their newest keys. It returns nil if the server has no rotations after
+// the key already known.
+func rotatedIdentityKey(user models.User, uploadedAt time.Time) (ed25519.PublicKey, error) {
+	history, err := fetchKeyHistory(user.Username)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := verifyKeyChain(user, history)
+	if err != nil || len(chain) == 0 {
+		return nil, err
+	}
+
+	repin(user, chain[len(chain)-1])
+	return keyAt(user.IdentityPublicKey, chain, uploadedAt), nil
+}
+
+// keyAt returns the identity key that was current at t, starting from key
+// and following a verified chain of rotations.
+func keyAt(key ed25519.PublicKey, chain []models.KeyRotation, t time.Time) ed25519.PublicKey {
+	for _, r := range chain {
+		if t.Before(r.RotatedAt) {
+			break
+		}
+		key = r.IdentityPublicKey
+	}
+	return key
+}
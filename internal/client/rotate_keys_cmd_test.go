@@ -0,0 +1,173 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/models"
+	"github.com/VinMeld/go-send/internal/transport"
+	"github.com/VinMeld/go-send/pkg/gosend"
+)
+
+// signedRotation returns a rotation of alice's keys from old to a new key
+// pair, and the new key pair.
+func signedRotation(old *crypto.IdentityKeyPair, version int64, at time.Time) (models.KeyRotation, *crypto.IdentityKeyPair) {
+	next, _ := crypto.GenerateIdentityKeyPair()
+	k := crypto.KeyRotation{
+		Username:             "alice",
+		Version:              version,
+		OldIdentityPublicKey: old.Public,
+		IdentityPublicKey:    next.Public,
+		ExchangePublicKey:    make([]byte, 32),
+		RotatedAt:            at,
+	}
+	return models.KeyRotation{
+		Username:             k.Username,
+		Version:              k.Version,
+		OldIdentityPublicKey: k.OldIdentityPublicKey,
+		IdentityPublicKey:    k.IdentityPublicKey,
+		ExchangePublicKey:    k.ExchangePublicKey,
+		RotatedAt:            k.RotatedAt,
+		Signature:            crypto.SignKeyRotation(old.Private, k),
+	}, next
+}
+
+func TestVerifyKeyChain(t *testing.T) {
+	k0, _ := crypto.GenerateIdentityKeyPair()
+	now := time.Now()
+	r1, k1 := signedRotation(k0, 1, now)
+	r2, k2 := signedRotation(k1, 2, now.Add(time.Hour))
+	history := []models.KeyRotation{r1, r2}
+
+	chain, err := verifyKeyChain(models.User{Username: "alice", IdentityPublicKey: k0.Public}, history)
+	if err != nil || len(chain) != 2 || !bytes.Equal(chain[1].IdentityPublicKey, k2.Public) {
+		t.Fatalf("Expected the full chain, got %d rotations (%v)", len(chain), err)
+	}
+
+	// Only rotations after the trusted key are followed
+	chain, err = verifyKeyChain(models.User{Username: "alice", IdentityPublicKey: k1.Public}, history)
+	if err != nil || len(chain) != 1 || chain[0].Version != 2 {
+		t.Errorf("Expected one rotation after k1, got %d (%v)", len(chain), err)
+	}
+	chain, err = verifyKeyChain(models.User{Username: "alice", IdentityPublicKey: k2.Public}, history)
+	if err != nil || len(chain) != 0 {
+		t.Errorf("Expected no rotations after the newest key, got %d (%v)", len(chain), err)
+	}
+
+	// A forged rotation breaks the chain
+	mallory, _ := crypto.GenerateIdentityKeyPair()
+	forged, _ := signedRotation(mallory, 2, now.Add(time.Hour))
+	forged.OldIdentityPublicKey = k1.Public
+	if _, err := verifyKeyChain(models.User{Username: "alice", IdentityPublicKey: k0.Public}, []models.KeyRotation{r1, forged}); err == nil {
+		t.Error("Expected an error for a rotation not signed by the previous key")
+	}
+}
+
+func TestKeyAtUploadTime(t *testing.T) {
+	k0, _ := crypto.GenerateIdentityKeyPair()
+	rotatedAt := time.Now().Add(-time.Hour)
+	r1, k1 := signedRotation(k0, 1, rotatedAt)
+	chain := []models.KeyRotation{r1}
+
+	if key := keyAt(k0.Public, chain, rotatedAt.Add(-time.Minute)); !bytes.Equal(key, k0.Public) {
+		t.Error("Expected the old key before the rotation")
+	}
+	if key := keyAt(k0.Public, chain, rotatedAt); !bytes.Equal(key, k1.Public) {
+		t.Error("Expected the new key from the rotation on")
+	}
+
+	// Whoever holds the retired key cannot backdate a file to before the
+	// rotation, as the key is picked by when the server received it
+	meta := models.FileMetadata{Sender: "alice", Recipient: "bob", EncryptedKey: make([]byte, 32), SignedAt: rotatedAt.Add(-time.Minute), Timestamp: time.Now()}
+	hash := make([]byte, 32)
+	meta.Signature = crypto.SignManifest(k0.Private, crypto.Manifest{Sender: meta.Sender, Recipient: meta.Recipient, EncryptedKey: meta.EncryptedKey, CiphertextHash: hash, SignedAt: meta.SignedAt})
+	if !gosend.VerifyManifest(k0.Public, meta, hash) {
+		t.Fatal("Expected the forged manifest to be signed by the old key")
+	}
+	if gosend.VerifyManifest(keyAt(k0.Public, chain, meta.Timestamp), meta, hash) {
+		t.Error("Expected a backdated file signed with a retired key to be refused")
+	}
+}
+
+func TestUnwrapWithRetiredKey(t *testing.T) {
+	idKeys, _ := crypto.GenerateIdentityKeyPair()
+	oldKeys, _ := crypto.GenerateExchangeKeyPair()
+	newKeys, _ := crypto.GenerateExchangeKeyPair()
+	keys := configKeyStore{cfg: &Config{
+		IdentityPrivateKeys: map[string][]byte{"alice": idKeys.Private},
+		ExchangePrivateKeys: map[string][]byte{"alice": newKeys.Private[:]},
+		RetiredExchangeKeys: map[string][][]byte{"alice": {oldKeys.Private[:]}},
+	}}
+
+	contentKey, _ := crypto.GenerateSymmetricKey()
+	for _, pub := range []*[32]byte{oldKeys.Public, newKeys.Public} {
+		ephemeralPub, wrapped, err := crypto.WrapKey(contentKey, pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := keys.UnwrapKey("alice", wrapped, ephemeralPub)
+		if err != nil || !bytes.Equal(got[:], contentKey) {
+			t.Errorf("UnwrapKey = %x (%v), want %x", got, err, contentKey)
+		}
+	}
+
+	other, _ := crypto.GenerateExchangeKeyPair()
+	ephemeralPub, wrapped, _ := crypto.WrapKey(contentKey, other.Public)
+	if _, err := keys.UnwrapKey("alice", wrapped, ephemeralPub); err == nil {
+		t.Error("Expected an error for a key wrapped to someone else")
+	}
+}
+
+func TestLegacyDownloadWithRetiredKey(t *testing.T) {
+	oldKeys, _ := crypto.GenerateExchangeKeyPair()
+	newKeys, _ := crypto.GenerateExchangeKeyPair()
+	senderKeys, _ := crypto.GenerateExchangeKeyPair()
+
+	// A file from an older client, encrypted directly to alice's key from
+	// before she rotated it
+	content := bytes.Repeat([]byte("legacy "), 3*crypto.StreamChunkSize/7)
+	var ciphertext bytes.Buffer
+	if err := crypto.EncryptStream(&ciphertext, bytes.NewReader(content), oldKeys.Public, senderKeys.Private); err != nil {
+		t.Fatal(err)
+	}
+	header, err := transport.EncodeMetadata(models.FileMetadata{ID: "legacy", Sender: "bob", Recipient: "alice", FileName: "old.txt", EncryptedKey: senderKeys.Public[:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(transport.MetadataHeader, header)
+		_, _ = w.Write(ciphertext.Bytes())
+	}))
+	defer ts.Close()
+
+	keys := configKeyStore{cfg: &Config{
+		ExchangePrivateKeys: map[string][]byte{"alice": newKeys.Private[:]},
+		RetiredExchangeKeys: map[string][][]byte{"alice": {oldKeys.Private[:]}},
+	}}
+	client := gosend.New(ts.URL, "alice", keys)
+	client.Tokens = staticTokens{}
+	client.VerifySender = func(context.Context, models.FileMetadata, []byte) error { return nil }
+
+	var got bytes.Buffer
+	d, err := client.Download(context.Background(), "legacy", &got)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), content) || d.Info.Name != "old.txt" {
+		t.Errorf("Expected the file to be decrypted with the retired key, got %d of %d bytes", got.Len(), len(content))
+	}
+}
+
+// staticTokens holds a session that never ends.
+type staticTokens struct{}
+
+func (staticTokens) LoadTokens(string) (gosend.Tokens, bool) {
+	return gosend.Tokens{Access: "token"}, true
+}
+func (staticTokens) SaveTokens(string, gosend.Tokens) error { return nil }
+func (staticTokens) DeleteTokens(string) error              { return nil }
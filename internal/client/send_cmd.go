@@ -1,152 +1,210 @@
 package client
 
 import (
-	"bytes"
-	"encoding/json"
+	"bufio"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/VinMeld/go-send/internal/crypto"
 	"github.com/VinMeld/go-send/internal/models"
+	"github.com/VinMeld/go-send/pkg/gosend"
 	"github.com/spf13/cobra"
 )
 
 func init() {
 	rootCmd.AddCommand(sendFileCmd)
-	sendFileCmd.Flags().Bool("auto-delete", false, "Delete file from server after download")
+	sendFileCmd.Flags().Bool("auto-delete", false, "Delete file from server after download (same as --max-downloads 1)")
+	sendFileCmd.Flags().Int("max-downloads", 0, "Delete file from server after this many downloads (default: no limit)")
+	sendFileCmd.Flags().String("message", "", "Encrypted message to send with the file")
+	sendFileCmd.Flags().Duration("expires", 0, "Delete file from server after this long, e.g. 24h (default: server maximum)")
+	sendFileCmd.Flags().String("compress", compressNone, "Compress before encrypting: none, gzip, or auto to skip already compressed formats")
+	sendFileCmd.Flags().Lookup("compress").NoOptDefVal = compressAuto
+	sendFileCmd.Flags().StringSlice("to", nil, "Recipient, repeatable; all arguments are then paths to send. Needed for several recipients or paths")
+}
+
+// detectMIMEType guesses the MIME type of file from its extension, falling
+// back to sniffing its first bytes. It leaves file positioned at the start.
+func detectMIMEType(file *os.File) (string, error) {
+	if t := mime.TypeByExtension(filepath.Ext(file.Name())); t != "" {
+		return t, nil
+	}
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// openSendSource opens what send-file uploads for the given paths. A single
+// regular file is sent as it is. Directories, globs and several paths are
+// packed into a tar archive in a temporary file, which cleanup removes.
+func openSendSource(args []string) (file *os.File, info crypto.FileInfo, cleanup func(), err error) {
+	paths, err := expandPaths(args)
+	if err != nil {
+		return nil, info, nil, err
+	}
+
+	if len(paths) == 1 {
+		if stat, err := os.Stat(paths[0]); err == nil && stat.Mode().IsRegular() {
+			file, err := os.Open(paths[0])
+			if err != nil {
+				return nil, info, nil, err
+			}
+			mimeType, err := detectMIMEType(file)
+			if err != nil {
+				_ = file.Close()
+				return nil, info, nil, err
+			}
+			info = crypto.FileInfo{Name: filepath.Base(paths[0]), Size: stat.Size(), MIMEType: mimeType}
+			return file, info, func() { _ = file.Close() }, nil
+		}
+	}
+
+	file, err = os.CreateTemp("", "go-send-*.tar")
+	if err != nil {
+		return nil, info, nil, err
+	}
+	cleanup = func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}
+	fmt.Printf("Packing %d path(s) into an archive...\n", len(paths))
+	w := bufio.NewWriter(file)
+	if err := writeArchive(w, paths); err != nil {
+		cleanup()
+		return nil, info, nil, err
+	}
+	if err := w.Flush(); err != nil {
+		cleanup()
+		return nil, info, nil, err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, info, nil, err
+	}
+	info = crypto.FileInfo{Name: archiveName(paths), Size: size, MIMEType: archiveMIMEType, Archive: true}
+	return file, info, cleanup, nil
+}
+
+// sendFileArgs checks the arguments of send-file. Without --to they are an
+// optional recipient and one path, so that a path is never taken for a
+// recipient or the other way round.
+func sendFileArgs(cmd *cobra.Command, args []string) error {
+	if names, _ := cmd.Flags().GetStringSlice("to"); len(names) > 0 {
+		return cobra.MinimumNArgs(1)(cmd, args)
+	}
+	if len(args) > 2 {
+		return fmt.Errorf("expected [recipient] <path>, got %d arguments; use --to for several recipients or paths", len(args))
+	}
+	return cobra.RangeArgs(1, 2)(cmd, args)
 }
 
 var sendFileCmd = &cobra.Command{
-	Use:   "send-file [recipient] <file>",
-	Short: "Send an encrypted file",
-	Args:  cobra.RangeArgs(1, 2),
+	Use:   "send-file [recipient] <path>",
+	Short: "Send an encrypted file, directory or set of files",
+	Args:  sendFileArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		autoDelete, _ := cmd.Flags().GetBool("auto-delete")
-
-		var recipient string
-		var filePath string
-
-		if len(args) == 1 {
-			// send-file <file> -> Recipient is self
-			recipient = cfg.CurrentUsername
-			filePath = args[0]
-		} else {
-			// send-file <recipient> <file>
-			recipient = args[0]
-			filePath = args[1]
+		message, _ := cmd.Flags().GetString("message")
+		compress, _ := cmd.Flags().GetString("compress")
+		expires, _ := cmd.Flags().GetDuration("expires")
+		if expires < 0 {
+			fmt.Println("Expiry must be positive")
+			return
 		}
-
-		if recipient == "" {
-			fmt.Println("Recipient not specified and no current user set")
+		maxDownloads, _ := cmd.Flags().GetInt("max-downloads")
+		if maxDownloads < 0 {
+			fmt.Println("Download limit must not be negative")
 			return
 		}
 
-		// Get Recipient Public Key
-		recipientUser, ok := cfg.Users[recipient]
-		if !ok {
-			fmt.Printf("User '%s' not found locally. Searching on server...\n", recipient)
-			resp, err := http.Get(cfg.ServerURL + "/users?username=" + recipient)
-			if err != nil {
-				fmt.Printf("Error contacting server: %v\n", err)
+		// Without --to, the last argument is the path and the one before
+		// it, if any, the recipient
+		names, _ := cmd.Flags().GetStringSlice("to")
+		paths := args
+		if len(names) == 0 {
+			names = args[:len(args)-1]
+			paths = args[len(args)-1:]
+		}
+		if len(names) == 0 {
+			// send-file <file> -> Recipient is self
+			if cfg.CurrentUsername == "" {
+				fmt.Println("Recipient not specified and no current user set")
 				return
 			}
-			defer func() { _ = resp.Body.Close() }()
-
-			if resp.StatusCode == http.StatusOK {
-				var foundUser models.User
-				if err := json.NewDecoder(resp.Body).Decode(&foundUser); err != nil {
-					fmt.Printf("Error decoding user from server: %v\n", err)
-					return
-				}
-				if len(foundUser.IdentityPublicKey) == 0 || len(foundUser.ExchangePublicKey) == 0 {
-					fmt.Println("Server returned invalid user keys.")
-					return
-				}
-
-				cfg.Users[recipient] = foundUser
-				if err := SaveConfigGlobal(); err != nil {
-					fmt.Printf("Warning: Failed to save user to local config: %v\n", err)
-				} else {
-					fmt.Printf("Found user '%s' and added to address book.\n", recipient)
-				}
-				recipientUser = foundUser
-			} else {
-				fmt.Printf("Unknown user: %s. Add them with 'add-user' first or ensure they are registered.\n", recipient)
+			names = []string{cfg.CurrentUsername}
+		}
+
+		// Get Recipient Public Keys
+		var recipients []models.User
+		seen := make(map[string]bool, len(names))
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			user, err := recipientUser(name)
+			if err != nil {
+				fmt.Println(err)
 				return
 			}
+			recipients = append(recipients, user)
 		}
-		var recipientPub [32]byte
-		copy(recipientPub[:], recipientUser.ExchangePublicKey)
 
-		// Read File
-		fileContent, err := os.ReadFile(filePath)
+		file, info, cleanup, err := openSendSource(paths)
 		if err != nil {
 			fmt.Println("Error reading file:", err)
 			return
 		}
+		defer cleanup()
+		info.Message = message
 
-		fmt.Println("Encrypting file...")
-		ephemeral, err := crypto.GenerateExchangeKeyPair()
+		file, cleanupCompressed, err := compressSource(file, &info, compress)
 		if err != nil {
-			fmt.Println("Error generating ephemeral key:", err)
+			fmt.Println("Error compressing file:", err)
 			return
 		}
+		defer cleanupCompressed()
 
-		encryptedContent, err := crypto.Encrypt(fileContent, &recipientPub, ephemeral.Private)
-		if err != nil {
-			fmt.Println("Error encrypting file:", err)
+		if err := currentKeyStore().Check(cfg.CurrentUsername); err != nil {
+			fmt.Println("Error:", err)
 			return
 		}
 
-		// Upload
-		req := models.UploadRequest{
-			Metadata: models.FileMetadata{
-				Sender:       cfg.CurrentUsername,
-				Recipient:    recipient,
-				FileName:     filepath.Base(filePath),
-				EncryptedKey: ephemeral.Public[:],
-				AutoDelete:   autoDelete,
-			},
-			EncryptedContent: encryptedContent,
+		// The file name travels in the encrypted metadata only
+		opts := gosend.SendOptions{
+			Info:         info,
+			AutoDelete:   autoDelete,
+			MaxDownloads: maxDownloads,
 		}
-
-		data, err := json.Marshal(req)
-		if err != nil {
-			fmt.Println("Error marshaling request:", err)
-			return
+		if expires > 0 {
+			opts.ExpiresAt = time.Now().Add(expires)
 		}
 
-		authHeader, err := GetAuthHeader()
-		if err != nil {
-			fmt.Println("Authentication error:", err)
-			return
-		}
-
-		reqBody, err := http.NewRequest("POST", cfg.ServerURL+"/files", bytes.NewBuffer(data))
-		if err != nil {
-			fmt.Println("Error creating request:", err)
-			return
-		}
-		reqBody.Header.Set("Content-Type", "application/json")
-		reqBody.Header.Set("Authorization", authHeader)
-
-		client := &http.Client{}
-		resp, err := client.Do(reqBody)
-		if err != nil {
-			fmt.Println("Error uploading file:", err)
+		fmt.Println("Encrypting file...")
+		if _, err := apiClient().Send(cmd.Context(), recipients, file, opts); err != nil {
+			fmt.Println("Upload failed:", err)
+			fmt.Println("Run the same command again to resume the upload.")
 			return
 		}
-		defer func() { _ = resp.Body.Close() }()
 
-		if resp.StatusCode != http.StatusCreated {
-			body, _ := io.ReadAll(resp.Body)
-			fmt.Printf("Upload failed: %s\n", string(body))
+		if len(recipients) > 1 {
+			fmt.Printf("File sent successfully to %d recipients!\n", len(recipients))
 			return
 		}
-
 		fmt.Println("File sent successfully!")
 	},
 }
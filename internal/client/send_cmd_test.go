@@ -0,0 +1,32 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestSendFileArgs(t *testing.T) {
+	tests := []struct {
+		to   []string
+		args []string
+		ok   bool
+	}{
+		{nil, []string{"file.txt"}, true},
+		{nil, []string{"bob", "file.txt"}, true},
+		{nil, []string{"bob", "carol", "file.txt"}, false},
+		{nil, nil, false},
+		{[]string{"bob", "carol"}, []string{"a.txt", "b.txt", "c.txt"}, true},
+		{[]string{"bob"}, nil, false},
+	}
+	for _, tt := range tests {
+		cmd := &cobra.Command{}
+		cmd.Flags().StringSlice("to", nil, "")
+		for _, name := range tt.to {
+			_ = cmd.Flags().Set("to", name)
+		}
+		if err := sendFileArgs(cmd, tt.args); (err == nil) != tt.ok {
+			t.Errorf("sendFileArgs(--to %v, %v) = %v, want ok %v", tt.to, tt.args, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(logoutCmd)
+	rootCmd.AddCommand(sessionsCmd)
+	sessionsCmd.AddCommand(sessionsListCmd)
+	sessionsCmd.AddCommand(sessionsRevokeCmd)
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "End the current user's session",
+	Run: func(cmd *cobra.Command, args []string) {
+		me := cfg.CurrentUsername
+		if _, ok := cfg.SessionTokens[me]; me == "" || !ok {
+			fmt.Println("Not logged in.")
+			return
+		}
+
+		// Logging out does not log in again, so it does not need the keys
+		if err := apiClient().Logout(cmd.Context()); err != nil {
+			fmt.Println("Error logging out:", err)
+			return
+		}
+		fmt.Printf("Logged out %s.\n", me)
+	},
+}
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "List and revoke the current user's sessions",
+}
+
+var sessionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the current user's sessions",
+	Run: func(cmd *cobra.Command, args []string) {
+		if cfg.CurrentUsername == "" {
+			fmt.Println("No current user set. Use 'config init' first.")
+			return
+		}
+		sessions, err := apiClient().Sessions(cmd.Context())
+		if err != nil {
+			fmt.Println("Error fetching sessions:", err)
+			return
+		}
+
+		fmt.Printf("Sessions for %s:\n", cfg.CurrentUsername)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  ID\tDEVICE\tCREATED\tLAST USED\tEXPIRES")
+		for _, s := range sessions {
+			marker := " "
+			if s.Current {
+				marker = "*"
+			}
+			device := s.Device
+			if device == "" {
+				device = "-"
+			}
+			fmt.Fprintf(w, "%s %s\t%s\t%s\t%s\t%s\n", marker, s.ID, device,
+				s.CreatedAt.Local().Format(time.RFC822), s.LastUsedAt.Local().Format(time.RFC822), s.ExpiresAt.Local().Format(time.RFC822))
+		}
+		_ = w.Flush()
+	},
+}
+
+var sessionsRevokeCmd = &cobra.Command{
+	Use:   "revoke <session_id>",
+	Short: "End one of the current user's sessions",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := args[0]
+		if cfg.CurrentUsername == "" {
+			fmt.Println("No current user set. Use 'config init' first.")
+			return
+		}
+		if err := apiClient().RevokeSession(cmd.Context(), id); err != nil {
+			fmt.Println("Error revoking session:", err)
+			return
+		}
+		fmt.Printf("Session %s revoked.\n", id)
+	},
+}
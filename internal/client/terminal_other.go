@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package client
+
+import (
+	"errors"
+	"os"
+)
+
+// isTerminal reports whether f is a terminal. Passphrase prompts are not
+// supported on this platform, so it always reports false and the passphrase
+// has to come from GO_SEND_PASSPHRASE.
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+// readPassword is not supported on this platform.
+func readPassword(f *os.File) ([]byte, error) {
+	return nil, errors.New("passphrase prompts are not supported on this platform")
+}
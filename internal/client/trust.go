@@ -0,0 +1,112 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/models"
+)
+
+// userFingerprint returns the fingerprint of a user's public keys.
+func userFingerprint(user models.User) crypto.Fingerprint {
+	return crypto.KeyFingerprint(user.IdentityPublicKey, user.ExchangePublicKey)
+}
+
+// isVerified reports whether the keys of user have been verified with
+// verify-user. The current user's own keys count as verified.
+func isVerified(user models.User) bool {
+	if user.Username == cfg.CurrentUsername {
+		return true
+	}
+	verified, ok := cfg.VerifiedUsers[user.Username]
+	return ok && verified == userFingerprint(user).Hex()
+}
+
+// sameKeys reports whether two copies of a user hold the same keys.
+func sameKeys(a, b models.User) bool {
+	return bytes.Equal(a.IdentityPublicKey, b.IdentityPublicKey) && bytes.Equal(a.ExchangePublicKey, b.ExchangePublicKey)
+}
+
+// recipientUser returns the keys to send to username: the pinned keys, or
+// the server's keys pinned on first use. Pinned keys are checked against the
+// server's and only replaced by a key rotation signed with them. In strict
+// mode, unverified users are refused.
+func recipientUser(username string) (models.User, error) {
+	user, known := cfg.Users[username]
+	if !known {
+		var err error
+		if user, err = lookupUser(username); err != nil {
+			return models.User{}, err
+		}
+	} else if username != cfg.CurrentUsername {
+		user = checkPinnedKeys(user)
+	}
+
+	if cfg.StrictVerification && !isVerified(user) {
+		return models.User{}, fmt.Errorf("refusing to send to unverified user '%s' in strict mode. Compare fingerprints and run 'go-send verify-user %s' first", username, username)
+	}
+	return user, nil
+}
+
+// checkPinnedKeys compares the pinned keys of user with the server's copy
+// and checks the server's copy against its key log. If they differ because
+// the user rotated their keys, the new keys are pinned; otherwise the pinned
+// keys are kept and a warning is printed. The pinned keys are also kept if
+// the server cannot be reached.
+func checkPinnedKeys(user models.User) models.User {
+	server, err := fetchUser(user.Username)
+	if err != nil {
+		return user
+	}
+	inLog := true
+	if err := verifyKeyLog(server); err != nil && !errors.Is(err, errNoKeyLog) {
+		fmt.Printf("WARNING: Key log check for %s failed: %v\n", user.Username, err)
+		fmt.Println("WARNING: The server may be showing you a different directory than other users.")
+		inLog = false
+	}
+	if sameKeys(user, server) {
+		return user
+	}
+
+	// New keys missing from the key log are not pinned, even if signed
+	if history, err := fetchKeyHistory(user.Username); inLog && err == nil {
+		if chain, err := verifyKeyChain(user, history); err == nil && len(chain) > 0 {
+			latest := chain[len(chain)-1]
+			if next := repin(user, latest); sameKeys(next, server) {
+				return next
+			}
+		}
+	}
+
+	fmt.Println("WARNING: THE SERVER'S KEYS FOR THIS USER DO NOT MATCH THE PINNED KEYS!")
+	fmt.Printf("WARNING: User:   %s\n", user.Username)
+	fmt.Printf("WARNING: Pinned: %s\n", userFingerprint(user).Hex())
+	fmt.Printf("WARNING: Server: %s\n", userFingerprint(server).Hex())
+	fmt.Println("WARNING: The server may be trying to intercept your files. The pinned keys are used.")
+	fmt.Printf("WARNING: If %s really has new keys, compare fingerprints with them, then run\n", user.Username)
+	fmt.Printf("WARNING: 'go-send remove-user %s' and send again to pin the new keys.\n", user.Username)
+	return user
+}
+
+// repin replaces the pinned keys of user with the keys of a rotation that
+// verifyKeyChain accepted. A verified user stays verified, since the new
+// keys are vouched for by the old.
+func repin(user models.User, latest models.KeyRotation) models.User {
+	next := models.User{
+		Username:          user.Username,
+		IdentityPublicKey: latest.IdentityPublicKey,
+		ExchangePublicKey: latest.ExchangePublicKey,
+	}
+	if isVerified(user) {
+		cfg.VerifiedUsers[user.Username] = userFingerprint(next).Hex()
+	}
+	cfg.Users[user.Username] = next
+	if err := SaveConfigGlobal(); err != nil {
+		fmt.Printf("Warning: Failed to save user to local config: %v\n", err)
+	} else {
+		fmt.Printf("User '%s' has rotated their keys. Address book updated.\n", user.Username)
+	}
+	return next
+}
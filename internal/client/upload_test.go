@@ -0,0 +1,131 @@
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/models"
+)
+
+// uploadServer is a mock server that keeps upload session state and can be
+// told to reject a chunk.
+type uploadServer struct {
+	mu        sync.Mutex
+	chunks    map[int64][]byte
+	puts      []int64
+	failIndex int64
+	completed bool
+}
+
+func (s *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/v1/users":
+		w.WriteHeader(http.StatusCreated)
+	case "/v1/auth/challenge":
+		_ = json.NewEncoder(w).Encode(models.AuthChallenge{Username: r.URL.Query().Get("username"), Nonce: "nonce", Origin: "http://" + r.Host})
+	case "/v1/auth/login":
+		_ = json.NewEncoder(w).Encode(models.Session{Token: "token"})
+	case "/v1/uploads":
+		session := models.UploadSession{ID: "upload1", ChunkSize: 1024, Chunks: []models.UploadChunk{}}
+		if r.Method == "POST" {
+			s.chunks = make(map[int64][]byte)
+			w.WriteHeader(http.StatusCreated)
+		}
+		for i, c := range s.chunks {
+			session.Chunks = append(session.Chunks, models.UploadChunk{Index: i, Size: int64(len(c))})
+		}
+		_ = json.NewEncoder(w).Encode(session)
+	case "/v1/uploads/chunk":
+		index, _ := strconv.ParseInt(r.URL.Query().Get("index"), 10, 64)
+		s.puts = append(s.puts, index)
+		if index == s.failIndex {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		s.chunks[index], _ = io.ReadAll(r.Body)
+	case "/v1/uploads/complete":
+		s.completed = true
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode([]models.FileMetadata{{ID: "file1"}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSendFileResumesUpload(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "go-send-upload-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	oldDelay := retryDelay
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = oldDelay }()
+
+	srv := &uploadServer{failIndex: 1}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	_, _ = runCmd(t, tmpDir, "config", "init", "--user", "alice", "--server", ts.URL)
+	_, _ = runCmd(t, tmpDir, "register", "--token", "any")
+
+	testFile := filepath.Join(tmpDir, "big.txt")
+	_ = os.WriteFile(testFile, []byte(strings.Repeat("x", 3000)), 0644)
+
+	// First attempt fails on chunk 1 after retrying it
+	output, _ := runCmd(t, tmpDir, "send-file", "alice", testFile)
+	if !strings.Contains(output, "Upload failed") {
+		t.Fatalf("Expected upload failure, got: %s", output)
+	}
+	if len(srv.puts) != 1+3 {
+		t.Errorf("Expected chunk 0 and 3 attempts at chunk 1, got %v", srv.puts)
+	}
+	if len(cfg.PendingUploads) != 1 {
+		t.Fatalf("Expected pending upload to be recorded, got %d", len(cfg.PendingUploads))
+	}
+
+	// Only the seed of the content key is saved; the key is derived again
+	for _, pending := range cfg.PendingUploads {
+		sig, _ := configKeyStore{cfg: cfg}.Sign("alice", crypto.UploadKeyMessage(pending.KeySeed))
+		key := crypto.UploadKey(sig)
+		saved, _ := os.ReadFile(filepath.Join(tmpDir, "config.json"))
+		if len(pending.KeySeed) == 0 || bytes.Contains(saved, []byte(base64.StdEncoding.EncodeToString(key[:]))) {
+			t.Error("Expected the content key not to be saved")
+		}
+	}
+
+	// Second attempt skips the chunk the server already has
+	srv.failIndex = -1
+	srv.puts = nil
+	output, _ = runCmd(t, tmpDir, "send-file", "alice", testFile)
+	if !strings.Contains(output, "Resuming upload") || !strings.Contains(output, "File sent successfully") {
+		t.Fatalf("Expected resumed upload, got: %s", output)
+	}
+	for _, index := range srv.puts {
+		if index == 0 {
+			t.Errorf("Chunk 0 was uploaded again: %v", srv.puts)
+		}
+	}
+	if !srv.completed {
+		t.Error("Upload was not completed")
+	}
+	if len(cfg.PendingUploads) != 0 {
+		t.Errorf("Pending upload should be cleared, got %d", len(cfg.PendingUploads))
+	}
+}
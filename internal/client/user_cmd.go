@@ -1,14 +1,14 @@
 package client
 
 import (
+	"context"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 
 	"github.com/VinMeld/go-send/internal/crypto"
 	"github.com/VinMeld/go-send/internal/models"
+	"github.com/VinMeld/go-send/pkg/gosend"
 	"github.com/spf13/cobra"
 )
 
@@ -16,6 +16,8 @@ func init() {
 	rootCmd.AddCommand(configCmd)
 	configCmd.AddCommand(configInitCmd)
 	configCmd.AddCommand(configPathCmd)
+	configCmd.AddCommand(configChangePassphraseCmd)
+	configCmd.AddCommand(configProtectCmd)
 	rootCmd.AddCommand(setUserCmd)
 	rootCmd.AddCommand(setServerCmd)
 	rootCmd.AddCommand(addUserCmd)
@@ -53,6 +55,16 @@ var configInitCmd = &cobra.Command{
 			return
 		}
 
+		if err := unlockKeys(); err != nil {
+			fmt.Println("Error unlocking private keys:", err)
+			return
+		}
+		// Replacing the keys here would orphan files already sent to them
+		if _, ok := cfg.IdentityPrivateKeys[username]; ok {
+			fmt.Printf("Keys for user %s already exist. Use 'rotate-keys' to replace them.\n", username)
+			return
+		}
+
 		// Generate Identity Keys (Ed25519)
 		idKeys, err := crypto.GenerateIdentityKeyPair()
 		if err != nil {
@@ -81,6 +93,10 @@ var configInitCmd = &cobra.Command{
 			cfg.ServerURL = serverURL
 		}
 
+		if _, err := protectKeys(); err != nil {
+			fmt.Println("Error encrypting private keys:", err)
+			return
+		}
 		if err := SaveConfigGlobal(); err != nil {
 			fmt.Println("Error saving config:", err)
 			return
@@ -102,12 +118,59 @@ var configPathCmd = &cobra.Command{
 	},
 }
 
+var configChangePassphraseCmd = &cobra.Command{
+	Use:   "change-passphrase",
+	Short: "Change the passphrase protecting your private keys",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := unlockKeystore(); err != nil {
+			fmt.Println("Error unlocking private keys:", err)
+			return
+		}
+		passphrase, err := newPassphrase(newPassphraseEnv, "New passphrase: ")
+		if err != nil {
+			fmt.Println("Error reading new passphrase:", err)
+			return
+		}
+		if err := cfg.setPassphrase(passphrase); err != nil {
+			fmt.Println("Error changing passphrase:", err)
+			return
+		}
+		if err := SaveConfigGlobal(); err != nil {
+			fmt.Println("Error saving config:", err)
+			return
+		}
+		fmt.Println("Passphrase changed.")
+	},
+}
+
+var configProtectCmd = &cobra.Command{
+	Use:   "protect",
+	Short: "Encrypt plaintext private keys with a passphrase",
+	Run: func(cmd *cobra.Command, args []string) {
+		if cfg.Keystore != nil {
+			fmt.Println("Private keys are already encrypted.")
+			return
+		}
+		if !cfg.hasPrivateKeys() {
+			fmt.Println("No private keys to encrypt.")
+			return
+		}
+		if err := migrateKeys(); err != nil {
+			fmt.Println("Error encrypting private keys:", err)
+		}
+	},
+}
+
 var setUserCmd = &cobra.Command{
 	Use:   "set-user <username>",
 	Short: "Set current active user",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		username := args[0]
+		if err := unlockKeys(); err != nil {
+			fmt.Println("Error unlocking private keys:", err)
+			return
+		}
 		if _, ok := cfg.IdentityPrivateKeys[username]; !ok {
 			fmt.Printf("User %s not found in local config (no private key)\n", username)
 			return
@@ -160,33 +223,31 @@ var listUsersCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("Local Users:")
 		for _, u := range cfg.Users {
-			fmt.Printf("- %s\n", u.Username)
+			status := "not verified"
+			if isVerified(u) {
+				status = "verified"
+			}
+			fmt.Printf("- %s (%s)\n", u.Username, status)
 		}
 
 		if cfg.ServerURL != "" {
 			fmt.Printf("\nServer Users (%s):\n", cfg.ServerURL)
-			resp, err := http.Get(cfg.ServerURL + "/users")
+			users, err := apiClient().Users(cmd.Context())
 			if err != nil {
 				fmt.Printf("Error fetching users from server: %v\n", err)
 				return
 			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				fmt.Printf("Server returned status: %d\n", resp.StatusCode)
-				return
-			}
-
-			var users []models.User
-			if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
-				fmt.Printf("Error decoding server response: %v\n", err)
-				return
-			}
 
 			for _, u := range users {
 				fmt.Printf("- %s\n", u.Username)
 				fmt.Printf("  Identity: %s\n", base64.StdEncoding.EncodeToString(u.IdentityPublicKey))
 				fmt.Printf("  Exchange: %s\n", base64.StdEncoding.EncodeToString(u.ExchangePublicKey))
+				if pinned, ok := cfg.Users[u.Username]; ok && !sameKeys(pinned, u) {
+					fmt.Println("  WARNING: These keys do not match the pinned keys!")
+				}
+				if err := verifyKeyLog(u); err != nil && !errors.Is(err, errNoKeyLog) {
+					fmt.Printf("  WARNING: Key log check failed: %v\n", err)
+				}
 			}
 		}
 	},
@@ -207,39 +268,16 @@ var removeUserCmd = &cobra.Command{
 				return
 			}
 
-			// Get auth header
-			authHeader, err := GetAuthHeader()
-			if err != nil {
-				fmt.Printf("Authentication error: %v\n", err)
-				return
-			}
-
-			// Send DELETE request
-			req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/users?username=%s", cfg.ServerURL, username), nil)
-			if err != nil {
-				fmt.Println("Error creating request:", err)
-				return
-			}
-			req.Header.Set("Authorization", authHeader)
-
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
+			if err := apiClient().DeleteUser(cmd.Context(), username); err != nil {
 				fmt.Println("Error deleting user:", err)
 				return
 			}
-			defer func() { _ = resp.Body.Close() }()
-
-			if resp.StatusCode != http.StatusOK {
-				body, _ := io.ReadAll(resp.Body)
-				fmt.Printf("Server error: %s\n", string(body))
-				return
-			}
 
 			fmt.Printf("User %s deleted from server successfully!\n", username)
 		} else {
 			// Delete locally
 			delete(cfg.Users, username)
+			delete(cfg.VerifiedUsers, username)
 			if err := SaveConfigGlobal(); err != nil {
 				fmt.Println("Error saving config:", err)
 				return
@@ -254,3 +292,43 @@ func init() {
 	configInitCmd.Flags().String("server", "", "Server URL")
 	removeUserCmd.Flags().Bool("remote", false, "Delete user from server (requires authentication)")
 }
+
+// lookupUser returns a user from the local address book, fetching and
+// pinning their keys from the server if they are not known yet. Fetched keys
+// are only pinned if the server's key log vouches for them.
+func lookupUser(username string) (models.User, error) {
+	if user, ok := cfg.Users[username]; ok {
+		return user, nil
+	}
+
+	fmt.Printf("User '%s' not found locally. Searching on server...\n", username)
+	foundUser, err := fetchUser(username)
+	if err != nil {
+		return models.User{}, err
+	}
+	if err := verifyKeyLog(foundUser); errors.Is(err, errNoKeyLog) {
+		fmt.Printf("Warning: %v; the keys of %s cannot be audited.\n", err, username)
+	} else if err != nil {
+		return models.User{}, fmt.Errorf("refusing to pin keys for %s: %w", username, err)
+	}
+
+	cfg.Users[username] = foundUser
+	if err := SaveConfigGlobal(); err != nil {
+		fmt.Printf("Warning: Failed to save user to local config: %v\n", err)
+	} else {
+		fmt.Printf("Found user '%s' and added to address book.\n", username)
+	}
+	fmt.Printf("Their keys are now pinned. Fingerprint: %s\n", userFingerprint(foundUser).Hex())
+	fmt.Printf("Compare it with %s and run 'go-send verify-user %s' to mark them verified.\n", username, username)
+	return foundUser, nil
+}
+
+// fetchUser returns the keys the server has for username.
+func fetchUser(username string) (models.User, error) {
+	user, err := apiClient().LookupUser(context.Background(), username)
+	var serverErr *gosend.Error
+	if errors.As(err, &serverErr) {
+		return models.User{}, fmt.Errorf("unknown user: %s. Add them with 'add-user' first or ensure they are registered", username)
+	}
+	return user, err
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/models"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(fingerprintCmd)
+	rootCmd.AddCommand(verifyUserCmd)
+	configCmd.AddCommand(configStrictCmd)
+}
+
+// printFingerprint shows the fingerprint of user in every form.
+func printFingerprint(user models.User) {
+	f := userFingerprint(user)
+	fmt.Printf("Fingerprint of %s:\n", user.Username)
+	fmt.Printf("  Hex:   %s\n", f.Hex())
+	fmt.Printf("  Words: %s\n", f.Words())
+	fmt.Printf("  QR:    %s\n", f.QR())
+}
+
+var fingerprintCmd = &cobra.Command{
+	Use:   "fingerprint [username]",
+	Short: "Show the key fingerprint of a user (default: current user)",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := cfg.CurrentUsername
+		if len(args) > 0 {
+			username = args[0]
+		}
+		if username == "" {
+			fmt.Println("No current user set. Use 'config init' first.")
+			return
+		}
+		user, err := lookupUser(username)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		printFingerprint(user)
+		if isVerified(user) {
+			fmt.Println("Status: verified")
+		} else {
+			fmt.Println("Status: not verified")
+		}
+	},
+}
+
+var verifyUserCmd = &cobra.Command{
+	Use:   "verify-user <username> [fingerprint]",
+	Short: "Mark a user's keys as verified by comparing fingerprints",
+	Long: `Compare the fingerprint of a user's pinned keys with the one they see with
+'go-send fingerprint', over a channel the server cannot tamper with. Pass the
+fingerprint they read out, in hex, words or QR form, or confirm the match when
+asked.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+		user, known := cfg.Users[username]
+		if known {
+			user = checkPinnedKeys(user)
+		} else {
+			var err error
+			if user, err = lookupUser(username); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+
+		if len(args) > 1 {
+			theirs, err := crypto.ParseFingerprint(args[1])
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			if theirs != userFingerprint(user) {
+				fmt.Printf("WARNING: FINGERPRINT MISMATCH! The pinned keys for '%s' are not the keys they have.\n", username)
+				printFingerprint(user)
+				fmt.Println("Do not send them anything until you find out why.")
+				return
+			}
+		} else {
+			printFingerprint(user)
+			confirmed, err := confirm(fmt.Sprintf("Does this match the fingerprint %s sees? [y/N]: ", username))
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			if !confirmed {
+				fmt.Printf("%s not verified.\n", username)
+				return
+			}
+		}
+
+		cfg.VerifiedUsers[username] = userFingerprint(user).Hex()
+		if err := SaveConfigGlobal(); err != nil {
+			fmt.Println("Error saving config:", err)
+			return
+		}
+		fmt.Printf("User %s verified.\n", username)
+	},
+}
+
+// confirm asks a yes or no question on the terminal.
+func confirm(prompt string) (bool, error) {
+	if !isTerminal(os.Stdin) {
+		return false, errors.New("not a terminal: pass the fingerprint as an argument instead")
+	}
+	fmt.Print(prompt)
+	answer, err := readLine(os.Stdin)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(string(answer))) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+var configStrictCmd = &cobra.Command{
+	Use:       "strict [on|off]",
+	Short:     "Show or set whether files may only be sent to verified users",
+	Args:      cobra.MaximumNArgs(1),
+	ValidArgs: []string{"on", "off"},
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			if cfg.StrictVerification {
+				fmt.Println("Strict mode is on: files are only sent to verified users.")
+			} else {
+				fmt.Println("Strict mode is off.")
+			}
+			return
+		}
+		switch args[0] {
+		case "on":
+			cfg.StrictVerification = true
+		case "off":
+			cfg.StrictVerification = false
+		default:
+			fmt.Println("Expected 'on' or 'off'")
+			return
+		}
+		if err := SaveConfigGlobal(); err != nil {
+			fmt.Println("Error saving config:", err)
+			return
+		}
+		fmt.Printf("Strict mode %s.\n", args[0])
+	},
+}
@@ -79,6 +79,23 @@ func Decrypt(encrypted []byte, senderPub *[32]byte, recipientPriv *[32]byte) ([]
 	return decrypted, nil
 }
 
+// DecryptWithSharedKey is like Decrypt but takes a shared key precomputed
+// with StreamKey.
+func DecryptWithSharedKey(encrypted []byte, sharedKey *[32]byte) ([]byte, error) {
+	if len(encrypted) < 24 {
+		return nil, errors.New("message too short")
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], encrypted[:24])
+
+	decrypted, ok := box.OpenAfterPrecomputation(nil, encrypted[24:], &nonce, sharedKey)
+	if !ok {
+		return nil, errors.New("decryption failed")
+	}
+	return decrypted, nil
+}
+
 // GenerateSymmetricKey generates a random 32-byte key.
 func GenerateSymmetricKey() ([]byte, error) {
 	key := make([]byte, 32)
@@ -87,3 +104,32 @@ func GenerateSymmetricKey() ([]byte, error) {
 	}
 	return key, nil
 }
+
+// WrapKey seals a symmetric key for a recipient under a fresh ephemeral
+// X25519 key pair. It returns the ephemeral public key, which the recipient
+// needs to unwrap, and the sealed key.
+func WrapKey(key []byte, recipientPub *[32]byte) (*[32]byte, []byte, error) {
+	ephemeral, err := GenerateExchangeKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := Encrypt(key, recipientPub, ephemeral.Private)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ephemeral.Public, wrapped, nil
+}
+
+// UnwrapKey recovers a 32-byte key sealed with WrapKey.
+func UnwrapKey(wrapped []byte, ephemeralPub *[32]byte, recipientPriv *[32]byte) (*[32]byte, error) {
+	key, err := Decrypt(wrapped, ephemeralPub, recipientPriv)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, errors.New("invalid key length")
+	}
+	var k [32]byte
+	copy(k[:], key)
+	return &k, nil
+}
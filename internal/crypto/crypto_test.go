@@ -70,6 +70,12 @@ func TestEncryptDecrypt(t *testing.T) {
 	if !bytes.Equal(message, decrypted) {
 		t.Errorf("Decrypted message does not match original.\nGot: %s\nWant: %s", decrypted, message)
 	}
+
+	// Bob decrypts with a precomputed shared key
+	decrypted, err = DecryptWithSharedKey(encrypted, StreamKey(alice.Public, bob.Private))
+	if err != nil || !bytes.Equal(message, decrypted) {
+		t.Errorf("DecryptWithSharedKey failed: %v", err)
+	}
 }
 
 func TestDecryptFailure(t *testing.T) {
@@ -96,3 +102,25 @@ func TestGenerateSymmetricKey(t *testing.T) {
 		t.Errorf("Expected key length 32, got %d", len(key))
 	}
 }
+
+func TestWrapUnwrapKey(t *testing.T) {
+	bob, _ := GenerateExchangeKeyPair()
+	eve, _ := GenerateExchangeKeyPair()
+	key, _ := GenerateSymmetricKey()
+
+	ephemeralPub, wrapped, err := WrapKey(key, bob.Public)
+	if err != nil {
+		t.Fatalf("WrapKey failed: %v", err)
+	}
+	unwrapped, err := UnwrapKey(wrapped, ephemeralPub, bob.Private)
+	if err != nil {
+		t.Fatalf("UnwrapKey failed: %v", err)
+	}
+	if !bytes.Equal(unwrapped[:], key) {
+		t.Error("Unwrapped key does not match")
+	}
+
+	if _, err := UnwrapKey(wrapped, ephemeralPub, eve.Private); err == nil {
+		t.Error("Expected unwrap failure for wrong private key, got nil")
+	}
+}
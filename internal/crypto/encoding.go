@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Domains start the encoding of everything signed or hashed under a
+// long-lived key. Each use has its own, so that a signature made for one
+// can never be passed off as another, such as a login as a manifest.
+const (
+	manifestDomain    = "go-send transfer manifest v2"
+	keyRotationDomain = "go-send key rotation v1"
+	loginDomain       = "go-send login v1"
+	uploadKeyDomain   = "go-send upload key v1"
+	keyLogEntryDomain = "go-send key log entry v1"
+	treeHeadDomain    = "go-send key log tree head v1"
+	accessTokenDomain = "go-send access token v1"
+	fingerprintDomain = "go-send key fingerprint v1"
+)
+
+// encoder builds a canonical encoding. Byte fields are length-prefixed and
+// numbers are fixed-size, so no two sequences of fields encode the same way.
+type encoder []byte
+
+// newEncoder returns an encoder that starts with domain.
+func newEncoder(domain string) encoder {
+	return encoder(nil).text(domain)
+}
+
+// field appends a length-prefixed byte field.
+func (e encoder) field(v []byte) encoder {
+	e = binary.BigEndian.AppendUint32(e, uint32(len(v)))
+	return append(e, v...)
+}
+
+// text appends a length-prefixed string.
+func (e encoder) text(s string) encoder {
+	e = binary.BigEndian.AppendUint32(e, uint32(len(s)))
+	return append(e, s...)
+}
+
+// number appends a 64-bit big-endian number.
+func (e encoder) number(v uint64) encoder {
+	return binary.BigEndian.AppendUint64(e, v)
+}
+
+// unix appends a time as whole seconds since the Unix epoch.
+func (e encoder) unix(t time.Time) encoder {
+	return e.number(uint64(t.Unix()))
+}
@@ -0,0 +1,26 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEncoder(t *testing.T) {
+	got := newEncoder("d").field([]byte("ab")).text("c").number(7).unix(time.Unix(9, 0))
+	want := []byte{
+		0, 0, 0, 1, 'd',
+		0, 0, 0, 2, 'a', 'b',
+		0, 0, 0, 1, 'c',
+		0, 0, 0, 0, 0, 0, 0, 7,
+		0, 0, 0, 0, 0, 0, 0, 9,
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Unexpected encoding: %v", []byte(got))
+	}
+
+	// Moving bytes between fields changes the encoding
+	if bytes.Equal(newEncoder("d").text("ab").text("c"), newEncoder("d").text("a").text("bc")) {
+		t.Error("Different fields encoded the same way")
+	}
+}
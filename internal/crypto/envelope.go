@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// envelopeAD separates sealed metadata from stream chunks, which are sealed
+// with the same key.
+var envelopeAD = []byte("go-send metadata v1")
+
+// ErrEnvelopeAuth is returned when a metadata envelope fails authentication.
+var ErrEnvelopeAuth = errors.New("metadata authentication failed")
+
+// FileInfo is the file metadata only the sender and recipient can read. It
+// travels sealed in an envelope so the server stores nothing but opaque bytes.
+type FileInfo struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	MIMEType string `json:"mime_type,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Archive  bool   `json:"archive,omitempty"` // Name is a tar archive of several files
+	// Compression is the algorithm the plaintext was compressed with before
+	// encryption, if any. Size is the size before compression.
+	Compression string `json:"compression,omitempty"`
+}
+
+// SealFileInfo encrypts info with the stream key of a transfer. The
+// envelope is a random nonce followed by the XChaCha20-Poly1305 ciphertext.
+func SealFileInfo(info FileInfo, key *[32]byte) ([]byte, error) {
+	plaintext, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, envelopeAD), nil
+}
+
+// OpenFileInfo decrypts an envelope produced by SealFileInfo.
+func OpenFileInfo(envelope []byte, key *[32]byte) (FileInfo, error) {
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if len(envelope) < aead.NonceSize()+aead.Overhead() {
+		return FileInfo{}, ErrEnvelopeAuth
+	}
+	nonce, ciphertext := envelope[:aead.NonceSize()], envelope[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, envelopeAD)
+	if err != nil {
+		return FileInfo{}, ErrEnvelopeAuth
+	}
+	var info FileInfo
+	if err := json.Unmarshal(plaintext, &info); err != nil {
+		return FileInfo{}, err
+	}
+	return info, nil
+}
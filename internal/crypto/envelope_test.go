@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSealOpenFileInfo(t *testing.T) {
+	sender, _ := GenerateExchangeKeyPair()
+	recipient, _ := GenerateExchangeKeyPair()
+	key := StreamKey(recipient.Public, sender.Private)
+
+	info := FileInfo{Name: "report.pdf", Size: 12345, MIMEType: "application/pdf", Message: "Q3 numbers"}
+	envelope, err := SealFileInfo(info, key)
+	if err != nil {
+		t.Fatalf("SealFileInfo failed: %v", err)
+	}
+	if strings.Contains(string(envelope), "report.pdf") {
+		t.Error("Envelope leaks the file name")
+	}
+
+	// The recipient derives the same key from the sender's public half
+	got, err := OpenFileInfo(envelope, StreamKey(sender.Public, recipient.Private))
+	if err != nil {
+		t.Fatalf("OpenFileInfo failed: %v", err)
+	}
+	if got != info {
+		t.Errorf("Expected %+v, got %+v", info, got)
+	}
+
+	other, _ := GenerateExchangeKeyPair()
+	if _, err := OpenFileInfo(envelope, StreamKey(sender.Public, other.Private)); !errors.Is(err, ErrEnvelopeAuth) {
+		t.Errorf("Expected ErrEnvelopeAuth for wrong key, got %v", err)
+	}
+
+	tampered := append([]byte(nil), envelope...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := OpenFileInfo(tampered, key); !errors.Is(err, ErrEnvelopeAuth) {
+		t.Errorf("Expected ErrEnvelopeAuth for tampered envelope, got %v", err)
+	}
+	if _, err := OpenFileInfo(envelope[:10], key); !errors.Is(err, ErrEnvelopeAuth) {
+		t.Errorf("Expected ErrEnvelopeAuth for short envelope, got %v", err)
+	}
+}
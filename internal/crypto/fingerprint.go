@@ -0,0 +1,147 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// fingerprintQRPrefix starts the QR form of a fingerprint. It uses only
+// characters of the QR alphanumeric mode, like the base32 that follows.
+const fingerprintQRPrefix = "GOSEND1:"
+
+// FingerprintSize is the length of a fingerprint in bytes.
+const FingerprintSize = 16
+
+// ErrInvalidFingerprint is returned when a fingerprint cannot be parsed.
+var ErrInvalidFingerprint = errors.New("invalid fingerprint")
+
+// Fingerprint is a short hash of a user's identity and exchange public keys
+// that two people can compare out of band.
+type Fingerprint [FingerprintSize]byte
+
+// KeyFingerprint returns the fingerprint of a user's public keys. Both keys
+// are covered, since a substituted exchange key exposes files as surely as a
+// substituted identity key.
+func KeyFingerprint(identityPublicKey, exchangePublicKey []byte) Fingerprint {
+	sum := sha256.Sum256(newEncoder(fingerprintDomain).field(identityPublicKey).field(exchangePublicKey))
+	var f Fingerprint
+	copy(f[:], sum[:])
+	return f
+}
+
+// Hex returns the fingerprint as groups of four hex digits.
+func (f Fingerprint) Hex() string {
+	s := strings.ToUpper(hex.EncodeToString(f[:]))
+	groups := make([]string, 0, len(s)/4)
+	for i := 0; i < len(s); i += 4 {
+		groups = append(groups, s[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+// Words returns the fingerprint as one word per byte, for reading aloud.
+func (f Fingerprint) Words() string {
+	words := make([]string, len(f))
+	for i, b := range f {
+		words[i] = fingerprintWords[b]
+	}
+	return strings.Join(words, " ")
+}
+
+// QR returns the fingerprint as a string suitable for a QR code.
+func (f Fingerprint) QR() string {
+	return fingerprintQRPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(f[:])
+}
+
+// ParseFingerprint parses a fingerprint in any of the forms returned by
+// Hex, Words and QR. Case and spacing are ignored.
+func ParseFingerprint(s string) (Fingerprint, error) {
+	var f Fingerprint
+	s = strings.TrimSpace(s)
+
+	if upper := strings.ToUpper(s); strings.HasPrefix(upper, fingerprintQRPrefix) {
+		b, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(upper[len(fingerprintQRPrefix):])
+		if err != nil || len(b) != FingerprintSize {
+			return f, ErrInvalidFingerprint
+		}
+		copy(f[:], b)
+		return f, nil
+	}
+
+	if f, ok := parseFingerprintWords(strings.Fields(strings.ToLower(s))); ok {
+		return f, nil
+	}
+
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil || len(b) != FingerprintSize {
+		return f, ErrInvalidFingerprint
+	}
+	copy(f[:], b)
+	return f, nil
+}
+
+// parseFingerprintWords parses the words of a fingerprint.
+func parseFingerprintWords(words []string) (Fingerprint, bool) {
+	var f Fingerprint
+	if len(words) != FingerprintSize {
+		return f, false
+	}
+	for i, word := range words {
+		b, ok := fingerprintWordIndex[word]
+		if !ok {
+			return f, false
+		}
+		f[i] = b
+	}
+	return f, true
+}
+
+// fingerprintWordIndex maps each word of fingerprintWords to its byte.
+var fingerprintWordIndex = func() map[string]byte {
+	m := make(map[string]byte, len(fingerprintWords))
+	for i, w := range fingerprintWords {
+		m[w] = byte(i)
+	}
+	return m
+}()
+
+// fingerprintWords has one distinct word for each byte value.
+var fingerprintWords = [256]string{
+	"acid", "acorn", "actor", "adult", "agent", "alarm", "album", "alert",
+	"alley", "alpha", "amber", "angle", "ankle", "apple", "april", "apron",
+	"arena", "armor", "arrow", "aspen", "atlas", "attic", "audio", "award",
+	"axis", "bacon", "badge", "baker", "bamboo", "banjo", "barn", "basil",
+	"basin", "beach", "beard", "beast", "berry", "bison", "blade", "blank",
+	"blaze", "bloom", "board", "bonus", "boots", "brain", "brass", "bread",
+	"brick", "bridge", "broom", "brush", "bucket", "buddy", "bugle", "cabin",
+	"cable", "cactus", "camel", "candle", "canoe", "canyon", "cargo",
+	"carpet", "castle", "cedar", "chalk", "chart", "cherry", "chess", "chief",
+	"cider", "cinema", "circle", "clamp", "claw", "cliff", "cloud", "clover",
+	"coach", "cobra", "cocoa", "comet", "coral", "cotton", "crane", "crater",
+	"crayon", "cricket", "crown", "crystal", "cube", "curtain", "daisy",
+	"dance", "delta", "denim", "desert", "diary", "dock", "dolphin", "donkey",
+	"dragon", "drum", "dune", "eagle", "easel", "echo", "eclipse", "elbow",
+	"elder", "ember", "emerald", "engine", "fabric", "falcon", "feather",
+	"fence", "ferry", "fiddle", "flame", "flask", "fleet", "flint", "flute",
+	"focus", "forest", "fossil", "fox", "frame", "frost", "fudge", "galaxy",
+	"garden", "garlic", "gecko", "geyser", "ghost", "giant", "ginger",
+	"glacier", "globe", "glove", "gorilla", "grain", "granite", "grape",
+	"gravel", "guitar", "hammer", "harbor", "harvest", "hazel", "helmet",
+	"heron", "honey", "hornet", "hotel", "igloo", "indigo", "iris", "island",
+	"ivory", "jacket", "jaguar", "jasmine", "jelly", "jewel", "jigsaw",
+	"juice", "jungle", "kayak", "kettle", "kiwi", "koala", "ladder", "lagoon",
+	"lantern", "laser", "lemon", "lentil", "lilac", "lime", "linen", "lizard",
+	"llama", "lobster", "locket", "lotus", "magnet", "mango", "maple",
+	"marble", "meadow", "melon", "meteor", "mint", "mirror", "mocha",
+	"monkey", "mosaic", "moss", "motor", "muffin", "nectar", "needle",
+	"nickel", "noodle", "nutmeg", "oasis", "ocean", "olive", "onion", "opal",
+	"orbit", "orchid", "otter", "oyster", "paddle", "panda", "panther",
+	"paper", "parrot", "pasta", "peach", "pebble", "pepper", "piano",
+	"pickle", "pilot", "planet", "plaza", "plum", "pocket", "polar", "poppy",
+	"potato", "prism", "pumpkin", "puzzle", "quartz", "quill", "rabbit",
+	"radar", "radio", "raven", "reef", "ribbon", "rocket", "ruby", "saddle",
+	"salmon", "violet", "walrus", "yogurt", "zebra",
+}
@@ -0,0 +1,43 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	id, _ := GenerateIdentityKeyPair()
+	ex, _ := GenerateExchangeKeyPair()
+	other, _ := GenerateExchangeKeyPair()
+
+	f := KeyFingerprint(id.Public, ex.Public[:])
+	if f != KeyFingerprint(id.Public, ex.Public[:]) {
+		t.Fatal("Fingerprint is not deterministic")
+	}
+	if f == KeyFingerprint(id.Public, other.Public[:]) {
+		t.Error("Fingerprint does not cover the exchange key")
+	}
+
+	for _, s := range []string{
+		f.Hex(),
+		strings.ToLower(strings.ReplaceAll(f.Hex(), " ", "")),
+		f.Words(),
+		strings.ToUpper(f.Words()),
+		f.QR(),
+		strings.ToLower(f.QR()),
+	} {
+		got, err := ParseFingerprint(s)
+		if err != nil || got != f {
+			t.Errorf("ParseFingerprint(%q) = %x, %v", s, got, err)
+		}
+	}
+	if len(strings.Fields(f.Words())) != FingerprintSize {
+		t.Errorf("Expected %d words, got %q", FingerprintSize, f.Words())
+	}
+
+	for _, s := range []string{"", "1234", f.Hex() + " 00", "GOSEND1:!!", strings.Repeat("nope ", FingerprintSize)} {
+		if _, err := ParseFingerprint(s); err == nil {
+			t.Errorf("Expected an error parsing %q", s)
+		}
+	}
+}
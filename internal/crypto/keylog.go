@@ -0,0 +1,60 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"time"
+)
+
+// KeyLogEntry is a leaf of the server's key transparency log, recording the
+// keys a user registered (version 0) or rotated to.
+type KeyLogEntry struct {
+	Username          string
+	Version           int64
+	IdentityPublicKey []byte
+	ExchangePublicKey []byte
+}
+
+// Bytes returns the canonical encoding of the entry that is hashed into the
+// log.
+func (e KeyLogEntry) Bytes() []byte {
+	return newEncoder(keyLogEntryDomain).
+		text(e.Username).
+		number(uint64(e.Version)).
+		field(e.IdentityPublicKey).
+		field(e.ExchangePublicKey)
+}
+
+// LeafHash returns the Merkle leaf hash of the entry.
+func (e KeyLogEntry) LeafHash() []byte {
+	return MerkleLeafHash(e.Bytes())
+}
+
+// TreeHead is the state of the key log at a point in time, as signed by
+// the server. Clients that keep the heads they have seen can tell when the
+// server shows them a log that is not an extension of an earlier one.
+type TreeHead struct {
+	TreeSize  uint64
+	RootHash  []byte
+	Timestamp time.Time
+}
+
+// Bytes returns the canonical encoding of the tree head that is signed.
+func (h TreeHead) Bytes() []byte {
+	return newEncoder(treeHeadDomain).
+		number(h.TreeSize).
+		field(h.RootHash).
+		unix(h.Timestamp)
+}
+
+// SignTreeHead signs a tree head with the log's key.
+func SignTreeHead(privateKey ed25519.PrivateKey, h TreeHead) []byte {
+	return Sign(privateKey, h.Bytes())
+}
+
+// VerifyTreeHead checks a tree head signature against the log's key.
+func VerifyTreeHead(publicKey ed25519.PublicKey, h TreeHead, signature []byte) bool {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return Verify(publicKey, h.Bytes(), signature)
+}
@@ -0,0 +1,44 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSignVerifyTreeHead(t *testing.T) {
+	logKeys, _ := GenerateIdentityKeyPair()
+	other, _ := GenerateIdentityKeyPair()
+
+	h := TreeHead{TreeSize: 3, RootHash: MerkleRoot(testLeaves(3)), Timestamp: time.Now()}
+	sig := SignTreeHead(logKeys.Private, h)
+	if !VerifyTreeHead(logKeys.Public, h, sig) {
+		t.Fatal("Valid tree head signature rejected")
+	}
+	if VerifyTreeHead(other.Public, h, sig) {
+		t.Error("Tree head verified with the wrong key")
+	}
+	for i, change := range []func(*TreeHead){
+		func(h *TreeHead) { h.TreeSize = 4 },
+		func(h *TreeHead) { h.RootHash = MerkleRoot(testLeaves(4)) },
+		func(h *TreeHead) { h.Timestamp = h.Timestamp.Add(time.Hour) },
+	} {
+		changed := h
+		change(&changed)
+		if VerifyTreeHead(logKeys.Public, changed, sig) {
+			t.Errorf("change %d: modified tree head verified", i)
+		}
+	}
+}
+
+func TestKeyLogEntryLeafHash(t *testing.T) {
+	e := KeyLogEntry{Username: "alice", IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)}
+	rotated := e
+	rotated.Version = 1
+	if bytes.Equal(e.LeafHash(), rotated.LeafHash()) {
+		t.Error("Entries of different versions hash the same")
+	}
+	if !bytes.Equal(e.LeafHash(), MerkleLeafHash(e.Bytes())) {
+		t.Error("LeafHash does not hash the entry as a leaf")
+	}
+}
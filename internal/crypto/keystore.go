@@ -0,0 +1,96 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// KDFArgon2id is the only passphrase KDF supported by keystores.
+const KDFArgon2id = "argon2id"
+
+// keystoreAD separates sealed keystores from other data sealed with
+// XChaCha20-Poly1305.
+var keystoreAD = []byte("go-send keystore v1")
+
+// ErrWrongPassphrase is returned when a keystore cannot be opened with the
+// given passphrase, or has been tampered with.
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+// KDFParams are the Argon2id cost parameters a keystore key is derived with.
+type KDFParams struct {
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"` // KiB
+	Threads uint8  `json:"threads"`
+}
+
+// DefaultKDFParams follow the second recommended option of RFC 9106.
+var DefaultKDFParams = KDFParams{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// Keystore holds secrets sealed under a key derived from a passphrase. The
+// KDF, its parameters and the salt are stored alongside, so the parameters
+// can be raised later without breaking existing keystores.
+type Keystore struct {
+	KDF    string    `json:"kdf"`
+	Params KDFParams `json:"params"`
+	Salt   []byte    `json:"salt"`
+	Sealed []byte    `json:"sealed"` // Random nonce followed by the XChaCha20-Poly1305 ciphertext
+}
+
+// NewKeystore returns an empty keystore with a random salt.
+func NewKeystore(params KDFParams) (*Keystore, error) {
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return &Keystore{KDF: KDFArgon2id, Params: params, Salt: salt}, nil
+}
+
+// DeriveKey derives the key the keystore is sealed with from passphrase.
+// A wrong passphrase is only detected when the keystore is opened.
+func (ks *Keystore) DeriveKey(passphrase []byte) (*[32]byte, error) {
+	if ks.KDF != KDFArgon2id {
+		return nil, fmt.Errorf("unsupported keystore KDF %q", ks.KDF)
+	}
+	if ks.Params.Time == 0 || ks.Params.Threads == 0 || len(ks.Salt) == 0 {
+		return nil, errors.New("invalid keystore KDF parameters")
+	}
+	var key [32]byte
+	copy(key[:], argon2.IDKey(passphrase, ks.Salt, ks.Params.Time, ks.Params.Memory, ks.Params.Threads, 32))
+	return &key, nil
+}
+
+// Seal encrypts plaintext under key, replacing the keystore's contents.
+func (ks *Keystore) Seal(plaintext []byte, key *[32]byte) error {
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	ks.Sealed = aead.Seal(nonce, nonce, plaintext, keystoreAD)
+	return nil
+}
+
+// Open decrypts the keystore's contents with key.
+func (ks *Keystore) Open(key *[32]byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nil, err
+	}
+	if len(ks.Sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrWrongPassphrase
+	}
+	nonce, ciphertext := ks.Sealed[:aead.NonceSize()], ks.Sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, keystoreAD)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,50 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestKeystore(t *testing.T) {
+	params := KDFParams{Time: 1, Memory: 1024, Threads: 1}
+	ks, err := NewKeystore(params)
+	if err != nil {
+		t.Fatalf("NewKeystore failed: %v", err)
+	}
+	key, err := ks.DeriveKey([]byte("correct horse"))
+	if err != nil {
+		t.Fatalf("DeriveKey failed: %v", err)
+	}
+	secret := []byte("private keys")
+	if err := ks.Seal(secret, key); err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if bytes.Contains(ks.Sealed, secret) {
+		t.Error("Keystore leaks its contents")
+	}
+
+	again, _ := ks.DeriveKey([]byte("correct horse"))
+	got, err := ks.Open(again)
+	if err != nil || !bytes.Equal(got, secret) {
+		t.Fatalf("Open = %q, %v", got, err)
+	}
+
+	wrong, _ := ks.DeriveKey([]byte("battery staple"))
+	if _, err := ks.Open(wrong); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+
+	// The salt is part of the key, so a keystore cannot be moved to another salt
+	other, _ := NewKeystore(params)
+	other.Sealed = ks.Sealed
+	otherKey, _ := other.DeriveKey([]byte("correct horse"))
+	if _, err := other.Open(otherKey); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase with another salt, got %v", err)
+	}
+
+	ks.KDF = "pbkdf2"
+	if _, err := ks.DeriveKey([]byte("correct horse")); err == nil {
+		t.Error("Expected unsupported KDF to be refused")
+	}
+}
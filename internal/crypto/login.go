@@ -0,0 +1,33 @@
+package crypto
+
+import "crypto/ed25519"
+
+// LoginChallenge is what a client signs to log in. Naming the server's
+// origin stops a malicious server from relaying another server's challenge
+// and logging in there with the signature.
+type LoginChallenge struct {
+	Origin   string // Scheme and host of the server, such as https://send.example.com
+	Username string
+	Nonce    string
+}
+
+// Bytes returns the canonical encoding of the challenge that is signed.
+func (c LoginChallenge) Bytes() []byte {
+	return newEncoder(loginDomain).
+		text(c.Origin).
+		text(c.Username).
+		text(c.Nonce)
+}
+
+// SignLoginChallenge signs a login challenge with an identity key.
+func SignLoginChallenge(privateKey ed25519.PrivateKey, c LoginChallenge) []byte {
+	return Sign(privateKey, c.Bytes())
+}
+
+// VerifyLoginChallenge checks a login signature against an identity key.
+func VerifyLoginChallenge(publicKey ed25519.PublicKey, c LoginChallenge, signature []byte) bool {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return Verify(publicKey, c.Bytes(), signature)
+}
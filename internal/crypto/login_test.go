@@ -0,0 +1,31 @@
+package crypto
+
+import "testing"
+
+func TestSignVerifyLoginChallenge(t *testing.T) {
+	keys, _ := GenerateIdentityKeyPair()
+	c := LoginChallenge{Origin: "https://send.example.com", Username: "alice", Nonce: "nonce"}
+
+	sig := SignLoginChallenge(keys.Private, c)
+	if !VerifyLoginChallenge(keys.Public, c, sig) {
+		t.Fatal("Valid login signature rejected")
+	}
+
+	changes := []func(*LoginChallenge){
+		func(c *LoginChallenge) { c.Origin = "https://evil.example.com" },
+		func(c *LoginChallenge) { c.Username = "mallory" },
+		func(c *LoginChallenge) { c.Nonce = "other" },
+	}
+	for i, change := range changes {
+		changed := c
+		change(&changed)
+		if VerifyLoginChallenge(keys.Public, changed, sig) {
+			t.Errorf("change %d: modified challenge verified", i)
+		}
+	}
+
+	// A signature over the bare nonce, as older clients made, is not a login
+	if VerifyLoginChallenge(keys.Public, c, Sign(keys.Private, []byte(c.Nonce))) {
+		t.Error("Signature without the login domain verified")
+	}
+}
@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"time"
+)
+
+// Manifest describes a transfer as signed by its sender. The signature binds
+// the sender's identity to the exact ciphertext and the key it was
+// encrypted with, so neither the server nor anyone else can substitute
+// content or claim a different sender.
+type Manifest struct {
+	Sender         string
+	Recipient      string
+	Metadata       []byte // Sealed FileInfo envelope, which holds the file name
+	EncryptedKey   []byte
+	WrappedKey     []byte // Content key sealed to the recipient, if any
+	CiphertextHash []byte // SHA-256 of the uploaded ciphertext
+	SignedAt       time.Time
+}
+
+// Bytes returns the canonical encoding of the manifest that is signed.
+func (m Manifest) Bytes() []byte {
+	return newEncoder(manifestDomain).
+		text(m.Sender).
+		text(m.Recipient).
+		field(m.Metadata).
+		field(m.EncryptedKey).
+		field(m.WrappedKey).
+		field(m.CiphertextHash).
+		unix(m.SignedAt)
+}
+
+// SignManifest signs a manifest with the sender's identity key.
+func SignManifest(privateKey ed25519.PrivateKey, m Manifest) []byte {
+	return Sign(privateKey, m.Bytes())
+}
+
+// VerifyManifest checks a manifest signature against the sender's identity key.
+func VerifyManifest(publicKey ed25519.PublicKey, m Manifest, signature []byte) bool {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return Verify(publicKey, m.Bytes(), signature)
+}
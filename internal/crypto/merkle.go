@@ -0,0 +1,161 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// The Merkle tree follows RFC 6962: leaves and interior nodes are hashed
+// with distinct prefixes so that neither can be passed off as the other.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// MerkleLeafHash returns the hash of a leaf holding data.
+func MerkleLeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleLeafPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// merkleNodeHash returns the hash of an interior node.
+func merkleNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// splitPoint returns the largest power of two smaller than n, for n > 1.
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// MerkleRoot returns the root hash of the tree with the given leaf hashes.
+func MerkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		h := sha256.Sum256(nil)
+		return h[:]
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(len(leaves))
+	return merkleNodeHash(MerkleRoot(leaves[:k]), MerkleRoot(leaves[k:]))
+}
+
+// MerkleInclusionProof returns the audit path proving that leaf index is in
+// the tree with the given leaf hashes.
+func MerkleInclusionProof(leaves [][]byte, index int) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := splitPoint(len(leaves))
+	if index < k {
+		return append(MerkleInclusionProof(leaves[:k], index), MerkleRoot(leaves[k:]))
+	}
+	return append(MerkleInclusionProof(leaves[k:], index-k), MerkleRoot(leaves[:k]))
+}
+
+// MerkleConsistencyProof returns the proof that the tree of the first size
+// leaves is a prefix of the tree with the given leaf hashes.
+func MerkleConsistencyProof(leaves [][]byte, size int) [][]byte {
+	if size <= 0 || size >= len(leaves) {
+		return nil
+	}
+	return consistencySubproof(leaves, size, true)
+}
+
+func consistencySubproof(leaves [][]byte, size int, complete bool) [][]byte {
+	if size == len(leaves) {
+		if complete {
+			return nil
+		}
+		return [][]byte{MerkleRoot(leaves)}
+	}
+	k := splitPoint(len(leaves))
+	if size <= k {
+		return append(consistencySubproof(leaves[:k], size, complete), MerkleRoot(leaves[k:]))
+	}
+	return append(consistencySubproof(leaves[k:], size-k, false), MerkleRoot(leaves[:k]))
+}
+
+// VerifyMerkleInclusion checks that leafHash is leaf index of the tree of
+// size leaves with the given root, as described in RFC 9162.
+func VerifyMerkleInclusion(leafHash []byte, index, size uint64, proof [][]byte, root []byte) bool {
+	if index >= size {
+		return false
+	}
+	fn, sn := index, size-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
+
+// VerifyMerkleConsistency checks that the tree of size first with root
+// firstRoot is a prefix of the tree of size second with root secondRoot, as
+// described in RFC 9162.
+func VerifyMerkleConsistency(first, second uint64, firstRoot, secondRoot []byte, proof [][]byte) bool {
+	switch {
+	case first > second:
+		return false
+	case first == second:
+		return len(proof) == 0 && bytes.Equal(firstRoot, secondRoot)
+	case first == 0:
+		return len(proof) == 0
+	}
+
+	// When the first tree is complete, its root is the start of the path
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return false
+	}
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = merkleNodeHash(c, fr)
+			sr = merkleNodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = merkleNodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, firstRoot) && bytes.Equal(sr, secondRoot)
+}
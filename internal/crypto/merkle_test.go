@@ -0,0 +1,59 @@
+package crypto
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = MerkleLeafHash([]byte(fmt.Sprintf("leaf %d", i)))
+	}
+	return leaves
+}
+
+func TestMerkleInclusion(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		leaves := testLeaves(n)
+		root := MerkleRoot(leaves)
+		for i := 0; i < n; i++ {
+			proof := MerkleInclusionProof(leaves, i)
+			if !VerifyMerkleInclusion(leaves[i], uint64(i), uint64(n), proof, root) {
+				t.Fatalf("size %d: inclusion proof of leaf %d rejected", n, i)
+			}
+			if VerifyMerkleInclusion(MerkleLeafHash([]byte("other")), uint64(i), uint64(n), proof, root) {
+				t.Errorf("size %d: proof verified for the wrong leaf", n)
+			}
+			if n > 1 && VerifyMerkleInclusion(leaves[i], uint64((i+1)%n), uint64(n), proof, root) {
+				t.Errorf("size %d: proof of leaf %d verified at another index", n, i)
+			}
+		}
+		if VerifyMerkleInclusion(leaves[0], uint64(n), uint64(n), nil, root) {
+			t.Errorf("size %d: index past the end verified", n)
+		}
+	}
+}
+
+func TestMerkleConsistency(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		leaves := testLeaves(n)
+		root := MerkleRoot(leaves)
+		for m := 1; m <= n; m++ {
+			oldRoot := MerkleRoot(leaves[:m])
+			proof := MerkleConsistencyProof(leaves, m)
+			if !VerifyMerkleConsistency(uint64(m), uint64(n), oldRoot, root, proof) {
+				t.Fatalf("consistency proof %d -> %d rejected", m, n)
+			}
+			if m < n {
+				forked := MerkleRoot(append(testLeaves(m-1), MerkleLeafHash([]byte("forked"))))
+				if VerifyMerkleConsistency(uint64(m), uint64(n), forked, root, proof) {
+					t.Errorf("consistency %d -> %d verified for a forked tree", m, n)
+				}
+			}
+		}
+		if n > 1 && VerifyMerkleConsistency(uint64(n), 1, root, leaves[0], nil) {
+			t.Errorf("size %d: shrinking tree verified as consistent", n)
+		}
+	}
+}
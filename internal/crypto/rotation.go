@@ -0,0 +1,43 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"time"
+)
+
+// KeyRotation replaces a user's keys, as signed with their old identity key.
+// Each rotation names the key it replaces, so a peer who trusts any key in a
+// user's history can follow the chain of rotations to their current keys.
+type KeyRotation struct {
+	Username             string
+	Version              int64 // 1 for a user's first rotation
+	OldIdentityPublicKey []byte
+	IdentityPublicKey    []byte
+	ExchangePublicKey    []byte
+	RotatedAt            time.Time
+}
+
+// Bytes returns the canonical encoding of the rotation that is signed.
+func (k KeyRotation) Bytes() []byte {
+	return newEncoder(keyRotationDomain).
+		text(k.Username).
+		number(uint64(k.Version)).
+		field(k.OldIdentityPublicKey).
+		field(k.IdentityPublicKey).
+		field(k.ExchangePublicKey).
+		unix(k.RotatedAt)
+}
+
+// SignKeyRotation signs a rotation with the old identity key it replaces.
+func SignKeyRotation(oldPrivateKey ed25519.PrivateKey, k KeyRotation) []byte {
+	return Sign(oldPrivateKey, k.Bytes())
+}
+
+// VerifyKeyRotation checks that a rotation was signed by the old identity
+// key it names.
+func VerifyKeyRotation(k KeyRotation, signature []byte) bool {
+	if len(k.OldIdentityPublicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return Verify(k.OldIdentityPublicKey, k.Bytes(), signature)
+}
@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignVerifyKeyRotation(t *testing.T) {
+	oldKeys, _ := GenerateIdentityKeyPair()
+	newKeys, _ := GenerateIdentityKeyPair()
+	exKeys, _ := GenerateExchangeKeyPair()
+
+	k := KeyRotation{
+		Username:             "alice",
+		Version:              1,
+		OldIdentityPublicKey: oldKeys.Public,
+		IdentityPublicKey:    newKeys.Public,
+		ExchangePublicKey:    exKeys.Public[:],
+		RotatedAt:            time.Now(),
+	}
+	sig := SignKeyRotation(oldKeys.Private, k)
+	if !VerifyKeyRotation(k, sig) {
+		t.Fatal("Valid rotation signature rejected")
+	}
+	if VerifyKeyRotation(k, SignKeyRotation(newKeys.Private, k)) {
+		t.Error("Rotation signed by the new key verified")
+	}
+
+	changes := []func(*KeyRotation){
+		func(k *KeyRotation) { k.Username = "mallory" },
+		func(k *KeyRotation) { k.Version = 2 },
+		func(k *KeyRotation) { k.OldIdentityPublicKey = newKeys.Public },
+		func(k *KeyRotation) { k.IdentityPublicKey = oldKeys.Public },
+		func(k *KeyRotation) { k.ExchangePublicKey = make([]byte, 32) },
+		func(k *KeyRotation) { k.RotatedAt = k.RotatedAt.Add(time.Hour) },
+		func(k *KeyRotation) { k.OldIdentityPublicKey = nil },
+	}
+	for i, change := range changes {
+		changed := k
+		change(&changed)
+		if VerifyKeyRotation(changed, sig) {
+			t.Errorf("change %d: modified rotation verified", i)
+		}
+	}
+}
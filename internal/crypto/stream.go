@@ -0,0 +1,343 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Streaming format
+//
+//	header: magic "GSS1" | chunk size (uint32, big endian) | nonce prefix (16 bytes)
+//	chunk:  XChaCha20-Poly1305 sealed plaintext
+//
+// Chunk i is sealed with nonce = prefix || i (uint64, big endian) and additional
+// data = header || i || final flag. Every chunk except the last carries exactly
+// ChunkSize bytes of plaintext; the last one may be shorter (or empty) and is the
+// only one sealed with the final flag set, so truncation, reordering and
+// splicing chunks from another stream all fail authentication.
+
+const (
+	// StreamChunkSize is the default plaintext size of a stream chunk.
+	StreamChunkSize = 64 * 1024
+	// StreamHeaderSize is the size of the stream header in bytes.
+	StreamHeaderSize = 24
+	// StreamOverhead is the number of bytes each chunk adds to its plaintext.
+	StreamOverhead = chacha20poly1305.Overhead
+
+	maxStreamChunkSize = 16 * 1024 * 1024
+)
+
+var streamMagic = []byte("GSS1")
+
+var (
+	// ErrInvalidStream is returned when a stream header cannot be parsed.
+	ErrInvalidStream = errors.New("invalid stream header")
+	// ErrStreamAuth is returned when a chunk fails authentication.
+	ErrStreamAuth = errors.New("stream authentication failed")
+	// ErrStreamTruncated is returned when a stream ends before its final chunk.
+	ErrStreamTruncated = errors.New("stream truncated")
+)
+
+// StreamHeader holds the per-stream parameters written before the first chunk.
+type StreamHeader struct {
+	ChunkSize   uint32
+	NoncePrefix [16]byte
+}
+
+// NewStreamHeader returns a header with the default chunk size and a random nonce prefix.
+func NewStreamHeader() (StreamHeader, error) {
+	h := StreamHeader{ChunkSize: StreamChunkSize}
+	if _, err := io.ReadFull(rand.Reader, h.NoncePrefix[:]); err != nil {
+		return StreamHeader{}, err
+	}
+	return h, nil
+}
+
+// Bytes returns the wire encoding of the header.
+func (h StreamHeader) Bytes() []byte {
+	b := make([]byte, StreamHeaderSize)
+	copy(b, streamMagic)
+	binary.BigEndian.PutUint32(b[4:8], h.ChunkSize)
+	copy(b[8:], h.NoncePrefix[:])
+	return b
+}
+
+// ParseStreamHeader decodes a header produced by StreamHeader.Bytes.
+func ParseStreamHeader(b []byte) (StreamHeader, error) {
+	if !IsStream(b) || len(b) < StreamHeaderSize {
+		return StreamHeader{}, ErrInvalidStream
+	}
+	var h StreamHeader
+	h.ChunkSize = binary.BigEndian.Uint32(b[4:8])
+	if h.ChunkSize == 0 || h.ChunkSize > maxStreamChunkSize {
+		return StreamHeader{}, ErrInvalidStream
+	}
+	copy(h.NoncePrefix[:], b[8:StreamHeaderSize])
+	return h, nil
+}
+
+// ChunkOffset returns the byte offset of chunk index within an encoded stream.
+func (h StreamHeader) ChunkOffset(index uint64) int64 {
+	return StreamHeaderSize + int64(index)*(int64(h.ChunkSize)+StreamOverhead)
+}
+
+// IsStream reports whether b starts with the streaming format magic.
+func IsStream(b []byte) bool {
+	return len(b) >= len(streamMagic) && string(b[:len(streamMagic)]) == string(streamMagic)
+}
+
+// StreamKey derives the symmetric stream key shared by an X25519 key pair.
+func StreamKey(peerPub, priv *[32]byte) *[32]byte {
+	var key [32]byte
+	box.Precompute(&key, peerPub, priv)
+	return &key
+}
+
+func chunkNonce(h StreamHeader, index uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	copy(nonce, h.NoncePrefix[:])
+	binary.BigEndian.PutUint64(nonce[16:], index)
+	return nonce
+}
+
+func chunkAD(header []byte, index uint64, final bool) []byte {
+	ad := make([]byte, len(header)+9)
+	copy(ad, header)
+	binary.BigEndian.PutUint64(ad[len(header):], index)
+	if final {
+		ad[len(ad)-1] = 1
+	}
+	return ad
+}
+
+type streamWriter struct {
+	dst    io.Writer
+	aead   cipher.AEAD
+	header StreamHeader
+	hdr    []byte
+	buf    []byte
+	out    []byte
+	index  uint64
+	closed bool
+	err    error
+}
+
+// NewEncryptWriter returns a writer that encrypts everything written to it
+// into dst using key. Close must be called to seal the final chunk; it does
+// not close dst.
+func NewEncryptWriter(dst io.Writer, key *[32]byte) (io.WriteCloser, error) {
+	h, err := NewStreamHeader()
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryptWriterWithHeader(dst, key, h)
+}
+
+// NewEncryptWriterWithHeader is like NewEncryptWriter but uses the given header.
+// Reusing a header with the same key is only safe for identical plaintext.
+func NewEncryptWriterWithHeader(dst io.Writer, key *[32]byte, h StreamHeader) (io.WriteCloser, error) {
+	if h.ChunkSize == 0 || h.ChunkSize > maxStreamChunkSize {
+		return nil, ErrInvalidStream
+	}
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nil, err
+	}
+	hdr := h.Bytes()
+	if _, err := dst.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &streamWriter{
+		dst:    dst,
+		aead:   aead,
+		header: h,
+		hdr:    hdr,
+		buf:    make([]byte, 0, h.ChunkSize),
+		out:    make([]byte, 0, int(h.ChunkSize)+StreamOverhead),
+	}, nil
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errors.New("write to closed stream")
+	}
+	n := 0
+	for len(p) > 0 {
+		// A full buffer is only sealed once more data arrives, so the
+		// last chunk is always sealed by Close with the final flag.
+		if len(w.buf) == cap(w.buf) {
+			if err := w.seal(false); err != nil {
+				w.err = err
+				return n, err
+			}
+		}
+		k := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+k]
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+func (w *streamWriter) seal(final bool) error {
+	w.out = w.aead.Seal(w.out[:0], chunkNonce(w.header, w.index), w.buf, chunkAD(w.hdr, w.index, final))
+	if _, err := w.dst.Write(w.out); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	w.index++
+	return nil
+}
+
+// Close seals the final chunk.
+func (w *streamWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.seal(true)
+	return w.err
+}
+
+type streamReader struct {
+	src    io.Reader
+	aead   cipher.AEAD
+	header StreamHeader
+	hdr    []byte
+	buf    []byte
+	n      int
+	plain  []byte
+	out    []byte
+	index  uint64
+	err    error
+}
+
+// NewDecryptReader reads a stream header from src and returns a reader that
+// yields the authenticated plaintext. The reader only returns io.EOF after
+// the final chunk has been verified.
+func NewDecryptReader(src io.Reader, key *[32]byte) (io.Reader, error) {
+	hdr := make([]byte, StreamHeaderSize)
+	if _, err := io.ReadFull(src, hdr); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrInvalidStream
+		}
+		return nil, err
+	}
+	h, err := ParseStreamHeader(hdr)
+	if err != nil {
+		return nil, err
+	}
+	return NewDecryptReaderAt(src, key, h, 0)
+}
+
+// NewDecryptReaderAt returns a reader that decrypts a stream with header h
+// starting at chunk index, for resuming an interrupted download. src must be
+// positioned at h.ChunkOffset(index). Chunk indices are bound into each
+// authentication tag, so starting at the wrong offset fails with ErrStreamAuth.
+func NewDecryptReaderAt(src io.Reader, key *[32]byte, h StreamHeader, index uint64) (io.Reader, error) {
+	if h.ChunkSize == 0 || h.ChunkSize > maxStreamChunkSize {
+		return nil, ErrInvalidStream
+	}
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return &streamReader{
+		src:    src,
+		aead:   aead,
+		header: h,
+		hdr:    h.Bytes(),
+		buf:    make([]byte, int(h.ChunkSize)+StreamOverhead+1),
+		out:    make([]byte, 0, h.ChunkSize),
+		index:  index,
+	}, nil
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next opens one chunk. It keeps one byte of lookahead so that a full-sized
+// chunk can be told apart from the final one.
+func (r *streamReader) next() error {
+	size := len(r.buf) - 1
+	m, err := io.ReadFull(r.src, r.buf[r.n:])
+	r.n += m
+	final := false
+	switch {
+	case err == nil:
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		final = true
+	default:
+		return err
+	}
+
+	chunk := r.buf[:size]
+	if final {
+		chunk = r.buf[:r.n]
+		if len(chunk) < StreamOverhead {
+			return ErrStreamTruncated
+		}
+	}
+
+	out, openErr := r.aead.Open(r.out[:0], chunkNonce(r.header, r.index), chunk, chunkAD(r.hdr, r.index, final))
+	if openErr != nil {
+		return ErrStreamAuth
+	}
+	r.out = out
+	r.plain = out
+	r.index++
+
+	if final {
+		return io.EOF
+	}
+	r.buf[0] = r.buf[size]
+	r.n = 1
+	return nil
+}
+
+// EncryptStream encrypts src into dst for a recipient using their public key
+// and the sender's private key.
+func EncryptStream(dst io.Writer, src io.Reader, recipientPub *[32]byte, senderPriv *[32]byte) error {
+	w, err := NewEncryptWriter(dst, StreamKey(recipientPub, senderPriv))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// DecryptStream decrypts a stream produced by EncryptStream into dst using the
+// sender's public key and the recipient's private key. Data written to dst
+// before an error is returned has been authenticated chunk by chunk, but the
+// stream as a whole is only complete when DecryptStream returns nil.
+func DecryptStream(dst io.Writer, src io.Reader, senderPub *[32]byte, recipientPriv *[32]byte) error {
+	r, err := NewDecryptReader(src, StreamKey(senderPub, recipientPriv))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, r)
+	return err
+}
@@ -0,0 +1,164 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func encryptWithChunkSize(t *testing.T, plaintext []byte, key *[32]byte, chunkSize uint32) []byte {
+	t.Helper()
+	h, err := NewStreamHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ChunkSize = chunkSize
+
+	var buf bytes.Buffer
+	w, err := NewEncryptWriterWithHeader(&buf, key, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decryptAll(ciphertext []byte, key *[32]byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(ciphertext), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key, _ := GenerateSymmetricKey()
+	var k [32]byte
+	copy(k[:], key)
+
+	// Sizes around the chunk boundary, including empty input.
+	for _, size := range []int{0, 1, 15, 16, 17, 32, 33, 100} {
+		plaintext := bytes.Repeat([]byte{'x'}, size)
+		ciphertext := encryptWithChunkSize(t, plaintext, &k, 16)
+
+		got, err := decryptAll(ciphertext, &k)
+		if err != nil {
+			t.Fatalf("size %d: decrypt failed: %v", size, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("size %d: plaintext mismatch", size)
+		}
+	}
+}
+
+func TestEncryptDecryptStream(t *testing.T) {
+	alice, _ := GenerateExchangeKeyPair()
+	bob, _ := GenerateExchangeKeyPair()
+
+	message := bytes.Repeat([]byte("Hello, Bob! "), 20000)
+
+	var encrypted bytes.Buffer
+	if err := EncryptStream(&encrypted, bytes.NewReader(message), bob.Public, alice.Private); err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	if !IsStream(encrypted.Bytes()) {
+		t.Error("Expected stream magic")
+	}
+
+	var decrypted bytes.Buffer
+	if err := DecryptStream(&decrypted, bytes.NewReader(encrypted.Bytes()), alice.Public, bob.Private); err != nil {
+		t.Fatalf("DecryptStream failed: %v", err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), message) {
+		t.Error("Decrypted stream does not match original")
+	}
+
+	// Wrong key
+	eve, _ := GenerateExchangeKeyPair()
+	if err := DecryptStream(io.Discard, bytes.NewReader(encrypted.Bytes()), alice.Public, eve.Private); err == nil {
+		t.Error("Expected failure for wrong private key")
+	}
+}
+
+func TestStreamTamperDetection(t *testing.T) {
+	key, _ := GenerateSymmetricKey()
+	var k [32]byte
+	copy(k[:], key)
+
+	const chunk = 16
+	sealed := chunk + StreamOverhead
+	plaintext := bytes.Repeat([]byte("0123456789abcdef"), 4) // 4 full chunks
+	ciphertext := encryptWithChunkSize(t, plaintext, &k, chunk)
+
+	// Truncated at a chunk boundary: the last remaining chunk is not final.
+	truncated := ciphertext[:StreamHeaderSize+2*sealed]
+	if _, err := decryptAll(truncated, &k); !errors.Is(err, ErrStreamAuth) {
+		t.Errorf("Expected auth failure for truncated stream, got %v", err)
+	}
+
+	// Truncated to the header only.
+	if _, err := decryptAll(ciphertext[:StreamHeaderSize], &k); !errors.Is(err, ErrStreamTruncated) {
+		t.Errorf("Expected truncation error, got %v", err)
+	}
+
+	// Swapped chunks.
+	swapped := append([]byte{}, ciphertext...)
+	first := StreamHeaderSize
+	second := StreamHeaderSize + sealed
+	copy(swapped[first:first+sealed], ciphertext[second:second+sealed])
+	copy(swapped[second:second+sealed], ciphertext[first:first+sealed])
+	if _, err := decryptAll(swapped, &k); !errors.Is(err, ErrStreamAuth) {
+		t.Errorf("Expected auth failure for reordered stream, got %v", err)
+	}
+
+	// Flipped bit in the header.
+	badHeader := append([]byte{}, ciphertext...)
+	badHeader[10] ^= 1
+	if _, err := decryptAll(badHeader, &k); !errors.Is(err, ErrStreamAuth) {
+		t.Errorf("Expected auth failure for modified header, got %v", err)
+	}
+
+	// Garbage header.
+	if _, err := decryptAll([]byte("not a stream at all, not at all"), &k); !errors.Is(err, ErrInvalidStream) {
+		t.Errorf("Expected invalid stream error, got %v", err)
+	}
+}
+
+func TestDecryptReaderAt(t *testing.T) {
+	key, _ := GenerateSymmetricKey()
+	var k [32]byte
+	copy(k[:], key)
+
+	const chunk = 16
+	plaintext := bytes.Repeat([]byte("0123456789abcdef"), 4)
+	plaintext = append(plaintext, "tail"...)
+	ciphertext := encryptWithChunkSize(t, plaintext, &k, chunk)
+	h, _ := ParseStreamHeader(ciphertext)
+
+	// Resuming at each chunk yields the remaining plaintext
+	for index := uint64(0); index <= 4; index++ {
+		r, err := NewDecryptReaderAt(bytes.NewReader(ciphertext[h.ChunkOffset(index):]), &k, h, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("chunk %d: decrypt failed: %v", index, err)
+		}
+		if !bytes.Equal(got, plaintext[index*chunk:]) {
+			t.Errorf("chunk %d: plaintext mismatch", index)
+		}
+	}
+
+	// Starting at the wrong offset fails authentication
+	r, _ := NewDecryptReaderAt(bytes.NewReader(ciphertext[h.ChunkOffset(2):]), &k, h, 1)
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrStreamAuth) {
+		t.Errorf("Expected auth failure for misaligned resume, got %v", err)
+	}
+}
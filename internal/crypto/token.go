@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidAccessToken is returned for access tokens that are malformed or
+// not signed by the expected key.
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
+// AccessToken is a short-lived bearer credential for a session. The server
+// signs it, so that requests can be authenticated without looking the
+// session up.
+type AccessToken struct {
+	SessionID string    `json:"sid"`
+	Username  string    `json:"sub"`
+	IssuedAt  time.Time `json:"iat"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// SignAccessToken encodes and signs an access token. The result is the
+// base64url encoded JSON claims and signature, joined by a dot.
+func SignAccessToken(privateKey ed25519.PrivateKey, t AccessToken) string {
+	payload, _ := json.Marshal(t)
+	sig := Sign(privateKey, accessTokenMessage(payload))
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// VerifyAccessToken checks the signature on an access token and returns its
+// claims. Callers must check ExpiresAt themselves.
+func VerifyAccessToken(publicKey ed25519.PublicKey, token string) (AccessToken, error) {
+	payload, sig, err := splitAccessToken(token)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if len(publicKey) != ed25519.PublicKeySize || !Verify(publicKey, accessTokenMessage(payload), sig) {
+		return AccessToken{}, ErrInvalidAccessToken
+	}
+	return decodeAccessToken(payload)
+}
+
+// ReadAccessToken returns the claims of an access token without checking
+// its signature, for clients that hold a token but not the key that signed
+// it.
+func ReadAccessToken(token string) (AccessToken, error) {
+	payload, _, err := splitAccessToken(token)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	return decodeAccessToken(payload)
+}
+
+// accessTokenMessage returns the message signed for the claims in payload.
+func accessTokenMessage(payload []byte) []byte {
+	return append(newEncoder(accessTokenDomain), payload...)
+}
+
+func splitAccessToken(token string) (payload, sig []byte, err error) {
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, nil, ErrInvalidAccessToken
+	}
+	if payload, err = base64.RawURLEncoding.DecodeString(encPayload); err != nil {
+		return nil, nil, ErrInvalidAccessToken
+	}
+	if sig, err = base64.RawURLEncoding.DecodeString(encSig); err != nil {
+		return nil, nil, ErrInvalidAccessToken
+	}
+	return payload, sig, nil
+}
+
+func decodeAccessToken(payload []byte) (AccessToken, error) {
+	var t AccessToken
+	if err := json.Unmarshal(payload, &t); err != nil {
+		return AccessToken{}, ErrInvalidAccessToken
+	}
+	return t, nil
+}
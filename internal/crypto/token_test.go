@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerifyAccessToken(t *testing.T) {
+	keys, _ := GenerateIdentityKeyPair()
+	now := time.Now().UTC()
+	claims := AccessToken{SessionID: "0123456789abcdef", Username: "alice", IssuedAt: now, ExpiresAt: now.Add(time.Minute)}
+
+	token := SignAccessToken(keys.Private, claims)
+	got, err := VerifyAccessToken(keys.Public, token)
+	if err != nil {
+		t.Fatalf("Valid access token rejected: %v", err)
+	}
+	if got.SessionID != claims.SessionID || got.Username != claims.Username || !got.ExpiresAt.Equal(claims.ExpiresAt) {
+		t.Errorf("Expected %+v, got %+v", claims, got)
+	}
+	if read, err := ReadAccessToken(token); err != nil || read.Username != "alice" {
+		t.Errorf("ReadAccessToken returned %+v, %v", read, err)
+	}
+
+	other, _ := GenerateIdentityKeyPair()
+	if _, err := VerifyAccessToken(other.Public, token); !errors.Is(err, ErrInvalidAccessToken) {
+		t.Errorf("Expected a token signed by another key to be refused, got %v", err)
+	}
+
+	// Claims cannot be changed without the signature failing
+	claims.Username = "mallory"
+	forged := strings.SplitN(SignAccessToken(other.Private, claims), ".", 2)[0] + token[strings.Index(token, "."):]
+	if _, err := VerifyAccessToken(keys.Public, forged); !errors.Is(err, ErrInvalidAccessToken) {
+		t.Errorf("Expected changed claims to be refused, got %v", err)
+	}
+
+	// A signature over the claims alone, without the domain, is refused
+	payload, _, _ := splitAccessToken(token)
+	bare := token[:strings.Index(token, ".")] + "." + base64.RawURLEncoding.EncodeToString(Sign(keys.Private, payload))
+	if _, err := VerifyAccessToken(keys.Public, bare); !errors.Is(err, ErrInvalidAccessToken) {
+		t.Errorf("Expected a signature without the token domain to be refused, got %v", err)
+	}
+
+	for _, bad := range []string{"", "nodot", "!!!.!!!", "e30.AAAA"} {
+		if _, err := VerifyAccessToken(keys.Public, bad); !errors.Is(err, ErrInvalidAccessToken) {
+			t.Errorf("Expected %q to be refused, got %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package crypto
+
+import "crypto/sha256"
+
+// UploadKeyMessage returns what a sender signs with their identity key to
+// derive the content key of an upload from a random seed. Ed25519
+// signatures are deterministic, so signing it again gives the same key, and
+// an unfinished upload only has to keep the seed.
+func UploadKeyMessage(seed []byte) []byte {
+	return newEncoder(uploadKeyDomain).field(seed)
+}
+
+// UploadKey derives a content key from a signature over UploadKeyMessage.
+func UploadKey(signature []byte) *[32]byte {
+	h := sha256.New()
+	h.Write([]byte(uploadKeyDomain))
+	h.Write(signature)
+	var key [32]byte
+	copy(key[:], h.Sum(nil))
+	return &key
+}
@@ -0,0 +1,23 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUploadKey(t *testing.T) {
+	keys, _ := GenerateIdentityKeyPair()
+	seed := bytes.Repeat([]byte{1}, 32)
+
+	key := UploadKey(Sign(keys.Private, UploadKeyMessage(seed)))
+	if again := UploadKey(Sign(keys.Private, UploadKeyMessage(seed))); *again != *key {
+		t.Error("Expected the same seed to give the same key")
+	}
+	if other := UploadKey(Sign(keys.Private, UploadKeyMessage(bytes.Repeat([]byte{2}, 32)))); *other == *key {
+		t.Error("Expected another seed to give another key")
+	}
+	otherKeys, _ := GenerateIdentityKeyPair()
+	if other := UploadKey(Sign(otherKeys.Private, UploadKeyMessage(seed))); *other == *key {
+		t.Error("Expected another identity key to give another key")
+	}
+}
@@ -24,38 +24,80 @@ func New(db DBTX) *Queries {
 func Prepare(ctx context.Context, db DBTX) (*Queries, error) {
 	q := Queries{db: db}
 	var err error
+	if q.appendKeyLogEntryStmt, err = db.PrepareContext(ctx, appendKeyLogEntry); err != nil {
+		return nil, fmt.Errorf("error preparing query AppendKeyLogEntry: %w", err)
+	}
+	if q.countFilesByBlobStmt, err = db.PrepareContext(ctx, countFilesByBlob); err != nil {
+		return nil, fmt.Errorf("error preparing query CountFilesByBlob: %w", err)
+	}
+	if q.countKeyLogEntriesStmt, err = db.PrepareContext(ctx, countKeyLogEntries); err != nil {
+		return nil, fmt.Errorf("error preparing query CountKeyLogEntries: %w", err)
+	}
 	if q.createChallengeStmt, err = db.PrepareContext(ctx, createChallenge); err != nil {
 		return nil, fmt.Errorf("error preparing query CreateChallenge: %w", err)
 	}
+	if q.createCompletedUploadStmt, err = db.PrepareContext(ctx, createCompletedUpload); err != nil {
+		return nil, fmt.Errorf("error preparing query CreateCompletedUpload: %w", err)
+	}
 	if q.createFileStmt, err = db.PrepareContext(ctx, createFile); err != nil {
 		return nil, fmt.Errorf("error preparing query CreateFile: %w", err)
 	}
+	if q.createKeyRotationStmt, err = db.PrepareContext(ctx, createKeyRotation); err != nil {
+		return nil, fmt.Errorf("error preparing query CreateKeyRotation: %w", err)
+	}
 	if q.createSessionStmt, err = db.PrepareContext(ctx, createSession); err != nil {
 		return nil, fmt.Errorf("error preparing query CreateSession: %w", err)
 	}
+	if q.createUploadSessionStmt, err = db.PrepareContext(ctx, createUploadSession); err != nil {
+		return nil, fmt.Errorf("error preparing query CreateUploadSession: %w", err)
+	}
 	if q.createUserStmt, err = db.PrepareContext(ctx, createUser); err != nil {
 		return nil, fmt.Errorf("error preparing query CreateUser: %w", err)
 	}
+	if q.decrementDownloadsStmt, err = db.PrepareContext(ctx, decrementDownloads); err != nil {
+		return nil, fmt.Errorf("error preparing query DecrementDownloads: %w", err)
+	}
 	if q.deleteChallengeStmt, err = db.PrepareContext(ctx, deleteChallenge); err != nil {
 		return nil, fmt.Errorf("error preparing query DeleteChallenge: %w", err)
 	}
+	if q.deleteExpiredChallengesStmt, err = db.PrepareContext(ctx, deleteExpiredChallenges); err != nil {
+		return nil, fmt.Errorf("error preparing query DeleteExpiredChallenges: %w", err)
+	}
+	if q.deleteExpiredSessionsStmt, err = db.PrepareContext(ctx, deleteExpiredSessions); err != nil {
+		return nil, fmt.Errorf("error preparing query DeleteExpiredSessions: %w", err)
+	}
 	if q.deleteFileStmt, err = db.PrepareContext(ctx, deleteFile); err != nil {
 		return nil, fmt.Errorf("error preparing query DeleteFile: %w", err)
 	}
-	if q.deleteSessionStmt, err = db.PrepareContext(ctx, deleteSession); err != nil {
-		return nil, fmt.Errorf("error preparing query DeleteSession: %w", err)
+	if q.deleteKeyRotationsStmt, err = db.PrepareContext(ctx, deleteKeyRotations); err != nil {
+		return nil, fmt.Errorf("error preparing query DeleteKeyRotations: %w", err)
+	}
+	if q.deleteStaleCompletedUploadsStmt, err = db.PrepareContext(ctx, deleteStaleCompletedUploads); err != nil {
+		return nil, fmt.Errorf("error preparing query DeleteStaleCompletedUploads: %w", err)
+	}
+	if q.deleteUploadChunksStmt, err = db.PrepareContext(ctx, deleteUploadChunks); err != nil {
+		return nil, fmt.Errorf("error preparing query DeleteUploadChunks: %w", err)
+	}
+	if q.deleteUploadSessionStmt, err = db.PrepareContext(ctx, deleteUploadSession); err != nil {
+		return nil, fmt.Errorf("error preparing query DeleteUploadSession: %w", err)
 	}
 	if q.deleteUserStmt, err = db.PrepareContext(ctx, deleteUser); err != nil {
 		return nil, fmt.Errorf("error preparing query DeleteUser: %w", err)
 	}
-	if q.getChallengeStmt, err = db.PrepareContext(ctx, getChallenge); err != nil {
-		return nil, fmt.Errorf("error preparing query GetChallenge: %w", err)
+	if q.deleteUserSessionStmt, err = db.PrepareContext(ctx, deleteUserSession); err != nil {
+		return nil, fmt.Errorf("error preparing query DeleteUserSession: %w", err)
+	}
+	if q.deleteUserSessionsStmt, err = db.PrepareContext(ctx, deleteUserSessions); err != nil {
+		return nil, fmt.Errorf("error preparing query DeleteUserSessions: %w", err)
 	}
 	if q.getFileStmt, err = db.PrepareContext(ctx, getFile); err != nil {
 		return nil, fmt.Errorf("error preparing query GetFile: %w", err)
 	}
-	if q.getSessionStmt, err = db.PrepareContext(ctx, getSession); err != nil {
-		return nil, fmt.Errorf("error preparing query GetSession: %w", err)
+	if q.getLatestKeyLogEntryStmt, err = db.PrepareContext(ctx, getLatestKeyLogEntry); err != nil {
+		return nil, fmt.Errorf("error preparing query GetLatestKeyLogEntry: %w", err)
+	}
+	if q.getUploadSessionStmt, err = db.PrepareContext(ctx, getUploadSession); err != nil {
+		return nil, fmt.Errorf("error preparing query GetUploadSession: %w", err)
 	}
 	if q.getUserStmt, err = db.PrepareContext(ctx, getUser); err != nil {
 		return nil, fmt.Errorf("error preparing query GetUser: %w", err)
@@ -63,47 +105,146 @@ func Prepare(ctx context.Context, db DBTX) (*Queries, error) {
 	if q.listAllUsersStmt, err = db.PrepareContext(ctx, listAllUsers); err != nil {
 		return nil, fmt.Errorf("error preparing query ListAllUsers: %w", err)
 	}
+	if q.listCompletedUploadFilesStmt, err = db.PrepareContext(ctx, listCompletedUploadFiles); err != nil {
+		return nil, fmt.Errorf("error preparing query ListCompletedUploadFiles: %w", err)
+	}
+	if q.listExpiredFilesStmt, err = db.PrepareContext(ctx, listExpiredFiles); err != nil {
+		return nil, fmt.Errorf("error preparing query ListExpiredFiles: %w", err)
+	}
 	if q.listFilesStmt, err = db.PrepareContext(ctx, listFiles); err != nil {
 		return nil, fmt.Errorf("error preparing query ListFiles: %w", err)
 	}
+	if q.listKeyLogHashesStmt, err = db.PrepareContext(ctx, listKeyLogHashes); err != nil {
+		return nil, fmt.Errorf("error preparing query ListKeyLogHashes: %w", err)
+	}
+	if q.listKeyRotationsStmt, err = db.PrepareContext(ctx, listKeyRotations); err != nil {
+		return nil, fmt.Errorf("error preparing query ListKeyRotations: %w", err)
+	}
+	if q.listStaleUploadSessionsStmt, err = db.PrepareContext(ctx, listStaleUploadSessions); err != nil {
+		return nil, fmt.Errorf("error preparing query ListStaleUploadSessions: %w", err)
+	}
+	if q.listUploadChunksStmt, err = db.PrepareContext(ctx, listUploadChunks); err != nil {
+		return nil, fmt.Errorf("error preparing query ListUploadChunks: %w", err)
+	}
+	if q.listUserSessionsStmt, err = db.PrepareContext(ctx, listUserSessions); err != nil {
+		return nil, fmt.Errorf("error preparing query ListUserSessions: %w", err)
+	}
+	if q.listUsersMissingFromKeyLogStmt, err = db.PrepareContext(ctx, listUsersMissingFromKeyLog); err != nil {
+		return nil, fmt.Errorf("error preparing query ListUsersMissingFromKeyLog: %w", err)
+	}
+	if q.refreshSessionStmt, err = db.PrepareContext(ctx, refreshSession); err != nil {
+		return nil, fmt.Errorf("error preparing query RefreshSession: %w", err)
+	}
+	if q.takeChallengeStmt, err = db.PrepareContext(ctx, takeChallenge); err != nil {
+		return nil, fmt.Errorf("error preparing query TakeChallenge: %w", err)
+	}
+	if q.touchUploadSessionStmt, err = db.PrepareContext(ctx, touchUploadSession); err != nil {
+		return nil, fmt.Errorf("error preparing query TouchUploadSession: %w", err)
+	}
+	if q.updateUserKeysStmt, err = db.PrepareContext(ctx, updateUserKeys); err != nil {
+		return nil, fmt.Errorf("error preparing query UpdateUserKeys: %w", err)
+	}
+	if q.upsertUploadChunkStmt, err = db.PrepareContext(ctx, upsertUploadChunk); err != nil {
+		return nil, fmt.Errorf("error preparing query UpsertUploadChunk: %w", err)
+	}
 	return &q, nil
 }
 
 func (q *Queries) Close() error {
 	var err error
+	if q.appendKeyLogEntryStmt != nil {
+		if cerr := q.appendKeyLogEntryStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing appendKeyLogEntryStmt: %w", cerr)
+		}
+	}
+	if q.countFilesByBlobStmt != nil {
+		if cerr := q.countFilesByBlobStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing countFilesByBlobStmt: %w", cerr)
+		}
+	}
+	if q.countKeyLogEntriesStmt != nil {
+		if cerr := q.countKeyLogEntriesStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing countKeyLogEntriesStmt: %w", cerr)
+		}
+	}
 	if q.createChallengeStmt != nil {
 		if cerr := q.createChallengeStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing createChallengeStmt: %w", cerr)
 		}
 	}
+	if q.createCompletedUploadStmt != nil {
+		if cerr := q.createCompletedUploadStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing createCompletedUploadStmt: %w", cerr)
+		}
+	}
 	if q.createFileStmt != nil {
 		if cerr := q.createFileStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing createFileStmt: %w", cerr)
 		}
 	}
+	if q.createKeyRotationStmt != nil {
+		if cerr := q.createKeyRotationStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing createKeyRotationStmt: %w", cerr)
+		}
+	}
 	if q.createSessionStmt != nil {
 		if cerr := q.createSessionStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing createSessionStmt: %w", cerr)
 		}
 	}
+	if q.createUploadSessionStmt != nil {
+		if cerr := q.createUploadSessionStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing createUploadSessionStmt: %w", cerr)
+		}
+	}
 	if q.createUserStmt != nil {
 		if cerr := q.createUserStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing createUserStmt: %w", cerr)
 		}
 	}
+	if q.decrementDownloadsStmt != nil {
+		if cerr := q.decrementDownloadsStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing decrementDownloadsStmt: %w", cerr)
+		}
+	}
 	if q.deleteChallengeStmt != nil {
 		if cerr := q.deleteChallengeStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing deleteChallengeStmt: %w", cerr)
 		}
 	}
+	if q.deleteExpiredChallengesStmt != nil {
+		if cerr := q.deleteExpiredChallengesStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing deleteExpiredChallengesStmt: %w", cerr)
+		}
+	}
+	if q.deleteExpiredSessionsStmt != nil {
+		if cerr := q.deleteExpiredSessionsStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing deleteExpiredSessionsStmt: %w", cerr)
+		}
+	}
 	if q.deleteFileStmt != nil {
 		if cerr := q.deleteFileStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing deleteFileStmt: %w", cerr)
 		}
 	}
-	if q.deleteSessionStmt != nil {
-		if cerr := q.deleteSessionStmt.Close(); cerr != nil {
-			err = fmt.Errorf("error closing deleteSessionStmt: %w", cerr)
+	if q.deleteKeyRotationsStmt != nil {
+		if cerr := q.deleteKeyRotationsStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing deleteKeyRotationsStmt: %w", cerr)
+		}
+	}
+	if q.deleteStaleCompletedUploadsStmt != nil {
+		if cerr := q.deleteStaleCompletedUploadsStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing deleteStaleCompletedUploadsStmt: %w", cerr)
+		}
+	}
+	if q.deleteUploadChunksStmt != nil {
+		if cerr := q.deleteUploadChunksStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing deleteUploadChunksStmt: %w", cerr)
+		}
+	}
+	if q.deleteUploadSessionStmt != nil {
+		if cerr := q.deleteUploadSessionStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing deleteUploadSessionStmt: %w", cerr)
 		}
 	}
 	if q.deleteUserStmt != nil {
@@ -111,9 +252,14 @@ func (q *Queries) Close() error {
 			err = fmt.Errorf("error closing deleteUserStmt: %w", cerr)
 		}
 	}
-	if q.getChallengeStmt != nil {
-		if cerr := q.getChallengeStmt.Close(); cerr != nil {
-			err = fmt.Errorf("error closing getChallengeStmt: %w", cerr)
+	if q.deleteUserSessionStmt != nil {
+		if cerr := q.deleteUserSessionStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing deleteUserSessionStmt: %w", cerr)
+		}
+	}
+	if q.deleteUserSessionsStmt != nil {
+		if cerr := q.deleteUserSessionsStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing deleteUserSessionsStmt: %w", cerr)
 		}
 	}
 	if q.getFileStmt != nil {
@@ -121,9 +267,14 @@ func (q *Queries) Close() error {
 			err = fmt.Errorf("error closing getFileStmt: %w", cerr)
 		}
 	}
-	if q.getSessionStmt != nil {
-		if cerr := q.getSessionStmt.Close(); cerr != nil {
-			err = fmt.Errorf("error closing getSessionStmt: %w", cerr)
+	if q.getLatestKeyLogEntryStmt != nil {
+		if cerr := q.getLatestKeyLogEntryStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getLatestKeyLogEntryStmt: %w", cerr)
+		}
+	}
+	if q.getUploadSessionStmt != nil {
+		if cerr := q.getUploadSessionStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getUploadSessionStmt: %w", cerr)
 		}
 	}
 	if q.getUserStmt != nil {
@@ -136,11 +287,76 @@ func (q *Queries) Close() error {
 			err = fmt.Errorf("error closing listAllUsersStmt: %w", cerr)
 		}
 	}
+	if q.listCompletedUploadFilesStmt != nil {
+		if cerr := q.listCompletedUploadFilesStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing listCompletedUploadFilesStmt: %w", cerr)
+		}
+	}
+	if q.listExpiredFilesStmt != nil {
+		if cerr := q.listExpiredFilesStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing listExpiredFilesStmt: %w", cerr)
+		}
+	}
 	if q.listFilesStmt != nil {
 		if cerr := q.listFilesStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing listFilesStmt: %w", cerr)
 		}
 	}
+	if q.listKeyLogHashesStmt != nil {
+		if cerr := q.listKeyLogHashesStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing listKeyLogHashesStmt: %w", cerr)
+		}
+	}
+	if q.listKeyRotationsStmt != nil {
+		if cerr := q.listKeyRotationsStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing listKeyRotationsStmt: %w", cerr)
+		}
+	}
+	if q.listStaleUploadSessionsStmt != nil {
+		if cerr := q.listStaleUploadSessionsStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing listStaleUploadSessionsStmt: %w", cerr)
+		}
+	}
+	if q.listUploadChunksStmt != nil {
+		if cerr := q.listUploadChunksStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing listUploadChunksStmt: %w", cerr)
+		}
+	}
+	if q.listUserSessionsStmt != nil {
+		if cerr := q.listUserSessionsStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing listUserSessionsStmt: %w", cerr)
+		}
+	}
+	if q.listUsersMissingFromKeyLogStmt != nil {
+		if cerr := q.listUsersMissingFromKeyLogStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing listUsersMissingFromKeyLogStmt: %w", cerr)
+		}
+	}
+	if q.refreshSessionStmt != nil {
+		if cerr := q.refreshSessionStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing refreshSessionStmt: %w", cerr)
+		}
+	}
+	if q.takeChallengeStmt != nil {
+		if cerr := q.takeChallengeStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing takeChallengeStmt: %w", cerr)
+		}
+	}
+	if q.touchUploadSessionStmt != nil {
+		if cerr := q.touchUploadSessionStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing touchUploadSessionStmt: %w", cerr)
+		}
+	}
+	if q.updateUserKeysStmt != nil {
+		if cerr := q.updateUserKeysStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing updateUserKeysStmt: %w", cerr)
+		}
+	}
+	if q.upsertUploadChunkStmt != nil {
+		if cerr := q.upsertUploadChunkStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing upsertUploadChunkStmt: %w", cerr)
+		}
+	}
 	return err
 }
 
@@ -178,41 +394,95 @@ func (q *Queries) queryRow(ctx context.Context, stmt *sql.Stmt, query string, ar
 }
 
 type Queries struct {
-	db                  DBTX
-	tx                  *sql.Tx
-	createChallengeStmt *sql.Stmt
-	createFileStmt      *sql.Stmt
-	createSessionStmt   *sql.Stmt
-	createUserStmt      *sql.Stmt
-	deleteChallengeStmt *sql.Stmt
-	deleteFileStmt      *sql.Stmt
-	deleteSessionStmt   *sql.Stmt
-	deleteUserStmt      *sql.Stmt
-	getChallengeStmt    *sql.Stmt
-	getFileStmt         *sql.Stmt
-	getSessionStmt      *sql.Stmt
-	getUserStmt         *sql.Stmt
-	listAllUsersStmt    *sql.Stmt
-	listFilesStmt       *sql.Stmt
+	db                              DBTX
+	tx                              *sql.Tx
+	appendKeyLogEntryStmt           *sql.Stmt
+	countFilesByBlobStmt            *sql.Stmt
+	countKeyLogEntriesStmt          *sql.Stmt
+	createChallengeStmt             *sql.Stmt
+	createCompletedUploadStmt       *sql.Stmt
+	createFileStmt                  *sql.Stmt
+	createKeyRotationStmt           *sql.Stmt
+	createSessionStmt               *sql.Stmt
+	createUploadSessionStmt         *sql.Stmt
+	createUserStmt                  *sql.Stmt
+	decrementDownloadsStmt          *sql.Stmt
+	deleteChallengeStmt             *sql.Stmt
+	deleteExpiredChallengesStmt     *sql.Stmt
+	deleteExpiredSessionsStmt       *sql.Stmt
+	deleteFileStmt                  *sql.Stmt
+	deleteKeyRotationsStmt          *sql.Stmt
+	deleteStaleCompletedUploadsStmt *sql.Stmt
+	deleteUploadChunksStmt          *sql.Stmt
+	deleteUploadSessionStmt         *sql.Stmt
+	deleteUserStmt                  *sql.Stmt
+	deleteUserSessionStmt           *sql.Stmt
+	deleteUserSessionsStmt          *sql.Stmt
+	getFileStmt                     *sql.Stmt
+	getLatestKeyLogEntryStmt        *sql.Stmt
+	getUploadSessionStmt            *sql.Stmt
+	getUserStmt                     *sql.Stmt
+	listAllUsersStmt                *sql.Stmt
+	listCompletedUploadFilesStmt    *sql.Stmt
+	listExpiredFilesStmt            *sql.Stmt
+	listFilesStmt                   *sql.Stmt
+	listKeyLogHashesStmt            *sql.Stmt
+	listKeyRotationsStmt            *sql.Stmt
+	listStaleUploadSessionsStmt     *sql.Stmt
+	listUploadChunksStmt            *sql.Stmt
+	listUserSessionsStmt            *sql.Stmt
+	listUsersMissingFromKeyLogStmt  *sql.Stmt
+	refreshSessionStmt              *sql.Stmt
+	takeChallengeStmt               *sql.Stmt
+	touchUploadSessionStmt          *sql.Stmt
+	updateUserKeysStmt              *sql.Stmt
+	upsertUploadChunkStmt           *sql.Stmt
 }
 
 func (q *Queries) WithTx(tx *sql.Tx) *Queries {
 	return &Queries{
-		db:                  tx,
-		tx:                  tx,
-		createChallengeStmt: q.createChallengeStmt,
-		createFileStmt:      q.createFileStmt,
-		createSessionStmt:   q.createSessionStmt,
-		createUserStmt:      q.createUserStmt,
-		deleteChallengeStmt: q.deleteChallengeStmt,
-		deleteFileStmt:      q.deleteFileStmt,
-		deleteSessionStmt:   q.deleteSessionStmt,
-		deleteUserStmt:      q.deleteUserStmt,
-		getChallengeStmt:    q.getChallengeStmt,
-		getFileStmt:         q.getFileStmt,
-		getSessionStmt:      q.getSessionStmt,
-		getUserStmt:         q.getUserStmt,
-		listAllUsersStmt:    q.listAllUsersStmt,
-		listFilesStmt:       q.listFilesStmt,
+		db:                              tx,
+		tx:                              tx,
+		appendKeyLogEntryStmt:           q.appendKeyLogEntryStmt,
+		countFilesByBlobStmt:            q.countFilesByBlobStmt,
+		countKeyLogEntriesStmt:          q.countKeyLogEntriesStmt,
+		createChallengeStmt:             q.createChallengeStmt,
+		createCompletedUploadStmt:       q.createCompletedUploadStmt,
+		createFileStmt:                  q.createFileStmt,
+		createKeyRotationStmt:           q.createKeyRotationStmt,
+		createSessionStmt:               q.createSessionStmt,
+		createUploadSessionStmt:         q.createUploadSessionStmt,
+		createUserStmt:                  q.createUserStmt,
+		decrementDownloadsStmt:          q.decrementDownloadsStmt,
+		deleteChallengeStmt:             q.deleteChallengeStmt,
+		deleteExpiredChallengesStmt:     q.deleteExpiredChallengesStmt,
+		deleteExpiredSessionsStmt:       q.deleteExpiredSessionsStmt,
+		deleteFileStmt:                  q.deleteFileStmt,
+		deleteKeyRotationsStmt:          q.deleteKeyRotationsStmt,
+		deleteStaleCompletedUploadsStmt: q.deleteStaleCompletedUploadsStmt,
+		deleteUploadChunksStmt:          q.deleteUploadChunksStmt,
+		deleteUploadSessionStmt:         q.deleteUploadSessionStmt,
+		deleteUserStmt:                  q.deleteUserStmt,
+		deleteUserSessionStmt:           q.deleteUserSessionStmt,
+		deleteUserSessionsStmt:          q.deleteUserSessionsStmt,
+		getFileStmt:                     q.getFileStmt,
+		getLatestKeyLogEntryStmt:        q.getLatestKeyLogEntryStmt,
+		getUploadSessionStmt:            q.getUploadSessionStmt,
+		getUserStmt:                     q.getUserStmt,
+		listAllUsersStmt:                q.listAllUsersStmt,
+		listCompletedUploadFilesStmt:    q.listCompletedUploadFilesStmt,
+		listExpiredFilesStmt:            q.listExpiredFilesStmt,
+		listFilesStmt:                   q.listFilesStmt,
+		listKeyLogHashesStmt:            q.listKeyLogHashesStmt,
+		listKeyRotationsStmt:            q.listKeyRotationsStmt,
+		listStaleUploadSessionsStmt:     q.listStaleUploadSessionsStmt,
+		listUploadChunksStmt:            q.listUploadChunksStmt,
+		listUserSessionsStmt:            q.listUserSessionsStmt,
+		listUsersMissingFromKeyLogStmt:  q.listUsersMissingFromKeyLogStmt,
+		refreshSessionStmt:              q.refreshSessionStmt,
+		takeChallengeStmt:               q.takeChallengeStmt,
+		touchUploadSessionStmt:          q.touchUploadSessionStmt,
+		updateUserKeysStmt:              q.updateUserKeysStmt,
+		upsertUploadChunkStmt:           q.upsertUploadChunkStmt,
 	}
 }
@@ -5,30 +5,84 @@
 package db
 
 import (
+	"database/sql"
 	"time"
 )
 
 type Challenge struct {
-	Username  string    `json:"username"`
-	Nonce     string    `json:"nonce"`
-	CreatedAt time.Time `json:"created_at"`
+	Username  string       `json:"username"`
+	Nonce     string       `json:"nonce"`
+	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt sql.NullTime `json:"expires_at"`
+}
+
+type CompletedUpload struct {
+	UploadID    string    `json:"upload_id"`
+	FileID      string    `json:"file_id"`
+	Sender      string    `json:"sender"`
+	CompletedAt time.Time `json:"completed_at"`
 }
 
 type File struct {
-	ID           string    `json:"id"`
-	Sender       string    `json:"sender"`
-	Recipient    string    `json:"recipient"`
-	FileName     string    `json:"file_name"`
-	EncryptedKey []byte    `json:"encrypted_key"`
-	AutoDelete   bool      `json:"auto_delete"`
-	Timestamp    time.Time `json:"timestamp"`
+	ID                 string        `json:"id"`
+	Sender             string        `json:"sender"`
+	Recipient          string        `json:"recipient"`
+	FileName           string        `json:"file_name"`
+	EncryptedKey       []byte        `json:"encrypted_key"`
+	AutoDelete         bool          `json:"auto_delete"`
+	Timestamp          time.Time     `json:"timestamp"`
+	Signature          []byte        `json:"signature"`
+	SignedAt           sql.NullTime  `json:"signed_at"`
+	EncryptedMetadata  []byte        `json:"encrypted_metadata"`
+	BlobID             string        `json:"blob_id"`
+	WrappedKey         []byte        `json:"wrapped_key"`
+	ExpiresAt          sql.NullTime  `json:"expires_at"`
+	DownloadsRemaining sql.NullInt64 `json:"downloads_remaining"`
+}
+
+type KeyLog struct {
+	Idx               int64     `json:"idx"`
+	Username          string    `json:"username"`
+	Version           int64     `json:"version"`
+	IdentityPublicKey []byte    `json:"identity_public_key"`
+	ExchangePublicKey []byte    `json:"exchange_public_key"`
+	LeafHash          []byte    `json:"leaf_hash"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+type KeyRotation struct {
+	Username             string    `json:"username"`
+	Version              int64     `json:"version"`
+	OldIdentityPublicKey []byte    `json:"old_identity_public_key"`
+	IdentityPublicKey    []byte    `json:"identity_public_key"`
+	ExchangePublicKey    []byte    `json:"exchange_public_key"`
+	RotatedAt            time.Time `json:"rotated_at"`
+	Signature            []byte    `json:"signature"`
 }
 
 type Session struct {
-	Token     string    `json:"token"`
-	Username  string    `json:"username"`
-	ExpiresAt time.Time `json:"expires_at"`
+	RefreshHash string       `json:"refresh_hash"`
+	Username    string       `json:"username"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+	CreatedAt   time.Time    `json:"created_at"`
+	ID          string       `json:"id"`
+	Device      string       `json:"device"`
+	LastUsedAt  sql.NullTime `json:"last_used_at"`
+}
+
+type UploadChunk struct {
+	SessionID string `json:"session_id"`
+	Idx       int64  `json:"idx"`
+	Size      int64  `json:"size"`
+}
+
+type UploadSession struct {
+	ID        string    `json:"id"`
+	Sender    string    `json:"sender"`
+	Metadata  string    `json:"metadata"`
+	ChunkSize int64     `json:"chunk_size"`
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type User struct {
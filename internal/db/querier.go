@@ -6,23 +6,52 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"time"
 )
 
 type Querier interface {
+	AppendKeyLogEntry(ctx context.Context, arg AppendKeyLogEntryParams) error
+	CountFilesByBlob(ctx context.Context, blobID string) (int64, error)
+	CountKeyLogEntries(ctx context.Context) (int64, error)
 	CreateChallenge(ctx context.Context, arg CreateChallengeParams) error
+	CreateCompletedUpload(ctx context.Context, arg CreateCompletedUploadParams) error
 	CreateFile(ctx context.Context, arg CreateFileParams) error
+	CreateKeyRotation(ctx context.Context, arg CreateKeyRotationParams) error
 	CreateSession(ctx context.Context, arg CreateSessionParams) error
+	CreateUploadSession(ctx context.Context, arg CreateUploadSessionParams) error
 	CreateUser(ctx context.Context, arg CreateUserParams) error
+	DecrementDownloads(ctx context.Context, id string) (sql.NullInt64, error)
 	DeleteChallenge(ctx context.Context, username string) error
+	DeleteExpiredChallenges(ctx context.Context, expiresAt sql.NullTime) (int64, error)
+	DeleteExpiredSessions(ctx context.Context, expiresAt time.Time) (int64, error)
 	DeleteFile(ctx context.Context, id string) error
-	DeleteSession(ctx context.Context, token string) error
+	DeleteKeyRotations(ctx context.Context, username string) error
+	DeleteStaleCompletedUploads(ctx context.Context, completedAt time.Time) (int64, error)
+	DeleteUploadChunks(ctx context.Context, sessionID string) error
+	DeleteUploadSession(ctx context.Context, id string) (int64, error)
 	DeleteUser(ctx context.Context, username string) error
-	GetChallenge(ctx context.Context, username string) (string, error)
+	DeleteUserSession(ctx context.Context, arg DeleteUserSessionParams) (int64, error)
+	DeleteUserSessions(ctx context.Context, username string) error
 	GetFile(ctx context.Context, id string) (File, error)
-	GetSession(ctx context.Context, token string) (Session, error)
+	GetLatestKeyLogEntry(ctx context.Context, username string) (KeyLog, error)
+	GetUploadSession(ctx context.Context, id string) (UploadSession, error)
 	GetUser(ctx context.Context, username string) (User, error)
 	ListAllUsers(ctx context.Context) ([]ListAllUsersRow, error)
+	ListCompletedUploadFiles(ctx context.Context, arg ListCompletedUploadFilesParams) ([]File, error)
+	ListExpiredFiles(ctx context.Context, expiresAt sql.NullTime) ([]string, error)
 	ListFiles(ctx context.Context, recipient string) ([]File, error)
+	ListKeyLogHashes(ctx context.Context) ([][]byte, error)
+	ListKeyRotations(ctx context.Context, username string) ([]KeyRotation, error)
+	ListStaleUploadSessions(ctx context.Context, updatedAt time.Time) ([]string, error)
+	ListUploadChunks(ctx context.Context, sessionID string) ([]ListUploadChunksRow, error)
+	ListUserSessions(ctx context.Context, username string) ([]Session, error)
+	ListUsersMissingFromKeyLog(ctx context.Context) ([]ListUsersMissingFromKeyLogRow, error)
+	RefreshSession(ctx context.Context, arg RefreshSessionParams) (Session, error)
+	TakeChallenge(ctx context.Context, arg TakeChallengeParams) (sql.NullTime, error)
+	TouchUploadSession(ctx context.Context, arg TouchUploadSessionParams) error
+	UpdateUserKeys(ctx context.Context, arg UpdateUserKeysParams) error
+	UpsertUploadChunk(ctx context.Context, arg UpsertUploadChunkParams) error
 }
 
 var _ Querier = (*Queries)(nil)
@@ -7,38 +7,118 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"time"
 )
 
+const appendKeyLogEntry = `-- name: AppendKeyLogEntry :exec
+INSERT INTO key_log (idx, username, version, identity_public_key, exchange_public_key, leaf_hash)
+VALUES (?, ?, ?, ?, ?, ?)
+`
+
+type AppendKeyLogEntryParams struct {
+	Idx               int64  `json:"idx"`
+	Username          string `json:"username"`
+	Version           int64  `json:"version"`
+	IdentityPublicKey []byte `json:"identity_public_key"`
+	ExchangePublicKey []byte `json:"exchange_public_key"`
+	LeafHash          []byte `json:"leaf_hash"`
+}
+
+func (q *Queries) AppendKeyLogEntry(ctx context.Context, arg AppendKeyLogEntryParams) error {
+	_, err := q.exec(ctx, q.appendKeyLogEntryStmt, appendKeyLogEntry,
+		arg.Idx,
+		arg.Username,
+		arg.Version,
+		arg.IdentityPublicKey,
+		arg.ExchangePublicKey,
+		arg.LeafHash,
+	)
+	return err
+}
+
+const countFilesByBlob = `-- name: CountFilesByBlob :one
+SELECT COUNT(*) FROM files
+WHERE blob_id = ?
+`
+
+func (q *Queries) CountFilesByBlob(ctx context.Context, blobID string) (int64, error) {
+	row := q.queryRow(ctx, q.countFilesByBlobStmt, countFilesByBlob, blobID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countKeyLogEntries = `-- name: CountKeyLogEntries :one
+SELECT COUNT(*) FROM key_log
+`
+
+func (q *Queries) CountKeyLogEntries(ctx context.Context) (int64, error) {
+	row := q.queryRow(ctx, q.countKeyLogEntriesStmt, countKeyLogEntries)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createChallenge = `-- name: CreateChallenge :exec
-INSERT INTO challenges (username, nonce)
-VALUES (?, ?)
-ON CONFLICT(username) DO UPDATE SET nonce = excluded.nonce
+INSERT INTO challenges (username, nonce, expires_at)
+VALUES (?, ?, ?)
+ON CONFLICT(username) DO UPDATE SET nonce = excluded.nonce, expires_at = excluded.expires_at
 `
 
 type CreateChallengeParams struct {
-	Username string `json:"username"`
-	Nonce    string `json:"nonce"`
+	Username  string       `json:"username"`
+	Nonce     string       `json:"nonce"`
+	ExpiresAt sql.NullTime `json:"expires_at"`
 }
 
 func (q *Queries) CreateChallenge(ctx context.Context, arg CreateChallengeParams) error {
-	_, err := q.exec(ctx, q.createChallengeStmt, createChallenge, arg.Username, arg.Nonce)
+	_, err := q.exec(ctx, q.createChallengeStmt, createChallenge, arg.Username, arg.Nonce, arg.ExpiresAt)
+	return err
+}
+
+const createCompletedUpload = `-- name: CreateCompletedUpload :exec
+INSERT INTO completed_uploads (upload_id, file_id, sender, completed_at)
+VALUES (?, ?, ?, ?)
+`
+
+type CreateCompletedUploadParams struct {
+	UploadID    string    `json:"upload_id"`
+	FileID      string    `json:"file_id"`
+	Sender      string    `json:"sender"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+func (q *Queries) CreateCompletedUpload(ctx context.Context, arg CreateCompletedUploadParams) error {
+	_, err := q.exec(ctx, q.createCompletedUploadStmt, createCompletedUpload,
+		arg.UploadID,
+		arg.FileID,
+		arg.Sender,
+		arg.CompletedAt,
+	)
 	return err
 }
 
 const createFile = `-- name: CreateFile :exec
-INSERT INTO files (id, sender, recipient, file_name, encrypted_key, auto_delete, timestamp)
-VALUES (?, ?, ?, ?, ?, ?, ?)
+INSERT INTO files (id, sender, recipient, file_name, encrypted_key, auto_delete, timestamp, signature, signed_at, encrypted_metadata, blob_id, wrapped_key, expires_at, downloads_remaining)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
 type CreateFileParams struct {
-	ID           string    `json:"id"`
-	Sender       string    `json:"sender"`
-	Recipient    string    `json:"recipient"`
-	FileName     string    `json:"file_name"`
-	EncryptedKey []byte    `json:"encrypted_key"`
-	AutoDelete   bool      `json:"auto_delete"`
-	Timestamp    time.Time `json:"timestamp"`
+	ID                 string        `json:"id"`
+	Sender             string        `json:"sender"`
+	Recipient          string        `json:"recipient"`
+	FileName           string        `json:"file_name"`
+	EncryptedKey       []byte        `json:"encrypted_key"`
+	AutoDelete         bool          `json:"auto_delete"`
+	Timestamp          time.Time     `json:"timestamp"`
+	Signature          []byte        `json:"signature"`
+	SignedAt           sql.NullTime  `json:"signed_at"`
+	EncryptedMetadata  []byte        `json:"encrypted_metadata"`
+	BlobID             string        `json:"blob_id"`
+	WrappedKey         []byte        `json:"wrapped_key"`
+	ExpiresAt          sql.NullTime  `json:"expires_at"`
+	DownloadsRemaining sql.NullInt64 `json:"downloads_remaining"`
 }
 
 func (q *Queries) CreateFile(ctx context.Context, arg CreateFileParams) error {
@@ -50,23 +130,94 @@ func (q *Queries) CreateFile(ctx context.Context, arg CreateFileParams) error {
 		arg.EncryptedKey,
 		arg.AutoDelete,
 		arg.Timestamp,
+		arg.Signature,
+		arg.SignedAt,
+		arg.EncryptedMetadata,
+		arg.BlobID,
+		arg.WrappedKey,
+		arg.ExpiresAt,
+		arg.DownloadsRemaining,
+	)
+	return err
+}
+
+const createKeyRotation = `-- name: CreateKeyRotation :exec
+INSERT INTO key_rotations (username, version, old_identity_public_key, identity_public_key, exchange_public_key, rotated_at, signature)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+`
+
+type CreateKeyRotationParams struct {
+	Username             string    `json:"username"`
+	Version              int64     `json:"version"`
+	OldIdentityPublicKey []byte    `json:"old_identity_public_key"`
+	IdentityPublicKey    []byte    `json:"identity_public_key"`
+	ExchangePublicKey    []byte    `json:"exchange_public_key"`
+	RotatedAt            time.Time `json:"rotated_at"`
+	Signature            []byte    `json:"signature"`
+}
+
+func (q *Queries) CreateKeyRotation(ctx context.Context, arg CreateKeyRotationParams) error {
+	_, err := q.exec(ctx, q.createKeyRotationStmt, createKeyRotation,
+		arg.Username,
+		arg.Version,
+		arg.OldIdentityPublicKey,
+		arg.IdentityPublicKey,
+		arg.ExchangePublicKey,
+		arg.RotatedAt,
+		arg.Signature,
 	)
 	return err
 }
 
 const createSession = `-- name: CreateSession :exec
-INSERT INTO sessions (token, username, expires_at)
-VALUES (?, ?, ?)
+INSERT INTO sessions (refresh_hash, id, username, device, expires_at, last_used_at)
+VALUES (?, ?, ?, ?, ?, ?)
 `
 
 type CreateSessionParams struct {
-	Token     string    `json:"token"`
-	Username  string    `json:"username"`
-	ExpiresAt time.Time `json:"expires_at"`
+	RefreshHash string       `json:"refresh_hash"`
+	ID          string       `json:"id"`
+	Username    string       `json:"username"`
+	Device      string       `json:"device"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+	LastUsedAt  sql.NullTime `json:"last_used_at"`
 }
 
 func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) error {
-	_, err := q.exec(ctx, q.createSessionStmt, createSession, arg.Token, arg.Username, arg.ExpiresAt)
+	_, err := q.exec(ctx, q.createSessionStmt, createSession,
+		arg.RefreshHash,
+		arg.ID,
+		arg.Username,
+		arg.Device,
+		arg.ExpiresAt,
+		arg.LastUsedAt,
+	)
+	return err
+}
+
+const createUploadSession = `-- name: CreateUploadSession :exec
+INSERT INTO upload_sessions (id, sender, metadata, chunk_size, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?)
+`
+
+type CreateUploadSessionParams struct {
+	ID        string    `json:"id"`
+	Sender    string    `json:"sender"`
+	Metadata  string    `json:"metadata"`
+	ChunkSize int64     `json:"chunk_size"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (q *Queries) CreateUploadSession(ctx context.Context, arg CreateUploadSessionParams) error {
+	_, err := q.exec(ctx, q.createUploadSessionStmt, createUploadSession,
+		arg.ID,
+		arg.Sender,
+		arg.Metadata,
+		arg.ChunkSize,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+	)
 	return err
 }
 
@@ -86,6 +237,19 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) error {
 	return err
 }
 
+const decrementDownloads = `-- name: DecrementDownloads :one
+UPDATE files SET downloads_remaining = downloads_remaining - 1
+WHERE id = ? AND downloads_remaining > 0
+RETURNING downloads_remaining
+`
+
+func (q *Queries) DecrementDownloads(ctx context.Context, id string) (sql.NullInt64, error) {
+	row := q.queryRow(ctx, q.decrementDownloadsStmt, decrementDownloads, id)
+	var downloads_remaining sql.NullInt64
+	err := row.Scan(&downloads_remaining)
+	return downloads_remaining, err
+}
+
 const deleteChallenge = `-- name: DeleteChallenge :exec
 DELETE FROM challenges
 WHERE username = ?
@@ -96,6 +260,32 @@ func (q *Queries) DeleteChallenge(ctx context.Context, username string) error {
 	return err
 }
 
+const deleteExpiredChallenges = `-- name: DeleteExpiredChallenges :execrows
+DELETE FROM challenges
+WHERE expires_at IS NULL OR expires_at < ?
+`
+
+func (q *Queries) DeleteExpiredChallenges(ctx context.Context, expiresAt sql.NullTime) (int64, error) {
+	result, err := q.exec(ctx, q.deleteExpiredChallengesStmt, deleteExpiredChallenges, expiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :execrows
+DELETE FROM sessions
+WHERE expires_at < ?
+`
+
+func (q *Queries) DeleteExpiredSessions(ctx context.Context, expiresAt time.Time) (int64, error) {
+	result, err := q.exec(ctx, q.deleteExpiredSessionsStmt, deleteExpiredSessions, expiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const deleteFile = `-- name: DeleteFile :exec
 DELETE FROM files
 WHERE id = ?
@@ -106,16 +296,52 @@ func (q *Queries) DeleteFile(ctx context.Context, id string) error {
 	return err
 }
 
-const deleteSession = `-- name: DeleteSession :exec
-DELETE FROM sessions
-WHERE token = ?
+const deleteKeyRotations = `-- name: DeleteKeyRotations :exec
+DELETE FROM key_rotations
+WHERE username = ?
+`
+
+func (q *Queries) DeleteKeyRotations(ctx context.Context, username string) error {
+	_, err := q.exec(ctx, q.deleteKeyRotationsStmt, deleteKeyRotations, username)
+	return err
+}
+
+const deleteStaleCompletedUploads = `-- name: DeleteStaleCompletedUploads :execrows
+DELETE FROM completed_uploads
+WHERE completed_at < ?
 `
 
-func (q *Queries) DeleteSession(ctx context.Context, token string) error {
-	_, err := q.exec(ctx, q.deleteSessionStmt, deleteSession, token)
+func (q *Queries) DeleteStaleCompletedUploads(ctx context.Context, completedAt time.Time) (int64, error) {
+	result, err := q.exec(ctx, q.deleteStaleCompletedUploadsStmt, deleteStaleCompletedUploads, completedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUploadChunks = `-- name: DeleteUploadChunks :exec
+DELETE FROM upload_chunks
+WHERE session_id = ?
+`
+
+func (q *Queries) DeleteUploadChunks(ctx context.Context, sessionID string) error {
+	_, err := q.exec(ctx, q.deleteUploadChunksStmt, deleteUploadChunks, sessionID)
 	return err
 }
 
+const deleteUploadSession = `-- name: DeleteUploadSession :execrows
+DELETE FROM upload_sessions
+WHERE id = ?
+`
+
+func (q *Queries) DeleteUploadSession(ctx context.Context, id string) (int64, error) {
+	result, err := q.exec(ctx, q.deleteUploadSessionStmt, deleteUploadSession, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const deleteUser = `-- name: DeleteUser :exec
 DELETE FROM users
 WHERE username = ?
@@ -126,20 +352,36 @@ func (q *Queries) DeleteUser(ctx context.Context, username string) error {
 	return err
 }
 
-const getChallenge = `-- name: GetChallenge :one
-SELECT nonce FROM challenges
-WHERE username = ? LIMIT 1
+const deleteUserSession = `-- name: DeleteUserSession :execrows
+DELETE FROM sessions
+WHERE username = ? AND id = ?
 `
 
-func (q *Queries) GetChallenge(ctx context.Context, username string) (string, error) {
-	row := q.queryRow(ctx, q.getChallengeStmt, getChallenge, username)
-	var nonce string
-	err := row.Scan(&nonce)
-	return nonce, err
+type DeleteUserSessionParams struct {
+	Username string `json:"username"`
+	ID       string `json:"id"`
+}
+
+func (q *Queries) DeleteUserSession(ctx context.Context, arg DeleteUserSessionParams) (int64, error) {
+	result, err := q.exec(ctx, q.deleteUserSessionStmt, deleteUserSession, arg.Username, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUserSessions = `-- name: DeleteUserSessions :exec
+DELETE FROM sessions
+WHERE username = ?
+`
+
+func (q *Queries) DeleteUserSessions(ctx context.Context, username string) error {
+	_, err := q.exec(ctx, q.deleteUserSessionsStmt, deleteUserSessions, username)
+	return err
 }
 
 const getFile = `-- name: GetFile :one
-SELECT id, sender, recipient, file_name, encrypted_key, auto_delete, timestamp FROM files
+SELECT id, sender, recipient, file_name, encrypted_key, auto_delete, timestamp, signature, signed_at, encrypted_metadata, blob_id, wrapped_key, expires_at, downloads_remaining FROM files
 WHERE id = ? LIMIT 1
 `
 
@@ -154,27 +396,57 @@ func (q *Queries) GetFile(ctx context.Context, id string) (File, error) {
 		&i.EncryptedKey,
 		&i.AutoDelete,
 		&i.Timestamp,
+		&i.Signature,
+		&i.SignedAt,
+		&i.EncryptedMetadata,
+		&i.BlobID,
+		&i.WrappedKey,
+		&i.ExpiresAt,
+		&i.DownloadsRemaining,
 	)
 	return i, err
 }
 
-const getSession = `-- name: GetSession :one
-SELECT token, username, expires_at, created_at FROM sessions
-WHERE token = ? LIMIT 1
+const getLatestKeyLogEntry = `-- name: GetLatestKeyLogEntry :one
+SELECT idx, username, version, identity_public_key, exchange_public_key, leaf_hash, created_at FROM key_log
+WHERE username = ?
+ORDER BY idx DESC LIMIT 1
 `
 
-func (q *Queries) GetSession(ctx context.Context, token string) (Session, error) {
-	row := q.queryRow(ctx, q.getSessionStmt, getSession, token)
-	var i Session
+func (q *Queries) GetLatestKeyLogEntry(ctx context.Context, username string) (KeyLog, error) {
+	row := q.queryRow(ctx, q.getLatestKeyLogEntryStmt, getLatestKeyLogEntry, username)
+	var i KeyLog
 	err := row.Scan(
-		&i.Token,
+		&i.Idx,
 		&i.Username,
-		&i.ExpiresAt,
+		&i.Version,
+		&i.IdentityPublicKey,
+		&i.ExchangePublicKey,
+		&i.LeafHash,
 		&i.CreatedAt,
 	)
 	return i, err
 }
 
+const getUploadSession = `-- name: GetUploadSession :one
+SELECT id, sender, metadata, chunk_size, created_at, updated_at FROM upload_sessions
+WHERE id = ? LIMIT 1
+`
+
+func (q *Queries) GetUploadSession(ctx context.Context, id string) (UploadSession, error) {
+	row := q.queryRow(ctx, q.getUploadSessionStmt, getUploadSession, id)
+	var i UploadSession
+	err := row.Scan(
+		&i.ID,
+		&i.Sender,
+		&i.Metadata,
+		&i.ChunkSize,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const getUser = `-- name: GetUser :one
 SELECT username, identity_public_key, exchange_public_key, created_at FROM users
 WHERE username = ? LIMIT 1
@@ -227,8 +499,86 @@ func (q *Queries) ListAllUsers(ctx context.Context) ([]ListAllUsersRow, error) {
 	return items, nil
 }
 
+const listCompletedUploadFiles = `-- name: ListCompletedUploadFiles :many
+SELECT files.id, files.sender, files.recipient, files.file_name, files.encrypted_key, files.auto_delete, files.timestamp, files.signature, files.signed_at, files.encrypted_metadata, files.blob_id, files.wrapped_key, files.expires_at, files.downloads_remaining FROM files
+JOIN completed_uploads ON completed_uploads.file_id = files.id
+WHERE completed_uploads.upload_id = ? AND completed_uploads.sender = ?
+ORDER BY completed_uploads.rowid
+`
+
+type ListCompletedUploadFilesParams struct {
+	UploadID string `json:"upload_id"`
+	Sender   string `json:"sender"`
+}
+
+func (q *Queries) ListCompletedUploadFiles(ctx context.Context, arg ListCompletedUploadFilesParams) ([]File, error) {
+	rows, err := q.query(ctx, q.listCompletedUploadFilesStmt, listCompletedUploadFiles, arg.UploadID, arg.Sender)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []File
+	for rows.Next() {
+		var i File
+		if err := rows.Scan(
+			&i.ID,
+			&i.Sender,
+			&i.Recipient,
+			&i.FileName,
+			&i.EncryptedKey,
+			&i.AutoDelete,
+			&i.Timestamp,
+			&i.Signature,
+			&i.SignedAt,
+			&i.EncryptedMetadata,
+			&i.BlobID,
+			&i.WrappedKey,
+			&i.ExpiresAt,
+			&i.DownloadsRemaining,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExpiredFiles = `-- name: ListExpiredFiles :many
+SELECT id FROM files
+WHERE expires_at < ? OR downloads_remaining = 0
+`
+
+func (q *Queries) ListExpiredFiles(ctx context.Context, expiresAt sql.NullTime) ([]string, error) {
+	rows, err := q.query(ctx, q.listExpiredFilesStmt, listExpiredFiles, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listFiles = `-- name: ListFiles :many
-SELECT id, sender, recipient, file_name, encrypted_key, auto_delete, timestamp FROM files
+SELECT id, sender, recipient, file_name, encrypted_key, auto_delete, timestamp, signature, signed_at, encrypted_metadata, blob_id, wrapped_key, expires_at, downloads_remaining FROM files
 WHERE recipient = ?
 ORDER BY timestamp DESC
 `
@@ -250,6 +600,177 @@ func (q *Queries) ListFiles(ctx context.Context, recipient string) ([]File, erro
 			&i.EncryptedKey,
 			&i.AutoDelete,
 			&i.Timestamp,
+			&i.Signature,
+			&i.SignedAt,
+			&i.EncryptedMetadata,
+			&i.BlobID,
+			&i.WrappedKey,
+			&i.ExpiresAt,
+			&i.DownloadsRemaining,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listKeyLogHashes = `-- name: ListKeyLogHashes :many
+SELECT leaf_hash FROM key_log
+ORDER BY idx
+`
+
+func (q *Queries) ListKeyLogHashes(ctx context.Context) ([][]byte, error) {
+	rows, err := q.query(ctx, q.listKeyLogHashesStmt, listKeyLogHashes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items [][]byte
+	for rows.Next() {
+		var leaf_hash []byte
+		if err := rows.Scan(&leaf_hash); err != nil {
+			return nil, err
+		}
+		items = append(items, leaf_hash)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listKeyRotations = `-- name: ListKeyRotations :many
+SELECT username, version, old_identity_public_key, identity_public_key, exchange_public_key, rotated_at, signature FROM key_rotations
+WHERE username = ?
+ORDER BY version
+`
+
+func (q *Queries) ListKeyRotations(ctx context.Context, username string) ([]KeyRotation, error) {
+	rows, err := q.query(ctx, q.listKeyRotationsStmt, listKeyRotations, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []KeyRotation
+	for rows.Next() {
+		var i KeyRotation
+		if err := rows.Scan(
+			&i.Username,
+			&i.Version,
+			&i.OldIdentityPublicKey,
+			&i.IdentityPublicKey,
+			&i.ExchangePublicKey,
+			&i.RotatedAt,
+			&i.Signature,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listStaleUploadSessions = `-- name: ListStaleUploadSessions :many
+SELECT id FROM upload_sessions
+WHERE updated_at < ?
+`
+
+func (q *Queries) ListStaleUploadSessions(ctx context.Context, updatedAt time.Time) ([]string, error) {
+	rows, err := q.query(ctx, q.listStaleUploadSessionsStmt, listStaleUploadSessions, updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUploadChunks = `-- name: ListUploadChunks :many
+SELECT idx, size FROM upload_chunks
+WHERE session_id = ?
+ORDER BY idx
+`
+
+type ListUploadChunksRow struct {
+	Idx  int64 `json:"idx"`
+	Size int64 `json:"size"`
+}
+
+func (q *Queries) ListUploadChunks(ctx context.Context, sessionID string) ([]ListUploadChunksRow, error) {
+	rows, err := q.query(ctx, q.listUploadChunksStmt, listUploadChunks, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUploadChunksRow
+	for rows.Next() {
+		var i ListUploadChunksRow
+		if err := rows.Scan(&i.Idx, &i.Size); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserSessions = `-- name: ListUserSessions :many
+SELECT refresh_hash, username, expires_at, created_at, id, device, last_used_at FROM sessions
+WHERE username = ?
+ORDER BY created_at
+`
+
+func (q *Queries) ListUserSessions(ctx context.Context, username string) ([]Session, error) {
+	rows, err := q.query(ctx, q.listUserSessionsStmt, listUserSessions, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.RefreshHash,
+			&i.Username,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+			&i.ID,
+			&i.Device,
+			&i.LastUsedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -263,3 +784,141 @@ func (q *Queries) ListFiles(ctx context.Context, recipient string) ([]File, erro
 	}
 	return items, nil
 }
+
+const listUsersMissingFromKeyLog = `-- name: ListUsersMissingFromKeyLog :many
+SELECT username, identity_public_key, exchange_public_key FROM users
+WHERE username NOT IN (SELECT username FROM key_log)
+ORDER BY username
+`
+
+type ListUsersMissingFromKeyLogRow struct {
+	Username          string `json:"username"`
+	IdentityPublicKey []byte `json:"identity_public_key"`
+	ExchangePublicKey []byte `json:"exchange_public_key"`
+}
+
+func (q *Queries) ListUsersMissingFromKeyLog(ctx context.Context) ([]ListUsersMissingFromKeyLogRow, error) {
+	rows, err := q.query(ctx, q.listUsersMissingFromKeyLogStmt, listUsersMissingFromKeyLog)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUsersMissingFromKeyLogRow
+	for rows.Next() {
+		var i ListUsersMissingFromKeyLogRow
+		if err := rows.Scan(&i.Username, &i.IdentityPublicKey, &i.ExchangePublicKey); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const refreshSession = `-- name: RefreshSession :one
+UPDATE sessions
+SET refresh_hash = ?, expires_at = ?, last_used_at = ?
+WHERE refresh_hash = ? AND expires_at > ?
+  AND EXISTS (SELECT 1 FROM users WHERE users.username = sessions.username)
+RETURNING refresh_hash, username, expires_at, created_at, id, device, last_used_at
+`
+
+type RefreshSessionParams struct {
+	NewRefreshHash string       `json:"new_refresh_hash"`
+	ExpiresAt      time.Time    `json:"expires_at"`
+	LastUsedAt     sql.NullTime `json:"last_used_at"`
+	RefreshHash    string       `json:"refresh_hash"`
+	Now            time.Time    `json:"now"`
+}
+
+func (q *Queries) RefreshSession(ctx context.Context, arg RefreshSessionParams) (Session, error) {
+	row := q.queryRow(ctx, q.refreshSessionStmt, refreshSession,
+		arg.NewRefreshHash,
+		arg.ExpiresAt,
+		arg.LastUsedAt,
+		arg.RefreshHash,
+		arg.Now,
+	)
+	var i Session
+	err := row.Scan(
+		&i.RefreshHash,
+		&i.Username,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.ID,
+		&i.Device,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const takeChallenge = `-- name: TakeChallenge :one
+DELETE FROM challenges
+WHERE username = ? AND nonce = ?
+RETURNING expires_at
+`
+
+type TakeChallengeParams struct {
+	Username string `json:"username"`
+	Nonce    string `json:"nonce"`
+}
+
+func (q *Queries) TakeChallenge(ctx context.Context, arg TakeChallengeParams) (sql.NullTime, error) {
+	row := q.queryRow(ctx, q.takeChallengeStmt, takeChallenge, arg.Username, arg.Nonce)
+	var expires_at sql.NullTime
+	err := row.Scan(&expires_at)
+	return expires_at, err
+}
+
+const touchUploadSession = `-- name: TouchUploadSession :exec
+UPDATE upload_sessions SET updated_at = ?
+WHERE id = ?
+`
+
+type TouchUploadSessionParams struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	ID        string    `json:"id"`
+}
+
+func (q *Queries) TouchUploadSession(ctx context.Context, arg TouchUploadSessionParams) error {
+	_, err := q.exec(ctx, q.touchUploadSessionStmt, touchUploadSession, arg.UpdatedAt, arg.ID)
+	return err
+}
+
+const updateUserKeys = `-- name: UpdateUserKeys :exec
+UPDATE users SET identity_public_key = ?, exchange_public_key = ?
+WHERE username = ?
+`
+
+type UpdateUserKeysParams struct {
+	IdentityPublicKey []byte `json:"identity_public_key"`
+	ExchangePublicKey []byte `json:"exchange_public_key"`
+	Username          string `json:"username"`
+}
+
+func (q *Queries) UpdateUserKeys(ctx context.Context, arg UpdateUserKeysParams) error {
+	_, err := q.exec(ctx, q.updateUserKeysStmt, updateUserKeys, arg.IdentityPublicKey, arg.ExchangePublicKey, arg.Username)
+	return err
+}
+
+const upsertUploadChunk = `-- name: UpsertUploadChunk :exec
+INSERT INTO upload_chunks (session_id, idx, size)
+VALUES (?, ?, ?)
+ON CONFLICT(session_id, idx) DO UPDATE SET size = excluded.size
+`
+
+type UpsertUploadChunkParams struct {
+	SessionID string `json:"session_id"`
+	Idx       int64  `json:"idx"`
+	Size      int64  `json:"size"`
+}
+
+func (q *Queries) UpsertUploadChunk(ctx context.Context, arg UpsertUploadChunkParams) error {
+	_, err := q.exec(ctx, q.upsertUploadChunkStmt, upsertUploadChunk, arg.SessionID, arg.Idx, arg.Size)
+	return err
+}
@@ -2,13 +2,20 @@ package e2e
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/VinMeld/go-send/internal/client"
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/db"
 	"github.com/VinMeld/go-send/internal/server"
 )
 
@@ -28,7 +35,23 @@ func TestEndToEnd(t *testing.T) {
 	handler := server.NewHandler(storage)
 	handler.SetRegistrationToken("secret-token")
 
-	ts := httptest.NewServer(handler)
+	// While cutStreams is set, file downloads are cut off after that many
+	// bytes, as by a dropped connection
+	var cutStreams atomic.Int64
+	// While dropRotations is set, key rotations are applied but the client
+	// gets an error instead of the server's response
+	var dropRotations atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := cutStreams.Load(); n > 0 && strings.HasSuffix(r.URL.Path, "/files/stream") {
+			w = &cutWriter{ResponseWriter: w, left: n}
+		}
+		if dropRotations.Load() && r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/users/keys") {
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			http.Error(w, "connection lost", http.StatusBadGateway)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
 	defer ts.Close()
 
 	// 2. Setup Client Configs
@@ -61,6 +84,15 @@ func TestEndToEnd(t *testing.T) {
 		cmd := client.GetRootCmd()
 		cmd.SetArgs(append(args, "--config", configFile))
 
+		// Flags keep their values between runs, and repeated --to flags
+		// would add up
+		if send, _, err := cmd.Find([]string{"send-file"}); err == nil {
+			_ = send.Flags().Lookup("to").Value.(interface{ Replace([]string) error }).Replace(nil)
+		}
+		if download, _, err := cmd.Find([]string{"download-file"}); err == nil {
+			_ = download.Flags().Set("allow-unverified", "false")
+		}
+
 		// We also need to ensure the config is reloaded for each run
 		// The client.Execute() calls initConfig() via OnInitialize,
 		// but OnInitialize only registers the function.
@@ -130,7 +162,7 @@ func TestEndToEnd(t *testing.T) {
 	}
 
 	// Bob is not in Alice's address book, so this tests discovery too
-	output, err := runCmd(aliceDir, "send-file", "bob", testFile)
+	output, err := runCmd(aliceDir, "send-file", "bob", testFile, "--message", "Hi Bob")
 	if err != nil {
 		t.Fatalf("Alice send-file failed: %v", err)
 	}
@@ -152,6 +184,12 @@ func TestEndToEnd(t *testing.T) {
 	if !strings.Contains(output, "hello.txt") {
 		t.Errorf("Expected hello.txt in list, got: %s", output)
 	}
+	if !strings.Contains(output, "Message: Hi Bob") {
+		t.Errorf("Expected decrypted message in list, got: %s", output)
+	}
+	if !strings.Contains(output, "expires in 7d0h") {
+		t.Errorf("Expected time to live in list, got: %s", output)
+	}
 
 	// 7. Bob Download File
 	// We use index 1
@@ -182,4 +220,428 @@ func TestEndToEnd(t *testing.T) {
 	if string(content) != "Hello Bob!" {
 		t.Errorf("Expected 'Hello Bob!', got '%s'", string(content))
 	}
+
+	// 9. Resume an interrupted download of a multi-chunk file
+	big := bytes.Repeat([]byte("0123456789abcdef"), 20000)
+	bigFile := filepath.Join(aliceDir, "big.bin")
+	if err := os.WriteFile(bigFile, big, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := runCmd(aliceDir, "send-file", "bob", bigFile); err != nil || !strings.Contains(output, "File sent successfully") {
+		t.Fatalf("Alice send-file failed: %v %s", err, output)
+	}
+
+	files, err := storage.ListFiles(context.Background(), "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Files are listed newest first, and the server cannot see their names
+	bigID := files[0].ID
+	for _, f := range files {
+		if f.FileName != "" || len(f.EncryptedMetadata) == 0 {
+			t.Errorf("Server stored a plaintext file name: %+v", f)
+		}
+	}
+
+	// The connection drops during the fourth chunk
+	cutStreams.Store(3*crypto.StreamChunkSize + 100)
+	if output, _ := runCmd(bobDir, "download-file", bigID); !strings.Contains(output, "Run the same command again to resume") {
+		t.Fatalf("Expected the download to be interrupted, got: %s", output)
+	}
+	cutStreams.Store(0)
+	if _, err := os.Stat(filepath.Join(bobDir, "."+bigID+".part.state")); err != nil {
+		t.Fatalf("Expected the download's progress to be saved: %v", err)
+	}
+
+	output, err = runCmd(bobDir, "download-file", bigID)
+	if err != nil {
+		t.Fatalf("Bob download-file failed: %v", err)
+	}
+	if !strings.Contains(output, "Resuming download") {
+		t.Errorf("Expected resumed download, got: %s", output)
+	}
+	content, err = os.ReadFile(filepath.Join(bobDir, "big.bin"))
+	if err != nil || !bytes.Equal(content, big) {
+		t.Errorf("Resumed download mismatch (%v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(bobDir, "."+bigID+".part")); !os.IsNotExist(err) {
+		t.Error("Partial file should be renamed into place")
+	}
+	if _, err := os.Stat(filepath.Join(bobDir, "."+bigID+".part.state")); !os.IsNotExist(err) {
+		t.Error("Progress of a finished download should be removed")
+	}
+
+	// 10. A file whose signature was stripped on the server is refused
+	// unless Bob accepts unverified files
+	var signature []byte
+	if err := storage.DB.QueryRow(`SELECT signature FROM files WHERE id = ?`, bigID).Scan(&signature); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.DB.Exec(`UPDATE files SET signature = NULL WHERE id = ?`, bigID); err != nil {
+		t.Fatal(err)
+	}
+	output, _ = runCmd(bobDir, "download-file", bigID)
+	if !strings.Contains(output, "file is not signed") || !strings.Contains(output, "--allow-unverified") {
+		t.Errorf("Expected an unsigned file to be refused, got: %s", output)
+	}
+	output, err = runCmd(bobDir, "download-file", "--allow-unverified", bigID)
+	if err != nil || !strings.Contains(output, "WARNING") || !strings.Contains(output, "File downloaded and decrypted") {
+		t.Errorf("Expected an unsigned file to be accepted with --allow-unverified, got: %v %s", err, output)
+	}
+	if content, err := os.ReadFile(filepath.Join(bobDir, "big (1).bin")); err != nil || !bytes.Equal(content, big) {
+		t.Errorf("Unsigned download mismatch (%v)", err)
+	}
+	if _, err := storage.DB.Exec(`UPDATE files SET signature = ? WHERE id = ?`, signature, bigID); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file whose manifest was tampered with on the server is refused
+	if _, err := storage.DB.Exec(`UPDATE files SET signed_at = ? WHERE id = ?`, time.Now().Add(-time.Hour), bigID); err != nil {
+		t.Fatal(err)
+	}
+	output, _ = runCmd(bobDir, "download-file", bigID)
+	if !strings.Contains(output, "sender signature does not verify") {
+		t.Errorf("Expected signature failure, got: %s", output)
+	}
+	if _, err := os.Stat(filepath.Join(bobDir, "big (2).bin")); !os.IsNotExist(err) {
+		t.Error("Tampered file should not be saved")
+	}
+	if _, err := os.Stat(filepath.Join(bobDir, "."+bigID+".part")); !os.IsNotExist(err) {
+		t.Error("Partial file of a tampered download should be removed")
+	}
+
+	// 11. One upload can be sent to several recipients
+	sharedFile := filepath.Join(aliceDir, "shared.txt")
+	if err := os.WriteFile(sharedFile, []byte("For both of us"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCmd(aliceDir, "send-file", "bob", "alice", sharedFile); err == nil || !strings.Contains(err.Error(), "use --to") {
+		t.Errorf("Expected several positional recipients to be refused, got %v", err)
+	}
+	output, err = runCmd(aliceDir, "send-file", "--to", "bob", "--to", "alice", sharedFile)
+	if err != nil || !strings.Contains(output, "sent successfully to 2 recipients") {
+		t.Fatalf("Alice multi-recipient send-file failed: %v %s", err, output)
+	}
+	bobFiles, _ := storage.ListFiles(context.Background(), "bob")
+	aliceFiles, _ := storage.ListFiles(context.Background(), "alice")
+	if len(aliceFiles) != 1 || len(bobFiles) == 0 {
+		t.Fatalf("Expected a file for each recipient, got %d and %d", len(bobFiles), len(aliceFiles))
+	}
+	if bytes.Equal(bobFiles[0].WrappedKey, aliceFiles[0].WrappedKey) {
+		t.Error("Recipients should have their own wrapped keys")
+	}
+	var blobs int
+	if err := storage.DB.QueryRow(`SELECT COUNT(DISTINCT blob_id) FROM files WHERE id IN (?, ?)`, bobFiles[0].ID, aliceFiles[0].ID).Scan(&blobs); err != nil || blobs != 1 {
+		t.Errorf("Expected one shared blob, got %d (%v)", blobs, err)
+	}
+
+	// Alice downloads her copy away from the original
+	aliceInbox := filepath.Join(aliceDir, "inbox")
+	if err := os.Mkdir(aliceInbox, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, dl := range []struct{ configDir, wd, id string }{
+		{bobDir, bobDir, bobFiles[0].ID},
+		{aliceDir, aliceInbox, aliceFiles[0].ID},
+	} {
+		if err := os.Chdir(dl.wd); err != nil {
+			t.Fatal(err)
+		}
+		output, err := runCmd(dl.configDir, "download-file", dl.id)
+		if err != nil || !strings.Contains(output, "File downloaded and decrypted") || strings.Contains(output, "WARNING") {
+			t.Fatalf("Multi-recipient download failed: %v %s", err, output)
+		}
+		content, err := os.ReadFile(filepath.Join(dl.wd, "shared.txt"))
+		if err != nil || string(content) != "For both of us" {
+			t.Errorf("Downloaded copy mismatch: %q (%v)", content, err)
+		}
+	}
+
+	// 12. A file with a download limit is deleted after its last
+	// acknowledged download
+	if err := os.Chdir(bobDir); err != nil {
+		t.Fatal(err)
+	}
+	limitedFile := filepath.Join(aliceDir, "twice.txt")
+	if err := os.WriteFile(limitedFile, []byte("Read me twice"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := runCmd(aliceDir, "send-file", "bob", limitedFile, "--max-downloads", "2"); err != nil || !strings.Contains(output, "File sent successfully") {
+		t.Fatalf("Alice send-file failed: %v %s", err, output)
+	}
+	bobFiles, _ = storage.ListFiles(context.Background(), "bob")
+	limitedID := bobFiles[0].ID
+	output, _ = runCmd(bobDir, "list-files")
+	if !strings.Contains(output, "2 download(s) left") {
+		t.Errorf("Expected download limit in list, got: %s", output)
+	}
+	for _, want := range []string{"1 download(s) left on server", "File deleted from server after its last download"} {
+		output, err = runCmd(bobDir, "download-file", limitedID)
+		if err != nil || !strings.Contains(output, want) {
+			t.Errorf("Expected %q, got: %v %s", want, err, output)
+		}
+	}
+	if _, ok := storage.GetFileMetadata(context.Background(), limitedID); ok {
+		t.Error("File should be deleted after its last download")
+	}
+	// The second copy was saved under a new name rather than overwriting
+	if content, err := os.ReadFile(filepath.Join(bobDir, "twice (1).txt")); err != nil || string(content) != "Read me twice" {
+		t.Errorf("Expected renamed second copy, got %q (%v)", content, err)
+	}
+
+	// 13. A directory is sent as one archive and extracted safely
+	album := filepath.Join(aliceDir, "album")
+	if err := os.MkdirAll(filepath.Join(album, "2024"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(album, "2024", "beach.txt"), []byte("Sand"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("2024/beach.txt", filepath.Join(album, "latest")); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := runCmd(aliceDir, "send-file", "--to", "bob", album); err != nil || !strings.Contains(output, "File sent successfully") {
+		t.Fatalf("Alice directory send-file failed: %v %s", err, output)
+	}
+	bobFiles, _ = storage.ListFiles(context.Background(), "bob")
+	output, err = runCmd(bobDir, "download-file", bobFiles[0].ID, "--extract")
+	if err != nil || !strings.Contains(output, "File downloaded and decrypted") {
+		t.Fatalf("Bob archive download failed: %v %s", err, output)
+	}
+	extracted := filepath.Join(bobDir, "album")
+	if content, err := os.ReadFile(filepath.Join(extracted, "latest")); err != nil || string(content) != "Sand" {
+		t.Errorf("Extracted archive mismatch: %q (%v)", content, err)
+	}
+	if info, err := os.Stat(filepath.Join(extracted, "2024", "beach.txt")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Extracted file lost its permissions: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(bobDir, "album.tar")); !os.IsNotExist(err) {
+		t.Error("Archive should be removed after extraction")
+	}
+
+	// 14. Compressed files are decompressed transparently
+	logFile := filepath.Join(aliceDir, "server.log")
+	logContent := strings.Repeat("GET /files 200\n", 5000)
+	if err := os.WriteFile(logFile, []byte(logContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := runCmd(aliceDir, "send-file", "--to", "bob", logFile, "--compress"); err != nil || !strings.Contains(output, "Compressed") {
+		t.Fatalf("Alice compressed send-file failed: %v %s", err, output)
+	}
+	bobFiles, _ = storage.ListFiles(context.Background(), "bob")
+	if blob, err := storage.StatFileContent(context.Background(), bobFiles[0].ID); err != nil || blob.Size >= int64(len(logContent)) {
+		t.Errorf("Expected server to store less than %d bytes, got %+v (%v)", len(logContent), blob, err)
+	}
+	if output, err := runCmd(bobDir, "download-file", bobFiles[0].ID); err != nil || !strings.Contains(output, "File downloaded and decrypted") {
+		t.Fatalf("Bob compressed download failed: %v %s", err, output)
+	}
+	if content, err := os.ReadFile(filepath.Join(bobDir, "server.log")); err != nil || string(content) != logContent {
+		t.Errorf("Decompressed file mismatch (%v)", err)
+	}
+
+	// 15. Alice rotates her keys. Files queued for her old key can still be
+	// read, and Bob follows the signed key history to verify her new key.
+	queuedFile := filepath.Join(t.TempDir(), "queued.txt")
+	if err := os.WriteFile(queuedFile, []byte("Sent before rotation"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := runCmd(bobDir, "send-file", "--to", "alice", queuedFile); err != nil || !strings.Contains(output, "File sent successfully") {
+		t.Fatalf("Bob send-file failed: %v %s", err, output)
+	}
+	// The server's answer is lost, but the new keys were saved first and
+	// running rotate-keys again finishes the rotation
+	dropRotations.Store(true)
+	if output, _ := runCmd(aliceDir, "rotate-keys"); !strings.Contains(output, "Run rotate-keys again") {
+		t.Fatalf("Expected rotate-keys to fail, got: %s", output)
+	}
+	dropRotations.Store(false)
+	if user, _ := storage.GetUser(context.Background(), "alice"); bytes.Equal(user.IdentityPublicKey, client.GetConfig().Users["alice"].IdentityPublicKey) {
+		t.Fatal("Expected the server to have accepted the rotation")
+	}
+	output, err = runCmd(aliceDir, "rotate-keys")
+	if err != nil || !strings.Contains(output, "already accepted") || !strings.Contains(output, "Keys rotated for alice (version 1)") {
+		t.Fatalf("Alice rotate-keys failed: %v %s", err, output)
+	}
+	if user, _ := storage.GetUser(context.Background(), "alice"); !bytes.Equal(user.IdentityPublicKey, client.GetConfig().Users["alice"].IdentityPublicKey) {
+		t.Error("Alice's keys do not match the server's after finishing the rotation")
+	}
+	if output, _ := runCmd(aliceDir, "config", "init", "--user", "alice"); !strings.Contains(output, "already exist") {
+		t.Errorf("Expected config init to refuse to replace keys, got: %s", output)
+	}
+	aliceFiles, _ = storage.ListFiles(context.Background(), "alice")
+	if output, err := runCmd(aliceDir, "download-file", aliceFiles[0].ID); err != nil || !strings.Contains(output, "File downloaded and decrypted") {
+		t.Fatalf("Alice download after rotation failed: %v %s", err, output)
+	}
+	if content, err := os.ReadFile(filepath.Join(bobDir, "queued.txt")); err != nil || string(content) != "Sent before rotation" {
+		t.Errorf("Downloaded copy mismatch: %q (%v)", content, err)
+	}
+
+	rotatedFile := filepath.Join(aliceDir, "rotated.txt")
+	if err := os.WriteFile(rotatedFile, []byte("Signed with my new key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := runCmd(aliceDir, "send-file", "--to", "bob", rotatedFile); err != nil || !strings.Contains(output, "File sent successfully") {
+		t.Fatalf("Alice send-file after rotation failed: %v %s", err, output)
+	}
+	bobFiles, _ = storage.ListFiles(context.Background(), "bob")
+	output, err = runCmd(bobDir, "download-file", bobFiles[0].ID)
+	if err != nil || !strings.Contains(output, "has rotated their keys") || !strings.Contains(output, "File downloaded and decrypted") {
+		t.Fatalf("Bob download of rotated sender failed: %v %s", err, output)
+	}
+	if content, err := os.ReadFile(filepath.Join(bobDir, "rotated.txt")); err != nil || string(content) != "Signed with my new key" {
+		t.Errorf("Downloaded copy mismatch: %q (%v)", content, err)
+	}
+
+	// 16. In strict mode Bob only sends to users he has verified, and keys
+	// substituted by the server are never used
+	strictFile := filepath.Join(t.TempDir(), "strict.txt")
+	if err := os.WriteFile(strictFile, []byte("Verified only"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := runCmd(bobDir, "config", "strict", "on"); err != nil || !strings.Contains(output, "Strict mode on") {
+		t.Fatalf("Bob config strict failed: %v %s", err, output)
+	}
+	if output, _ := runCmd(bobDir, "send-file", "--to", "alice", strictFile); !strings.Contains(output, "refusing to send to unverified user 'alice'") {
+		t.Errorf("Expected strict mode to refuse, got: %s", output)
+	}
+	output, _ = runCmd(aliceDir, "fingerprint")
+	var qr string
+	for _, line := range strings.Split(output, "\n") {
+		if after, ok := strings.CutPrefix(strings.TrimSpace(line), "QR:"); ok {
+			qr = strings.TrimSpace(after)
+		}
+	}
+	if output, _ := runCmd(bobDir, "verify-user", "alice", "GOSEND1:AAAAAAAAAAAAAAAAAAAAAAAAAA"); !strings.Contains(output, "FINGERPRINT MISMATCH") {
+		t.Errorf("Expected a mismatch for the wrong fingerprint, got: %s", output)
+	}
+	if output, err := runCmd(bobDir, "verify-user", "alice", qr); err != nil || !strings.Contains(output, "User alice verified") {
+		t.Fatalf("Bob verify-user failed: %v %s", err, output)
+	}
+	if output, err := runCmd(bobDir, "send-file", "--to", "alice", strictFile); err != nil || !strings.Contains(output, "File sent successfully") {
+		t.Fatalf("Bob send-file to verified user failed: %v %s", err, output)
+	}
+
+	aliceUser, _ := storage.GetUser(context.Background(), "alice")
+	mallory, _ := crypto.GenerateExchangeKeyPair()
+	if err := storage.Queries.UpdateUserKeys(context.Background(), db.UpdateUserKeysParams{
+		Username:          "alice",
+		IdentityPublicKey: aliceUser.IdentityPublicKey,
+		ExchangePublicKey: mallory.Public[:],
+	}); err != nil {
+		t.Fatal(err)
+	}
+	output, err = runCmd(bobDir, "send-file", "--to", "alice", strictFile)
+	if err != nil || !strings.Contains(output, "DO NOT MATCH THE PINNED KEYS") || !strings.Contains(output, "File sent successfully") {
+		t.Fatalf("Expected a warning and a send to the pinned keys, got: %v %s", err, output)
+	}
+	aliceFiles, _ = storage.ListFiles(context.Background(), "alice")
+	if output, err := runCmd(aliceDir, "download-file", aliceFiles[0].ID); err != nil || !strings.Contains(output, "File downloaded and decrypted") {
+		t.Errorf("Alice could not read a file sent to her pinned keys: %v %s", err, output)
+	}
+
+	// 17. A server that rewrites its key log is caught by clients that have
+	// seen an earlier tree head
+	if err := storage.Queries.UpdateUserKeys(context.Background(), db.UpdateUserKeysParams{
+		Username:          "alice",
+		IdentityPublicKey: aliceUser.IdentityPublicKey,
+		ExchangePublicKey: aliceUser.ExchangePublicKey,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := runCmd(bobDir, "send-file", "--to", "alice", strictFile); err != nil || strings.Contains(output, "WARNING") {
+		t.Fatalf("Expected a clean send once the keys are restored, got: %v %s", err, output)
+	}
+	if _, err := storage.DB.Exec(`UPDATE key_log SET leaf_hash = ? WHERE idx = 0`, crypto.MerkleLeafHash([]byte("forged"))); err != nil {
+		t.Fatal(err)
+	}
+	if output, _ := runCmd(bobDir, "send-file", "--to", "alice", strictFile); !strings.Contains(output, "the key log has been rewritten") {
+		t.Errorf("Expected the rewritten key log to be detected, got: %s", output)
+	}
+	if output, _ := runCmd(bobDir, "list-users"); !strings.Contains(output, "Key log check failed") {
+		t.Errorf("Expected list-users to warn about the key log, got: %s", output)
+	}
+
+	// 18. Bob logs in from a second device, revokes his older sessions and
+	// logs out
+	if output, err := runCmd(bobDir, "login", "--device", "bob-phone"); err != nil || !strings.Contains(output, "Logged in successfully") {
+		t.Fatalf("Bob login failed: %v %s", err, output)
+	}
+	bobSessions, _ := storage.ListSessions(context.Background(), "bob")
+	output, err = runCmd(bobDir, "sessions", "list")
+	if err != nil || !strings.Contains(output, "* ") || !strings.Contains(output, "bob-phone") {
+		t.Fatalf("Bob sessions list failed: %v %s", err, output)
+	}
+	var others []string
+	for _, line := range strings.Split(output, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && strings.HasPrefix(line, "  ") && fields[0] != "ID" {
+			others = append(others, fields[0])
+		}
+	}
+	if len(others) != len(bobSessions)-1 {
+		t.Fatalf("Expected %d other sessions, got %v in: %s", len(bobSessions)-1, others, output)
+	}
+	for _, id := range others {
+		if output, err := runCmd(bobDir, "sessions", "revoke", id); err != nil || !strings.Contains(output, "revoked") {
+			t.Fatalf("Bob sessions revoke failed: %v %s", err, output)
+		}
+	}
+	if output, err := runCmd(bobDir, "logout"); err != nil || !strings.Contains(output, "Logged out bob") {
+		t.Fatalf("Bob logout failed: %v %s", err, output)
+	}
+	if remaining, _ := storage.ListSessions(context.Background(), "bob"); len(remaining) != 0 {
+		t.Errorf("Expected no sessions left for bob, got %+v", remaining)
+	}
+
+	// 19. When the server refuses Alice's access token, her client refreshes
+	// the session rather than logging in again
+	aliceConfigFile := filepath.Join(aliceDir, "config.json")
+	aliceCfg, err := client.LoadConfig(aliceConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _ := crypto.GenerateIdentityKeyPair()
+	aliceCfg.SessionTokens["alice"] = crypto.SignAccessToken(otherKey.Private, crypto.AccessToken{Username: "alice", ExpiresAt: time.Now().Add(time.Hour)})
+	oldRefresh := aliceCfg.RefreshTokens["alice"]
+	if err := client.SaveConfig(aliceConfigFile, aliceCfg); err != nil {
+		t.Fatal(err)
+	}
+	aliceSessions, _ := storage.ListSessions(context.Background(), "alice")
+	if output, err := runCmd(aliceDir, "list-files"); err != nil || !strings.Contains(output, "Files for alice") {
+		t.Fatalf("Expected list-files to refresh the session, got: %v %s", err, output)
+	}
+	if aliceCfg, err = client.LoadConfig(aliceConfigFile); err != nil || aliceCfg.RefreshTokens["alice"] == oldRefresh {
+		t.Errorf("Expected a new refresh token to be stored (%v)", err)
+	}
+	if sessions, _ := storage.ListSessions(context.Background(), "alice"); len(sessions) != len(aliceSessions) {
+		t.Errorf("Expected the session to be refreshed, not a new one opened: %d sessions, had %d", len(sessions), len(aliceSessions))
+	}
+
+	// 20. Once her session has ended, her client logs in again by itself
+	aliceCfg.SessionTokens["alice"] = crypto.SignAccessToken(otherKey.Private, crypto.AccessToken{Username: "alice", ExpiresAt: time.Now().Add(time.Hour)})
+	aliceCfg.RefreshTokens["alice"] = "ended"
+	if err := client.SaveConfig(aliceConfigFile, aliceCfg); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := runCmd(aliceDir, "list-files"); err != nil || !strings.Contains(output, "Files for alice") {
+		t.Fatalf("Expected list-files to log in again, got: %v %s", err, output)
+	}
+	if sessions, _ := storage.ListSessions(context.Background(), "alice"); len(sessions) != len(aliceSessions)+1 {
+		t.Errorf("Expected a new session to be opened: %d sessions, had %d", len(sessions), len(aliceSessions))
+	}
+}
+
+// cutWriter fails a response once left bytes have been written.
+type cutWriter struct {
+	http.ResponseWriter
+	left int64
+}
+
+func (w *cutWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) > w.left {
+		n, _ := w.ResponseWriter.Write(b[:w.left])
+		w.left = 0
+		return n, errors.New("connection dropped")
+	}
+	w.left -= int64(len(b))
+	return w.ResponseWriter.Write(b)
 }
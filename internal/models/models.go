@@ -9,15 +9,81 @@ type User struct {
 	ExchangePublicKey []byte `json:"exchange_public_key"` // X25519 public key for encryption
 }
 
+// KeyRotation replaces a user's keys. It is signed with the identity key it
+// replaces, and the server keeps every rotation so that peers can follow a
+// user's keys from one they already trust.
+type KeyRotation struct {
+	Username             string    `json:"username"`
+	Version              int64     `json:"version"` // 1 for a user's first rotation
+	OldIdentityPublicKey []byte    `json:"old_identity_public_key"`
+	IdentityPublicKey    []byte    `json:"identity_public_key"`
+	ExchangePublicKey    []byte    `json:"exchange_public_key"`
+	RotatedAt            time.Time `json:"rotated_at"`
+	Signature            []byte    `json:"signature"` // Ed25519 signature by OldIdentityPublicKey
+}
+
+// KeyLogEntry is a leaf of the server's key transparency log. Every
+// registration (version 0) and key rotation is appended to the log.
+type KeyLogEntry struct {
+	Username          string `json:"username"`
+	Version           int64  `json:"version"`
+	IdentityPublicKey []byte `json:"identity_public_key"`
+	ExchangePublicKey []byte `json:"exchange_public_key"`
+}
+
+// SignedTreeHead is the size and root hash of the key log at a point in
+// time, signed by the server's log key.
+type SignedTreeHead struct {
+	TreeSize     uint64    `json:"tree_size"`
+	RootHash     []byte    `json:"root_hash"`
+	Timestamp    time.Time `json:"timestamp"`
+	Signature    []byte    `json:"signature"`
+	LogPublicKey []byte    `json:"log_public_key"`
+}
+
+// KeyLogProof proves that a user's latest log entry is in the tree
+// described by TreeHead.
+type KeyLogProof struct {
+	Entry     KeyLogEntry    `json:"entry"`
+	LeafIndex uint64         `json:"leaf_index"`
+	Proof     [][]byte       `json:"proof"`
+	TreeHead  SignedTreeHead `json:"tree_head"`
+}
+
+// ConsistencyProof proves that the key log of size First is a prefix of the
+// log of size Second.
+type ConsistencyProof struct {
+	First  uint64   `json:"first"`
+	Second uint64   `json:"second"`
+	Proof  [][]byte `json:"proof"`
+}
+
 // FileMetadata contains information about an encrypted file.
 type FileMetadata struct {
 	ID           string    `json:"id"`
 	Sender       string    `json:"sender"`
 	Recipient    string    `json:"recipient"`
-	EncryptedKey []byte    `json:"encrypted_key"` // Symmetric key encrypted with recipient's public key
+	EncryptedKey []byte    `json:"encrypted_key"` // Sender's ephemeral X25519 public key
 	Timestamp    time.Time `json:"timestamp"`
-	FileName     string    `json:"file_name"` // Original filename
-	AutoDelete   bool      `json:"auto_delete"`
+	FileName     string    `json:"file_name"`   // Original filename; empty when sent in EncryptedMetadata
+	AutoDelete   bool      `json:"auto_delete"` // Same as a MaxDownloads of 1
+	// EncryptedMetadata is a sealed envelope holding the file name, size,
+	// MIME type and message, readable only by the sender and recipient.
+	EncryptedMetadata []byte `json:"encrypted_metadata,omitempty"`
+	// WrappedKey is the random content key sealed to the recipient with
+	// EncryptedKey. It is empty when the content is encrypted to the
+	// recipient directly.
+	WrappedKey []byte    `json:"wrapped_key,omitempty"`
+	Signature  []byte    `json:"signature,omitempty"` // Sender's Ed25519 signature over the transfer manifest
+	SignedAt   time.Time `json:"signed_at,omitempty"`
+	// ExpiresAt is when the server deletes the file. Senders may request an
+	// earlier time; the server caps it and fills it in when unset.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	// MaxDownloads is how many acknowledged downloads a sender allows before
+	// the file is deleted; zero means no limit. The server tracks the count
+	// left in DownloadsRemaining.
+	MaxDownloads       int `json:"max_downloads,omitempty"`
+	DownloadsRemaining int `json:"downloads_remaining,omitempty"`
 }
 
 // UploadRequest is the payload for uploading a file.
@@ -26,10 +92,58 @@ type UploadRequest struct {
 	EncryptedContent []byte       `json:"encrypted_content"`
 }
 
-// AuthChallenge represents a challenge sent by the server.
+// RecipientKey is one recipient's entry in an upload. The content is
+// encrypted once, and each recipient gets the content key wrapped for them.
+type RecipientKey struct {
+	Recipient         string `json:"recipient"`
+	EncryptedKey      []byte `json:"encrypted_key"` // Sender's ephemeral X25519 public key
+	WrappedKey        []byte `json:"wrapped_key"`
+	EncryptedMetadata []byte `json:"encrypted_metadata"`
+}
+
+// CreateUploadRequest is the payload for starting a resumable upload.
+type CreateUploadRequest struct {
+	Metadata FileMetadata `json:"metadata"`
+	// Recipients lists everyone the file is sent to. When empty, the
+	// recipient and keys in Metadata are used.
+	Recipients []RecipientKey `json:"recipients,omitempty"`
+	ChunkSize  int64          `json:"chunk_size"` // Requested chunk size; the server may adjust it
+}
+
+// UploadChunk describes a chunk the server has received for an upload session.
+type UploadChunk struct {
+	Index  int64 `json:"index"`
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+}
+
+// UploadSession describes a resumable upload in progress.
+type UploadSession struct {
+	ID        string        `json:"id"`
+	ChunkSize int64         `json:"chunk_size"`
+	Chunks    []UploadChunk `json:"chunks"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+// CompleteUploadRequest is the payload for finishing a resumable upload.
+// The signature covers the transfer manifest, which includes the hash of the
+// uploaded ciphertext and so can only be produced once all chunks are sent.
+type CompleteUploadRequest struct {
+	Signature []byte    `json:"signature"`
+	SignedAt  time.Time `json:"signed_at"`
+	// Signatures holds one signature per recipient, since each recipient's
+	// manifest differs. Signature is used for single-recipient uploads.
+	Signatures map[string][]byte `json:"signatures,omitempty"`
+}
+
+// AuthChallenge represents a challenge sent by the server. Clients sign it
+// bound to Origin, and must check that Origin is the server they meant to
+// log in to.
 type AuthChallenge struct {
-	Username string `json:"username"`
-	Nonce    string `json:"nonce"`
+	Username  string    `json:"username"`
+	Nonce     string    `json:"nonce"`
+	Origin    string    `json:"origin"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 // AuthResponse is the client's response to an authentication challenge.
@@ -37,11 +151,36 @@ type AuthResponse struct {
 	Username  string `json:"username"`
 	Nonce     string `json:"nonce"`
 	Signature []byte `json:"signature"`
+	Device    string `json:"device,omitempty"` // Label for the session, such as the host name
 }
 
-// Session represents an authenticated session.
+// Session represents an authenticated session. Token is a short-lived
+// access token sent with each request; when it expires the client trades
+// RefreshToken for new tokens on /auth/refresh, which also extends the
+// session to a new ExpiresAt.
 type Session struct {
-	Token     string    `json:"token"`
-	Username  string    `json:"username"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Token          string    `json:"token"`
+	TokenExpiresAt time.Time `json:"token_expires_at"`
+	RefreshToken   string    `json:"refresh_token"`
+	ID             string    `json:"id"` // Names the session without revealing its tokens
+	Username       string    `json:"username"`
+	Device         string    `json:"device,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	LastUsedAt     time.Time `json:"last_used_at"`
+}
+
+// RefreshRequest is the payload for trading a refresh token for new tokens.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// SessionInfo describes one of a user's sessions, without its tokens.
+// LastUsedAt is when the session was opened or last refreshed.
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // The session making the request
 }
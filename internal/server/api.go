@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// apiPrefix is the path prefix of the current version of the API.
+// Unversioned paths are still served, but are deprecated.
+const apiPrefix = "/v1"
+
+// RequestIDHeader carries the ID of a request, which is included in error
+// responses and logged with server errors. A client may choose it.
+const RequestIDHeader = "X-Request-ID"
+
+const (
+	requestIDContextKey contextKey = "request_id"
+	versionedContextKey contextKey = "versioned"
+)
+
+// Error codes are the machine-readable part of an error response.
+const (
+	CodeBadRequest          = "bad_request"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeNotFound            = "not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeConflict            = "conflict"
+	CodePayloadTooLarge     = "payload_too_large"
+	CodeRangeNotSatisfiable = "range_not_satisfiable"
+	CodeRateLimited         = "rate_limited"
+	CodeInternal            = "internal_error"
+	CodeUnavailable         = "unavailable"
+)
+
+// ErrorBody is the JSON body of an error response.
+type ErrorBody struct {
+	Error APIError `json:"error"`
+}
+
+// APIError describes why a request failed.
+type APIError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// errorCode returns the error code for an HTTP status.
+func errorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusRequestedRangeNotSatisfiable:
+		return CodeRangeNotSatisfiable
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+// writeError replies to r with an error. The details of server errors are
+// logged rather than sent, as they can name files or database internals.
+// The response is JSON for versioned requests and plain text, as it always
+// was, for unversioned ones, unless the Accept header prefers the other.
+func writeError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	id := requestIDFrom(r.Context())
+	if status >= 500 {
+		slog.Error("request failed", "request_id", id, "method", r.Method, "path", r.URL.Path, "status", status, "error", message)
+		message = strings.ToLower(http.StatusText(status))
+	}
+
+	if !wantsJSON(r) {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ErrorBody{Error: APIError{Code: errorCode(status), Message: message, RequestID: id}})
+}
+
+// wantsJSON reports whether an error response to r should be JSON rather
+// than plain text, going by the Accept header and, when it has no
+// preference, by whether the request is versioned.
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	j, t := acceptQuality(accept, "application", "json"), acceptQuality(accept, "text", "plain")
+	if j != t {
+		return j > t
+	}
+	versioned, _ := r.Context().Value(versionedContextKey).(bool)
+	return versioned
+}
+
+// acceptQuality returns the quality an Accept header gives a media type,
+// from its most specific matching range. A missing header accepts
+// everything.
+func acceptQuality(accept, typ, subtype string) float64 {
+	if strings.TrimSpace(accept) == "" {
+		return 1
+	}
+	quality, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		t, s, _ := strings.Cut(mediaType, "/")
+		var n int
+		switch {
+		case t == typ && s == subtype:
+			n = 2
+		case t == typ && s == "*":
+			n = 1
+		case t == "*" && s == "*":
+			n = 0
+		default:
+			continue
+		}
+		if n < specificity {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		quality, specificity = q, n
+	}
+	return quality
+}
+
+// requestID returns the ID a client gave r, if it is a reasonable one, or
+// a new ID.
+func requestID(r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > 128 {
+		return uuid.NewString()
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return uuid.NewString()
+		}
+	}
+	return id
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// versioned strips the API version from the path of r. Unversioned paths
+// other than the health check are marked as deprecated in favour of their
+// versioned successor.
+func versioned(w http.ResponseWriter, r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID(r))
+	w.Header().Set(RequestIDHeader, requestIDFrom(ctx))
+
+	path, ok := strings.CutPrefix(r.URL.Path, apiPrefix+"/")
+	if !ok {
+		if r.URL.Path != "/ping" {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", "<"+apiPrefix+r.URL.Path+">; rel=\"successor-version\"")
+		}
+		return r.WithContext(ctx)
+	}
+
+	r = r.WithContext(context.WithValue(ctx, versionedContextKey, true))
+	u := *r.URL
+	u.Path, u.RawPath = "/"+path, ""
+	r.URL = &u
+	return r
+}
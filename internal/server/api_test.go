@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVersionedErrors(t *testing.T) {
+	h, _, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	serve := func(path, accept, requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if requestID != "" {
+			req.Header.Set(RequestIDHeader, requestID)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) APIError {
+		t.Helper()
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("Expected a JSON error, got %s: %s", ct, w.Body.String())
+		}
+		var body ErrorBody
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Error
+	}
+
+	// Versioned errors are JSON and name the request
+	w := serve("/v1/users?username=nobody", "", "trace-1")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404, got %d", w.Code)
+	}
+	if e := decode(w); e.Code != CodeNotFound || e.Message != "user not found" || e.RequestID != "trace-1" {
+		t.Errorf("Unexpected error body %+v", e)
+	}
+	if w.Header().Get(RequestIDHeader) != "trace-1" || w.Header().Get("Deprecation") != "" {
+		t.Errorf("Unexpected headers %v", w.Header())
+	}
+
+	// Unknown routes and methods get the envelope too
+	if e := decode(serve("/v1/nowhere", "", "")); e.Code != CodeNotFound || e.RequestID == "" {
+		t.Errorf("Unexpected error body %+v", e)
+	}
+
+	// Request IDs that could be used to forge log lines are replaced
+	w = serve("/v1/users?username=nobody", "", "bad\nid")
+	if id := w.Header().Get(RequestIDHeader); id == "" || strings.Contains(id, "\n") {
+		t.Errorf("Expected a new request ID, got %q", id)
+	}
+
+	// Clients can ask for plain text
+	w = serve("/v1/users?username=nobody", "text/plain, application/json;q=0.5", "")
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") || strings.TrimSpace(w.Body.String()) != "user not found" {
+		t.Errorf("Expected a plain text error, got %s", w.Body.String())
+	}
+
+	// Unversioned routes still answer in plain text, and point to their
+	// successor
+	w = serve("/users?username=nobody", "", "")
+	if w.Code != http.StatusNotFound || strings.TrimSpace(w.Body.String()) != "user not found" {
+		t.Errorf("Expected the old error, got %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Deprecation") != "true" || w.Header().Get("Link") != `</v1/users>; rel="successor-version"` {
+		t.Errorf("Expected the route to be deprecated, got %v", w.Header())
+	}
+	if e := decode(serve("/users?username=nobody", "application/json", "")); e.Code != CodeNotFound {
+		t.Errorf("Expected a JSON error when asked for, got %+v", e)
+	}
+	if serve("/ping", "", "").Header().Get("Deprecation") != "" {
+		t.Error("Expected the health check not to be deprecated")
+	}
+}
+
+func TestServerErrorsAreHidden(t *testing.T) {
+	req := httptest.NewRequest("GET", "/v1/files", nil)
+	w := httptest.NewRecorder()
+	writeError(w, versioned(w, req), "sqlite: database disk image is malformed", http.StatusInternalServerError)
+
+	var body ErrorBody
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Code != CodeInternal || body.Error.Message != "internal server error" {
+		t.Errorf("Expected the details to be hidden, got %+v", body.Error)
+	}
+}
+
+func TestAcceptQuality(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   float64
+	}{
+		{"", 1},
+		{"application/json", 1},
+		{"text/plain", 0},
+		{"*/*;q=0.2", 0.2},
+		{"application/*;q=0.5, */*;q=0.1", 0.5},
+		{"application/*;q=0.5, application/json;q=0.8", 0.8},
+		{"application/json;q=0", 0},
+	}
+	for _, tt := range tests {
+		if got := acceptQuality(tt.accept, "application", "json"); got != tt.want {
+			t.Errorf("acceptQuality(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	h, _, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the document, got %d", w.Code)
+	}
+	var doc struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatalf("Invalid document: %v", err)
+	}
+
+	// Every documented operation is routed
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			req := httptest.NewRequest(strings.ToUpper(method), "/v1"+path, nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			var body ErrorBody
+			_ = json.NewDecoder(w.Body).Decode(&body)
+			if body.Error.Code == CodeMethodNotAllowed || body.Error.Message == "not found" {
+				t.Errorf("%s %s is documented but not routed", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
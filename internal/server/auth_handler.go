@@ -4,47 +4,54 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/VinMeld/go-send/internal/crypto"
 	"github.com/VinMeld/go-send/internal/models"
-	"github.com/google/uuid"
 )
 
 // HandleGetChallenge generates a random nonce for the user.
 func (h *Handler) HandleGetChallenge(w http.ResponseWriter, r *http.Request) {
 	username := r.URL.Query().Get("username")
 	if username == "" {
-		http.Error(w, "username required", http.StatusBadRequest)
+		writeError(w, r, "username required", http.StatusBadRequest)
 		return
 	}
 
 	// Check if user exists
 	if _, ok := h.Storage.GetUser(r.Context(), username); !ok {
-		http.Error(w, "user not found", http.StatusNotFound)
+		writeError(w, r, "user not found", http.StatusNotFound)
 		return
 	}
 
 	// Generate random nonce
 	nonceBytes := make([]byte, 32)
 	if _, err := rand.Read(nonceBytes); err != nil {
-		http.Error(w, "failed to generate nonce", http.StatusInternalServerError)
+		writeError(w, r, "failed to generate nonce", http.StatusInternalServerError)
 		return
 	}
 	nonce := base64.StdEncoding.EncodeToString(nonceBytes)
 
-	if err := h.Storage.CreateChallenge(r.Context(), username, nonce); err != nil {
+	expiresAt := time.Now().Add(h.ChallengeTTL)
+	if err := h.Storage.CreateChallenge(r.Context(), username, nonce, expiresAt); err != nil {
 		slog.Error("failed to create challenge", "username", username, "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	slog.Info("challenge created", "username", username)
 	_ = json.NewEncoder(w).Encode(models.AuthChallenge{
-		Username: username,
-		Nonce:    nonce,
+		Username:  username,
+		Nonce:     nonce,
+		Origin:    h.origin(r),
+		ExpiresAt: expiresAt,
 	})
 }
 
@@ -52,44 +59,211 @@ func (h *Handler) HandleGetChallenge(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	var resp models.AuthResponse
 	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
+		writeError(w, r, "invalid request", http.StatusBadRequest)
 		return
 	}
 
-	// Retrieve challenge
-	expectedNonce, ok := h.Storage.GetChallenge(r.Context(), resp.Username)
-	if !ok || expectedNonce != resp.Nonce {
-		http.Error(w, "invalid or expired challenge", http.StatusUnauthorized)
+	// Failed logins are throttled by client address, and more loosely by
+	// username, so that one address cannot lock a user out but guesses
+	// from many addresses are still slowed down
+	now := time.Now()
+	addr := clientAddr(r)
+	if wait := max(h.addrThrottle.wait(now, addr), h.userThrottle.wait(now, resp.Username)); wait > 0 {
+		slog.Warn("login throttled", "username", resp.Username, "addr", addr)
+		w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		writeError(w, r, "too many failed login attempts", http.StatusTooManyRequests)
 		return
 	}
 
-	// Get user's public identity key
+	// Get user's public identity key. Unknown users have no challenge, and
+	// are not counted by name so that made-up names take no memory
 	user, ok := h.Storage.GetUser(r.Context(), resp.Username)
 	if !ok {
-		http.Error(w, "user not found", http.StatusNotFound)
+		h.addrThrottle.fail(now, addr)
+		writeError(w, r, "invalid or expired challenge", http.StatusUnauthorized)
 		return
 	}
+	fail := func(msg string, code int) {
+		h.addrThrottle.fail(now, addr)
+		h.userThrottle.fail(now, resp.Username)
+		writeError(w, r, msg, code)
+	}
 
-	// Verify signature
-	if !crypto.Verify(user.IdentityPublicKey, []byte(resp.Nonce), resp.Signature) {
+	// Verify signature. Until it verifies, the challenge is left alone, so
+	// that others cannot use it up
+	challenge := crypto.LoginChallenge{Origin: h.origin(r), Username: resp.Username, Nonce: resp.Nonce}
+	if !crypto.VerifyLoginChallenge(user.IdentityPublicKey, challenge, resp.Signature) {
 		slog.Warn("invalid login signature", "username", resp.Username)
-		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		fail("invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	// Taking the challenge removes it, so it is answered only once
+	if !h.Storage.TakeChallenge(r.Context(), resp.Username, resp.Nonce, now) {
+		fail("invalid or expired challenge", http.StatusUnauthorized)
 		return
 	}
 
 	// Create session
-	token := uuid.New().String()
 	session := models.Session{
-		Token:     token,
-		Username:  resp.Username,
-		ExpiresAt: time.Now().Add(24 * time.Hour),
+		RefreshToken: newRefreshToken(),
+		ID:           newSessionID(),
+		Username:     resp.Username,
+		Device:       deviceLabel(resp.Device),
+		ExpiresAt:    now.Add(h.SessionTTL),
+		LastUsedAt:   now,
 	}
 	if err := h.Storage.CreateSession(r.Context(), session); err != nil {
 		slog.Error("failed to create session", "username", resp.Username, "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("user logged in", "username", resp.Username, "session", session.ID, "device", session.Device)
+	_ = json.NewEncoder(w).Encode(h.withAccessToken(session, now))
+}
+
+// HandleRefresh trades a refresh token for a new access token and refresh
+// token, and extends the session. The old refresh token stops working.
+func (h *Handler) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		writeError(w, r, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	session, err := h.Storage.RefreshSession(r.Context(), req.RefreshToken, newRefreshToken(), now.Add(h.SessionTTL), now)
+	if errors.Is(err, ErrSessionNotFound) {
+		writeError(w, r, "invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to refresh session", "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("session refreshed", "username", session.Username, "session", session.ID)
+	_ = json.NewEncoder(w).Encode(h.withAccessToken(session, now))
+}
+
+// withAccessToken returns session with a new access token for it.
+func (h *Handler) withAccessToken(session models.Session, now time.Time) models.Session {
+	session.TokenExpiresAt = now.Add(h.AccessTokenTTL)
+	if session.TokenExpiresAt.After(session.ExpiresAt) {
+		session.TokenExpiresAt = session.ExpiresAt
+	}
+	session.Token = crypto.SignAccessToken(h.Storage.TokenKey, crypto.AccessToken{
+		SessionID: session.ID,
+		Username:  session.Username,
+		IssuedAt:  now,
+		ExpiresAt: session.TokenExpiresAt,
+	})
+	return session
+}
+
+// origin returns the origin login challenges are bound to.
+func (h *Handler) origin(r *http.Request) string {
+	if h.Origin != "" {
+		return h.Origin
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// clientAddr returns the address of the client making the request, without
+// its port.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// maxDeviceLabel is the longest device label kept for a session.
+const maxDeviceLabel = 64
+
+// deviceLabel cleans up a client-supplied device label for display.
+func deviceLabel(label string) string {
+	label = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(label))
+	if runes := []rune(label); len(runes) > maxDeviceLabel {
+		label = string(runes[:maxDeviceLabel])
+	}
+	return label
+}
+
+// HandleLogout ends the session making the request (authenticated).
+func (h *Handler) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	token, ok := r.Context().Value(sessionContextKey).(crypto.AccessToken)
+	if !ok {
+		writeError(w, r, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	err := h.Storage.RevokeSession(r.Context(), token.Username, token.SessionID)
+	if err != nil && !errors.Is(err, ErrSessionNotFound) {
+		slog.Error("failed to delete session", "username", token.Username, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.revoked.revokeSession(token.SessionID, time.Now().Add(h.AccessTokenTTL))
+	slog.Info("user logged out", "username", token.Username, "session", token.SessionID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// ListSessions lists the authenticated user's sessions (authenticated).
+func (h *Handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	current, ok := r.Context().Value(sessionContextKey).(crypto.AccessToken)
+	if !ok {
+		writeError(w, r, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	sessions, err := h.Storage.ListSessions(r.Context(), current.Username)
+	if err != nil {
+		slog.Error("failed to list sessions", "username", current.Username, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current.SessionID
+	}
+	_ = json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession ends one of the authenticated user's sessions by ID
+// (authenticated).
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	current, ok := r.Context().Value(sessionContextKey).(crypto.AccessToken)
+	if !ok {
+		writeError(w, r, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, r, "id required", http.StatusBadRequest)
 		return
 	}
 
-	slog.Info("user logged in", "username", resp.Username)
-	_ = json.NewEncoder(w).Encode(session)
+	err := h.Storage.RevokeSession(r.Context(), current.Username, id)
+	if errors.Is(err, ErrSessionNotFound) {
+		writeError(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to revoke session", "username", current.Username, "session", id, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.revoked.revokeSession(id, time.Now().Add(h.AccessTokenTTL))
+	slog.Info("session revoked", "username", current.Username, "session", id)
+	w.WriteHeader(http.StatusOK)
 }
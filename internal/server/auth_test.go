@@ -3,7 +3,9 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -26,6 +28,7 @@ func TestAuthHandlers(t *testing.T) {
 		t.Fatal(err)
 	}
 	handler := NewHandler(storage)
+	handler.Origin = "https://send.example.com"
 
 	// Setup user
 	idKey, _ := crypto.GenerateIdentityKeyPair()
@@ -53,9 +56,16 @@ func TestAuthHandlers(t *testing.T) {
 	if challenge.Nonce == "" {
 		t.Error("Expected nonce in challenge")
 	}
+	if challenge.Origin != handler.Origin || challenge.ExpiresAt.IsZero() {
+		t.Errorf("Expected the challenge to name the origin and expiry, got %+v", challenge)
+	}
 
 	// Test HandleLogin
-	signature := crypto.Sign(idKey.Private, []byte(challenge.Nonce))
+	signature := crypto.SignLoginChallenge(idKey.Private, crypto.LoginChallenge{
+		Origin:   challenge.Origin,
+		Username: "alice",
+		Nonce:    challenge.Nonce,
+	})
 	authResp := models.AuthResponse{
 		Username:  "alice",
 		Nonce:     challenge.Nonce,
@@ -77,13 +87,18 @@ func TestAuthHandlers(t *testing.T) {
 		t.Error("Expected session token")
 	}
 
-	// Verify session in storage
-	storedSession, ok := storage.GetSession(context.Background(), session.Token)
-	if !ok {
-		t.Error("Session not stored")
+	if session.RefreshToken == "" || session.TokenExpiresAt.IsZero() {
+		t.Errorf("Expected a refresh token and access token expiry, got %+v", session)
 	}
-	if storedSession.Username != "alice" {
-		t.Errorf("Expected session username alice, got %s", storedSession.Username)
+	token, err := crypto.VerifyAccessToken(storage.TokenKey.Public().(ed25519.PublicKey), session.Token)
+	if err != nil || token.Username != "alice" || token.SessionID != session.ID {
+		t.Errorf("Expected an access token for alice's session, got %+v (%v)", token, err)
+	}
+
+	// Verify session in storage
+	stored, err := storage.ListSessions(context.Background(), "alice")
+	if err != nil || len(stored) != 1 || stored[0].ID != session.ID {
+		t.Errorf("Session not stored: %+v (%v)", stored, err)
 	}
 }
 
@@ -123,6 +138,162 @@ func TestAuthErrors(t *testing.T) {
 	}
 }
 
+func TestLoginChallenges(t *testing.T) {
+	tmpDir := t.TempDir()
+	storage, err := NewStorage(tmpDir, NewLocalBlobStore(tmpDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(storage)
+	handler.Origin = "https://send.example.com"
+
+	idKey, _ := crypto.GenerateIdentityKeyPair()
+	exKey, _ := crypto.GenerateExchangeKeyPair()
+	user := models.User{Username: "alice", IdentityPublicKey: idKey.Public, ExchangePublicKey: exKey.Public[:]}
+	if err := storage.AddUser(context.Background(), user); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+
+	getChallenge := func() models.AuthChallenge {
+		rr := httptest.NewRecorder()
+		handler.HandleGetChallenge(rr, httptest.NewRequest("GET", "/auth/challenge?username=alice", nil))
+		var challenge models.AuthChallenge
+		_ = json.NewDecoder(rr.Body).Decode(&challenge)
+		return challenge
+	}
+	sign := func(origin, nonce string) []byte {
+		return crypto.SignLoginChallenge(idKey.Private, crypto.LoginChallenge{Origin: origin, Username: "alice", Nonce: nonce})
+	}
+	loginFrom := func(addr, nonce string, signature []byte) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.AuthResponse{Username: "alice", Nonce: nonce, Signature: signature})
+		req := httptest.NewRequest("POST", "/auth/login", bytes.NewReader(body))
+		req.RemoteAddr = addr
+		rr := httptest.NewRecorder()
+		handler.HandleLogin(rr, req)
+		return rr
+	}
+	login := func(nonce string, signature []byte) *httptest.ResponseRecorder {
+		return loginFrom("192.0.2.1:1234", nonce, signature)
+	}
+
+	// A challenge can be answered once
+	challenge := getChallenge()
+	signature := sign(challenge.Origin, challenge.Nonce)
+	if rr := login(challenge.Nonce, signature); rr.Code != http.StatusOK {
+		t.Fatalf("Expected login to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := login(challenge.Nonce, signature); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a replayed login to be refused, got %d", rr.Code)
+	}
+
+	// A signature over the bare nonce, as older clients sent, is refused
+	challenge = getChallenge()
+	if rr := login(challenge.Nonce, crypto.Sign(idKey.Private, []byte(challenge.Nonce))); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a signature without domain separation to be refused, got %d", rr.Code)
+	}
+
+	// A signature made for another server is refused, but does not use up
+	// the challenge, so others cannot cancel a user's login
+	challenge = getChallenge()
+	if rr := login(challenge.Nonce, sign("https://evil.example.com", challenge.Nonce)); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a signature for another origin to be refused, got %d", rr.Code)
+	}
+	if rr := login(challenge.Nonce, []byte("sig")); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an invalid signature to be refused, got %d", rr.Code)
+	}
+	if rr := login(challenge.Nonce, sign(challenge.Origin, challenge.Nonce)); rr.Code != http.StatusOK {
+		t.Errorf("Expected the challenge to survive failed logins, got %d", rr.Code)
+	}
+
+	// A stale challenge is refused
+	if err := storage.CreateChallenge(context.Background(), "alice", "stale", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if rr := login("stale", sign(handler.Origin, "stale")); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a stale challenge to be refused, got %d", rr.Code)
+	}
+	if err := storage.CreateChallenge(context.Background(), "alice", "stale", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := storage.PurgeExpiredChallenges(context.Background(), time.Now()); err != nil || n != 1 {
+		t.Errorf("Expected the stale challenge to be purged, got %d, %v", n, err)
+	}
+
+	// After too many failures even a valid login from the same address is
+	// throttled
+	handler.addrThrottle = newLoginThrottle(DefaultMaxLoginFailures, DefaultLoginFailureWindow)
+	for i := 0; i < DefaultMaxLoginFailures; i++ {
+		login("invalid", []byte("sig"))
+	}
+	challenge = getChallenge()
+	rr := login(challenge.Nonce, sign(challenge.Origin, challenge.Nonce))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected login to be throttled, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+
+	// The user is not locked out everywhere else
+	if rr := loginFrom("198.51.100.7:4321", challenge.Nonce, sign(challenge.Origin, challenge.Nonce)); rr.Code != http.StatusOK {
+		t.Errorf("Expected login from another address to succeed, got %d", rr.Code)
+	}
+
+	// Guesses spread over many addresses are throttled by username, at a
+	// higher limit than one address gets
+	handler.addrThrottle = newLoginThrottle(DefaultMaxLoginFailures, DefaultLoginFailureWindow)
+	handler.userThrottle = newLoginThrottle(DefaultMaxUserLoginFailures, DefaultLoginFailureWindow)
+	for i := 0; i < DefaultMaxUserLoginFailures-1; i++ {
+		loginFrom(fmt.Sprintf("203.0.113.%d:1234", i), "invalid", []byte("sig"))
+	}
+	challenge = getChallenge()
+	if rr := loginFrom("198.51.100.8:1", challenge.Nonce, sign(challenge.Origin, challenge.Nonce)); rr.Code != http.StatusOK {
+		t.Errorf("Expected login below the user's limit to succeed, got %d", rr.Code)
+	}
+	loginFrom("203.0.113.99:1234", "invalid", []byte("sig"))
+	challenge = getChallenge()
+	if rr := loginFrom("198.51.100.9:1", challenge.Nonce, sign(challenge.Origin, challenge.Nonce)); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected logins to a guessed-at user to be throttled, got %d", rr.Code)
+	}
+
+	// Failures for unknown users only count against the address
+	for i := 0; i < DefaultMaxUserLoginFailures; i++ {
+		body, _ := json.Marshal(models.AuthResponse{Username: "nobody", Nonce: "invalid", Signature: []byte("sig")})
+		req := httptest.NewRequest("POST", "/auth/login", bytes.NewReader(body))
+		req.RemoteAddr = fmt.Sprintf("203.0.113.%d:1234", 100+i)
+		handler.HandleLogin(httptest.NewRecorder(), req)
+	}
+	if _, ok := handler.userThrottle.failures["nobody"]; ok {
+		t.Error("Expected failures for an unknown user not to be counted by name")
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	throttle := newLoginThrottle(2, time.Minute)
+	now := time.Now()
+
+	throttle.fail(now, "addr:10.0.0.1")
+	if wait := throttle.wait(now, "addr:10.0.0.1"); wait != 0 {
+		t.Errorf("Expected one failure to be allowed, got a wait of %v", wait)
+	}
+	throttle.fail(now, "addr:10.0.0.1")
+	if wait := throttle.wait(now.Add(10*time.Second), "addr:10.0.0.2", "addr:10.0.0.1"); wait != 50*time.Second {
+		t.Errorf("Expected the address to wait out the window, got %v", wait)
+	}
+	if wait := throttle.wait(now, "addr:10.0.0.2"); wait != 0 {
+		t.Errorf("Expected other addresses not to wait, got %v", wait)
+	}
+
+	later := now.Add(time.Minute)
+	if wait := throttle.wait(later, "addr:10.0.0.1"); wait != 0 {
+		t.Errorf("Expected the window to end, got %v", wait)
+	}
+	throttle.fail(later, "addr:10.0.0.2")
+	if _, ok := throttle.failures["addr:10.0.0.1"]; ok {
+		t.Error("Expected failures from an ended window to be pruned")
+	}
+}
+
 func TestAuthMiddleware(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "go-send-auth-mw-test")
 	if err != nil {
@@ -138,13 +309,12 @@ func TestAuthMiddleware(t *testing.T) {
 
 	// Create session
 	session := models.Session{
-		Token:     "valid-token",
-		Username:  "alice",
-		ExpiresAt: time.Now().Add(1 * time.Hour),
-	}
-	if err := storage.CreateSession(context.Background(), session); err != nil {
-		t.Fatalf("Failed to create session: %v", err)
+		RefreshToken: "refresh-token",
+		ID:           "session",
+		Username:     "alice",
+		ExpiresAt:    time.Now().Add(1 * time.Hour),
 	}
+	token := openSession(t, handler, session)
 
 	protectedHandler := handler.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value(userContextKey).(string)
@@ -156,7 +326,7 @@ func TestAuthMiddleware(t *testing.T) {
 
 	// Test Valid Token
 	req, _ := http.NewRequest("GET", "/", nil)
-	req.Header.Set("Authorization", "Bearer valid-token")
+	req.Header.Set("Authorization", "Bearer "+token)
 	rr := httptest.NewRecorder()
 	protectedHandler(rr, req)
 	if rr.Code != http.StatusOK {
@@ -179,4 +349,212 @@ func TestAuthMiddleware(t *testing.T) {
 	if rr.Code != http.StatusUnauthorized {
 		t.Errorf("Expected 401 for invalid token, got %d", rr.Code)
 	}
+
+	// Test Expired Token
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+handler.withAccessToken(session, time.Now().Add(-time.Hour)).Token)
+	rr = httptest.NewRecorder()
+	protectedHandler(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for expired token, got %d", rr.Code)
+	}
+
+	// Test Token Signed By Another Key
+	otherKey, _ := crypto.GenerateIdentityKeyPair()
+	forged := crypto.SignAccessToken(otherKey.Private, crypto.AccessToken{
+		SessionID: "session",
+		Username:  "alice",
+		IssuedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+forged)
+	rr = httptest.NewRecorder()
+	protectedHandler(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a token signed by another key, got %d", rr.Code)
+	}
+}
+
+// openSession stores a session and returns an access token for it.
+func openSession(t *testing.T, h *Handler, session models.Session) string {
+	t.Helper()
+	if session.ID == "" {
+		session.ID = newSessionID()
+	}
+	if session.RefreshToken == "" {
+		session.RefreshToken = newRefreshToken()
+	}
+	if session.ExpiresAt.IsZero() {
+		session.ExpiresAt = time.Now().Add(time.Hour)
+	}
+	if err := h.Storage.CreateSession(context.Background(), session); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	return h.withAccessToken(session, time.Now()).Token
+}
+
+func TestSessions(t *testing.T) {
+	storage, err := NewStorage(t.TempDir(), NewLocalBlobStore(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(storage)
+	ctx := context.Background()
+
+	hourAgo := time.Now().Add(-time.Hour)
+	laptop := openSession(t, handler, models.Session{ID: "laptop", Username: "alice", Device: "laptop"})
+	phone := openSession(t, handler, models.Session{ID: "phone", Username: "alice", Device: "phone"})
+	openSession(t, handler, models.Session{ID: "bob", Username: "bob"})
+	openSession(t, handler, models.Session{ID: "old", Username: "alice", ExpiresAt: hourAgo})
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Expired sessions are purged by the janitor
+	NewJanitor(storage, time.Hour).Sweep(ctx)
+	rr := do("GET", "/sessions", laptop)
+	var listed []models.SessionInfo
+	if err := json.NewDecoder(rr.Body).Decode(&listed); err != nil || len(listed) != 2 {
+		t.Fatalf("Expected alice's two unexpired sessions, got %v (%v)", listed, err)
+	}
+	for _, s := range listed {
+		if s.Current != (s.ID == "laptop") {
+			t.Errorf("Wrong current flag on %+v", s)
+		}
+	}
+
+	if rr := do("DELETE", "/sessions?id=bob", laptop); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 revoking another user's session, got %d", rr.Code)
+	}
+	if rr := do("DELETE", "/sessions?id=phone", laptop); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 revoking own session, got %d", rr.Code)
+	}
+	if rr := do("GET", "/sessions", phone); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked session's access token to be refused, got %d", rr.Code)
+	}
+
+	if rr := do("POST", "/auth/logout", laptop); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for logout, got %d", rr.Code)
+	}
+	if rr := do("GET", "/sessions", laptop); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected access token to be refused after logout, got %d", rr.Code)
+	}
+	if sessions, _ := storage.ListSessions(ctx, "alice"); len(sessions) != 0 {
+		t.Errorf("Sessions left after logout: %+v", sessions)
+	}
+	if sessions, _ := storage.ListSessions(ctx, "bob"); len(sessions) != 1 {
+		t.Error("Logout ended another user's session")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	storage, err := NewStorage(t.TempDir(), NewLocalBlobStore(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(storage)
+	if err := storage.AddUser(context.Background(), models.User{Username: "alice", IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)}); err != nil {
+		t.Fatal(err)
+	}
+
+	hourAgo := time.Now().Add(-time.Hour)
+	openSession(t, handler, models.Session{RefreshToken: "refresh-token", ID: "laptop", Username: "alice", ExpiresAt: time.Now().Add(time.Minute), LastUsedAt: hourAgo})
+	refresh := func(refreshToken string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.RefreshRequest{RefreshToken: refreshToken})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/auth/refresh", bytes.NewReader(body)))
+		return rr
+	}
+
+	rr := refresh("refresh-token")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected refresh to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var session models.Session
+	_ = json.NewDecoder(rr.Body).Decode(&session)
+	if session.ID != "laptop" || session.RefreshToken == "" || session.RefreshToken == "refresh-token" {
+		t.Errorf("Expected a new refresh token for the session, got %+v", session)
+	}
+	if !session.LastUsedAt.After(hourAgo) || !session.ExpiresAt.After(time.Now().Add(handler.SessionTTL-time.Minute)) {
+		t.Errorf("Expected the session to be extended, got %+v", session)
+	}
+
+	req := httptest.NewRequest("GET", "/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected the new access token to work, got %d", rr.Code)
+	}
+
+	if rr := refresh("refresh-token"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a used refresh token to be refused, got %d", rr.Code)
+	}
+	if rr := refresh(""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a refresh token, got %d", rr.Code)
+	}
+
+	if err := storage.RevokeSession(context.Background(), "alice", "laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if rr := refresh(session.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked session's refresh token to be refused, got %d", rr.Code)
+	}
+}
+
+func TestRefreshAfterDeleteUser(t *testing.T) {
+	storage, err := NewStorage(t.TempDir(), NewLocalBlobStore(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(storage)
+	ctx := context.Background()
+	alice := models.User{Username: "alice", IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)}
+	if err := storage.AddUser(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	token := openSession(t, handler, models.Session{RefreshToken: "refresh-token", Username: "alice"})
+
+	do := func(method, path, token string, body any) int {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := do("DELETE", "/users?username=alice", token, nil); code != http.StatusOK {
+		t.Fatalf("Expected 200 deleting own account, got %d", code)
+	}
+	if sessions, _ := storage.ListSessions(ctx, "alice"); len(sessions) != 0 {
+		t.Errorf("Sessions left after deleting the account: %+v", sessions)
+	}
+	if code := do("GET", "/sessions", token, nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected a deleted user's access token to be refused, got %d", code)
+	}
+	if code := do("POST", "/auth/refresh", "", models.RefreshRequest{RefreshToken: "refresh-token"}); code != http.StatusUnauthorized {
+		t.Errorf("Expected a deleted user's refresh token to be refused, got %d", code)
+	}
+
+	// Registering the name again does not bring the old sessions back
+	if err := storage.AddUser(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	if code := do("POST", "/auth/refresh", "", models.RefreshRequest{RefreshToken: "refresh-token"}); code != http.StatusUnauthorized {
+		t.Errorf("Expected the old refresh token to be refused after registering again, got %d", code)
+	}
+
+	// A session whose user is gone cannot be refreshed even if it was left behind
+	openSession(t, handler, models.Session{RefreshToken: "orphan-token", Username: "carol"})
+	if code := do("POST", "/auth/refresh", "", models.RefreshRequest{RefreshToken: "orphan-token"}); code != http.StatusUnauthorized {
+		t.Errorf("Expected a session without a user to be refused, got %d", code)
+	}
 }
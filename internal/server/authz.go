@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/VinMeld/go-send/internal/models"
+)
+
+// fileAccess decides whether a user may act on a file.
+type fileAccess func(h *Handler, user string, meta models.FileMetadata) bool
+
+// canDownload allows the recipient, and the sender if the handler permits
+// senders to fetch what they sent.
+func canDownload(h *Handler, user string, meta models.FileMetadata) bool {
+	return meta.Recipient == user || (h.SenderCanDownload && meta.Sender == user)
+}
+
+// canAcknowledge allows only the recipient, whose downloads are the ones
+// that count against a file's download limit.
+func canAcknowledge(_ *Handler, user string, meta models.FileMetadata) bool {
+	return meta.Recipient == user
+}
+
+// canDelete allows either party to a transfer.
+func canDelete(_ *Handler, user string, meta models.FileMetadata) bool {
+	return meta.Sender == user || meta.Recipient == user
+}
+
+// authenticatedUser returns the user set by AuthMiddleware, writing an error
+// response if there is none.
+func authenticatedUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok || user == "" {
+		writeError(w, r, "authentication required", http.StatusUnauthorized)
+		return "", false
+	}
+	return user, true
+}
+
+// fileFor looks up the file named by the "id" query parameter and checks
+// that the authenticated user may access it. Files the user may not access
+// are reported as not found, so their existence is not revealed.
+func (h *Handler) fileFor(w http.ResponseWriter, r *http.Request, access fileAccess) (string, models.FileMetadata, bool) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, r, "id required", http.StatusBadRequest)
+		return "", models.FileMetadata{}, false
+	}
+
+	user, ok := authenticatedUser(w, r)
+	if !ok {
+		return "", models.FileMetadata{}, false
+	}
+
+	meta, ok := h.Storage.GetFileMetadata(r.Context(), id)
+	if !ok || !access(h, user, meta) {
+		writeError(w, r, "file not found", http.StatusNotFound)
+		return "", models.FileMetadata{}, false
+	}
+	return user, meta, true
+}
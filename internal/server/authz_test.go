@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/models"
+)
+
+func TestFileAuthorization(t *testing.T) {
+	h, store, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	ctx := context.Background()
+	tokens := make(map[string]string)
+	for _, name := range []string{"alice", "bob", "eve"} {
+		_ = store.AddUser(ctx, models.User{Username: name, IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)})
+		tokens[name] = openSession(t, h, models.Session{Username: name})
+	}
+	meta := models.FileMetadata{ID: "file1", Sender: "alice", Recipient: "bob", FileName: "test.txt", EncryptedKey: []byte("key"), Timestamp: time.Now()}
+	if err := store.SaveFile(ctx, meta, []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests go through ServeHTTP so every route is checked with its
+	// middleware in place
+	do := func(method, target, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if user != "" {
+			req.Header.Set("Authorization", "Bearer "+tokens[user])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		user   string
+		want   int
+	}{
+		{"list own files", "GET", "/files?recipient=bob", "bob", http.StatusOK},
+		{"list defaults to own files", "GET", "/files", "bob", http.StatusOK},
+		{"list other user's files", "GET", "/files?recipient=bob", "eve", http.StatusForbidden},
+		{"list unauthenticated", "GET", "/files?recipient=bob", "", http.StatusUnauthorized},
+
+		{"download by recipient", "GET", "/files/download?id=file1", "bob", http.StatusOK},
+		{"download by sender", "GET", "/files/download?id=file1", "alice", http.StatusNotFound},
+		{"download by stranger", "GET", "/files/download?id=file1", "eve", http.StatusNotFound},
+		{"download unknown file", "GET", "/files/download?id=unknown", "bob", http.StatusNotFound},
+		{"download unauthenticated", "GET", "/files/download?id=file1", "", http.StatusUnauthorized},
+
+		{"stream by recipient", "GET", "/files/stream?id=file1", "bob", http.StatusOK},
+		{"stream by sender", "GET", "/files/stream?id=file1", "alice", http.StatusNotFound},
+		{"stream by stranger", "GET", "/files/stream?id=file1", "eve", http.StatusNotFound},
+		{"stream unknown file", "GET", "/files/stream?id=unknown", "bob", http.StatusNotFound},
+		{"stream unauthenticated", "GET", "/files/stream?id=file1", "", http.StatusUnauthorized},
+
+		{"ack by recipient", "POST", "/files/ack?id=file1", "bob", http.StatusOK},
+		{"ack by sender", "POST", "/files/ack?id=file1", "alice", http.StatusNotFound},
+		{"ack by stranger", "POST", "/files/ack?id=file1", "eve", http.StatusNotFound},
+		{"ack unauthenticated", "POST", "/files/ack?id=file1", "", http.StatusUnauthorized},
+
+		{"delete by stranger", "DELETE", "/files?id=file1", "eve", http.StatusNotFound},
+		{"delete unknown file", "DELETE", "/files?id=unknown", "bob", http.StatusNotFound},
+		{"delete unauthenticated", "DELETE", "/files?id=file1", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if w := do(tt.method, tt.target, tt.user); w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.want, w.Code, w.Body.String())
+		}
+	}
+
+	// A forbidden file is indistinguishable from a missing one
+	hidden := do("GET", "/files/download?id=file1", "eve")
+	missing := do("GET", "/files/download?id=unknown", "eve")
+	if hidden.Body.String() != missing.Body.String() {
+		t.Errorf("Forbidden and missing files differ: %q vs %q", hidden.Body.String(), missing.Body.String())
+	}
+
+	// Listing only ever returns the caller's files
+	var files []models.FileMetadata
+	_ = json.NewDecoder(do("GET", "/files", "eve").Body).Decode(&files)
+	if len(files) != 0 {
+		t.Errorf("Expected eve to see no files, got %d", len(files))
+	}
+
+	// Senders may download when enabled, but cannot use up the recipient's
+	// downloads
+	h.SenderCanDownload = true
+	meta.ID, meta.AutoDelete = "file2", true
+	if err := store.SaveFile(ctx, meta, []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{"/files/download?id=file2", "/files/stream?id=file2"} {
+		if w := do("GET", target, "alice"); w.Code != http.StatusOK {
+			t.Errorf("%s by sender: expected 200, got %d", target, w.Code)
+		}
+	}
+	if w := do("POST", "/files/ack?id=file2", "alice"); w.Code != http.StatusNotFound {
+		t.Errorf("Acknowledgement by sender: expected 404, got %d", w.Code)
+	}
+	if _, ok := store.GetFileMetadata(ctx, "file2"); !ok {
+		t.Error("Sender download should not auto-delete the file")
+	}
+	if w := do("GET", "/files/stream?id=file2", "eve"); w.Code != http.StatusNotFound {
+		t.Errorf("Stranger download with sender access enabled: expected 404, got %d", w.Code)
+	}
+}
@@ -1,15 +1,56 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"time"
 )
 
-// BlobStore defines the interface for storing file content.
+// ErrBlobNotFound is returned when a blob does not exist.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobInfo describes a stored blob.
+type BlobInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// BlobStore defines the interface for storing file content. All operations
+// honour context cancellation, so an aborted request stops its blob I/O.
 type BlobStore interface {
-	Save(id string, content []byte) error
-	Get(id string) ([]byte, error)
-	Delete(id string) error
+	// Put stores everything read from r under id and returns the number of
+	// bytes written. A failed Put never leaves a partial blob behind.
+	Put(ctx context.Context, id string, r io.Reader) (int64, error)
+	// Get opens a blob for reading. The caller must close the reader.
+	Get(ctx context.Context, id string) (io.ReadCloser, error)
+	// GetRange opens length bytes of a blob starting at offset. A negative
+	// length reads to the end of the blob.
+	GetRange(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error)
+	// Stat returns the size and modification time of a blob.
+	Stat(ctx context.Context, id string) (BlobInfo, error)
+	// Delete removes a blob. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, id string) error
+}
+
+// contextReader fails reads once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+type contextReadCloser struct {
+	contextReader
+	io.Closer
 }
 
 // LocalBlobStore implements BlobStore using the local filesystem.
@@ -21,19 +62,88 @@ func NewLocalBlobStore(baseDir string) *LocalBlobStore {
 	return &LocalBlobStore{BaseDir: baseDir}
 }
 
-func (s *LocalBlobStore) Save(id string, content []byte) error {
-	filePath := filepath.Join(s.BaseDir, id+".bin")
-	return os.WriteFile(filePath, content, 0644)
+func (s *LocalBlobStore) path(id string) string {
+	return filepath.Join(s.BaseDir, id+".bin")
 }
 
-func (s *LocalBlobStore) Get(id string) ([]byte, error) {
-	filePath := filepath.Join(s.BaseDir, id+".bin")
-	return os.ReadFile(filePath)
+// Put writes the blob to a temporary file and renames it into place.
+func (s *LocalBlobStore) Put(ctx context.Context, id string, r io.Reader) (int64, error) {
+	tmp, err := os.CreateTemp(s.BaseDir, id+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	n, err := io.Copy(tmp, contextReader{ctx: ctx, r: r})
+	if err != nil {
+		_ = tmp.Close()
+		return n, err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), s.path(id))
 }
 
-func (s *LocalBlobStore) Delete(id string) error {
-	filePath := filepath.Join(s.BaseDir, id+".bin")
-	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+func (s *LocalBlobStore) Get(ctx context.Context, id string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, err
+	}
+	return contextReadCloser{contextReader{ctx: ctx, r: f}, f}, nil
+}
+
+func (s *LocalBlobStore) GetRange(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	var r io.Reader = f
+	if length >= 0 {
+		r = io.LimitReader(f, length)
+	}
+	return contextReadCloser{contextReader{ctx: ctx, r: r}, f}, nil
+}
+
+func (s *LocalBlobStore) Stat(ctx context.Context, id string) (BlobInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return BlobInfo{}, err
+	}
+	info, err := os.Stat(s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return BlobInfo{}, ErrBlobNotFound
+		}
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *LocalBlobStore) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	return nil
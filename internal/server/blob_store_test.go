@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "go-send-blob-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	store := NewLocalBlobStore(tmpDir)
+	ctx := context.Background()
+
+	content := []byte("hello blob")
+	n, err := store.Put(ctx, "blob1", bytes.NewReader(content))
+	if err != nil || n != int64(len(content)) {
+		t.Fatalf("Put failed: n=%d err=%v", n, err)
+	}
+
+	info, err := store.Stat(ctx, "blob1")
+	if err != nil || info.Size != int64(len(content)) {
+		t.Errorf("Stat mismatch: %+v (%v)", info, err)
+	}
+
+	r, err := store.Get(ctx, "blob1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	got, _ := io.ReadAll(r)
+	_ = r.Close()
+	if !bytes.Equal(got, content) {
+		t.Error("Get mismatch")
+	}
+
+	r, err = store.GetRange(ctx, "blob1", 6, 3)
+	if err != nil {
+		t.Fatalf("GetRange failed: %v", err)
+	}
+	got, _ = io.ReadAll(r)
+	_ = r.Close()
+	if string(got) != "blo" {
+		t.Errorf("GetRange mismatch: %q", got)
+	}
+
+	if err := store.Delete(ctx, "blob1"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if _, err := store.Get(ctx, "blob1"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Expected ErrBlobNotFound, got %v", err)
+	}
+	if _, err := store.Stat(ctx, "blob1"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Expected ErrBlobNotFound, got %v", err)
+	}
+	if err := store.Delete(ctx, "blob1"); err != nil {
+		t.Errorf("Deleting a missing blob should succeed, got %v", err)
+	}
+}
+
+func TestLocalBlobStoreCancel(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "go-send-blob-cancel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	store := NewLocalBlobStore(tmpDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := store.Put(ctx, "blob1", bytes.NewReader([]byte("data"))); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	// A cancelled Put leaves neither the blob nor its temporary file behind
+	entries, _ := os.ReadDir(tmpDir)
+	if len(entries) != 0 {
+		t.Errorf("Expected empty dir, found %d entries", len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "blob1.bin")); !os.IsNotExist(err) {
+		t.Error("Blob should not exist after cancelled Put")
+	}
+
+	// Reads stop once the request context is done
+	_, _ = store.Put(context.Background(), "blob2", bytes.NewReader([]byte("data")))
+	ctx, cancel = context.WithCancel(context.Background())
+	r, err := store.Get(ctx, "blob2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+	cancel()
+	if _, err := io.ReadAll(r); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from read, got %v", err)
+	}
+}
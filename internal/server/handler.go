@@ -2,12 +2,15 @@ package server
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/VinMeld/go-send/internal/crypto"
 	"github.com/VinMeld/go-send/internal/models"
 	"github.com/google/uuid"
 )
@@ -15,16 +18,63 @@ import (
 type contextKey string
 
 const (
-	userContextKey contextKey = "user"
+	userContextKey    contextKey = "user"
+	sessionContextKey contextKey = "session"
 )
 
+// DefaultMaxFileTTL is the longest a file is kept on the server by default.
+const DefaultMaxFileTTL = 7 * 24 * time.Hour
+
+// DefaultChallengeTTL is how long a login challenge can be answered.
+const DefaultChallengeTTL = 2 * time.Minute
+
+// DefaultAccessTokenTTL is how long an access token is valid by default.
+const DefaultAccessTokenTTL = 15 * time.Minute
+
+// DefaultSessionTTL is how long a session lasts without being refreshed by
+// default.
+const DefaultSessionTTL = 30 * 24 * time.Hour
+
 type Handler struct {
 	Storage           *Storage
 	RegistrationToken string
+	// UploadSessionTTL is how long an idle upload session is kept.
+	UploadSessionTTL time.Duration
+	// SenderCanDownload lets senders download files they sent, in addition
+	// to the recipient.
+	SenderCanDownload bool
+	// MaxFileTTL is the longest a file is kept before it expires. It is
+	// also the lifetime of files whose sender does not choose one.
+	MaxFileTTL time.Duration
+	// Origin is the scheme and host clients use to reach the server, such
+	// as "https://send.example.com". Login signatures are bound to it. When
+	// empty it is taken from each request, which a relaying server can fake.
+	Origin string
+	// ChallengeTTL is how long a login challenge can be answered.
+	ChallengeTTL time.Duration
+	// AccessTokenTTL is how long an access token is valid. A session ended
+	// on another server is only refused once its access tokens expire.
+	AccessTokenTTL time.Duration
+	// SessionTTL is how long a session lasts without being refreshed.
+	SessionTTL time.Duration
+
+	addrThrottle *loginThrottle
+	userThrottle *loginThrottle
+	revoked      *revocations
 }
 
 func NewHandler(storage *Storage) *Handler {
-	return &Handler{Storage: storage}
+	return &Handler{
+		Storage:          storage,
+		UploadSessionTTL: DefaultUploadSessionTTL,
+		MaxFileTTL:       DefaultMaxFileTTL,
+		ChallengeTTL:     DefaultChallengeTTL,
+		AccessTokenTTL:   DefaultAccessTokenTTL,
+		SessionTTL:       DefaultSessionTTL,
+		addrThrottle:     newLoginThrottle(DefaultMaxLoginFailures, DefaultLoginFailureWindow),
+		userThrottle:     newLoginThrottle(DefaultMaxUserLoginFailures, DefaultLoginFailureWindow),
+		revoked:          newRevocations(),
+	}
 }
 
 // SetRegistrationToken sets the registration token for the handler.
@@ -42,7 +92,7 @@ func (h *Handler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 		token := r.Header.Get("X-Registration-Token")
 		if token != h.RegistrationToken {
 			slog.Warn("invalid registration token", "token", token)
-			http.Error(w, "forbidden: invalid registration token", http.StatusForbidden)
+			writeError(w, r, "forbidden: invalid registration token", http.StatusForbidden)
 			return
 		}
 	}
@@ -50,17 +100,21 @@ func (h *Handler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		slog.Error("failed to decode user", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if user.Username == "" || len(user.IdentityPublicKey) == 0 || len(user.ExchangePublicKey) == 0 {
-		http.Error(w, "invalid user", http.StatusBadRequest)
+		writeError(w, r, "invalid user", http.StatusBadRequest)
 		return
 	}
 
 	if err := h.Storage.AddUser(r.Context(), user); err != nil {
+		if errors.Is(err, ErrUserExists) {
+			writeError(w, r, "user already exists; use key rotation to replace its keys", http.StatusConflict)
+			return
+		}
 		slog.Error("failed to add user", "username", user.Username, "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	slog.Info("user registered", "username", user.Username)
@@ -74,7 +128,7 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 		users, err := h.Storage.ListAllUsers(r.Context())
 		if err != nil {
 			slog.Error("failed to list users", "error", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		_ = json.NewEncoder(w).Encode(users)
@@ -83,7 +137,7 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 
 	user, ok := h.Storage.GetUser(r.Context(), username)
 	if !ok {
-		http.Error(w, "user not found", http.StatusNotFound)
+		writeError(w, r, "user not found", http.StatusNotFound)
 		return
 	}
 
@@ -94,56 +148,124 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	username := r.URL.Query().Get("username")
 	if username == "" {
-		http.Error(w, "username required", http.StatusBadRequest)
+		writeError(w, r, "username required", http.StatusBadRequest)
 		return
 	}
 
 	// Get authenticated user from context
 	currentUser, ok := r.Context().Value(userContextKey).(string)
 	if !ok {
-		http.Error(w, "authentication required", http.StatusUnauthorized)
+		writeError(w, r, "authentication required", http.StatusUnauthorized)
 		return
 	}
 
 	// Users can only delete their own account
 	if currentUser != username {
 		slog.Warn("unauthorized user deletion attempt", "current_user", currentUser, "target_user", username)
-		http.Error(w, "forbidden: can only delete your own account", http.StatusForbidden)
+		writeError(w, r, "forbidden: can only delete your own account", http.StatusForbidden)
 		return
 	}
 
 	// Delete the user
 	if err := h.Storage.DeleteUser(r.Context(), username); err != nil {
 		slog.Error("failed to delete user", "username", username, "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Deletion ends the user's sessions; refuse their access tokens too
+	now := time.Now()
+	h.revoked.revokeUser(username, now, now.Add(h.AccessTokenTTL))
 	slog.Info("user deleted", "username", username)
 	w.WriteHeader(http.StatusOK)
 }
 
+// policyValid reports whether the expiry time and download limit requested
+// in meta are valid, writing an error response if they are not.
+func policyValid(w http.ResponseWriter, r *http.Request, meta models.FileMetadata) bool {
+	if !meta.ExpiresAt.IsZero() && !meta.ExpiresAt.After(time.Now()) {
+		writeError(w, r, "expiry time must be in the future", http.StatusBadRequest)
+		return false
+	}
+	if meta.MaxDownloads < 0 {
+		writeError(w, r, "download limit must not be negative", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// downloadsRemaining returns the download limit of a new file, treating
+// AutoDelete as a limit of one. Zero means no limit.
+func downloadsRemaining(meta models.FileMetadata) int {
+	if meta.MaxDownloads == 0 && meta.AutoDelete {
+		return 1
+	}
+	return meta.MaxDownloads
+}
+
+// expiresAt returns when a file stored at now expires. Requested times
+// beyond MaxFileTTL are capped, and an unset time gets the maximum.
+func (h *Handler) expiresAt(requested, now time.Time) time.Time {
+	limit := now.Add(h.MaxFileTTL)
+	if requested.IsZero() || requested.After(limit) {
+		return limit
+	}
+	return requested
+}
+
+// signed reports whether meta carries the sender's signature over its
+// transfer manifest, writing an error response if it does not. The server
+// cannot check the signature itself, but recipients refuse unsigned files,
+// so storing one would only waste space.
+func signed(w http.ResponseWriter, r *http.Request, meta models.FileMetadata) bool {
+	if len(meta.Signature) == 0 {
+		writeError(w, r, "upload is not signed", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// senderMatches reports whether the sender claimed in meta is the
+// authenticated user, writing an error response if it is not.
+func senderMatches(w http.ResponseWriter, r *http.Request, meta models.FileMetadata) bool {
+	currentUser, ok := authenticatedUser(w, r)
+	if !ok {
+		return false
+	}
+	if meta.Sender != currentUser {
+		slog.Warn("upload with mismatched sender", "current_user", currentUser, "sender", meta.Sender)
+		writeError(w, r, "forbidden: sender does not match authenticated user", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
 func (h *Handler) UploadFile(w http.ResponseWriter, r *http.Request) {
 	var req models.UploadRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		slog.Error("failed to decode upload request", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Validate
 	if req.Metadata.Recipient == "" || len(req.EncryptedContent) == 0 {
-		http.Error(w, "invalid request", http.StatusBadRequest)
+		writeError(w, r, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !senderMatches(w, r, req.Metadata) || !policyValid(w, r, req.Metadata) || !signed(w, r, req.Metadata) {
 		return
 	}
 
-	// Assign ID and Timestamp
+	// Assign ID, Timestamp, expiry and download limit
 	req.Metadata.ID = uuid.New().String()
 	req.Metadata.Timestamp = time.Now()
+	req.Metadata.ExpiresAt = h.expiresAt(req.Metadata.ExpiresAt, req.Metadata.Timestamp)
+	req.Metadata.DownloadsRemaining = downloadsRemaining(req.Metadata)
 
 	if err := h.Storage.SaveFile(r.Context(), req.Metadata, req.EncryptedContent); err != nil {
 		slog.Error("failed to save file", "sender", req.Metadata.Sender, "recipient", req.Metadata.Recipient, "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	slog.Info("file uploaded", "id", req.Metadata.ID, "sender", req.Metadata.Sender, "recipient", req.Metadata.Recipient)
@@ -152,95 +274,104 @@ func (h *Handler) UploadFile(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(req.Metadata)
 }
 
+// ListFiles lists the files sent to the authenticated user. The optional
+// "recipient" parameter must name that user.
 func (h *Handler) ListFiles(w http.ResponseWriter, r *http.Request) {
+	currentUser, ok := authenticatedUser(w, r)
+	if !ok {
+		return
+	}
 	recipient := r.URL.Query().Get("recipient")
 	if recipient == "" {
-		http.Error(w, "recipient required", http.StatusBadRequest)
+		recipient = currentUser
+	}
+	if recipient != currentUser {
+		slog.Warn("unauthorized file listing attempt", "current_user", currentUser, "recipient", recipient)
+		writeError(w, r, "forbidden: can only list your own files", http.StatusForbidden)
 		return
 	}
 
 	files, err := h.Storage.ListFiles(r.Context(), recipient)
 	if err != nil {
 		slog.Error("failed to list files", "recipient", recipient, "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	_ = json.NewEncoder(w).Encode(files)
 }
 
+// DownloadFile returns a file and its metadata as JSON. Files with a download
+// limit are only counted once the client acknowledges the download.
 func (h *Handler) DownloadFile(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		http.Error(w, "id required", http.StatusBadRequest)
-		return
-	}
-
-	meta, ok := h.Storage.GetFileMetadata(r.Context(), id)
+	currentUser, meta, ok := h.fileFor(w, r, canDownload)
 	if !ok {
-		http.Error(w, "file not found", http.StatusNotFound)
 		return
 	}
+	id := meta.ID
 
-	content, err := h.Storage.GetFileContent(id)
+	content, err := h.Storage.GetFileContent(r.Context(), id)
 	if err != nil {
 		slog.Error("failed to get file content", "id", id, "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	slog.Info("file downloaded", "id", id, "recipient", meta.Recipient)
+	slog.Info("file downloaded", "id", id, "by", currentUser)
 
 	resp := models.UploadRequest{
 		Metadata:         meta,
 		EncryptedContent: content,
 	}
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		return
-	}
-
-	// Auto-delete after successful download if requested
-	if meta.AutoDelete {
-		_ = h.Storage.DeleteFile(r.Context(), id)
-	}
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
-// AuthMiddleware protects routes by requiring a valid session token.
+// AuthMiddleware protects routes by requiring a valid access token. Tokens
+// are checked against the server's token key rather than looked up, so
+// authenticating a request does not touch the database.
 func (h *Handler) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "authorization header required", http.StatusUnauthorized)
+			writeError(w, r, "authorization header required", http.StatusUnauthorized)
 			return
 		}
 
 		// Expect "Bearer <token>"
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "invalid authorization format", http.StatusUnauthorized)
+			writeError(w, r, "invalid authorization format", http.StatusUnauthorized)
 			return
 		}
 
-		token := parts[1]
-		session, ok := h.Storage.GetSession(r.Context(), token)
-		if !ok || session.ExpiresAt.Before(time.Now()) {
-			if ok {
-				_ = h.Storage.DeleteSession(r.Context(), token)
-			}
-			http.Error(w, "invalid or expired session", http.StatusUnauthorized)
+		token, err := crypto.VerifyAccessToken(h.Storage.TokenKey.Public().(ed25519.PublicKey), parts[1])
+		if err != nil || !time.Now().Before(token.ExpiresAt) || h.revoked.revoked(token) {
+			writeError(w, r, "invalid or expired access token", http.StatusUnauthorized)
 			return
 		}
 
-		// Add user to context
-		ctx := context.WithValue(r.Context(), userContextKey, session.Username)
+		// Add user and session to context
+		ctx := context.WithValue(r.Context(), userContextKey, token.Username)
+		ctx = context.WithValue(ctx, sessionContextKey, token)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
 
-// ServeHTTP implements http.Handler.
+// ServeHTTP implements http.Handler. The API is served under /v1, and at
+// the unversioned paths older clients use.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.route(w, versioned(w, r))
+}
+
+func (h *Handler) route(w http.ResponseWriter, r *http.Request) {
 	// Simple router
 	switch r.URL.Path {
 	case "/ping":
 		h.Ping(w, r)
+	case "/openapi.json":
+		if r.Method == http.MethodGet {
+			h.OpenAPI(w, r)
+		} else {
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
 	case "/users":
 		if r.Method == http.MethodPost {
 			h.RegisterUser(w, r)
@@ -249,7 +380,41 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		} else if r.Method == http.MethodDelete {
 			h.AuthMiddleware(h.DeleteUser)(w, r)
 		} else {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "/users/keys":
+		if r.Method == http.MethodGet {
+			h.GetKeyHistory(w, r)
+		} else if r.Method == http.MethodPost {
+			h.AuthMiddleware(h.RotateKeys)(w, r)
+		} else {
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "/log/head":
+		if r.Method == http.MethodGet {
+			h.GetKeyLogHead(w, r)
+		} else {
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "/log/proof":
+		if r.Method == http.MethodGet {
+			h.GetKeyLogProof(w, r)
+		} else {
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "/log/consistency":
+		if r.Method == http.MethodGet {
+			h.GetKeyLogConsistency(w, r)
+		} else {
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "/sessions":
+		if r.Method == http.MethodGet {
+			h.AuthMiddleware(h.ListSessions)(w, r)
+		} else if r.Method == http.MethodDelete {
+			h.AuthMiddleware(h.RevokeSession)(w, r)
+		} else {
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
 		}
 	case "/files":
 		if r.Method == http.MethodPost {
@@ -259,27 +424,75 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		} else if r.Method == http.MethodDelete {
 			h.AuthMiddleware(h.DeleteFile)(w, r)
 		} else {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
 		}
 	case "/files/download":
 		if r.Method == http.MethodGet {
 			h.AuthMiddleware(h.DownloadFile)(w, r)
 		} else {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "/files/ack":
+		if r.Method == http.MethodPost {
+			h.AuthMiddleware(h.AcknowledgeDownload)(w, r)
+		} else {
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "/files/stream":
+		if r.Method == http.MethodPost {
+			h.AuthMiddleware(h.UploadFileStream)(w, r)
+		} else if r.Method == http.MethodGet {
+			h.AuthMiddleware(h.DownloadFileStream)(w, r)
+		} else {
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "/uploads":
+		if r.Method == http.MethodPost {
+			h.AuthMiddleware(h.CreateUpload)(w, r)
+		} else if r.Method == http.MethodGet {
+			h.AuthMiddleware(h.GetUpload)(w, r)
+		} else if r.Method == http.MethodDelete {
+			h.AuthMiddleware(h.AbortUpload)(w, r)
+		} else {
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "/uploads/chunk":
+		if r.Method == http.MethodPut {
+			h.AuthMiddleware(h.UploadChunk)(w, r)
+		} else {
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "/uploads/complete":
+		if r.Method == http.MethodPost {
+			h.AuthMiddleware(h.CompleteUpload)(w, r)
+		} else {
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
 		}
 	case "/auth/challenge":
 		if r.Method == http.MethodGet {
 			h.HandleGetChallenge(w, r)
 		} else {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
 		}
 	case "/auth/login":
 		if r.Method == http.MethodPost {
 			h.HandleLogin(w, r)
 		} else {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "/auth/refresh":
+		if r.Method == http.MethodPost {
+			h.HandleRefresh(w, r)
+		} else {
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "/auth/logout":
+		if r.Method == http.MethodPost {
+			h.AuthMiddleware(h.HandleLogout)(w, r)
+		} else {
+			writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
 		}
 	default:
-		http.NotFound(w, r)
+		writeError(w, r, "not found", http.StatusNotFound)
 	}
 }
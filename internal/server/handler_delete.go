@@ -7,37 +7,43 @@ import (
 
 // DeleteFile deletes a file if the user is the sender or recipient.
 func (h *Handler) DeleteFile(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		http.Error(w, "id required", http.StatusBadRequest)
+	username, meta, ok := h.fileFor(w, r, canDelete)
+	if !ok {
 		return
 	}
+	id := meta.ID
 
-	// Get authenticated user
-	username, ok := r.Context().Value(userContextKey).(string)
-	if !ok {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	if err := h.Storage.DeleteFile(r.Context(), id); err != nil {
+		slog.Error("failed to delete file", "id", id, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	meta, ok := h.Storage.GetFileMetadata(r.Context(), id)
+	slog.Info("file deleted", "id", id, "by", username)
+	w.WriteHeader(http.StatusOK)
+}
+
+// AcknowledgeDownload records that the recipient has downloaded and
+// decrypted a file. Each acknowledgement uses up one download of a file with
+// a download limit, and the file is deleted when none remain. Files without
+// a limit are unaffected.
+func (h *Handler) AcknowledgeDownload(w http.ResponseWriter, r *http.Request) {
+	username, meta, ok := h.fileFor(w, r, canAcknowledge)
 	if !ok {
-		http.Error(w, "file not found", http.StatusNotFound)
 		return
 	}
+	id := meta.ID
 
-	// Check permission
-	if meta.Sender != username && meta.Recipient != username {
-		http.Error(w, "forbidden", http.StatusForbidden)
+	remaining, err := h.Storage.AcknowledgeDownload(r.Context(), id)
+	if err != nil {
+		slog.Error("failed to acknowledge download", "id", id, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	if err := h.Storage.DeleteFile(r.Context(), id); err != nil {
-		slog.Error("failed to delete file", "id", id, "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if remaining == 0 {
+		slog.Info("file deleted after last download", "id", id, "by", username)
+	} else if remaining > 0 {
+		slog.Info("download acknowledged", "id", id, "by", username, "remaining", remaining)
 	}
-
-	slog.Info("file deleted", "id", id, "by", username)
 	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/models"
+)
+
+// maxRotationSkew is how far the signed time of a key rotation may be from
+// the server's clock.
+const maxRotationSkew = 5 * time.Minute
+
+// GetKeyHistory returns the key rotations of a user, oldest first, so that
+// peers can follow their keys from one they already trust.
+func (h *Handler) GetKeyHistory(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		writeError(w, r, "username required", http.StatusBadRequest)
+		return
+	}
+	if _, ok := h.Storage.GetUser(r.Context(), username); !ok {
+		writeError(w, r, "user not found", http.StatusNotFound)
+		return
+	}
+
+	history, err := h.Storage.ListKeyRotations(r.Context(), username)
+	if err != nil {
+		slog.Error("failed to list key rotations", "username", username, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(history)
+}
+
+// RotateKeys replaces the authenticated user's keys with ones signed by
+// their current identity key (authenticated).
+func (h *Handler) RotateKeys(w http.ResponseWriter, r *http.Request) {
+	currentUser, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		writeError(w, r, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var rotation models.KeyRotation
+	if err := json.NewDecoder(r.Body).Decode(&rotation); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rotation.Username != currentUser {
+		slog.Warn("key rotation for another user", "current_user", currentUser, "target_user", rotation.Username)
+		writeError(w, r, "forbidden: can only rotate your own keys", http.StatusForbidden)
+		return
+	}
+	if len(rotation.IdentityPublicKey) != ed25519.PublicKeySize || len(rotation.ExchangePublicKey) != 32 {
+		writeError(w, r, "invalid key rotation", http.StatusBadRequest)
+		return
+	}
+	// Peers choose which key signed a file by the rotation times, so a
+	// rotation may not claim to have happened at another time
+	if skew := time.Since(rotation.RotatedAt).Abs(); skew > maxRotationSkew {
+		writeError(w, r, "key rotation time is too far from the server's clock", http.StatusBadRequest)
+		return
+	}
+	if !crypto.VerifyKeyRotation(crypto.KeyRotation{
+		Username:             rotation.Username,
+		Version:              rotation.Version,
+		OldIdentityPublicKey: rotation.OldIdentityPublicKey,
+		IdentityPublicKey:    rotation.IdentityPublicKey,
+		ExchangePublicKey:    rotation.ExchangePublicKey,
+		RotatedAt:            rotation.RotatedAt,
+	}, rotation.Signature) {
+		slog.Warn("invalid key rotation signature", "username", currentUser)
+		writeError(w, r, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	err := h.Storage.RotateUserKeys(r.Context(), rotation)
+	if errors.Is(err, ErrKeyRotationConflict) {
+		writeError(w, r, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to rotate keys", "username", currentUser, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Rotation ends the user's sessions; refuse their access tokens too
+	now := time.Now()
+	h.revoked.revokeUser(currentUser, now, now.Add(h.AccessTokenTTL))
+	slog.Info("keys rotated", "username", currentUser, "version", rotation.Version)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// GetKeyLogHead returns the signed head of the key transparency log.
+func (h *Handler) GetKeyLogHead(w http.ResponseWriter, r *http.Request) {
+	head, err := h.Storage.KeyLogHead(r.Context())
+	if err != nil {
+		slog.Error("failed to read key log", "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(head)
+}
+
+// GetKeyLogProof returns a user's latest key log entry with a proof that it
+// is in the log.
+func (h *Handler) GetKeyLogProof(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		writeError(w, r, "username required", http.StatusBadRequest)
+		return
+	}
+
+	proof, err := h.Storage.KeyLogProof(r.Context(), username)
+	if errors.Is(err, ErrUserNotFound) {
+		writeError(w, r, "user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to prove key log entry", "username", username, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(proof)
+}
+
+// GetKeyLogConsistency returns a proof that the key log of size first is a
+// prefix of the log of size second.
+func (h *Handler) GetKeyLogConsistency(w http.ResponseWriter, r *http.Request) {
+	first, err1 := strconv.ParseUint(r.URL.Query().Get("first"), 10, 64)
+	second, err2 := strconv.ParseUint(r.URL.Query().Get("second"), 10, 64)
+	if err1 != nil || err2 != nil {
+		writeError(w, r, "first and second tree sizes required", http.StatusBadRequest)
+		return
+	}
+
+	proof, err := h.Storage.KeyLogConsistency(r.Context(), first, second)
+	if errors.Is(err, ErrInvalidTreeSize) {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to prove key log consistency", "first", first, "second", second, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(proof)
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/transport"
+	"github.com/google/uuid"
+)
+
+// errInvalidRange is returned by parseRange for malformed or unsatisfiable ranges.
+var errInvalidRange = errors.New("invalid range")
+
+// parseRange parses a single-range "bytes=" Range header against a blob of
+// the given size and returns the offset and length to serve. Multiple ranges
+// are not supported.
+func parseRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, errInvalidRange
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errInvalidRange
+	}
+
+	if first == "" {
+		// Suffix range: the last N bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, errInvalidRange
+		}
+		n = min(n, size)
+		return size - n, n, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, errInvalidRange
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, errInvalidRange
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, nil
+}
+
+// UploadFileStream stores a file sent as a raw ciphertext body. The metadata
+// travels in the transport.MetadataHeader header, so the body is streamed
+// straight into the BlobStore without being decoded or buffered.
+func (h *Handler) UploadFileStream(w http.ResponseWriter, r *http.Request) {
+	meta, err := transport.DecodeMetadata(r.Header.Get(transport.MetadataHeader))
+	if err != nil {
+		writeError(w, r, "invalid metadata header", http.StatusBadRequest)
+		return
+	}
+	if meta.Recipient == "" || r.ContentLength == 0 {
+		writeError(w, r, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !senderMatches(w, r, meta) || !policyValid(w, r, meta) || !signed(w, r, meta) {
+		return
+	}
+
+	// Assign ID, Timestamp, expiry and download limit
+	meta.ID = uuid.New().String()
+	meta.Timestamp = time.Now()
+	meta.ExpiresAt = h.expiresAt(meta.ExpiresAt, meta.Timestamp)
+	meta.DownloadsRemaining = downloadsRemaining(meta)
+
+	if err := h.Storage.SaveFileStream(r.Context(), meta, r.Body); err != nil {
+		slog.Error("failed to save file", "sender", meta.Sender, "recipient", meta.Recipient, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("file uploaded", "id", meta.ID, "sender", meta.Sender, "recipient", meta.Recipient)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(meta)
+}
+
+// DownloadFileStream returns a file as a raw ciphertext body with its
+// metadata in the transport.MetadataHeader header. A single-range Range
+// header is honoured with a 206 response so interrupted downloads can resume.
+// Delivering the content never deletes the file; clients acknowledge
+// downloads they have decrypted on /files/ack.
+func (h *Handler) DownloadFileStream(w http.ResponseWriter, r *http.Request) {
+	currentUser, meta, ok := h.fileFor(w, r, canDownload)
+	if !ok {
+		return
+	}
+	id := meta.ID
+
+	info, err := h.Storage.StatFileContent(r.Context(), id)
+	if err != nil {
+		slog.Error("failed to stat file content", "id", id, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	header, err := transport.EncodeMetadata(meta)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(transport.MetadataHeader, header)
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	offset, length, status := int64(0), info.Size, http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		offset, length, err = parseRange(rangeHeader, info.Size)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+			writeError(w, r, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, info.Size))
+	}
+
+	content, err := h.Storage.OpenFileContentRange(r.Context(), id, offset, length)
+	if err != nil {
+		slog.Error("failed to open file content", "id", id, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = content.Close() }()
+
+	w.Header().Set("Content-Type", transport.ContentTypeBinary)
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+
+	if _, err := io.Copy(w, content); err != nil {
+		slog.Warn("file download interrupted", "id", id, "error", err)
+		return
+	}
+	slog.Info("file downloaded", "id", id, "by", currentUser, "offset", offset, "length", length)
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -10,7 +11,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/VinMeld/go-send/internal/crypto"
 	"github.com/VinMeld/go-send/internal/models"
+	"github.com/VinMeld/go-send/internal/transport"
 )
 
 func setupTestServer(t *testing.T) (*Handler, *Storage, string) {
@@ -72,6 +75,14 @@ func TestRegisterUserHandler(t *testing.T) {
 	if wGet.Code != http.StatusOK {
 		t.Errorf("Expected status 200 for GetUser, got %d", wGet.Code)
 	}
+
+	// Registering the same name again must not replace the keys
+	req = httptest.NewRequest("POST", "/users", bytes.NewBuffer(data))
+	w = httptest.NewRecorder()
+	h.RegisterUser(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for existing user, got %d", w.Code)
+	}
 }
 
 func TestHandlerErrors(t *testing.T) {
@@ -125,7 +136,7 @@ func TestUploadListFiles(t *testing.T) {
 
 	// Upload File
 	meta := models.FileMetadata{
-		ID: "file1", Sender: "alice", Recipient: "bob", FileName: "test.txt", EncryptedKey: []byte("key"),
+		ID: "file1", Sender: "alice", Recipient: "bob", FileName: "test.txt", EncryptedKey: []byte("key"), Signature: []byte("signature"),
 	}
 	reqBody := models.UploadRequest{
 		Metadata:         meta,
@@ -133,6 +144,7 @@ func TestUploadListFiles(t *testing.T) {
 	}
 	data, _ := json.Marshal(reqBody)
 	req := httptest.NewRequest("POST", "/files", bytes.NewBuffer(data))
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, "alice"))
 	w := httptest.NewRecorder()
 	h.UploadFile(w, req)
 
@@ -142,6 +154,7 @@ func TestUploadListFiles(t *testing.T) {
 
 	// List Files
 	req = httptest.NewRequest("GET", "/files?recipient=bob", nil)
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, "bob"))
 	w = httptest.NewRecorder()
 	h.ListFiles(w, req)
 
@@ -160,6 +173,7 @@ func TestUploadListFiles(t *testing.T) {
 
 	// Download File
 	req = httptest.NewRequest("GET", "/files/download?id="+fileID, nil)
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, "bob"))
 	w = httptest.NewRecorder()
 	h.DownloadFile(w, req)
 
@@ -168,6 +182,200 @@ func TestUploadListFiles(t *testing.T) {
 	}
 }
 
+func TestStreamUploadDownload(t *testing.T) {
+	h, store, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	_ = store.AddUser(context.Background(), models.User{Username: "bob", IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)})
+
+	// Upload raw body with metadata header
+	meta := models.FileMetadata{Sender: "alice", Recipient: "bob", FileName: "big.bin", EncryptedKey: []byte("key"), AutoDelete: true}
+	content := bytes.Repeat([]byte("ciphertext"), 1000)
+	upload := func(meta models.FileMetadata) *httptest.ResponseRecorder {
+		header, _ := transport.EncodeMetadata(meta)
+		req := httptest.NewRequest("POST", "/files/stream", bytes.NewReader(content))
+		req.Header.Set(transport.MetadataHeader, header)
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, "alice"))
+		w := httptest.NewRecorder()
+		h.UploadFileStream(w, req)
+		return w
+	}
+
+	// Recipients refuse unsigned files, so the server does not store them
+	if w := upload(meta); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unsigned upload, got %d", w.Code)
+	}
+	meta.Signature = []byte("signature")
+	w := upload(meta)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created models.FileMetadata
+	_ = json.NewDecoder(w.Body).Decode(&created)
+	if created.ID == "" {
+		t.Fatal("Expected server-assigned ID")
+	}
+	if created.DownloadsRemaining != 1 {
+		t.Errorf("Auto-delete should allow one download, got %d", created.DownloadsRemaining)
+	}
+
+	// Download raw body
+	req := httptest.NewRequest("GET", "/files/stream?id="+created.ID, nil)
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, "bob"))
+	w = httptest.NewRecorder()
+	h.DownloadFileStream(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if !bytes.Equal(w.Body.Bytes(), content) {
+		t.Error("Downloaded content mismatch")
+	}
+	got, err := transport.DecodeMetadata(w.Header().Get(transport.MetadataHeader))
+	if err != nil || got.FileName != "big.bin" || got.ID != created.ID {
+		t.Errorf("Unexpected metadata header: %+v (%v)", got, err)
+	}
+
+	// Delivering the content does not use up the download
+	if _, ok := store.GetFileMetadata(context.Background(), created.ID); !ok {
+		t.Error("File should be kept until the download is acknowledged")
+	}
+
+	// Missing metadata header
+	req = httptest.NewRequest("POST", "/files/stream", bytes.NewReader(content))
+	w = httptest.NewRecorder()
+	h.UploadFileStream(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for missing metadata, got %d", w.Code)
+	}
+
+	// Unknown file
+	req = httptest.NewRequest("GET", "/files/stream?id=unknown", nil)
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, "bob"))
+	w = httptest.NewRecorder()
+	h.DownloadFileStream(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown file, got %d", w.Code)
+	}
+}
+
+func TestStreamDownloadRange(t *testing.T) {
+	h, store, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	_ = store.AddUser(context.Background(), models.User{Username: "bob", IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)})
+	content := []byte("0123456789")
+	meta := models.FileMetadata{ID: "file1", Sender: "alice", Recipient: "bob", FileName: "f.bin", EncryptedKey: []byte("key"), AutoDelete: true, Timestamp: time.Now()}
+	if err := store.SaveFile(context.Background(), meta, content); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/files/stream?id=file1", nil)
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, "bob"))
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		w := httptest.NewRecorder()
+		h.DownloadFileStream(w, req)
+		return w
+	}
+
+	// Partial range
+	w := get("bytes=2-5")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+		t.Errorf("Expected 206 with 2345, got %d %q", w.Code, w.Body.String())
+	}
+	if cr := w.Header().Get("Content-Range"); cr != "bytes 2-5/10" {
+		t.Errorf("Unexpected Content-Range: %s", cr)
+	}
+	if _, ok := store.GetFileMetadata(context.Background(), "file1"); !ok {
+		t.Fatal("Partial download should not delete the file")
+	}
+
+	// Unsatisfiable range
+	w = get("bytes=10-")
+	if w.Code != http.StatusRequestedRangeNotSatisfiable || w.Header().Get("Content-Range") != "bytes */10" {
+		t.Errorf("Expected 416, got %d (%s)", w.Code, w.Header().Get("Content-Range"))
+	}
+
+	// Open-ended range reaching the end completes the download
+	w = get("bytes=6-")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "6789" {
+		t.Errorf("Expected 206 with 6789, got %d %q", w.Code, w.Body.String())
+	}
+	if _, ok := store.GetFileMetadata(context.Background(), "file1"); !ok {
+		t.Error("Reaching the end of the file should not delete it before acknowledgement")
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header         string
+		offset, length int64
+		ok             bool
+	}{
+		{"bytes=0-9", 0, 10, true},
+		{"bytes=5-", 5, 5, true},
+		{"bytes=5-100", 5, 5, true},
+		{"bytes=-3", 7, 3, true},
+		{"bytes=-30", 0, 10, true},
+		{"bytes=10-", 0, 0, false},
+		{"bytes=5-2", 0, 0, false},
+		{"bytes=0-1,3-4", 0, 0, false},
+		{"items=0-1", 0, 0, false},
+		{"bytes=abc", 0, 0, false},
+	}
+	for _, tc := range tests {
+		offset, length, err := parseRange(tc.header, 10)
+		if (err == nil) != tc.ok || offset != tc.offset || length != tc.length {
+			t.Errorf("parseRange(%q) = %d, %d, %v", tc.header, offset, length, err)
+		}
+	}
+}
+
+func TestUploadSenderMismatch(t *testing.T) {
+	h, store, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	_ = store.AddUser(context.Background(), models.User{Username: "bob", IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)})
+	meta := models.FileMetadata{Sender: "alice", Recipient: "bob", FileName: "forged.txt", EncryptedKey: []byte("key")}
+
+	// Mallory claims to be alice on every upload path
+	data, _ := json.Marshal(models.UploadRequest{Metadata: meta, EncryptedContent: []byte("content")})
+	req := httptest.NewRequest("POST", "/files", bytes.NewBuffer(data))
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, "mallory"))
+	w := httptest.NewRecorder()
+	h.UploadFile(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for JSON upload, got %d", w.Code)
+	}
+
+	header, _ := transport.EncodeMetadata(meta)
+	req = httptest.NewRequest("POST", "/files/stream", bytes.NewReader([]byte("content")))
+	req.Header.Set(transport.MetadataHeader, header)
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, "mallory"))
+	w = httptest.NewRecorder()
+	h.UploadFileStream(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for stream upload, got %d", w.Code)
+	}
+
+	data, _ = json.Marshal(models.CreateUploadRequest{Metadata: meta})
+	req = httptest.NewRequest("POST", "/uploads", bytes.NewBuffer(data))
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, "mallory"))
+	w = httptest.NewRecorder()
+	h.CreateUpload(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for upload session, got %d", w.Code)
+	}
+
+	files, _ := store.ListFiles(context.Background(), "bob")
+	if len(files) != 0 {
+		t.Errorf("Expected no files, got %d", len(files))
+	}
+}
+
 func TestAutoDelete(t *testing.T) {
 	h, store, tmpDir := setupTestServer(t)
 	defer func() { _ = os.RemoveAll(tmpDir) }()
@@ -182,7 +390,7 @@ func TestAutoDelete(t *testing.T) {
 
 	// Upload File with AutoDelete
 	meta := models.FileMetadata{
-		ID: "file1", Sender: "alice", Recipient: "bob", FileName: "secret.txt", AutoDelete: true, EncryptedKey: []byte("key"),
+		ID: "file1", Sender: "alice", Recipient: "bob", FileName: "secret.txt", AutoDelete: true, EncryptedKey: []byte("key"), Signature: []byte("signature"),
 	}
 	reqBody := models.UploadRequest{
 		Metadata:         meta,
@@ -190,6 +398,7 @@ func TestAutoDelete(t *testing.T) {
 	}
 	data, _ := json.Marshal(reqBody)
 	req := httptest.NewRequest("POST", "/files", bytes.NewBuffer(data))
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, "alice"))
 	w := httptest.NewRecorder()
 	h.UploadFile(w, req)
 
@@ -198,6 +407,7 @@ func TestAutoDelete(t *testing.T) {
 	}
 
 	req = httptest.NewRequest("GET", "/files?recipient=bob", nil)
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, "bob"))
 	w = httptest.NewRecorder()
 	h.ListFiles(w, req)
 
@@ -210,18 +420,37 @@ func TestAutoDelete(t *testing.T) {
 
 	// Download File
 	req = httptest.NewRequest("GET", "/files/download?id="+fileID, nil)
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, "bob"))
 	w = httptest.NewRecorder()
 	h.DownloadFile(w, req)
 
 	if w.Code != http.StatusOK {
 		t.Fatalf("Expected 200, got %d", w.Code)
 	}
+	if _, ok := store.GetFileMetadata(context.Background(), fileID); !ok {
+		t.Fatal("File should be kept until the download is acknowledged")
+	}
+
+	// Only the recipient's acknowledgement counts
+	ack := func(user string) int {
+		req := httptest.NewRequest("POST", "/files/ack?id="+fileID, nil)
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+		w := httptest.NewRecorder()
+		h.AcknowledgeDownload(w, req)
+		return w.Code
+	}
+	if code := ack("alice"); code != http.StatusNotFound {
+		t.Errorf("Sender acknowledgement: expected 404, got %d", code)
+	}
+	if code := ack("bob"); code != http.StatusOK {
+		t.Fatalf("Recipient acknowledgement: expected 200, got %d", code)
+	}
 
 	// Verify Deletion
 	if _, ok := store.GetFileMetadata(context.Background(), fileID); ok {
 		t.Error("File metadata should be deleted")
 	}
-	if _, err := store.GetFileContent(fileID); err == nil {
+	if _, err := store.GetFileContent(context.Background(), fileID); err == nil {
 		t.Error("File content should be deleted")
 	}
 }
@@ -269,10 +498,10 @@ func TestDeleteFile(t *testing.T) {
 	// Re-create file
 	_ = store.SaveFile(context.Background(), meta, []byte("content"))
 
-	// Test 3: Unauthorized user cannot delete
+	// Test 3: Unauthorized user cannot delete, or learn that the file exists
 	resp = makeDeleteReq("eve", "file1")
 	_ = resp.Body.Close()
-	if resp.StatusCode != http.StatusForbidden {
+	if resp.StatusCode != http.StatusNotFound {
 		t.Errorf("Unauthorized user should not be able to delete, got %d", resp.StatusCode)
 	}
 
@@ -349,18 +578,8 @@ func TestDeleteUser(t *testing.T) {
 	_ = store.AddUser(context.Background(), bob)
 
 	// Create sessions for both users
-	aliceToken := "alice-token"
-	bobToken := "bob-token"
-	_ = store.CreateSession(context.Background(), models.Session{
-		Token:     aliceToken,
-		Username:  "alice",
-		ExpiresAt: time.Now().Add(time.Hour),
-	})
-	_ = store.CreateSession(context.Background(), models.Session{
-		Token:     bobToken,
-		Username:  "bob",
-		ExpiresAt: time.Now().Add(time.Hour),
-	})
+	aliceToken := openSession(t, h, models.Session{Username: "alice"})
+	bobToken := openSession(t, h, models.Session{Username: "bob"})
 
 	// Test 1: Delete own account successfully
 	req := httptest.NewRequest("DELETE", "/users?username=alice", nil)
@@ -403,3 +622,352 @@ func TestDeleteUser(t *testing.T) {
 		t.Errorf("Expected 400 for missing username, got %d", w.Code)
 	}
 }
+
+func TestFileExpiry(t *testing.T) {
+	h, store, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	h.MaxFileTTL = 24 * time.Hour
+
+	ctx := context.Background()
+	_ = store.AddUser(ctx, models.User{Username: "bob", IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)})
+
+	upload := func(expiresAt time.Time) *httptest.ResponseRecorder {
+		meta := models.FileMetadata{Sender: "alice", Recipient: "bob", EncryptedKey: []byte("key"), Signature: []byte("signature"), ExpiresAt: expiresAt}
+		data, _ := json.Marshal(models.UploadRequest{Metadata: meta, EncryptedContent: []byte("content")})
+		req := httptest.NewRequest("POST", "/files", bytes.NewBuffer(data))
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, "alice"))
+		w := httptest.NewRecorder()
+		h.UploadFile(w, req)
+		return w
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name      string
+		requested time.Time
+		want      time.Time
+	}{
+		{"unset gets the maximum", time.Time{}, now.Add(24 * time.Hour)},
+		{"beyond the maximum is capped", now.Add(48 * time.Hour), now.Add(24 * time.Hour)},
+		{"earlier is kept", now.Add(time.Hour), now.Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		w := upload(tt.requested)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%s: expected 201, got %d: %s", tt.name, w.Code, w.Body.String())
+		}
+		var meta models.FileMetadata
+		_ = json.NewDecoder(w.Body).Decode(&meta)
+		if d := meta.ExpiresAt.Sub(tt.want); d < -time.Minute || d > time.Minute {
+			t.Errorf("%s: expected expiry near %v, got %v", tt.name, tt.want, meta.ExpiresAt)
+		}
+	}
+	if w := upload(now.Add(-time.Minute)); w.Code != http.StatusBadRequest {
+		t.Errorf("Past expiry: expected 400, got %d", w.Code)
+	}
+
+	// An expired file is hidden before the janitor gets to it
+	meta := models.FileMetadata{ID: "expired", Sender: "alice", Recipient: "bob", EncryptedKey: []byte("key"), Timestamp: now, ExpiresAt: now.Add(-time.Second)}
+	if err := store.SaveFile(ctx, meta, []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.GetFileMetadata(ctx, "expired"); ok {
+		t.Error("Expired file should not be found")
+	}
+	files, _ := store.ListFiles(ctx, "bob")
+	if len(files) != len(tests) {
+		t.Errorf("Expected %d unexpired files, got %d", len(tests), len(files))
+	}
+
+	// The janitor removes it along with its content
+	NewJanitor(store, time.Hour).Sweep(ctx)
+	if _, err := store.blobID(ctx, "expired"); err == nil {
+		t.Error("Expired file should be purged")
+	}
+	if _, err := store.BlobStore.Stat(ctx, "expired"); err != ErrBlobNotFound {
+		t.Errorf("Expired blob should be removed, got %v", err)
+	}
+	if files, _ := store.ListFiles(ctx, "bob"); len(files) != len(tests) {
+		t.Errorf("Unexpired files should survive the sweep, got %d", len(files))
+	}
+}
+
+func TestDownloadLimit(t *testing.T) {
+	h, store, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	ctx := context.Background()
+	_ = store.AddUser(ctx, models.User{Username: "bob", IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)})
+
+	upload := func(maxDownloads int) *httptest.ResponseRecorder {
+		meta := models.FileMetadata{Sender: "alice", Recipient: "bob", EncryptedKey: []byte("key"), Signature: []byte("signature"), MaxDownloads: maxDownloads}
+		header, _ := transport.EncodeMetadata(meta)
+		req := httptest.NewRequest("POST", "/files/stream", bytes.NewReader([]byte("content")))
+		req.Header.Set(transport.MetadataHeader, header)
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, "alice"))
+		w := httptest.NewRecorder()
+		h.UploadFileStream(w, req)
+		return w
+	}
+	ack := func(id string) int {
+		req := httptest.NewRequest("POST", "/files/ack?id="+id, nil)
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, "bob"))
+		w := httptest.NewRecorder()
+		h.AcknowledgeDownload(w, req)
+		return w.Code
+	}
+
+	if w := upload(-1); w.Code != http.StatusBadRequest {
+		t.Errorf("Negative limit: expected 400, got %d", w.Code)
+	}
+
+	var limited, unlimited models.FileMetadata
+	_ = json.NewDecoder(upload(2).Body).Decode(&limited)
+	_ = json.NewDecoder(upload(0).Body).Decode(&unlimited)
+	if limited.DownloadsRemaining != 2 || unlimited.DownloadsRemaining != 0 {
+		t.Fatalf("Unexpected limits: %d and %d", limited.DownloadsRemaining, unlimited.DownloadsRemaining)
+	}
+
+	// Each acknowledgement uses up one download
+	if code := ack(limited.ID); code != http.StatusOK {
+		t.Fatalf("First acknowledgement: expected 200, got %d", code)
+	}
+	meta, ok := store.GetFileMetadata(ctx, limited.ID)
+	if !ok || meta.DownloadsRemaining != 1 {
+		t.Fatalf("Expected one download left, got %+v (%v)", meta, ok)
+	}
+	if code := ack(limited.ID); code != http.StatusOK {
+		t.Fatalf("Second acknowledgement: expected 200, got %d", code)
+	}
+	if _, ok := store.GetFileMetadata(ctx, limited.ID); ok {
+		t.Error("File should be deleted after its last download")
+	}
+	if _, err := store.BlobStore.Stat(ctx, limited.ID); err != ErrBlobNotFound {
+		t.Errorf("Content should be deleted after the last download, got %v", err)
+	}
+	if code := ack(limited.ID); code != http.StatusNotFound {
+		t.Errorf("Acknowledging a used-up file: expected 404, got %d", code)
+	}
+
+	// Files without a limit are unaffected
+	for i := 0; i < 3; i++ {
+		if code := ack(unlimited.ID); code != http.StatusOK {
+			t.Fatalf("Unlimited acknowledgement: expected 200, got %d", code)
+		}
+	}
+	if _, ok := store.GetFileMetadata(ctx, unlimited.ID); !ok {
+		t.Error("File without a limit should be kept")
+	}
+
+	// A used-up file that could not be deleted is hidden and then purged
+	if err := store.SaveFile(ctx, models.FileMetadata{ID: "used", Sender: "alice", Recipient: "bob", EncryptedKey: []byte("key"), Timestamp: time.Now(), DownloadsRemaining: 1}, []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.DB.Exec(`UPDATE files SET downloads_remaining = 0 WHERE id = 'used'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.GetFileMetadata(ctx, "used"); ok {
+		t.Error("Used-up file should be hidden")
+	}
+	if n, err := store.PurgeExpiredFiles(ctx, time.Now()); err != nil || n != 1 {
+		t.Errorf("Expected one used-up file purged, got %d (%v)", n, err)
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+	h, store, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	ctx := context.Background()
+
+	oldKeys, _ := crypto.GenerateIdentityKeyPair()
+	exKeys, _ := crypto.GenerateExchangeKeyPair()
+	_ = store.AddUser(ctx, models.User{Username: "alice", IdentityPublicKey: oldKeys.Public, ExchangePublicKey: exKeys.Public[:]})
+	_ = store.AddUser(ctx, models.User{Username: "bob", IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)})
+	aliceToken := openSession(t, h, models.Session{Username: "alice"})
+	bobToken := openSession(t, h, models.Session{Username: "bob"})
+
+	newKeys, _ := crypto.GenerateIdentityKeyPair()
+	newExKeys, _ := crypto.GenerateExchangeKeyPair()
+	rotationAt := func(version int64, signer ed25519.PrivateKey, old ed25519.PublicKey, at time.Time) models.KeyRotation {
+		k := crypto.KeyRotation{
+			Username:             "alice",
+			Version:              version,
+			OldIdentityPublicKey: old,
+			IdentityPublicKey:    newKeys.Public,
+			ExchangePublicKey:    newExKeys.Public[:],
+			RotatedAt:            at,
+		}
+		return models.KeyRotation{
+			Username:             k.Username,
+			Version:              k.Version,
+			OldIdentityPublicKey: k.OldIdentityPublicKey,
+			IdentityPublicKey:    k.IdentityPublicKey,
+			ExchangePublicKey:    k.ExchangePublicKey,
+			RotatedAt:            k.RotatedAt,
+			Signature:            crypto.SignKeyRotation(signer, k),
+		}
+	}
+	rotation := func(version int64, signer ed25519.PrivateKey, old ed25519.PublicKey) models.KeyRotation {
+		return rotationAt(version, signer, old, time.Now())
+	}
+	post := func(token string, rot models.KeyRotation) int {
+		data, _ := json.Marshal(rot)
+		req := httptest.NewRequest("POST", "/users/keys", bytes.NewBuffer(data))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := post(bobToken, rotation(1, oldKeys.Private, oldKeys.Public)); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another user's rotation, got %d", code)
+	}
+	if code := post(aliceToken, rotation(1, newKeys.Private, oldKeys.Public)); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a rotation not signed by the old key, got %d", code)
+	}
+	if code := post(aliceToken, rotation(2, oldKeys.Private, oldKeys.Public)); code != http.StatusConflict {
+		t.Errorf("Expected 409 for a skipped version, got %d", code)
+	}
+	// Peers pick keys by rotation time, so it must be close to the server's
+	for _, at := range []time.Time{time.Now().Add(-time.Hour), time.Now().Add(time.Hour)} {
+		if code := post(aliceToken, rotationAt(1, oldKeys.Private, oldKeys.Public, at)); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for a rotation dated %v, got %d", at, code)
+		}
+	}
+	if code := post(aliceToken, rotation(1, oldKeys.Private, oldKeys.Public)); code != http.StatusOK {
+		t.Fatalf("Expected 200 for a valid rotation, got %d", code)
+	}
+
+	user, _ := store.GetUser(ctx, "alice")
+	if !bytes.Equal(user.IdentityPublicKey, newKeys.Public) || !bytes.Equal(user.ExchangePublicKey, newExKeys.Public[:]) {
+		t.Error("Keys not replaced")
+	}
+	if sessions, _ := store.ListSessions(ctx, "alice"); len(sessions) != 0 {
+		t.Errorf("Sessions opened with the old key should have ended, got %+v", sessions)
+	}
+	if code := post(aliceToken, rotation(2, newKeys.Private, newKeys.Public)); code != http.StatusUnauthorized {
+		t.Errorf("Expected an access token from before the rotation to be refused, got %d", code)
+	}
+
+	// The history is public and verifies
+	req := httptest.NewRequest("GET", "/users/keys?username=alice", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var history []models.KeyRotation
+	if err := json.NewDecoder(w.Body).Decode(&history); err != nil || len(history) != 1 {
+		t.Fatalf("Expected one rotation in history, got %v (%v)", history, err)
+	}
+	if history[0].Version != 1 || !bytes.Equal(history[0].OldIdentityPublicKey, oldKeys.Public) {
+		t.Errorf("Unexpected history entry: %+v", history[0])
+	}
+
+	req = httptest.NewRequest("GET", "/users/keys?username=nobody", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown user, got %d", w.Code)
+	}
+
+	// Replaying the rotation against the new keys is refused
+	aliceToken = openSession(t, h, models.Session{Username: "alice"})
+	if code := post(aliceToken, rotation(1, oldKeys.Private, oldKeys.Public)); code != http.StatusConflict {
+		t.Errorf("Expected 409 for a replayed rotation, got %d", code)
+	}
+	if code := post(aliceToken, rotationAt(2, newKeys.Private, newKeys.Public, time.Now().Add(-time.Minute))); code != http.StatusConflict {
+		t.Errorf("Expected 409 for a rotation dated before the last one, got %d", code)
+	}
+}
+
+func TestKeyLog(t *testing.T) {
+	h, store, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	ctx := context.Background()
+
+	aliceKeys, _ := crypto.GenerateIdentityKeyPair()
+	_ = store.AddUser(ctx, models.User{Username: "alice", IdentityPublicKey: aliceKeys.Public, ExchangePublicKey: make([]byte, 32)})
+	_ = store.AddUser(ctx, models.User{Username: "bob", IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)})
+
+	get := func(path string, v any) int {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+				t.Fatalf("Failed to decode %s: %v", path, err)
+			}
+		}
+		return w.Code
+	}
+	verifyHead := func(head models.SignedTreeHead) {
+		t.Helper()
+		if !crypto.VerifyTreeHead(head.LogPublicKey, crypto.TreeHead{
+			TreeSize:  head.TreeSize,
+			RootHash:  head.RootHash,
+			Timestamp: head.Timestamp,
+		}, head.Signature) {
+			t.Errorf("Tree head signature does not verify")
+		}
+	}
+
+	var head models.SignedTreeHead
+	if code := get("/log/head", &head); code != http.StatusOK || head.TreeSize != 2 {
+		t.Fatalf("Expected a head of size 2, got %d: %+v", code, head)
+	}
+	verifyHead(head)
+
+	// Rotating appends the new keys and moves the user's proof to them
+	newKeys, _ := crypto.GenerateIdentityKeyPair()
+	if err := store.RotateUserKeys(ctx, models.KeyRotation{
+		Username:             "alice",
+		Version:              1,
+		OldIdentityPublicKey: aliceKeys.Public,
+		IdentityPublicKey:    newKeys.Public,
+		ExchangePublicKey:    make([]byte, 32),
+		RotatedAt:            time.Now(),
+		Signature:            []byte("unchecked"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var proof models.KeyLogProof
+	if code := get("/log/proof?username=alice", &proof); code != http.StatusOK {
+		t.Fatalf("Expected 200 for proof, got %d", code)
+	}
+	verifyHead(proof.TreeHead)
+	entry := crypto.KeyLogEntry{
+		Username:          proof.Entry.Username,
+		Version:           proof.Entry.Version,
+		IdentityPublicKey: proof.Entry.IdentityPublicKey,
+		ExchangePublicKey: proof.Entry.ExchangePublicKey,
+	}
+	if proof.Entry.Version != 1 || !bytes.Equal(proof.Entry.IdentityPublicKey, newKeys.Public) || proof.LeafIndex != 2 {
+		t.Errorf("Unexpected entry: %+v at %d", proof.Entry, proof.LeafIndex)
+	}
+	if !crypto.VerifyMerkleInclusion(entry.LeafHash(), proof.LeafIndex, proof.TreeHead.TreeSize, proof.Proof, proof.TreeHead.RootHash) {
+		t.Error("Inclusion proof does not verify")
+	}
+
+	var consistency models.ConsistencyProof
+	if code := get("/log/consistency?first=2&second=3", &consistency); code != http.StatusOK {
+		t.Fatalf("Expected 200 for consistency proof, got %d", code)
+	}
+	if !crypto.VerifyMerkleConsistency(2, 3, head.RootHash, proof.TreeHead.RootHash, consistency.Proof) {
+		t.Error("Consistency proof does not verify")
+	}
+
+	if code := get("/log/consistency?first=2&second=4", &consistency); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a size past the end, got %d", code)
+	}
+	if code := get("/log/proof?username=nobody", &proof); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown user, got %d", code)
+	}
+
+	// The log key survives a restart
+	reopened, err := NewStorage(tmpDir, NewLocalBlobStore(tmpDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.LogKey.Equal(reopened.LogKey) {
+		t.Error("Log key changed after reopening storage")
+	}
+}
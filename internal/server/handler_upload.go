@@ -0,0 +1,274 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultUploadChunkSize is used when a client does not request a chunk size.
+	DefaultUploadChunkSize = 4 * 1024 * 1024
+	minUploadChunkSize     = 64 * 1024
+	maxUploadChunkSize     = 64 * 1024 * 1024
+	// maxUploadChunks bounds the number of chunks in one upload session.
+	maxUploadChunks = 1 << 20
+	// maxUploadRecipients bounds the number of recipients of one upload.
+	maxUploadRecipients = 100
+
+	// DefaultUploadSessionTTL is how long an upload session may sit idle
+	// before it is garbage-collected.
+	DefaultUploadSessionTTL = 24 * time.Hour
+)
+
+// uploadSessionFor looks up the upload session named by the "id" query
+// parameter and checks that it belongs to the authenticated user. Sessions
+// of other users are reported as not found.
+func (h *Handler) uploadSessionFor(w http.ResponseWriter, r *http.Request) (UploadSessionRecord, bool) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, r, "id required", http.StatusBadRequest)
+		return UploadSessionRecord{}, false
+	}
+
+	currentUser, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		writeError(w, r, "authentication required", http.StatusUnauthorized)
+		return UploadSessionRecord{}, false
+	}
+
+	session, ok := h.Storage.GetUploadSession(r.Context(), id)
+	if !ok || session.Sender != currentUser {
+		writeError(w, r, "upload session not found", http.StatusNotFound)
+		return UploadSessionRecord{}, false
+	}
+	return session, true
+}
+
+// uploadSessionResponse converts a session record to its API representation.
+func (h *Handler) uploadSessionResponse(session UploadSessionRecord) models.UploadSession {
+	return models.UploadSession{
+		ID:        session.ID,
+		ChunkSize: session.ChunkSize,
+		Chunks:    session.Chunks,
+		ExpiresAt: session.UpdatedAt.Add(h.UploadSessionTTL),
+	}
+}
+
+// CreateUpload starts a resumable upload session for the authenticated user.
+func (h *Handler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	currentUser, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		writeError(w, r, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("failed to decode upload session request", "error", err)
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	recipients := recipientKeys(req.Metadata, req.Recipients)
+	if len(recipients) > maxUploadRecipients {
+		writeError(w, r, "too many recipients", http.StatusBadRequest)
+		return
+	}
+	seen := make(map[string]bool, len(recipients))
+	for _, rk := range recipients {
+		if rk.Recipient == "" || seen[rk.Recipient] {
+			writeError(w, r, "invalid request", http.StatusBadRequest)
+			return
+		}
+		seen[rk.Recipient] = true
+	}
+	if !senderMatches(w, r, req.Metadata) || !policyValid(w, r, req.Metadata) {
+		return
+	}
+
+	chunkSize := req.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = DefaultUploadChunkSize
+	}
+	chunkSize = min(max(chunkSize, minUploadChunkSize), maxUploadChunkSize)
+
+	id := uuid.New().String()
+	if err := h.Storage.CreateUploadSession(r.Context(), id, currentUser, req.Metadata, req.Recipients, chunkSize); err != nil {
+		slog.Error("failed to create upload session", "sender", currentUser, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("upload session created", "id", id, "sender", currentUser, "recipients", len(recipients))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(models.UploadSession{
+		ID:        id,
+		ChunkSize: chunkSize,
+		Chunks:    []models.UploadChunk{},
+		ExpiresAt: time.Now().Add(h.UploadSessionTTL),
+	})
+}
+
+// GetUpload reports which chunks of an upload session the server already has.
+func (h *Handler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.uploadSessionFor(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.uploadSessionResponse(session))
+}
+
+// UploadChunk stores the raw request body as chunk "index" of an upload
+// session. Every chunk except the last must be exactly the session's chunk
+// size; re-sending a chunk replaces it.
+func (h *Handler) UploadChunk(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.uploadSessionFor(w, r)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.ParseInt(r.URL.Query().Get("index"), 10, 64)
+	if err != nil || index < 0 || index >= maxUploadChunks {
+		writeError(w, r, "invalid chunk index", http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength == 0 || r.ContentLength > session.ChunkSize {
+		writeError(w, r, "invalid chunk size", http.StatusBadRequest)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, session.ChunkSize)
+	n, err := h.Storage.SaveUploadChunk(r.Context(), session.ID, index, body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, "invalid chunk size", http.StatusRequestEntityTooLarge)
+			return
+		}
+		slog.Error("failed to save upload chunk", "id", session.ID, "index", index, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(models.UploadChunk{
+		Index:  index,
+		Offset: index * session.ChunkSize,
+		Size:   n,
+	})
+}
+
+// CompleteUpload assembles the chunks of an upload session into a file for
+// each recipient, all sharing one copy of the content, and returns their
+// metadata. The chunks must form a contiguous run starting at zero in which
+// only the last chunk may be shorter than the chunk size. The optional JSON
+// body carries the sender's manifest signatures. Completing a session again,
+// as a client does after losing the response, returns the same files.
+func (h *Handler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	if h.writeCompletedUpload(w, r) {
+		return
+	}
+	session, ok := h.uploadSessionFor(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.CompleteUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(session.Chunks) == 0 {
+		writeError(w, r, "upload has no chunks", http.StatusConflict)
+		return
+	}
+	for i, c := range session.Chunks {
+		last := i == len(session.Chunks)-1
+		if c.Index != int64(i) || (!last && c.Size != session.ChunkSize) {
+			writeError(w, r, "upload is incomplete", http.StatusConflict)
+			return
+		}
+	}
+
+	// Assign IDs, Timestamp, expiry and download limits. The expiry was
+	// checked when the session was created, so it is only capped here.
+	now := time.Now()
+	expiresAt := h.expiresAt(session.Metadata.ExpiresAt, now)
+	files := make([]models.FileMetadata, 0, len(session.Recipients))
+	for _, rk := range session.Recipients {
+		meta := session.Metadata
+		meta.ID = uuid.New().String()
+		meta.Timestamp = now
+		meta.ExpiresAt = expiresAt
+		meta.DownloadsRemaining = downloadsRemaining(meta)
+		meta.Recipient = rk.Recipient
+		meta.EncryptedKey = rk.EncryptedKey
+		meta.WrappedKey = rk.WrappedKey
+		meta.EncryptedMetadata = rk.EncryptedMetadata
+		meta.Signature = req.Signatures[rk.Recipient]
+		if meta.Signature == nil && len(session.Recipients) == 1 {
+			meta.Signature = req.Signature
+		}
+		meta.SignedAt = req.SignedAt
+		if !signed(w, r, meta) {
+			return
+		}
+		files = append(files, meta)
+	}
+
+	if err := h.Storage.CompleteUpload(r.Context(), session, files); err != nil {
+		// A concurrent request completed the session first
+		if errors.Is(err, ErrUploadCompleted) && h.writeCompletedUpload(w, r) {
+			return
+		}
+		slog.Error("failed to complete upload", "id", session.ID, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, meta := range files {
+		slog.Info("file uploaded", "id", meta.ID, "upload", session.ID, "sender", meta.Sender, "recipient", meta.Recipient)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(files)
+}
+
+// writeCompletedUpload writes the files the current user's upload session
+// was completed with, and reports whether there were any.
+func (h *Handler) writeCompletedUpload(w http.ResponseWriter, r *http.Request) bool {
+	currentUser, _ := r.Context().Value(userContextKey).(string)
+	files, err := h.Storage.CompletedUploadFiles(r.Context(), r.URL.Query().Get("id"), currentUser)
+	if err != nil || len(files) == 0 {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(files)
+	return true
+}
+
+// AbortUpload discards an upload session and its chunks.
+func (h *Handler) AbortUpload(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.uploadSessionFor(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Storage.DeleteUploadSession(r.Context(), session.ID); err != nil {
+		slog.Error("failed to delete upload session", "id", session.ID, "error", err)
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("upload session aborted", "id", session.ID)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,323 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/models"
+)
+
+func uploadRequest(method, target string, body []byte, user string) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	return req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+}
+
+func TestUploadSession(t *testing.T) {
+	h, store, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	ctx := context.Background()
+	for _, name := range []string{"alice", "bob"} {
+		_ = store.AddUser(ctx, models.User{Username: name, IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)})
+	}
+
+	// Create session; the chunk size is clamped to the minimum
+	body, _ := json.Marshal(models.CreateUploadRequest{
+		Metadata:  models.FileMetadata{Sender: "alice", Recipient: "bob", FileName: "big.bin", EncryptedKey: []byte("key")},
+		ChunkSize: 10,
+	})
+	w := httptest.NewRecorder()
+	h.CreateUpload(w, uploadRequest("POST", "/uploads", body, "alice"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var session models.UploadSession
+	_ = json.NewDecoder(w.Body).Decode(&session)
+	if session.ID == "" || session.ChunkSize != minUploadChunkSize {
+		t.Fatalf("Unexpected session: %+v", session)
+	}
+
+	content := bytes.Repeat([]byte("c"), int(2*session.ChunkSize+100))
+	chunk := func(i int) []byte {
+		end := min((i+1)*int(session.ChunkSize), len(content))
+		return content[i*int(session.ChunkSize) : end]
+	}
+	put := func(i int, data []byte, user string) int {
+		w := httptest.NewRecorder()
+		h.UploadChunk(w, uploadRequest("PUT", fmt.Sprintf("/uploads/chunk?id=%s&index=%d", session.ID, i), data, user))
+		return w.Code
+	}
+
+	// Upload chunks 0 and 2, leaving a gap
+	if code := put(0, chunk(0), "alice"); code != http.StatusOK {
+		t.Fatalf("Expected 200 for chunk 0, got %d", code)
+	}
+	if code := put(2, chunk(2), "alice"); code != http.StatusOK {
+		t.Fatalf("Expected 200 for chunk 2, got %d", code)
+	}
+
+	// Status reports received chunks with their offsets
+	w = httptest.NewRecorder()
+	h.GetUpload(w, uploadRequest("GET", "/uploads?id="+session.ID, nil, "alice"))
+	var status models.UploadSession
+	_ = json.NewDecoder(w.Body).Decode(&status)
+	if len(status.Chunks) != 2 || status.Chunks[1].Index != 2 || status.Chunks[1].Offset != 2*session.ChunkSize {
+		t.Errorf("Unexpected status: %+v", status.Chunks)
+	}
+
+	// Completing with a gap fails
+	w = httptest.NewRecorder()
+	h.CompleteUpload(w, uploadRequest("POST", "/uploads/complete?id="+session.ID, nil, "alice"))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for incomplete upload, got %d", w.Code)
+	}
+
+	// Oversized chunks and other users are rejected
+	if code := put(1, append(bytes.Clone(chunk(1)), 'x'), "alice"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for oversized chunk, got %d", code)
+	}
+	if code := put(1, chunk(1), "bob"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user's session, got %d", code)
+	}
+
+	// Fill the gap and complete
+	if code := put(1, chunk(1), "alice"); code != http.StatusOK {
+		t.Fatalf("Expected 200 for chunk 1, got %d", code)
+	}
+	w = httptest.NewRecorder()
+	h.CompleteUpload(w, uploadRequest("POST", "/uploads/complete?id="+session.ID, nil, "alice"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unsigned upload, got %d", w.Code)
+	}
+	signedAt := time.Now().Truncate(time.Second)
+	body, _ = json.Marshal(models.CompleteUploadRequest{Signature: []byte("signature"), SignedAt: signedAt})
+	w = httptest.NewRecorder()
+	h.CompleteUpload(w, uploadRequest("POST", "/uploads/complete?id="+session.ID, body, "alice"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var files []models.FileMetadata
+	_ = json.NewDecoder(w.Body).Decode(&files)
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 file, got %d", len(files))
+	}
+	created := files[0]
+
+	stored, _ := store.GetFileMetadata(ctx, created.ID)
+	if string(stored.Signature) != "signature" || !stored.SignedAt.Equal(signedAt) {
+		t.Errorf("Signature not stored: %q at %v", stored.Signature, stored.SignedAt)
+	}
+
+	got, err := store.GetFileContent(ctx, created.ID)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("Assembled content mismatch (%v)", err)
+	}
+	if _, ok := store.GetUploadSession(ctx, session.ID); ok {
+		t.Error("Upload session should be removed after completion")
+	}
+	if _, err := store.BlobStore.Stat(ctx, uploadChunkBlobID(session.ID, 0)); err != ErrBlobNotFound {
+		t.Errorf("Chunk blobs should be removed, got %v", err)
+	}
+
+	// A retry after a lost response gets the same file rather than a new one
+	w = httptest.NewRecorder()
+	h.CompleteUpload(w, uploadRequest("POST", "/uploads/complete?id="+session.ID, body, "alice"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 for a retried completion, got %d: %s", w.Code, w.Body.String())
+	}
+	var retried []models.FileMetadata
+	_ = json.NewDecoder(w.Body).Decode(&retried)
+	if len(retried) != 1 || retried[0].ID != created.ID {
+		t.Errorf("Retry returned %+v, want file %s", retried, created.ID)
+	}
+	if listed, _ := store.ListFiles(ctx, "bob"); len(listed) != 1 {
+		t.Errorf("Expected 1 file for bob after retry, got %d", len(listed))
+	}
+	w = httptest.NewRecorder()
+	h.CompleteUpload(w, uploadRequest("POST", "/uploads/complete?id="+session.ID, body, "bob"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user's completed upload, got %d", w.Code)
+	}
+}
+
+func TestCompleteUploadOnce(t *testing.T) {
+	_, store, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	ctx := context.Background()
+	_ = store.AddUser(ctx, models.User{Username: "alice", IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)})
+	_ = store.CreateUploadSession(ctx, "upload", "alice", models.FileMetadata{Recipient: "alice"}, nil, minUploadChunkSize)
+	_, _ = store.SaveUploadChunk(ctx, "upload", 0, bytes.NewReader([]byte("data")))
+	session, _ := store.GetUploadSession(ctx, "upload")
+
+	file := func(id string) []models.FileMetadata {
+		return []models.FileMetadata{{ID: id, Sender: "alice", Recipient: "alice", EncryptedKey: []byte("key")}}
+	}
+	if err := store.CompleteUpload(ctx, session, file("first")); err != nil {
+		t.Fatalf("CompleteUpload failed: %v", err)
+	}
+
+	// A second completion of the same session, such as a concurrent request
+	// that loaded it first, saves nothing
+	if err := store.CompleteUpload(ctx, session, file("second")); err != ErrUploadCompleted {
+		t.Fatalf("Expected ErrUploadCompleted, got %v", err)
+	}
+	if _, ok := store.GetFileMetadata(ctx, "second"); ok {
+		t.Error("Second completion saved a file")
+	}
+	if _, err := store.BlobStore.Stat(ctx, "second"); err != ErrBlobNotFound {
+		t.Errorf("Second completion left its content behind, got %v", err)
+	}
+	files, err := store.CompletedUploadFiles(ctx, "upload", "alice")
+	if err != nil || len(files) != 1 || files[0].ID != "first" {
+		t.Errorf("Expected the first completion's file, got %+v (%v)", files, err)
+	}
+
+	// The record of the completion is purged with stale sessions
+	if _, err := store.PurgeStaleUploads(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := store.CompletedUploadFiles(ctx, "upload", "alice"); len(files) != 0 {
+		t.Errorf("Completed upload record should be purged, got %+v", files)
+	}
+}
+
+func TestMultiRecipientUpload(t *testing.T) {
+	h, store, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	ctx := context.Background()
+	recipient := func(name string) models.RecipientKey {
+		return models.RecipientKey{Recipient: name, EncryptedKey: []byte(name + "-ephemeral"), WrappedKey: []byte(name + "-wrapped"), EncryptedMetadata: []byte(name + "-envelope")}
+	}
+	create := func(recipients ...models.RecipientKey) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.CreateUploadRequest{Metadata: models.FileMetadata{Sender: "alice"}, Recipients: recipients})
+		w := httptest.NewRecorder()
+		h.CreateUpload(w, uploadRequest("POST", "/uploads", body, "alice"))
+		return w
+	}
+
+	if w := create(recipient("bob"), recipient("bob")); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for duplicate recipients, got %d", w.Code)
+	}
+	if w := create(recipient("bob"), models.RecipientKey{}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for empty recipient, got %d", w.Code)
+	}
+
+	w := create(recipient("bob"), recipient("carol"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var session models.UploadSession
+	_ = json.NewDecoder(w.Body).Decode(&session)
+
+	content := []byte("shared ciphertext")
+	w = httptest.NewRecorder()
+	h.UploadChunk(w, uploadRequest("PUT", "/uploads/chunk?id="+session.ID+"&index=0", content, "alice"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for chunk, got %d", w.Code)
+	}
+
+	body, _ := json.Marshal(models.CompleteUploadRequest{
+		Signatures: map[string][]byte{"bob": []byte("bob-sig"), "carol": []byte("carol-sig")},
+		SignedAt:   time.Now(),
+	})
+	w = httptest.NewRecorder()
+	h.CompleteUpload(w, uploadRequest("POST", "/uploads/complete?id="+session.ID, body, "alice"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var files []models.FileMetadata
+	_ = json.NewDecoder(w.Body).Decode(&files)
+	if len(files) != 2 || files[0].ID == files[1].ID {
+		t.Fatalf("Expected 2 distinct files, got %+v", files)
+	}
+
+	// Each recipient sees their own entry with their own keys
+	for _, f := range files {
+		listed, _ := store.ListFiles(ctx, f.Recipient)
+		if len(listed) != 1 || listed[0].ID != f.ID {
+			t.Fatalf("Expected %s to see file %s, got %+v", f.Recipient, f.ID, listed)
+		}
+		got := listed[0]
+		if string(got.WrappedKey) != f.Recipient+"-wrapped" || string(got.EncryptedMetadata) != f.Recipient+"-envelope" || string(got.Signature) != f.Recipient+"-sig" {
+			t.Errorf("Wrong keys for %s: %+v", f.Recipient, got)
+		}
+		if data, err := store.GetFileContent(ctx, f.ID); err != nil || !bytes.Equal(data, content) {
+			t.Errorf("Content mismatch for %s (%v)", f.Recipient, err)
+		}
+	}
+
+	// The content is stored once and outlives all but the last reference
+	if _, err := store.BlobStore.Stat(ctx, files[1].ID); err != ErrBlobNotFound {
+		t.Errorf("Expected a single shared blob, got %v", err)
+	}
+	if err := store.DeleteFile(ctx, files[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := store.GetFileContent(ctx, files[1].ID); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("Content should survive while referenced (%v)", err)
+	}
+	if err := store.DeleteFile(ctx, files[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.BlobStore.Stat(ctx, files[0].ID); err != ErrBlobNotFound {
+		t.Errorf("Content should be deleted with the last reference, got %v", err)
+	}
+}
+
+func TestAbortUpload(t *testing.T) {
+	h, store, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	ctx := context.Background()
+	_ = store.AddUser(ctx, models.User{Username: "alice", IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)})
+	_ = store.CreateUploadSession(ctx, "upload1", "alice", models.FileMetadata{Recipient: "alice"}, nil, minUploadChunkSize)
+	_, _ = store.SaveUploadChunk(ctx, "upload1", 0, bytes.NewReader([]byte("data")))
+
+	w := httptest.NewRecorder()
+	h.AbortUpload(w, uploadRequest("DELETE", "/uploads?id=upload1", nil, "alice"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if _, ok := store.GetUploadSession(ctx, "upload1"); ok {
+		t.Error("Upload session should be removed")
+	}
+	if _, err := store.BlobStore.Stat(ctx, uploadChunkBlobID("upload1", 0)); err != ErrBlobNotFound {
+		t.Errorf("Chunk blob should be removed, got %v", err)
+	}
+}
+
+func TestJanitorPurgesStaleUploads(t *testing.T) {
+	_, store, tmpDir := setupTestServer(t)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	ctx := context.Background()
+	_ = store.AddUser(ctx, models.User{Username: "alice", IdentityPublicKey: make([]byte, 32), ExchangePublicKey: make([]byte, 32)})
+	_ = store.CreateUploadSession(ctx, "stale", "alice", models.FileMetadata{Recipient: "alice"}, nil, minUploadChunkSize)
+	_, _ = store.SaveUploadChunk(ctx, "stale", 0, bytes.NewReader([]byte("data")))
+
+	// A fresh session survives the sweep
+	janitor := NewJanitor(store, time.Hour)
+	janitor.Sweep(ctx)
+	if _, ok := store.GetUploadSession(ctx, "stale"); !ok {
+		t.Fatal("Fresh session should not be purged")
+	}
+
+	// Once idle past the TTL it is removed along with its chunks
+	janitor.UploadTTL = -time.Minute
+	janitor.Sweep(ctx)
+	if _, ok := store.GetUploadSession(ctx, "stale"); ok {
+		t.Error("Stale session should be purged")
+	}
+	if _, err := store.BlobStore.Stat(ctx, uploadChunkBlobID("stale", 0)); err != ErrBlobNotFound {
+		t.Errorf("Chunk blob should be removed, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// DefaultJanitorInterval is how often the janitor sweeps by default.
+const DefaultJanitorInterval = 10 * time.Minute
+
+// Janitor periodically removes server state that is no longer needed, such
+// as abandoned upload sessions, expired files, and expired login sessions
+// and challenges.
+type Janitor struct {
+	Storage   *Storage
+	Interval  time.Duration
+	UploadTTL time.Duration
+}
+
+func NewJanitor(storage *Storage, uploadTTL time.Duration) *Janitor {
+	return &Janitor{
+		Storage:   storage,
+		Interval:  DefaultJanitorInterval,
+		UploadTTL: uploadTTL,
+	}
+}
+
+// Run sweeps immediately and then every Interval until ctx is done.
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	for {
+		j.Sweep(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep performs a single cleanup pass.
+func (j *Janitor) Sweep(ctx context.Context) {
+	n, err := j.Storage.PurgeStaleUploads(ctx, time.Now().Add(-j.UploadTTL))
+	if err != nil {
+		slog.Error("failed to purge stale uploads", "error", err)
+	}
+	if n > 0 {
+		slog.Info("purged stale uploads", "count", n)
+	}
+
+	n, err = j.Storage.PurgeExpiredFiles(ctx, time.Now())
+	if err != nil {
+		slog.Error("failed to purge expired files", "error", err)
+	}
+	if n > 0 {
+		slog.Info("purged expired files", "count", n)
+	}
+
+	n, err = j.Storage.PurgeExpiredSessions(ctx, time.Now())
+	if err != nil {
+		slog.Error("failed to purge expired sessions", "error", err)
+	}
+	if n > 0 {
+		slog.Info("purged expired sessions", "count", n)
+	}
+
+	n, err = j.Storage.PurgeExpiredChallenges(ctx, time.Now())
+	if err != nil {
+		slog.Error("failed to purge expired login challenges", "error", err)
+	}
+	if n > 0 {
+		slog.Info("purged expired login challenges", "count", n)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxLoginFailures is how many failed logins a client address
+	// may make within DefaultLoginFailureWindow.
+	DefaultMaxLoginFailures = 5
+	// DefaultMaxUserLoginFailures is how many failed logins to one account,
+	// from any address, are allowed within DefaultLoginFailureWindow. It is
+	// higher than the limit for an address, so that an attacker behind one
+	// address cannot lock a user out, while guesses spread over many
+	// addresses are still slowed down.
+	DefaultMaxUserLoginFailures = 20
+	// DefaultLoginFailureWindow is the period over which failed logins are
+	// counted.
+	DefaultLoginFailureWindow = 15 * time.Minute
+)
+
+// loginThrottle counts failed logins by key, such as a client address or a
+// username, and
+// refuses further attempts for keys that fail too often.
+// Counts are kept in memory and start over when a window ends.
+type loginThrottle struct {
+	mu       sync.Mutex
+	limit    int
+	window   time.Duration
+	failures map[string]loginFailures
+}
+
+type loginFailures struct {
+	count int
+	start time.Time
+}
+
+func newLoginThrottle(limit int, window time.Duration) *loginThrottle {
+	return &loginThrottle{limit: limit, window: window, failures: make(map[string]loginFailures)}
+}
+
+// wait returns how long the caller must wait before another attempt for any
+// of keys is allowed, or zero if one is allowed now.
+func (t *loginThrottle) wait(now time.Time, keys ...string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var longest time.Duration
+	for _, key := range keys {
+		f, ok := t.failures[key]
+		if !ok || f.count < t.limit {
+			continue
+		}
+		if d := f.start.Add(t.window).Sub(now); d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// fail records a failed attempt for each of keys.
+func (t *loginThrottle) fail(now time.Time, keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(now)
+	for _, key := range keys {
+		f, ok := t.failures[key]
+		if !ok {
+			f.start = now
+		}
+		f.count++
+		t.failures[key] = f
+	}
+}
+
+// prune drops counts whose window has ended. The caller must hold t.mu.
+func (t *loginThrottle) prune(now time.Time) {
+	for key, f := range t.failures {
+		if !now.Before(f.start.Add(t.window)) {
+			delete(t.failures, key)
+		}
+	}
+}
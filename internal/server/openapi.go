@@ -0,0 +1,17 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPI describes the versioned API.
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// OpenAPI serves the OpenAPI document describing the API.
+func (h *Handler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPI)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+)
+
+// revocations remembers sessions ended on this server whose access tokens
+// may not have expired yet. Access tokens are checked without looking their
+// session up, so without it a logout or revocation would only take effect
+// once the session's last access token ran out.
+type revocations struct {
+	mu       sync.Mutex
+	sessions map[string]time.Time // Session ID to when its tokens have expired
+	users    map[string]userRevocation
+}
+
+// userRevocation ends every session a user had at a point in time.
+type userRevocation struct {
+	at    time.Time // Tokens issued up to now are revoked
+	until time.Time // When those tokens have expired
+}
+
+func newRevocations() *revocations {
+	return &revocations{sessions: make(map[string]time.Time), users: make(map[string]userRevocation)}
+}
+
+// revokeSession revokes the access tokens of a session, which all expire by
+// until.
+func (r *revocations) revokeSession(id string, until time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(time.Now())
+	r.sessions[id] = until
+}
+
+// revokeUser revokes the access tokens a user was issued up to at, which
+// all expire by until.
+func (r *revocations) revokeUser(username string, at, until time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(time.Now())
+	r.users[username] = userRevocation{at: at, until: until}
+}
+
+// revoked reports whether an access token has been revoked.
+func (r *revocations) revoked(t crypto.AccessToken) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.sessions[t.SessionID]; ok {
+		return true
+	}
+	u, ok := r.users[t.Username]
+	return ok && !t.IssuedAt.After(u.at)
+}
+
+// prune drops revocations whose tokens have all expired. The caller must
+// hold r.mu.
+func (r *revocations) prune(now time.Time) {
+	for id, until := range r.sessions {
+		if now.After(until) {
+			delete(r.sessions, id)
+		}
+	}
+	for username, u := range r.users {
+		if now.After(u.until) {
+			delete(r.users, username)
+		}
+	}
+}
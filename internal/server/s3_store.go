@@ -3,18 +3,30 @@ package server
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
+// s3PartSize is the size of each multipart upload part. Blobs smaller than
+// one part are uploaded with a single PutObject.
+const s3PartSize = 8 * 1024 * 1024
+
 // S3ClientAPI defines the interface for S3 operations we use.
 type S3ClientAPI interface {
 	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
 	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
 	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
 }
 
 // S3BlobStore implements BlobStore using AWS S3.
@@ -40,32 +52,142 @@ func NewS3BlobStore(ctx context.Context, bucket string, region string) (*S3BlobS
 	}, nil
 }
 
-func (s *S3BlobStore) Save(id string, content []byte) error {
-	_, err := s.Client.PutObject(context.TODO(), &s3.PutObjectInput{
+// Put uploads r with a single PutObject if it fits in one part, and as a
+// multipart upload otherwise. Only one part is held in memory at a time.
+func (s *S3BlobStore) Put(ctx context.Context, id string, r io.Reader) (int64, error) {
+	buf := make([]byte, s3PartSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		_, err = s.Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:        aws.String(s.Bucket),
+			Key:           aws.String(id),
+			Body:          bytes.NewReader(buf[:n]),
+			ContentLength: aws.Int64(int64(n)),
+		})
+		return int64(n), err
+	}
+	if err != nil {
+		return 0, err
+	}
+	return s.putMultipart(ctx, id, r, buf)
+}
+
+func (s *S3BlobStore) putMultipart(ctx context.Context, id string, r io.Reader, first []byte) (int64, error) {
+	created, err := s.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(id),
-		Body:   bytes.NewReader(content),
 	})
-	return err
+	if err != nil {
+		return 0, err
+	}
+
+	abort := func(err error) (int64, error) {
+		// Abort even if ctx was cancelled, otherwise the parts linger in the bucket.
+		_, _ = s.Client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.Bucket),
+			Key:      aws.String(id),
+			UploadId: created.UploadId,
+		})
+		return 0, err
+	}
+
+	var (
+		parts []types.CompletedPart
+		total int64
+		part  = first
+	)
+	for number := int32(1); len(part) > 0; number++ {
+		out, err := s.Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(s.Bucket),
+			Key:           aws.String(id),
+			UploadId:      created.UploadId,
+			PartNumber:    aws.Int32(number),
+			Body:          bytes.NewReader(part),
+			ContentLength: aws.Int64(int64(len(part))),
+		})
+		if err != nil {
+			return abort(err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(number)})
+		total += int64(len(part))
+
+		n, err := io.ReadFull(r, first)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return abort(err)
+		}
+		part = first[:n]
+	}
+
+	_, err = s.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.Bucket),
+		Key:             aws.String(id),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return abort(err)
+	}
+	return total, nil
 }
 
-func (s *S3BlobStore) Get(id string) ([]byte, error) {
-	resp, err := s.Client.GetObject(context.TODO(), &s3.GetObjectInput{
+func (s *S3BlobStore) Get(ctx context.Context, id string) (io.ReadCloser, error) {
+	resp, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(id),
 	})
 	if err != nil {
-		return nil, err
+		return nil, s3Error(err)
 	}
-	defer func() { _ = resp.Body.Close() }()
+	return resp.Body, nil
+}
 
-	return io.ReadAll(resp.Body)
+func (s *S3BlobStore) GetRange(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error) {
+	rng := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		if length == 0 {
+			return io.NopCloser(bytes.NewReader(nil)), nil
+		}
+		rng = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+	resp, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(id),
+		Range:  aws.String(rng),
+	})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return resp.Body, nil
 }
 
-func (s *S3BlobStore) Delete(id string) error {
-	_, err := s.Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+func (s *S3BlobStore) Stat(ctx context.Context, id string) (BlobInfo, error) {
+	resp, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(id),
 	})
+	if err != nil {
+		return BlobInfo{}, s3Error(err)
+	}
+	return BlobInfo{
+		Size:    aws.ToInt64(resp.ContentLength),
+		ModTime: aws.ToTime(resp.LastModified),
+	}, nil
+}
+
+func (s *S3BlobStore) Delete(ctx context.Context, id string) error {
+	_, err := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(id),
+	})
+	return err
+}
+
+// s3Error maps S3 "not found" errors to ErrBlobNotFound.
+func s3Error(err error) error {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return ErrBlobNotFound
+	}
 	return err
 }
@@ -6,6 +6,9 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -16,6 +19,7 @@ type Server struct {
 	Storage           *Storage
 	Handler           *Handler
 	Server            *http.Server
+	Janitor           *Janitor
 	RegistrationToken string
 }
 
@@ -62,63 +66,39 @@ func NewServer(port string, storageDir string) (*Server, error) {
 		h.SetRegistrationToken(token)
 		slog.Info("Registration token enabled")
 	}
-
-	mux := http.NewServeMux()
-	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			h.Ping(w, r)
-		} else {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			h.RegisterUser(w, r)
-		case http.MethodGet:
-			h.GetUser(w, r)
-		default:
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	mux.HandleFunc("/auth/challenge", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			h.HandleGetChallenge(w, r)
-		} else {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	uploadTTL, err := durationEnv("UPLOAD_SESSION_TTL", DefaultUploadSessionTTL)
+	if err != nil {
+		return nil, err
+	}
+	h.UploadSessionTTL = uploadTTL
+	if v := os.Getenv("ALLOW_SENDER_DOWNLOAD"); v != "" {
+		if h.SenderCanDownload, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid ALLOW_SENDER_DOWNLOAD: %q", v)
 		}
-	})
+	}
 
-	mux.HandleFunc("/auth/login", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
-			h.HandleLogin(w, r)
-		} else {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	if h.MaxFileTTL, err = durationEnv("MAX_FILE_TTL", DefaultMaxFileTTL); err != nil {
+		return nil, err
+	}
 
-	mux.HandleFunc("/files", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			h.AuthMiddleware(h.UploadFile)(w, r)
-		case http.MethodGet:
-			h.AuthMiddleware(h.ListFiles)(w, r)
-		case http.MethodDelete:
-			h.AuthMiddleware(h.DeleteFile)(w, r)
-		default:
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	if h.ChallengeTTL, err = durationEnv("CHALLENGE_TTL", DefaultChallengeTTL); err != nil {
+		return nil, err
+	}
+	if h.AccessTokenTTL, err = durationEnv("ACCESS_TOKEN_TTL", DefaultAccessTokenTTL); err != nil {
+		return nil, err
+	}
+	if h.SessionTTL, err = durationEnv("SESSION_TTL", DefaultSessionTTL); err != nil {
+		return nil, err
+	}
+	h.Origin = strings.TrimSuffix(os.Getenv("SERVER_ORIGIN"), "/")
+	if h.Origin == "" {
+		slog.Warn("SERVER_ORIGIN not set; login challenges are bound to the Host header of each request")
+	}
 
-	mux.HandleFunc("/files/download", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			h.AuthMiddleware(h.DownloadFile)(w, r)
-		} else {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	janitor := NewJanitor(store, uploadTTL)
+	if janitor.Interval, err = durationEnv("JANITOR_INTERVAL", DefaultJanitorInterval); err != nil {
+		return nil, err
+	}
 
 	// Ensure port has colon
 	if port == "" {
@@ -128,17 +108,37 @@ func NewServer(port string, storageDir string) (*Server, error) {
 		port = ":" + port
 	}
 
+	// Handler.ServeHTTP routes all requests.
 	return &Server{
 		Port:              port,
 		Storage:           store,
 		Handler:           h,
-		Server:            &http.Server{Addr: port, Handler: mux},
+		Server:            &http.Server{Addr: port, Handler: h},
+		Janitor:           janitor,
 		RegistrationToken: os.Getenv("REGISTRATION_TOKEN"),
 	}, nil
 }
 
-// Start starts the server.
+// Start starts the janitor and the server.
 func (s *Server) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Janitor.Run(ctx)
+
 	slog.Info("Server starting", "addr", s.Server.Addr)
 	return s.Server.ListenAndServe()
 }
+
+// durationEnv parses the environment variable name as a time.Duration,
+// returning def if it is unset.
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, v)
+	}
+	return d, nil
+}
@@ -1,12 +1,24 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/VinMeld/go-send/internal/crypto"
 	"github.com/VinMeld/go-send/internal/db"
 	"github.com/VinMeld/go-send/internal/models"
 	_ "github.com/mattn/go-sqlite3"
@@ -17,8 +29,32 @@ type Storage struct {
 	DB        *sql.DB
 	Queries   *db.Queries
 	BlobStore BlobStore
+	// LogKey signs the tree heads of the key transparency log.
+	LogKey ed25519.PrivateKey
+	// TokenKey signs session access tokens.
+	TokenKey ed25519.PrivateKey
+
+	// keyLogMu is held by transactions that append to the key log, so
+	// that each entry is given the next index
+	keyLogMu sync.Mutex
 }
 
+var (
+	// ErrUserExists is returned when registering a username that is taken.
+	ErrUserExists = errors.New("user already exists")
+	// ErrUserNotFound is returned for operations on an unknown user.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrKeyRotationConflict is returned when a key rotation does not follow
+	// on from the user's current keys.
+	ErrKeyRotationConflict = errors.New("key rotation does not match the current keys")
+	// ErrSessionNotFound is returned when revoking a session that does not
+	// exist, or refreshing one with an unknown or expired refresh token.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrUploadCompleted is returned when completing an upload session that
+	// another request has already completed.
+	ErrUploadCompleted = errors.New("upload already completed")
+)
+
 // NewStorage creates a new Storage instance.
 func NewStorage(baseDir string, blobStore BlobStore) (*Storage, error) {
 	if err := os.MkdirAll(baseDir, 0755); err != nil {
@@ -56,15 +92,25 @@ func NewStorage(baseDir string, blobStore BlobStore) (*Storage, error) {
 		encrypted_key BLOB NOT NULL,
 		auto_delete BOOLEAN NOT NULL DEFAULT 0,
 		timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		signature BLOB,
+		signed_at DATETIME,
+		encrypted_metadata BLOB,
+		blob_id TEXT NOT NULL,
+		wrapped_key BLOB,
+		expires_at DATETIME,
+		downloads_remaining INTEGER,
 		FOREIGN KEY(sender) REFERENCES users(username),
 		FOREIGN KEY(recipient) REFERENCES users(username)
 	);
 
 	CREATE TABLE IF NOT EXISTS sessions (
-		token TEXT PRIMARY KEY,
+		refresh_hash TEXT PRIMARY KEY,
 		username TEXT NOT NULL,
 		expires_at DATETIME NOT NULL,
 		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		id TEXT NOT NULL DEFAULT '',
+		device TEXT NOT NULL DEFAULT '',
+		last_used_at DATETIME,
 		FOREIGN KEY(username) REFERENCES users(username)
 	);
 
@@ -72,20 +118,165 @@ func NewStorage(baseDir string, blobStore BlobStore) (*Storage, error) {
 		username TEXT PRIMARY KEY,
 		nonce TEXT NOT NULL,
 		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME,
 		FOREIGN KEY(username) REFERENCES users(username)
 	);
+
+	CREATE TABLE IF NOT EXISTS upload_sessions (
+		id TEXT PRIMARY KEY,
+		sender TEXT NOT NULL,
+		metadata TEXT NOT NULL,
+		chunk_size INTEGER NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(sender) REFERENCES users(username)
+	);
+
+	CREATE TABLE IF NOT EXISTS upload_chunks (
+		session_id TEXT NOT NULL,
+		idx INTEGER NOT NULL,
+		size INTEGER NOT NULL,
+		PRIMARY KEY(session_id, idx),
+		FOREIGN KEY(session_id) REFERENCES upload_sessions(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS completed_uploads (
+		upload_id TEXT NOT NULL,
+		file_id TEXT NOT NULL,
+		sender TEXT NOT NULL,
+		completed_at DATETIME NOT NULL,
+		PRIMARY KEY(upload_id, file_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS key_rotations (
+		username TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		old_identity_public_key BLOB NOT NULL,
+		identity_public_key BLOB NOT NULL,
+		exchange_public_key BLOB NOT NULL,
+		rotated_at DATETIME NOT NULL,
+		signature BLOB NOT NULL,
+		PRIMARY KEY(username, version),
+		FOREIGN KEY(username) REFERENCES users(username)
+	);
+
+	CREATE TABLE IF NOT EXISTS key_log (
+		idx INTEGER PRIMARY KEY,
+		username TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		identity_public_key BLOB NOT NULL,
+		exchange_public_key BLOB NOT NULL,
+		leaf_hash BLOB NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS key_log_username ON key_log(username, idx);
 	`
 
 	if _, err := sqliteDB.Exec(schema); err != nil {
 		sqliteDB.Close()
 		return nil, fmt.Errorf("failed to apply schema: %w", err)
 	}
+	if err := migrate(sqliteDB); err != nil {
+		sqliteDB.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
 
-	return &Storage{
+	logKey, err := loadSigningKey(filepath.Join(baseDir, "log_key"))
+	if err != nil {
+		sqliteDB.Close()
+		return nil, fmt.Errorf("failed to load log key: %w", err)
+	}
+	tokenKey, err := loadSigningKey(filepath.Join(baseDir, "token_key"))
+	if err != nil {
+		sqliteDB.Close()
+		return nil, fmt.Errorf("failed to load token key: %w", err)
+	}
+
+	s := &Storage{
 		DB:        sqliteDB,
 		Queries:   db.New(sqliteDB),
 		BlobStore: blobStore,
-	}, nil
+		LogKey:    logKey,
+		TokenKey:  tokenKey,
+	}
+	if err := s.backfillKeyLog(context.Background()); err != nil {
+		sqliteDB.Close()
+		return nil, fmt.Errorf("failed to backfill key log: %w", err)
+	}
+	return s, nil
+}
+
+// loadSigningKey reads an Ed25519 key from path, creating it on first use.
+// The log key is pinned by clients and the token key signs access tokens
+// that outlive a restart, so both must be kept.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	seed, err := os.ReadFile(path)
+	if err == nil {
+		if len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("signing key %s: wrong size", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, key.Seed(), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// migrations bring databases created by older versions of the schema up to
+// date. Each one must be safe to run again; "duplicate column" and "no such
+// column" errors from columns new databases already have, or have already
+// renamed, are ignored.
+var migrations = []string{
+	`ALTER TABLE files ADD COLUMN signature BLOB`,
+	`ALTER TABLE files ADD COLUMN signed_at DATETIME`,
+	`ALTER TABLE files ADD COLUMN encrypted_metadata BLOB`,
+	`ALTER TABLE files ADD COLUMN blob_id TEXT NOT NULL DEFAULT ''`,
+	`UPDATE files SET blob_id = id WHERE blob_id = ''`,
+	`ALTER TABLE files ADD COLUMN wrapped_key BLOB`,
+	`CREATE INDEX IF NOT EXISTS files_blob_id ON files(blob_id)`,
+	// Files stored before expiry was introduced never expire
+	`ALTER TABLE files ADD COLUMN expires_at DATETIME`,
+	`CREATE INDEX IF NOT EXISTS files_expires_at ON files(expires_at)`,
+	`ALTER TABLE files ADD COLUMN downloads_remaining INTEGER`,
+	`UPDATE files SET downloads_remaining = 1 WHERE auto_delete = 1 AND downloads_remaining IS NULL`,
+	// Sessions opened before they could be listed get an ID to revoke them by
+	`ALTER TABLE sessions ADD COLUMN id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE sessions ADD COLUMN device TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE sessions ADD COLUMN last_used_at DATETIME`,
+	`UPDATE sessions SET id = lower(hex(randomblob(8))) WHERE id = ''`,
+	`UPDATE sessions SET last_used_at = created_at WHERE last_used_at IS NULL`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS sessions_id ON sessions(id)`,
+	// Challenges issued before they expired are treated as expired
+	`ALTER TABLE challenges ADD COLUMN expires_at DATETIME`,
+	// Sessions from before refresh tokens hold a bearer token rather than a
+	// hash, and can no longer be used
+	`ALTER TABLE sessions RENAME COLUMN token TO refresh_hash`,
+	`DELETE FROM sessions WHERE length(refresh_hash) != 64`,
+}
+
+func migrate(sqliteDB *sql.DB) error {
+	for _, m := range migrations {
+		if _, err := sqliteDB.Exec(m); err != nil && !isAppliedMigration(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// isAppliedMigration reports whether err comes from a migration whose
+// change the database already has.
+func isAppliedMigration(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "duplicate column name") || strings.Contains(msg, "no such column")
 }
 
 // Close closes the database connection.
@@ -93,13 +284,38 @@ func (s *Storage) Close() error {
 	return s.DB.Close()
 }
 
-// AddUser adds or updates a user.
+// AddUser adds a new user and appends their keys to the key log. It returns
+// ErrUserExists if the username is taken; keys of existing users are
+// replaced with RotateUserKeys.
 func (s *Storage) AddUser(ctx context.Context, user models.User) error {
-	return s.Queries.CreateUser(ctx, db.CreateUserParams{
+	s.keyLogMu.Lock()
+	defer s.keyLogMu.Unlock()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	q := s.Queries.WithTx(tx)
+	err = q.CreateUser(ctx, db.CreateUserParams{
 		Username:          user.Username,
 		IdentityPublicKey: user.IdentityPublicKey,
 		ExchangePublicKey: user.ExchangePublicKey,
 	})
+	if err != nil {
+		_ = tx.Rollback()
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return ErrUserExists
+		}
+		return err
+	}
+	if err := appendKeyLogEntry(ctx, q, crypto.KeyLogEntry{
+		Username:          user.Username,
+		IdentityPublicKey: user.IdentityPublicKey,
+		ExchangePublicKey: user.ExchangePublicKey,
+	}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 // GetUser retrieves a user by username.
@@ -136,50 +352,262 @@ func (s *Storage) ListAllUsers(ctx context.Context) ([]models.User, error) {
 	return result, nil
 }
 
-// DeleteUser deletes a user from the database.
+// DeleteUser deletes a user, their key history and their sessions from the
+// database.
 func (s *Storage) DeleteUser(ctx context.Context, username string) error {
-	return s.Queries.DeleteUser(ctx, username)
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	q := s.Queries.WithTx(tx)
+	if err := q.DeleteUserSessions(ctx, username); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := q.DeleteKeyRotations(ctx, username); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := q.DeleteUser(ctx, username); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// RotateUserKeys replaces a user's keys and records the rotation in their
+// key history and the key log. The rotation must name the user's current identity key and
+// be the next version in their history, no earlier than the last rotation,
+// or ErrKeyRotationConflict is returned. The user's sessions, which were opened with the old key, are
+// ended. The signature is not checked here.
+func (s *Storage) RotateUserKeys(ctx context.Context, rotation models.KeyRotation) error {
+	s.keyLogMu.Lock()
+	defer s.keyLogMu.Unlock()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	q := s.Queries.WithTx(tx)
+	if err := rotateUserKeys(ctx, q, rotation); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func rotateUserKeys(ctx context.Context, q *db.Queries, rotation models.KeyRotation) error {
+	user, err := q.GetUser(ctx, rotation.Username)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(user.IdentityPublicKey, rotation.OldIdentityPublicKey) {
+		return ErrKeyRotationConflict
+	}
+	history, err := q.ListKeyRotations(ctx, rotation.Username)
+	if err != nil {
+		return err
+	}
+	if rotation.Version != int64(len(history))+1 {
+		return ErrKeyRotationConflict
+	}
+	if len(history) > 0 && rotation.RotatedAt.Before(history[len(history)-1].RotatedAt) {
+		return ErrKeyRotationConflict
+	}
+
+	if err := q.CreateKeyRotation(ctx, db.CreateKeyRotationParams{
+		Username:             rotation.Username,
+		Version:              rotation.Version,
+		OldIdentityPublicKey: rotation.OldIdentityPublicKey,
+		IdentityPublicKey:    rotation.IdentityPublicKey,
+		ExchangePublicKey:    rotation.ExchangePublicKey,
+		RotatedAt:            rotation.RotatedAt,
+		Signature:            rotation.Signature,
+	}); err != nil {
+		return err
+	}
+	if err := q.UpdateUserKeys(ctx, db.UpdateUserKeysParams{
+		IdentityPublicKey: rotation.IdentityPublicKey,
+		ExchangePublicKey: rotation.ExchangePublicKey,
+		Username:          rotation.Username,
+	}); err != nil {
+		return err
+	}
+	if err := appendKeyLogEntry(ctx, q, crypto.KeyLogEntry{
+		Username:          rotation.Username,
+		Version:           rotation.Version,
+		IdentityPublicKey: rotation.IdentityPublicKey,
+		ExchangePublicKey: rotation.ExchangePublicKey,
+	}); err != nil {
+		return err
+	}
+	return q.DeleteUserSessions(ctx, rotation.Username)
+}
+
+// ListKeyRotations returns a user's key history, oldest first.
+func (s *Storage) ListKeyRotations(ctx context.Context, username string) ([]models.KeyRotation, error) {
+	rows, err := s.Queries.ListKeyRotations(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	result := []models.KeyRotation{}
+	for _, r := range rows {
+		result = append(result, models.KeyRotation{
+			Username:             r.Username,
+			Version:              r.Version,
+			OldIdentityPublicKey: r.OldIdentityPublicKey,
+			IdentityPublicKey:    r.IdentityPublicKey,
+			ExchangePublicKey:    r.ExchangePublicKey,
+			RotatedAt:            r.RotatedAt,
+			Signature:            r.Signature,
+		})
+	}
+	return result, nil
 }
 
 // SaveFile saves a file and its metadata.
 func (s *Storage) SaveFile(ctx context.Context, metadata models.FileMetadata, content []byte) error {
+	return s.SaveFileStream(ctx, metadata, bytes.NewReader(content))
+}
+
+// SaveFileStream saves a file read from r and its metadata.
+func (s *Storage) SaveFileStream(ctx context.Context, metadata models.FileMetadata, r io.Reader) error {
+	return s.SaveSharedFileStream(ctx, []models.FileMetadata{metadata}, r)
+}
+
+// SaveSharedFileStream stores the content read from r once for several files
+// that differ only in recipient and keys. The blob is stored under the ID of
+// the first file and is deleted with the last file that refers to it.
+func (s *Storage) SaveSharedFileStream(ctx context.Context, files []models.FileMetadata, r io.Reader) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no files to save")
+	}
+	blobID := files[0].ID
+
 	// Save content to BlobStore first
-	if err := s.BlobStore.Save(metadata.ID, content); err != nil {
+	if _, err := s.BlobStore.Put(ctx, blobID, r); err != nil {
 		return err
 	}
 
-	// Save metadata to DB
-	return s.Queries.CreateFile(ctx, db.CreateFileParams{
-		ID:           metadata.ID,
-		Sender:       metadata.Sender,
-		Recipient:    metadata.Recipient,
-		FileName:     metadata.FileName,
-		EncryptedKey: metadata.EncryptedKey,
-		AutoDelete:   metadata.AutoDelete,
-		Timestamp:    metadata.Timestamp,
-	})
+	// Save one row per recipient, all or nothing
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		_ = s.BlobStore.Delete(ctx, blobID)
+		return err
+	}
+	q := s.Queries.WithTx(tx)
+	for _, metadata := range files {
+		if err := q.CreateFile(ctx, createFileParams(metadata, blobID)); err != nil {
+			_ = tx.Rollback()
+			_ = s.BlobStore.Delete(ctx, blobID)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// createFileParams converts a file's metadata to a files row.
+func createFileParams(metadata models.FileMetadata, blobID string) db.CreateFileParams {
+	return db.CreateFileParams{
+		ID:                 metadata.ID,
+		Sender:             metadata.Sender,
+		Recipient:          metadata.Recipient,
+		FileName:           metadata.FileName,
+		EncryptedKey:       metadata.EncryptedKey,
+		AutoDelete:         metadata.AutoDelete,
+		Timestamp:          metadata.Timestamp,
+		Signature:          metadata.Signature,
+		SignedAt:           sql.NullTime{Time: metadata.SignedAt, Valid: !metadata.SignedAt.IsZero()},
+		EncryptedMetadata:  metadata.EncryptedMetadata,
+		BlobID:             blobID,
+		WrappedKey:         metadata.WrappedKey,
+		ExpiresAt:          sql.NullTime{Time: metadata.ExpiresAt, Valid: !metadata.ExpiresAt.IsZero()},
+		DownloadsRemaining: sql.NullInt64{Int64: int64(metadata.DownloadsRemaining), Valid: metadata.DownloadsRemaining > 0},
+	}
+}
+
+// fileMetadata converts a files row to its API representation.
+func fileMetadata(f db.File) models.FileMetadata {
+	return models.FileMetadata{
+		ID:                 f.ID,
+		Sender:             f.Sender,
+		Recipient:          f.Recipient,
+		FileName:           f.FileName,
+		EncryptedKey:       f.EncryptedKey,
+		AutoDelete:         f.AutoDelete,
+		Timestamp:          f.Timestamp,
+		Signature:          f.Signature,
+		SignedAt:           f.SignedAt.Time,
+		EncryptedMetadata:  f.EncryptedMetadata,
+		WrappedKey:         f.WrappedKey,
+		ExpiresAt:          f.ExpiresAt.Time,
+		DownloadsRemaining: int(f.DownloadsRemaining.Int64),
+	}
+}
+
+// expired reports whether a file is past its expiry time or has no
+// downloads left. Expired files are hidden until the janitor purges them.
+func expired(f db.File, now time.Time) bool {
+	return (f.ExpiresAt.Valid && !now.Before(f.ExpiresAt.Time)) ||
+		(f.DownloadsRemaining.Valid && f.DownloadsRemaining.Int64 <= 0)
 }
 
 // GetFileMetadata retrieves metadata for a file.
 func (s *Storage) GetFileMetadata(ctx context.Context, id string) (models.FileMetadata, bool) {
 	f, err := s.Queries.GetFile(ctx, id)
-	if err != nil {
+	if err != nil || expired(f, time.Now()) {
 		return models.FileMetadata{}, false
 	}
-	return models.FileMetadata{
-		ID:           f.ID,
-		Sender:       f.Sender,
-		Recipient:    f.Recipient,
-		FileName:     f.FileName,
-		EncryptedKey: f.EncryptedKey,
-		AutoDelete:   f.AutoDelete,
-		Timestamp:    f.Timestamp,
-	}, true
+	return fileMetadata(f), true
+}
+
+// blobID returns the ID of the blob holding a file's content.
+func (s *Storage) blobID(ctx context.Context, id string) (string, error) {
+	f, err := s.Queries.GetFile(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return f.BlobID, nil
 }
 
 // GetFileContent retrieves the content of a file.
-func (s *Storage) GetFileContent(id string) ([]byte, error) {
-	return s.BlobStore.Get(id)
+func (s *Storage) GetFileContent(ctx context.Context, id string) ([]byte, error) {
+	r, err := s.OpenFileContent(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	return io.ReadAll(r)
+}
+
+// OpenFileContent opens the content of a file for streaming.
+func (s *Storage) OpenFileContent(ctx context.Context, id string) (io.ReadCloser, error) {
+	blobID, err := s.blobID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.BlobStore.Get(ctx, blobID)
+}
+
+// OpenFileContentRange opens length bytes of a file's content starting at
+// offset. A negative length reads to the end.
+func (s *Storage) OpenFileContentRange(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error) {
+	blobID, err := s.blobID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.BlobStore.GetRange(ctx, blobID, offset, length)
+}
+
+// StatFileContent returns the size and modification time of a file's content.
+func (s *Storage) StatFileContent(ctx context.Context, id string) (BlobInfo, error) {
+	blobID, err := s.blobID(ctx, id)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return s.BlobStore.Stat(ctx, blobID)
 }
 
 // ListFiles returns files for a specific recipient.
@@ -188,73 +616,201 @@ func (s *Storage) ListFiles(ctx context.Context, recipient string) ([]models.Fil
 	if err != nil {
 		return nil, err
 	}
+	now := time.Now()
 	var result []models.FileMetadata
 	for _, f := range files {
-		result = append(result, models.FileMetadata{
-			ID:           f.ID,
-			Sender:       f.Sender,
-			Recipient:    f.Recipient,
-			FileName:     f.FileName,
-			EncryptedKey: f.EncryptedKey,
-			AutoDelete:   f.AutoDelete,
-			Timestamp:    f.Timestamp,
-		})
+		if !expired(f, now) {
+			result = append(result, fileMetadata(f))
+		}
 	}
 	return result, nil
 }
 
-// DeleteFile removes a file and its metadata.
+// DeleteFile removes a file's metadata, and its content once no other
+// recipient's file refers to it.
 func (s *Storage) DeleteFile(ctx context.Context, id string) error {
-	// Remove from BlobStore
-	if err := s.BlobStore.Delete(id); err != nil {
+	blobID, err := s.blobID(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.Queries.DeleteFile(ctx, id); err != nil {
+		return err
+	}
+	refs, err := s.Queries.CountFilesByBlob(ctx, blobID)
+	if err != nil || refs > 0 {
 		return err
 	}
-	// Remove from DB
-	return s.Queries.DeleteFile(ctx, id)
+	return s.BlobStore.Delete(ctx, blobID)
 }
 
-// CreateChallenge generates and stores a nonce for a user.
-func (s *Storage) CreateChallenge(ctx context.Context, username string, nonce string) error {
-	return s.Queries.CreateChallenge(ctx, db.CreateChallengeParams{
-		Username: username,
-		Nonce:    nonce,
-	})
+// PurgeExpiredFiles deletes files that expired before now or have no
+// downloads left, and returns how many were deleted.
+func (s *Storage) PurgeExpiredFiles(ctx context.Context, now time.Time) (int, error) {
+	ids, err := s.Queries.ListExpiredFiles(ctx, sql.NullTime{Time: now, Valid: true})
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := s.DeleteFile(ctx, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
 }
 
-// GetChallenge retrieves and deletes a challenge for a user.
-func (s *Storage) GetChallenge(ctx context.Context, username string) (string, bool) {
-	nonce, err := s.Queries.GetChallenge(ctx, username)
+// AcknowledgeDownload counts a completed download of a file with a download
+// limit and deletes the file when none remain. It returns the number of
+// downloads left, or -1 if the file has no limit.
+func (s *Storage) AcknowledgeDownload(ctx context.Context, id string) (int, error) {
+	remaining, err := s.Queries.DecrementDownloads(ctx, id)
+	if err == sql.ErrNoRows {
+		return -1, nil
+	}
 	if err != nil {
-		return "", false
+		return 0, err
 	}
-	// Delete challenge after retrieval (one-time use)
-	_ = s.Queries.DeleteChallenge(ctx, username)
-	return nonce, true
+	if remaining.Int64 > 0 {
+		return int(remaining.Int64), nil
+	}
+	return 0, s.DeleteFile(ctx, id)
+}
+
+// CreateChallenge stores a nonce for a user that can be used to log in until
+// expiresAt. It replaces any challenge the user already has.
+func (s *Storage) CreateChallenge(ctx context.Context, username string, nonce string, expiresAt time.Time) error {
+	return s.Queries.CreateChallenge(ctx, db.CreateChallengeParams{
+		Username:  username,
+		Nonce:     nonce,
+		ExpiresAt: sql.NullTime{Time: expiresAt, Valid: true},
+	})
 }
 
-// CreateSession stores a new session.
+// TakeChallenge deletes a user's challenge if its nonce is nonce, so that
+// each challenge can be answered only once, and reports whether it had not
+// expired by now. A challenge with another nonce is left in place.
+func (s *Storage) TakeChallenge(ctx context.Context, username, nonce string, now time.Time) bool {
+	expiresAt, err := s.Queries.TakeChallenge(ctx, db.TakeChallengeParams{Username: username, Nonce: nonce})
+	return err == nil && expiresAt.Valid && now.Before(expiresAt.Time)
+}
+
+// PurgeExpiredChallenges deletes challenges that expired before now and
+// returns how many were deleted.
+func (s *Storage) PurgeExpiredChallenges(ctx context.Context, now time.Time) (int, error) {
+	n, err := s.Queries.DeleteExpiredChallenges(ctx, sql.NullTime{Time: now, Valid: true})
+	return int(n), err
+}
+
+// CreateSession stores a new session under the hash of its refresh token.
+// A session without an ID is given one.
 func (s *Storage) CreateSession(ctx context.Context, session models.Session) error {
+	if session.ID == "" {
+		session.ID = newSessionID()
+	}
+	if session.LastUsedAt.IsZero() {
+		session.LastUsedAt = time.Now()
+	}
 	return s.Queries.CreateSession(ctx, db.CreateSessionParams{
-		Token:     session.Token,
-		Username:  session.Username,
-		ExpiresAt: session.ExpiresAt,
+		RefreshHash: hashRefreshToken(session.RefreshToken),
+		ID:          session.ID,
+		Username:    session.Username,
+		Device:      session.Device,
+		ExpiresAt:   session.ExpiresAt,
+		LastUsedAt:  sql.NullTime{Time: session.LastUsedAt, Valid: true},
 	})
 }
 
-// GetSession retrieves a session by token.
-func (s *Storage) GetSession(ctx context.Context, token string) (models.Session, bool) {
-	sess, err := s.Queries.GetSession(ctx, token)
+// RefreshSession replaces the refresh token of the unexpired session that
+// refreshToken belongs to with newRefreshToken, and extends the session to
+// expiresAt. Each refresh token can be used once. It returns
+// ErrSessionNotFound if no unexpired session has refreshToken or the
+// session's user no longer exists.
+func (s *Storage) RefreshSession(ctx context.Context, refreshToken, newRefreshToken string, expiresAt, now time.Time) (models.Session, error) {
+	sess, err := s.Queries.RefreshSession(ctx, db.RefreshSessionParams{
+		NewRefreshHash: hashRefreshToken(newRefreshToken),
+		ExpiresAt:      expiresAt,
+		LastUsedAt:     sql.NullTime{Time: now, Valid: true},
+		RefreshHash:    hashRefreshToken(refreshToken),
+		Now:            now,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Session{}, ErrSessionNotFound
+	}
 	if err != nil {
-		return models.Session{}, false
+		return models.Session{}, err
 	}
 	return models.Session{
-		Token:     sess.Token,
-		Username:  sess.Username,
-		ExpiresAt: sess.ExpiresAt,
-	}, true
+		RefreshToken: newRefreshToken,
+		ID:           sess.ID,
+		Username:     sess.Username,
+		Device:       sess.Device,
+		ExpiresAt:    sess.ExpiresAt,
+		LastUsedAt:   sess.LastUsedAt.Time,
+	}, nil
+}
+
+// newSessionID returns a random ID that names a session in listings.
+func newSessionID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newRefreshToken returns a random refresh token.
+func newRefreshToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// hashRefreshToken returns the hash a refresh token is stored under, so
+// that a copy of the database cannot be used to refresh sessions.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ListSessions returns a user's sessions, oldest first, including any that
+// have expired but not yet been purged.
+func (s *Storage) ListSessions(ctx context.Context, username string) ([]models.SessionInfo, error) {
+	rows, err := s.Queries.ListUserSessions(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	result := []models.SessionInfo{}
+	for _, sess := range rows {
+		result = append(result, models.SessionInfo{
+			ID:         sess.ID,
+			Device:     sess.Device,
+			CreatedAt:  sess.CreatedAt,
+			LastUsedAt: sess.LastUsedAt.Time,
+			ExpiresAt:  sess.ExpiresAt,
+		})
+	}
+	return result, nil
+}
+
+// RevokeSession ends one of a user's sessions by ID. It returns
+// ErrSessionNotFound if the user has no session with that ID.
+func (s *Storage) RevokeSession(ctx context.Context, username, id string) error {
+	n, err := s.Queries.DeleteUserSession(ctx, db.DeleteUserSessionParams{
+		Username: username,
+		ID:       id,
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
 }
 
-// DeleteSession removes a session.
-func (s *Storage) DeleteSession(ctx context.Context, token string) error {
-	return s.Queries.DeleteSession(ctx, token)
+// PurgeExpiredSessions deletes sessions that expired before now and returns
+// how many were deleted.
+func (s *Storage) PurgeExpiredSessions(ctx context.Context, now time.Time) (int, error) {
+	n, err := s.Queries.DeleteExpiredSessions(ctx, now)
+	return int(n), err
 }
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/db"
+	"github.com/VinMeld/go-send/internal/models"
+)
+
+// ErrInvalidTreeSize is returned for consistency proofs between tree sizes
+// the log cannot prove.
+var ErrInvalidTreeSize = errors.New("invalid tree size")
+
+// appendKeyLogEntry appends the keys of a user to the key log. The entry's
+// index is the current size of the log, so the caller must hold
+// Storage.keyLogMu until the transaction q belongs to is committed.
+func appendKeyLogEntry(ctx context.Context, q *db.Queries, entry crypto.KeyLogEntry) error {
+	size, err := q.CountKeyLogEntries(ctx)
+	if err != nil {
+		return err
+	}
+	return q.AppendKeyLogEntry(ctx, db.AppendKeyLogEntryParams{
+		Idx:               size,
+		Username:          entry.Username,
+		Version:           entry.Version,
+		IdentityPublicKey: entry.IdentityPublicKey,
+		ExchangePublicKey: entry.ExchangePublicKey,
+		LeafHash:          entry.LeafHash(),
+	})
+}
+
+// backfillKeyLog appends the current keys of users registered before the
+// key log existed.
+func (s *Storage) backfillKeyLog(ctx context.Context) error {
+	s.keyLogMu.Lock()
+	defer s.keyLogMu.Unlock()
+	users, err := s.Queries.ListUsersMissingFromKeyLog(ctx)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		history, err := s.Queries.ListKeyRotations(ctx, u.Username)
+		if err != nil {
+			return err
+		}
+		if err := appendKeyLogEntry(ctx, s.Queries, crypto.KeyLogEntry{
+			Username:          u.Username,
+			Version:           int64(len(history)),
+			IdentityPublicKey: u.IdentityPublicKey,
+			ExchangePublicKey: u.ExchangePublicKey,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signTreeHead returns the signed head of the tree with the given leaves.
+func (s *Storage) signTreeHead(leaves [][]byte) models.SignedTreeHead {
+	head := crypto.TreeHead{
+		TreeSize:  uint64(len(leaves)),
+		RootHash:  crypto.MerkleRoot(leaves),
+		Timestamp: time.Now().UTC().Truncate(time.Second),
+	}
+	return models.SignedTreeHead{
+		TreeSize:     head.TreeSize,
+		RootHash:     head.RootHash,
+		Timestamp:    head.Timestamp,
+		Signature:    crypto.SignTreeHead(s.LogKey, head),
+		LogPublicKey: s.LogKey.Public().(ed25519.PublicKey),
+	}
+}
+
+// KeyLogHead returns the current signed tree head of the key log.
+//
+// The head and the proofs below are computed from every leaf hash in the
+// log, which is read afresh on each call. That is O(n) in the number of
+// registrations and rotations, and cheap at the sizes a single server
+// reaches; a larger log would want the tree cached in memory.
+func (s *Storage) KeyLogHead(ctx context.Context) (models.SignedTreeHead, error) {
+	leaves, err := s.Queries.ListKeyLogHashes(ctx)
+	if err != nil {
+		return models.SignedTreeHead{}, err
+	}
+	return s.signTreeHead(leaves), nil
+}
+
+// KeyLogProof returns a user's latest key log entry with a proof that it is
+// in the current tree. It returns ErrUserNotFound if the user has no entry.
+func (s *Storage) KeyLogProof(ctx context.Context, username string) (models.KeyLogProof, error) {
+	entry, err := s.Queries.GetLatestKeyLogEntry(ctx, username)
+	if err == sql.ErrNoRows {
+		return models.KeyLogProof{}, ErrUserNotFound
+	}
+	if err != nil {
+		return models.KeyLogProof{}, err
+	}
+	// The log only grows, so the tree read now includes the entry
+	leaves, err := s.Queries.ListKeyLogHashes(ctx)
+	if err != nil {
+		return models.KeyLogProof{}, err
+	}
+	return models.KeyLogProof{
+		Entry: models.KeyLogEntry{
+			Username:          entry.Username,
+			Version:           entry.Version,
+			IdentityPublicKey: entry.IdentityPublicKey,
+			ExchangePublicKey: entry.ExchangePublicKey,
+		},
+		LeafIndex: uint64(entry.Idx),
+		Proof:     crypto.MerkleInclusionProof(leaves, int(entry.Idx)),
+		TreeHead:  s.signTreeHead(leaves),
+	}, nil
+}
+
+// KeyLogConsistency returns a proof that the key log of size first is a
+// prefix of the log of size second.
+func (s *Storage) KeyLogConsistency(ctx context.Context, first, second uint64) (models.ConsistencyProof, error) {
+	leaves, err := s.Queries.ListKeyLogHashes(ctx)
+	if err != nil {
+		return models.ConsistencyProof{}, err
+	}
+	if first == 0 || first > second || second > uint64(len(leaves)) {
+		return models.ConsistencyProof{}, ErrInvalidTreeSize
+	}
+	return models.ConsistencyProof{
+		First:  first,
+		Second: second,
+		Proof:  crypto.MerkleConsistencyProof(leaves[:second], int(first)),
+	}, nil
+}
@@ -1,11 +1,18 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/VinMeld/go-send/internal/crypto"
 	"github.com/VinMeld/go-send/internal/models"
 )
 
@@ -54,7 +61,7 @@ func TestStorage(t *testing.T) {
 		t.Error("ListFiles returned wrong files")
 	}
 
-	retrievedContent, err := s.GetFileContent("file1")
+	retrievedContent, err := s.GetFileContent(context.Background(), "file1")
 	if err != nil || string(retrievedContent) != string(content) {
 		t.Error("GetFileContent failed")
 	}
@@ -68,23 +75,37 @@ func TestStorage(t *testing.T) {
 
 	// Test Session Operations
 	session := models.Session{
-		Token:     "token1",
-		Username:  "alice",
-		ExpiresAt: time.Now().Add(time.Hour),
+		RefreshToken: "refresh1",
+		ID:           "session1",
+		Username:     "alice",
+		ExpiresAt:    time.Now().Add(time.Hour),
 	}
 	if err := s.CreateSession(context.Background(), session); err != nil {
 		t.Errorf("CreateSession failed: %v", err)
 	}
 
-	retrievedSess, ok := s.GetSession(context.Background(), "token1")
-	if !ok || retrievedSess.Username != "alice" {
-		t.Error("GetSession failed")
+	now := time.Now()
+	refreshed, err := s.RefreshSession(context.Background(), "refresh1", "refresh2", now.Add(2*time.Hour), now)
+	if err != nil || refreshed.ID != "session1" || refreshed.Username != "alice" || refreshed.RefreshToken != "refresh2" {
+		t.Errorf("RefreshSession failed: %+v (%v)", refreshed, err)
+	}
+	if _, err := s.RefreshSession(context.Background(), "refresh1", "refresh3", now.Add(2*time.Hour), now); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected a used refresh token to be refused, got %v", err)
+	}
+	if _, err := s.RefreshSession(context.Background(), "refresh2", "refresh3", now.Add(2*time.Hour), now.Add(3*time.Hour)); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected an expired session to be refused, got %v", err)
+	}
+
+	// Refresh tokens are only stored hashed
+	var stored string
+	if err := s.DB.QueryRow(`SELECT refresh_hash FROM sessions WHERE id = 'session1'`).Scan(&stored); err != nil || stored == "refresh2" {
+		t.Errorf("Expected the refresh token to be stored hashed, got %q (%v)", stored, err)
 	}
 
-	if err := s.DeleteSession(context.Background(), "token1"); err != nil {
-		t.Errorf("DeleteSession failed: %v", err)
+	if err := s.RevokeSession(context.Background(), "alice", "session1"); err != nil {
+		t.Errorf("RevokeSession failed: %v", err)
 	}
-	if _, ok := s.GetSession(context.Background(), "token1"); ok {
+	if _, err := s.RefreshSession(context.Background(), "refresh2", "refresh3", now.Add(2*time.Hour), now); !errors.Is(err, ErrSessionNotFound) {
 		t.Error("Session should be deleted")
 	}
 }
@@ -103,3 +124,161 @@ func TestStorageErrors(t *testing.T) {
 		t.Errorf("NewStorage failed on new dir: %v", err)
 	}
 }
+
+func TestStorageMigratesOldSchema(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "go-send-migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// A files table as created before signatures were added
+	old, err := sql.Open("sqlite3", filepath.Join(tmpDir, "gosend.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`CREATE TABLE files (
+		id TEXT PRIMARY KEY,
+		sender TEXT NOT NULL,
+		recipient TEXT NOT NULL,
+		file_name TEXT NOT NULL,
+		encrypted_key BLOB NOT NULL,
+		auto_delete BOOLEAN NOT NULL DEFAULT 0,
+		timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err == nil {
+		// A file stored before blobs were shared keeps its blob under its own ID
+		_, err = old.Exec(`INSERT INTO files (id, sender, recipient, file_name, encrypted_key) VALUES ('old', 'alice', 'bob', 'old.txt', x'00')`)
+	}
+	if err == nil {
+		// A session opened before sessions had IDs
+		_, err = old.Exec(`CREATE TABLE sessions (
+			token TEXT PRIMARY KEY,
+			username TEXT NOT NULL,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)
+	}
+	if err == nil {
+		_, err = old.Exec(`INSERT INTO sessions (token, username, expires_at) VALUES ('old-token', 'alice', ?)`, time.Now().Add(time.Hour))
+	}
+	_ = old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLocalBlobStore(tmpDir).Put(context.Background(), "old", bytes.NewReader([]byte("old content"))); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewStorage(tmpDir, NewLocalBlobStore(tmpDir))
+	if err != nil {
+		t.Fatalf("NewStorage failed on old schema: %v", err)
+	}
+	defer func() { _ = s.Close() }()
+
+	ctx := context.Background()
+	meta := models.FileMetadata{ID: "file1", Sender: "alice", Recipient: "bob", EncryptedKey: []byte("key"), Signature: []byte("sig"), Timestamp: time.Now()}
+	if err := s.SaveFile(ctx, meta, []byte("content")); err != nil {
+		t.Fatalf("SaveFile failed after migration: %v", err)
+	}
+	got, ok := s.GetFileMetadata(ctx, "file1")
+	if !ok || string(got.Signature) != "sig" {
+		t.Errorf("Signature not persisted after migration: %+v", got)
+	}
+
+	if data, err := s.GetFileContent(ctx, "old"); err != nil || string(data) != "old content" {
+		t.Errorf("Existing file unreadable after migration: %q (%v)", data, err)
+	}
+
+	// Sessions from before refresh tokens cannot be refreshed, so they end
+	if sessions, err := s.ListSessions(ctx, "alice"); err != nil || len(sessions) != 0 {
+		t.Errorf("Expected the old session to be dropped, got %+v (%v)", sessions, err)
+	}
+
+	// Opening an up-to-date database again is a no-op
+	if _, err := NewStorage(tmpDir, NewLocalBlobStore(tmpDir)); err != nil {
+		t.Errorf("Reopening migrated storage failed: %v", err)
+	}
+}
+
+func TestStorageBackfillsKeyLog(t *testing.T) {
+	tmpDir := t.TempDir()
+	ctx := context.Background()
+
+	s, err := NewStorage(tmpDir, NewLocalBlobStore(tmpDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddUser(ctx, models.User{Username: "alice", IdentityPublicKey: []byte("id-key"), ExchangePublicKey: []byte("ex-key")}); err != nil {
+		t.Fatal(err)
+	}
+	// As if alice registered before the key log existed
+	if _, err := s.DB.Exec(`DELETE FROM key_log`); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Close()
+
+	s, err = NewStorage(tmpDir, NewLocalBlobStore(tmpDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+	proof, err := s.KeyLogProof(ctx, "alice")
+	if err != nil {
+		t.Fatalf("alice not backfilled into the key log: %v", err)
+	}
+	if string(proof.Entry.IdentityPublicKey) != "id-key" || proof.TreeHead.TreeSize != 1 {
+		t.Errorf("Unexpected backfilled entry: %+v", proof)
+	}
+}
+
+func TestStorageConcurrentKeyLogAppends(t *testing.T) {
+	tmpDir := t.TempDir()
+	ctx := context.Background()
+
+	s, err := NewStorage(tmpDir, NewLocalBlobStore(tmpDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+
+	const users = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, users)
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- s.AddUser(ctx, models.User{Username: fmt.Sprintf("user%d", i), IdentityPublicKey: []byte("id-key"), ExchangePublicKey: []byte("ex-key")})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Concurrent AddUser failed: %v", err)
+		}
+	}
+
+	// Every entry has its own index, and each proof checks out against
+	// the tree head it came with
+	head, err := s.KeyLogHead(ctx)
+	if err != nil || head.TreeSize != users {
+		t.Fatalf("Expected a log of %d entries, got %+v (%v)", users, head, err)
+	}
+	for i := 0; i < users; i++ {
+		proof, err := s.KeyLogProof(ctx, fmt.Sprintf("user%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf := crypto.KeyLogEntry{
+			Username:          proof.Entry.Username,
+			Version:           proof.Entry.Version,
+			IdentityPublicKey: proof.Entry.IdentityPublicKey,
+			ExchangePublicKey: proof.Entry.ExchangePublicKey,
+		}.LeafHash()
+		if !crypto.VerifyMerkleInclusion(leaf, proof.LeafIndex, proof.TreeHead.TreeSize, proof.Proof, proof.TreeHead.RootHash) {
+			t.Errorf("Inclusion proof for user%d does not verify", i)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/db"
+	"github.com/VinMeld/go-send/internal/models"
+)
+
+// UploadSessionRecord is the server-side state of a resumable upload.
+type UploadSessionRecord struct {
+	ID         string
+	Sender     string
+	Metadata   models.FileMetadata
+	Recipients []models.RecipientKey
+	ChunkSize  int64
+	UpdatedAt  time.Time
+	Chunks     []models.UploadChunk
+}
+
+// uploadSessionMetadata is the stored form of an upload session's metadata.
+// Sessions created before multi-recipient uploads have no Recipients.
+type uploadSessionMetadata struct {
+	models.FileMetadata
+	Recipients []models.RecipientKey `json:"recipients,omitempty"`
+}
+
+// recipientKeys returns the recipients of an upload, falling back to the
+// single recipient named in metadata.
+func recipientKeys(metadata models.FileMetadata, recipients []models.RecipientKey) []models.RecipientKey {
+	if len(recipients) > 0 {
+		return recipients
+	}
+	return []models.RecipientKey{{
+		Recipient:         metadata.Recipient,
+		EncryptedKey:      metadata.EncryptedKey,
+		WrappedKey:        metadata.WrappedKey,
+		EncryptedMetadata: metadata.EncryptedMetadata,
+	}}
+}
+
+// uploadChunkBlobID returns the BlobStore ID of a chunk of an upload session.
+func uploadChunkBlobID(sessionID string, index int64) string {
+	return fmt.Sprintf("%s.part%d", sessionID, index)
+}
+
+// CreateUploadSession starts a resumable upload owned by sender.
+func (s *Storage) CreateUploadSession(ctx context.Context, id string, sender string, metadata models.FileMetadata, recipients []models.RecipientKey, chunkSize int64) error {
+	data, err := json.Marshal(uploadSessionMetadata{FileMetadata: metadata, Recipients: recipients})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return s.Queries.CreateUploadSession(ctx, db.CreateUploadSessionParams{
+		ID:        id,
+		Sender:    sender,
+		Metadata:  string(data),
+		ChunkSize: chunkSize,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+}
+
+// GetUploadSession retrieves an upload session and the chunks received so far.
+func (s *Storage) GetUploadSession(ctx context.Context, id string) (UploadSessionRecord, bool) {
+	sess, err := s.Queries.GetUploadSession(ctx, id)
+	if err != nil {
+		return UploadSessionRecord{}, false
+	}
+	var metadata uploadSessionMetadata
+	if err := json.Unmarshal([]byte(sess.Metadata), &metadata); err != nil {
+		return UploadSessionRecord{}, false
+	}
+	rows, err := s.Queries.ListUploadChunks(ctx, id)
+	if err != nil {
+		return UploadSessionRecord{}, false
+	}
+	chunks := make([]models.UploadChunk, 0, len(rows))
+	for _, c := range rows {
+		chunks = append(chunks, models.UploadChunk{
+			Index:  c.Idx,
+			Offset: c.Idx * sess.ChunkSize,
+			Size:   c.Size,
+		})
+	}
+	return UploadSessionRecord{
+		ID:         sess.ID,
+		Sender:     sess.Sender,
+		Metadata:   metadata.FileMetadata,
+		Recipients: recipientKeys(metadata.FileMetadata, metadata.Recipients),
+		ChunkSize:  sess.ChunkSize,
+		UpdatedAt:  sess.UpdatedAt,
+		Chunks:     chunks,
+	}, true
+}
+
+// SaveUploadChunk stores one chunk of an upload session, replacing any
+// previous copy of the same chunk.
+func (s *Storage) SaveUploadChunk(ctx context.Context, sessionID string, index int64, r io.Reader) (int64, error) {
+	n, err := s.BlobStore.Put(ctx, uploadChunkBlobID(sessionID, index), r)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.Queries.UpsertUploadChunk(ctx, db.UpsertUploadChunkParams{
+		SessionID: sessionID,
+		Idx:       index,
+		Size:      n,
+	}); err != nil {
+		return 0, err
+	}
+	return n, s.Queries.TouchUploadSession(ctx, db.TouchUploadSessionParams{
+		UpdatedAt: time.Now(),
+		ID:        sessionID,
+	})
+}
+
+// CompleteUpload concatenates the chunks of an upload session into the
+// shared content of files, one per recipient. The files are saved, recorded
+// against the session ID and the session removed in one transaction, so a
+// session completes at most once; later attempts get ErrUploadCompleted and
+// can look the files up with CompletedUploadFiles.
+func (s *Storage) CompleteUpload(ctx context.Context, session UploadSessionRecord, files []models.FileMetadata) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no files to save")
+	}
+	blobID := files[0].ID
+
+	r := &chunkReader{ctx: ctx, store: s.BlobStore, sessionID: session.ID, chunks: session.Chunks}
+	_, err := s.BlobStore.Put(ctx, blobID, r)
+	_ = r.Close()
+	if err != nil {
+		_ = s.BlobStore.Delete(ctx, blobID)
+		// Another request may have completed the session and removed its chunks
+		if _, ok := s.GetUploadSession(ctx, session.ID); !ok {
+			return ErrUploadCompleted
+		}
+		return err
+	}
+	if err := s.completeUpload(ctx, session, files, blobID); err != nil {
+		_ = s.BlobStore.Delete(ctx, blobID)
+		return err
+	}
+
+	// The session is gone, so chunks left behind here are never read again
+	for _, c := range session.Chunks {
+		_ = s.BlobStore.Delete(ctx, uploadChunkBlobID(session.ID, c.Index))
+	}
+	return nil
+}
+
+// completeUpload saves the rows of a completed upload and removes its session.
+func (s *Storage) completeUpload(ctx context.Context, session UploadSessionRecord, files []models.FileMetadata, blobID string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	q := s.Queries.WithTx(tx)
+
+	if err := q.DeleteUploadChunks(ctx, session.ID); err != nil {
+		return err
+	}
+	n, err := q.DeleteUploadSession(ctx, session.ID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUploadCompleted
+	}
+	now := time.Now()
+	for _, metadata := range files {
+		if err := q.CreateFile(ctx, createFileParams(metadata, blobID)); err != nil {
+			return err
+		}
+		if err := q.CreateCompletedUpload(ctx, db.CreateCompletedUploadParams{
+			UploadID:    session.ID,
+			FileID:      metadata.ID,
+			Sender:      session.Sender,
+			CompletedAt: now,
+		}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CompletedUploadFiles returns the files an upload session of sender was
+// completed with, leaving out any deleted since.
+func (s *Storage) CompletedUploadFiles(ctx context.Context, id string, sender string) ([]models.FileMetadata, error) {
+	rows, err := s.Queries.ListCompletedUploadFiles(ctx, db.ListCompletedUploadFilesParams{
+		UploadID: id,
+		Sender:   sender,
+	})
+	if err != nil {
+		return nil, err
+	}
+	files := make([]models.FileMetadata, 0, len(rows))
+	for _, f := range rows {
+		files = append(files, fileMetadata(f))
+	}
+	return files, nil
+}
+
+// DeleteUploadSession removes an upload session and its chunks.
+func (s *Storage) DeleteUploadSession(ctx context.Context, id string) error {
+	rows, err := s.Queries.ListUploadChunks(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, c := range rows {
+		if err := s.BlobStore.Delete(ctx, uploadChunkBlobID(id, c.Idx)); err != nil {
+			return err
+		}
+	}
+	if err := s.Queries.DeleteUploadChunks(ctx, id); err != nil {
+		return err
+	}
+	_, err = s.Queries.DeleteUploadSession(ctx, id)
+	return err
+}
+
+// PurgeStaleUploads removes upload sessions that have not been touched since
+// before, and the records of uploads completed before then, and returns how
+// many sessions were removed.
+func (s *Storage) PurgeStaleUploads(ctx context.Context, before time.Time) (int, error) {
+	if _, err := s.Queries.DeleteStaleCompletedUploads(ctx, before); err != nil {
+		return 0, err
+	}
+	ids, err := s.Queries.ListStaleUploadSessions(ctx, before)
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := s.DeleteUploadSession(ctx, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// chunkReader reads the chunks of an upload session in order, opening each
+// chunk only when the previous one is exhausted.
+type chunkReader struct {
+	ctx       context.Context
+	store     BlobStore
+	sessionID string
+	chunks    []models.UploadChunk
+	current   io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := r.store.Get(r.ctx, uploadChunkBlobID(r.sessionID, r.chunks[0].Index))
+			if err != nil {
+				return 0, err
+			}
+			r.current = rc
+			r.chunks = r.chunks[1:]
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			_ = r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
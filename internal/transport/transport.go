@@ -1,5 +1,12 @@
 package transport
 
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/VinMeld/go-send/internal/models"
+)
+
 // Constants for default server configuration.
 const (
 	// DefaultServerPort is the default port the server listens on.
@@ -7,3 +14,32 @@ const (
 	// DefaultServerURL is the default URL for the server.
 	DefaultServerURL = "http://localhost:8082"
 )
+
+// Constants for the binary streaming endpoints.
+const (
+	// MetadataHeader carries the file metadata as base64-encoded JSON on
+	// streaming uploads and downloads, leaving the body for raw ciphertext.
+	MetadataHeader = "X-File-Metadata"
+	// ContentTypeBinary is the content type of raw ciphertext bodies.
+	ContentTypeBinary = "application/octet-stream"
+)
+
+// EncodeMetadata encodes file metadata for the MetadataHeader.
+func EncodeMetadata(meta models.FileMetadata) (string, error) {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecodeMetadata decodes a MetadataHeader value.
+func DecodeMetadata(value string) (models.FileMetadata, error) {
+	var meta models.FileMetadata
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(data, &meta)
+	return meta, err
+}
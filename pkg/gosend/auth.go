@@ -0,0 +1,294 @@
+package gosend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/models"
+)
+
+// tokenRefreshMargin is how long before it expires an access token is
+// refreshed, so that it does not expire on its way to the server.
+const tokenRefreshMargin = 30 * time.Second
+
+// Tokens are the tokens of a session: a short-lived access token sent with
+// each request, and a refresh token traded for new tokens when it expires.
+type Tokens struct {
+	Access  string
+	Refresh string
+}
+
+// TokenStore keeps the tokens of users' sessions.
+type TokenStore interface {
+	LoadTokens(username string) (Tokens, bool)
+	SaveTokens(username string, tokens Tokens) error
+	DeleteTokens(username string) error
+}
+
+// memoryTokenStore keeps tokens for as long as the client exists.
+type memoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]Tokens
+}
+
+func (s *memoryTokenStore) LoadTokens(username string) (Tokens, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[username]
+	return t, ok
+}
+
+func (s *memoryTokenStore) SaveTokens(username string, tokens Tokens) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens == nil {
+		s.tokens = make(map[string]Tokens)
+	}
+	s.tokens[username] = tokens
+	return nil
+}
+
+func (s *memoryTokenStore) DeleteTokens(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, username)
+	return nil
+}
+
+// Login opens a session by signing a challenge from the server. The
+// challenge must name the server's origin and the client user, so that a
+// malicious server cannot relay one from elsewhere to log in there as us.
+func (c *Client) Login(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.login(ctx)
+}
+
+func (c *Client) login(ctx context.Context) error {
+	if c.Username == "" {
+		return fmt.Errorf("no current user set")
+	}
+
+	var challenge models.AuthChallenge
+	if err := c.getJSON(ctx, "/auth/challenge?username="+url.QueryEscape(c.Username), &challenge); err != nil {
+		return fmt.Errorf("failed to get challenge: %w", err)
+	}
+	if !sameOrigin(challenge.Origin, c.ServerURL) {
+		return fmt.Errorf("challenge is for %q, not %s", challenge.Origin, c.ServerURL)
+	}
+	if challenge.Username != c.Username {
+		return fmt.Errorf("challenge is for user %q, not %s", challenge.Username, c.Username)
+	}
+	signature, err := c.Keys.Sign(c.Username, crypto.LoginChallenge{
+		Origin:   challenge.Origin,
+		Username: challenge.Username,
+		Nonce:    challenge.Nonce,
+	}.Bytes())
+	if err != nil {
+		return err
+	}
+
+	data, _ := json.Marshal(models.AuthResponse{
+		Username:  c.Username,
+		Nonce:     challenge.Nonce,
+		Signature: signature,
+		Device:    c.Device,
+	})
+	var session models.Session
+	if err := c.postSession(ctx, "/auth/login", data, &session); err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+	return c.tokens().SaveTokens(c.Username, Tokens{Access: session.Token, Refresh: session.RefreshToken})
+}
+
+// postSession posts data to an endpoint that answers with a session.
+func (c *Client) postSession(ctx context.Context, path string, data []byte, session *models.Session) error {
+	req, err := c.request(ctx, "POST", path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("error contacting server: %w", err)
+	}
+	return decodeResponse(resp, http.StatusOK, session)
+}
+
+// refresh trades the refresh token for new tokens. If the session has
+// ended, its tokens are forgotten and ErrSessionEnded is returned.
+func (c *Client) refresh(ctx context.Context) error {
+	tokens, ok := c.tokens().LoadTokens(c.Username)
+	if !ok || tokens.Refresh == "" {
+		_ = c.tokens().DeleteTokens(c.Username)
+		return ErrSessionEnded
+	}
+	data, _ := json.Marshal(models.RefreshRequest{RefreshToken: tokens.Refresh})
+	var session models.Session
+	err := c.postSession(ctx, "/auth/refresh", data, &session)
+	if errors.Is(err, ErrUnauthorized) {
+		if err := c.tokens().DeleteTokens(c.Username); err != nil {
+			return err
+		}
+		return ErrSessionEnded
+	}
+	if err != nil {
+		return fmt.Errorf("failed to refresh session: %w", err)
+	}
+	return c.tokens().SaveTokens(c.Username, Tokens{Access: session.Token, Refresh: session.RefreshToken})
+}
+
+// Logout ends the client's session. It does not log in to do so: a
+// session that cannot be refreshed has already ended.
+func (c *Client) Logout(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tokens, ok := c.tokens().LoadTokens(c.Username)
+	if ok && accessTokenExpiring(tokens.Access) {
+		if err := c.refresh(ctx); err != nil && !errors.Is(err, ErrSessionEnded) {
+			return err
+		}
+		tokens, ok = c.tokens().LoadTokens(c.Username)
+	}
+	if !ok {
+		return nil
+	}
+
+	req, err := c.request(ctx, "POST", "/auth/logout", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+tokens.Access)
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("error contacting server: %w", err)
+	}
+	// An expired or revoked session is already over
+	if err := decodeResponse(resp, http.StatusOK, nil); err != nil && !errors.Is(err, ErrUnauthorized) {
+		return err
+	}
+	return c.tokens().DeleteTokens(c.Username)
+}
+
+// accessToken returns the access token to send, refreshing it first if it
+// is about to expire, and logging in if there is no session.
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tokens, ok := c.tokens().LoadTokens(c.Username)
+	if ok && accessTokenExpiring(tokens.Access) {
+		if err := c.refresh(ctx); err != nil && !errors.Is(err, ErrSessionEnded) {
+			return "", err
+		}
+		tokens, ok = c.tokens().LoadTokens(c.Username)
+	}
+	if !ok {
+		if err := c.login(ctx); err != nil {
+			return "", fmt.Errorf("not logged in and automatic login failed: %w", err)
+		}
+		tokens, _ = c.tokens().LoadTokens(c.Username)
+	}
+	return tokens.Access, nil
+}
+
+// renew replaces the session whose access token the server refused: it is
+// refreshed, and if it has ended the client logs in again. Nothing is done
+// if another request has already replaced the token.
+func (c *Client) renew(ctx context.Context, refused string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if tokens, ok := c.tokens().LoadTokens(c.Username); ok && tokens.Access != refused {
+		return nil
+	}
+	err := c.refresh(ctx)
+	if !errors.Is(err, ErrSessionEnded) {
+		return err
+	}
+	if err := c.login(ctx); err != nil {
+		return fmt.Errorf("session has ended and logging in again failed: %w", err)
+	}
+	return nil
+}
+
+// Do sends req to the server with the client's access token. If the server
+// refuses the token, the session is renewed, logging in again if it has
+// ended, and the request is sent once more if it is replayable. Other
+// requests are left for the caller to repeat.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	token, err := c.accessToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.httpClient().Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	if err := c.renew(req.Context(), token); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	if !replayable(req) {
+		return resp, nil
+	}
+	_ = resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	tokens, _ := c.tokens().LoadTokens(c.Username)
+	retry.Header.Set("Authorization", "Bearer "+tokens.Access)
+	return c.httpClient().Do(retry)
+}
+
+// replayable reports whether req may be sent again after a 401: its method
+// must be idempotent and its body, if any, must be able to be read again.
+func replayable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	default:
+		return false
+	}
+}
+
+// accessTokenExpiring reports whether an access token expires within
+// tokenRefreshMargin. Tokens it cannot read are assumed to be valid and
+// left for the server to judge.
+func accessTokenExpiring(token string) bool {
+	claims, err := crypto.ReadAccessToken(token)
+	if err != nil {
+		return false
+	}
+	return time.Until(claims.ExpiresAt) < tokenRefreshMargin
+}
+
+// sameOrigin reports whether origin names the same scheme and host as the
+// server URL.
+func sameOrigin(origin, serverURL string) bool {
+	o, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	s, err := url.Parse(serverURL)
+	if err != nil {
+		return false
+	}
+	return o.Host != "" && strings.EqualFold(o.Scheme, s.Scheme) && strings.EqualFold(o.Host, s.Host)
+}
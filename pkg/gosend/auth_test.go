@@ -0,0 +1,175 @@
+package gosend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/models"
+)
+
+func TestRefresh(t *testing.T) {
+	// The server accepts one access token and one refresh token at a time,
+	// and rotates both on refresh
+	access, refresh := "access-1", "refresh-1"
+	refreshes := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/refresh":
+			var req models.RefreshRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if req.RefreshToken != refresh {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			refreshes++
+			access, refresh = fmt.Sprintf("access-%d", refreshes+1), fmt.Sprintf("refresh-%d", refreshes+1)
+			_ = json.NewEncoder(w).Encode(models.Session{Token: access, RefreshToken: refresh})
+		case "/echo":
+			if r.Header.Get("Authorization") != "Bearer "+access {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = io.Copy(w, r.Body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := New(ts.URL, "alice", PrivateKeys{})
+	_ = c.tokens().SaveTokens("alice", Tokens{Access: "stale", Refresh: "refresh-1"})
+
+	// A refused access token is refreshed and the request, body included,
+	// sent again
+	req, _ := http.NewRequest("PUT", ts.URL+"/echo", strings.NewReader("hello"))
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Fatalf("Expected the request to be retried, got %d %q", resp.StatusCode, body)
+	}
+	if tokens, _ := c.tokens().LoadTokens("alice"); tokens != (Tokens{Access: "access-2", Refresh: "refresh-2"}) {
+		t.Errorf("Expected the new tokens to be stored, got %+v", tokens)
+	}
+
+	// A request refused with an old token does not refresh again
+	if err := c.renew(ctx, "stale"); err != nil || refreshes != 1 {
+		t.Errorf("Expected the current session to be kept, got %v after %d refreshes", err, refreshes)
+	}
+
+	// An access token about to expire is refreshed before use
+	idKey, _ := crypto.GenerateIdentityKeyPair()
+	expiring := crypto.SignAccessToken(idKey.Private, crypto.AccessToken{Username: "alice", ExpiresAt: time.Now().Add(time.Second)})
+	_ = c.tokens().SaveTokens("alice", Tokens{Access: expiring, Refresh: refresh})
+	if token, err := c.accessToken(ctx); err != nil || token != "access-3" {
+		t.Errorf("Expected the token to be refreshed, got %q (%v)", token, err)
+	}
+
+	// When the session has ended, the tokens are forgotten
+	refresh = "revoked"
+	if err := c.refresh(ctx); !errors.Is(err, ErrSessionEnded) {
+		t.Errorf("Expected the session to have ended, got %v", err)
+	}
+	if _, ok := c.tokens().LoadTokens("alice"); ok {
+		t.Error("Expected the ended session's tokens to be forgotten")
+	}
+}
+
+func TestLogsInAgain(t *testing.T) {
+	// The server refuses every refresh, so an ended session can only be
+	// replaced by logging in
+	access := "revoked"
+	logins, echoes := 0, 0
+	refuseLogin := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/challenge":
+			_ = json.NewEncoder(w).Encode(models.AuthChallenge{Username: "alice", Nonce: "nonce", Origin: "http://" + r.Host})
+		case "/v1/auth/login":
+			if refuseLogin {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			logins++
+			access = fmt.Sprintf("login-%d", logins)
+			_ = json.NewEncoder(w).Encode(models.Session{Token: access, RefreshToken: "refresh"})
+		case "/v1/auth/refresh":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/echo":
+			if r.Header.Get("Authorization") != "Bearer "+access {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			echoes++
+			_, _ = io.Copy(w, r.Body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	idKey, _ := crypto.GenerateIdentityKeyPair()
+	c := New(ts.URL, "alice", PrivateKeys{Identity: idKey.Private})
+	_ = c.tokens().SaveTokens("alice", Tokens{Access: "expired", Refresh: "expired"})
+	send := func(method, body string) (*http.Response, error) {
+		req, _ := http.NewRequest(method, ts.URL+"/echo", strings.NewReader(body))
+		return c.Do(req)
+	}
+
+	// An idempotent request is sent again after logging in
+	resp, err := send("PUT", "hello")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" || logins != 1 {
+		t.Fatalf("Expected the request to be retried after logging in, got %d %q after %d logins", resp.StatusCode, body, logins)
+	}
+
+	// Other requests are not sent again, but the next one uses the new
+	// session
+	access = "revoked"
+	resp, err = send("POST", "once")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || logins != 2 || echoes != 1 {
+		t.Errorf("Expected the refusal to be returned after logging in, got %d after %d logins and %d echoes", resp.StatusCode, logins, echoes)
+	}
+	resp, err = send("POST", "once")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the next request to succeed, got %v", err)
+	}
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+
+	// Logging in is tried once per request
+	access, refuseLogin = "revoked", true
+	if _, err := send("GET", ""); err == nil || !strings.Contains(err.Error(), "logging in again failed") {
+		t.Errorf("Expected the failed login to be reported, got %v", err)
+	}
+	if _, ok := c.tokens().LoadTokens("alice"); ok {
+		t.Error("Expected the ended session's tokens to be forgotten")
+	}
+
+	// Logging out of an ended session does not log in
+	if err := c.Logout(context.Background()); err != nil || logins != 2 {
+		t.Errorf("Expected logging out to do nothing, got %v after %d logins", err, logins)
+	}
+}
@@ -0,0 +1,301 @@
+// Package gosend is a client for the go-send API. It encrypts, signs and
+// authenticates the same way as the go-send command, so other programs can
+// send and receive files without running it.
+package gosend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/models"
+)
+
+// apiPrefix is the path prefix of the version of the API the client speaks.
+const apiPrefix = "/v1"
+
+// Types shared with the server's API.
+type (
+	User             = models.User
+	FileMetadata     = models.FileMetadata
+	FileInfo         = crypto.FileInfo
+	KeyRotation      = models.KeyRotation
+	KeyLogProof      = models.KeyLogProof
+	ConsistencyProof = models.ConsistencyProof
+	SessionInfo      = models.SessionInfo
+	RecipientKey     = models.RecipientKey
+	UploadSession    = models.UploadSession
+)
+
+// Client talks to a go-send server as one user. Its fields must not be
+// changed once it is in use.
+type Client struct {
+	ServerURL string
+	Username  string
+	Keys      Keys
+	// Device labels the sessions the client opens, such as a host name.
+	Device string
+	// Tokens keeps the tokens of the client's session. When nil, they are
+	// kept in memory.
+	Tokens TokenStore
+	// Uploads keeps unfinished uploads so that sending the same content
+	// again resumes them. When nil, uploads are not resumed.
+	Uploads UploadStore
+	// VerifySender checks the sender's signature on a downloaded file
+	// against ciphertextHash. When nil, it is checked against the sender's
+	// current keys on the server, and unsigned files are refused.
+	VerifySender func(ctx context.Context, meta FileMetadata, ciphertextHash []byte) error
+	// RetryDelay is the pause before retrying a failed chunk upload,
+	// multiplied by the attempt number. When zero, one second is used.
+	RetryDelay time.Duration
+	// Logf, when set, receives progress messages such as resumed transfers.
+	Logf func(format string, args ...any)
+	// HTTPClient sends the client's requests. When nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	mu     sync.Mutex // Serializes logins and refreshes
+	memory memoryTokenStore
+}
+
+// New returns a client for username on the server at serverURL, using
+// keys for the user's private key operations.
+func New(serverURL, username string, keys Keys) *Client {
+	return &Client{ServerURL: serverURL, Username: username, Keys: keys}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) tokens() TokenStore {
+	if c.Tokens != nil {
+		return c.Tokens
+	}
+	return &c.memory
+}
+
+func (c *Client) logf(format string, args ...any) {
+	if c.Logf != nil {
+		c.Logf(format, args...)
+	}
+}
+
+// request builds a request for path in the server's API.
+func (c *Client) request(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, method, c.ServerURL+apiPrefix+path, body)
+}
+
+// getJSON sends an unauthenticated GET for path and decodes the response
+// into v.
+func (c *Client) getJSON(ctx context.Context, path string, v any) error {
+	req, err := c.request(ctx, "GET", path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("error contacting server: %w", err)
+	}
+	return decodeResponse(resp, http.StatusOK, v)
+}
+
+// sendJSON sends an authenticated request for path with v as its JSON
+// body, or no body if v is nil.
+func (c *Client) sendJSON(ctx context.Context, method, path string, v any) (*http.Response, error) {
+	var body io.Reader
+	if v != nil {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := c.request(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.Do(req)
+}
+
+// decodeResponse closes resp after decoding its body into v, which may be
+// nil, if it has the wanted status. Other responses become an *Error.
+func decodeResponse(resp *http.Response, status int, v any) error {
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != status {
+		return responseError(resp)
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// Register registers user, which must hold the client's public keys, with
+// the server. token is the server's registration token, if it needs one.
+func (c *Client) Register(ctx context.Context, user User, token string) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	req, err := c.request(ctx, "POST", "/users", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Registration-Token", token)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("error contacting server: %w", err)
+	}
+	return decodeResponse(resp, http.StatusCreated, nil)
+}
+
+// LookupUser returns the keys the server has for username. The server is
+// not trusted to return the right keys: callers that can should compare
+// them with keys they already know.
+func (c *Client) LookupUser(ctx context.Context, username string) (User, error) {
+	var user User
+	if err := c.getJSON(ctx, "/users?username="+url.QueryEscape(username), &user); err != nil {
+		return User{}, err
+	}
+	if user.Username != username || len(user.IdentityPublicKey) == 0 || len(user.ExchangePublicKey) == 0 {
+		return User{}, fmt.Errorf("server returned invalid user keys")
+	}
+	return user, nil
+}
+
+// Users returns every user registered with the server.
+func (c *Client) Users(ctx context.Context) ([]User, error) {
+	var users []User
+	if err := c.getJSON(ctx, "/users", &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// KeyHistory returns the key rotations of username recorded by the server,
+// oldest first.
+func (c *Client) KeyHistory(ctx context.Context, username string) ([]KeyRotation, error) {
+	var history []KeyRotation
+	if err := c.getJSON(ctx, "/users/keys?username="+url.QueryEscape(username), &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// RotateKeys sends a signed rotation of the client user's keys. The server
+// ends the user's sessions, so the client must log in again with the new
+// keys.
+func (c *Client) RotateKeys(ctx context.Context, rotation KeyRotation) error {
+	resp, err := c.sendJSON(ctx, "POST", "/users/keys", rotation)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, http.StatusOK, nil)
+}
+
+// ProveKeys returns the server's proof that the latest keys of username are
+// in its key log. The proof must be checked by the caller.
+func (c *Client) ProveKeys(ctx context.Context, username string) (KeyLogProof, error) {
+	var proof KeyLogProof
+	if err := c.getJSON(ctx, "/log/proof?username="+url.QueryEscape(username), &proof); err != nil {
+		return KeyLogProof{}, err
+	}
+	return proof, nil
+}
+
+// KeyLogConsistency returns the server's proof that its key log of size
+// second extends the one of size first. The proof must be checked by the
+// caller.
+func (c *Client) KeyLogConsistency(ctx context.Context, first, second uint64) (ConsistencyProof, error) {
+	var proof ConsistencyProof
+	path := "/log/consistency?first=" + strconv.FormatUint(first, 10) + "&second=" + strconv.FormatUint(second, 10)
+	if err := c.getJSON(ctx, path, &proof); err != nil {
+		return ConsistencyProof{}, err
+	}
+	return proof, nil
+}
+
+// DeleteUser deletes a user from the server.
+func (c *Client) DeleteUser(ctx context.Context, username string) error {
+	resp, err := c.sendJSON(ctx, "DELETE", "/users?username="+url.QueryEscape(username), nil)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, http.StatusOK, nil)
+}
+
+// List returns the files waiting for the client user, newest first.
+func (c *Client) List(ctx context.Context) ([]FileMetadata, error) {
+	resp, err := c.sendJSON(ctx, "GET", "/files?recipient="+url.QueryEscape(c.Username), nil)
+	if err != nil {
+		return nil, err
+	}
+	var files []FileMetadata
+	if err := decodeResponse(resp, http.StatusOK, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Delete deletes a file from the server.
+func (c *Client) Delete(ctx context.Context, id string) error {
+	resp, err := c.sendJSON(ctx, "DELETE", "/files?id="+url.QueryEscape(id), nil)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, http.StatusOK, nil)
+}
+
+// Acknowledge tells the server a file was downloaded and decrypted, which
+// uses up one of its downloads if it has a limit.
+func (c *Client) Acknowledge(ctx context.Context, id string) error {
+	resp, err := c.sendJSON(ctx, "POST", "/files/ack?id="+url.QueryEscape(id), nil)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, http.StatusOK, nil)
+}
+
+// Sessions returns the client user's sessions.
+func (c *Client) Sessions(ctx context.Context) ([]SessionInfo, error) {
+	resp, err := c.sendJSON(ctx, "GET", "/sessions", nil)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []SessionInfo
+	if err := decodeResponse(resp, http.StatusOK, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one of the client user's sessions.
+func (c *Client) RevokeSession(ctx context.Context, id string) error {
+	resp, err := c.sendJSON(ctx, "DELETE", "/sessions?id="+url.QueryEscape(id), nil)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, http.StatusOK, nil)
+}
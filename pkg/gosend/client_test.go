@@ -0,0 +1,246 @@
+package gosend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/server"
+)
+
+// newTestServer starts a server that requires the registration token
+// "secret".
+func newTestServer(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	storage, err := server.NewStorage(dir, server.NewLocalBlobStore(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := server.NewHandler(storage)
+	handler.SetRegistrationToken("secret")
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// newTestClient generates keys for username and registers them.
+func newTestClient(t *testing.T, serverURL, username string) (*Client, User) {
+	t.Helper()
+	idKey, err := crypto.GenerateIdentityKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exKey, err := crypto.GenerateExchangeKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Username: username, IdentityPublicKey: idKey.Public, ExchangePublicKey: exKey.Public[:]}
+	c := New(serverURL, username, PrivateKeys{Identity: idKey.Private, Exchange: exKey.Private})
+	if err := c.Register(context.Background(), user, "secret"); err != nil {
+		t.Fatalf("Register %s failed: %v", username, err)
+	}
+	return c, user
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	url := newTestServer(t)
+	alice, _ := newTestClient(t, url, "alice")
+	bob, bobUser := newTestClient(t, url, "bob")
+
+	mallory := New(url, "mallory", PrivateKeys{})
+	if err := mallory.Register(ctx, User{Username: "mallory"}, "wrong"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected a wrong registration token to be forbidden, got %v", err)
+	}
+
+	recipient, err := alice.LookupUser(ctx, "bob")
+	if err != nil || !bytes.Equal(recipient.IdentityPublicKey, bobUser.IdentityPublicKey) {
+		t.Fatalf("LookupUser failed: %v", err)
+	}
+	_, err = alice.LookupUser(ctx, "nobody")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected an unknown user not to be found, got %v", err)
+	}
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Code != "not_found" || apiErr.Message != "user not found" || apiErr.RequestID == "" {
+		t.Errorf("Expected the server's error to be decoded, got %+v", apiErr)
+	}
+
+	// Clients log in on their first authenticated request
+	content := strings.Repeat("hello, bob\n", 20000)
+	sent, err := alice.Send(ctx, []User{recipient}, strings.NewReader(content), SendOptions{Info: FileInfo{Name: "hello.txt"}})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if len(sent) != 1 || sent[0].Recipient != "bob" {
+		t.Fatalf("Expected one file for bob, got %+v", sent)
+	}
+
+	files, err := bob.List(ctx)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one file, got %d (%v)", len(files), err)
+	}
+	if info, err := bob.FileInfo(files[0]); err != nil || info.Name != "hello.txt" {
+		t.Errorf("Expected the file's name to be opened, got %+v (%v)", info, err)
+	}
+
+	var buf bytes.Buffer
+	d, err := bob.Download(ctx, files[0].ID, &buf)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if buf.String() != content || d.Info.Name != "hello.txt" {
+		t.Errorf("Expected the file to be decrypted, got %d bytes named %q", buf.Len(), d.Info.Name)
+	}
+
+	if err := bob.Delete(ctx, files[0].ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := bob.Download(ctx, files[0].ID, &buf); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the deleted file not to be found, got %v", err)
+	}
+}
+
+func TestResumeDownload(t *testing.T) {
+	ctx := context.Background()
+	url := newTestServer(t)
+	alice, _ := newTestClient(t, url, "alice")
+	bob, bobUser := newTestClient(t, url, "bob")
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 3*crypto.StreamChunkSize/16+100)
+	sent, err := alice.Send(ctx, []User{bobUser}, bytes.NewReader(content), SendOptions{Info: FileInfo{Name: "big.bin"}})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	part, err := os.Create(filepath.Join(t.TempDir(), "big.bin.part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = part.Close() }()
+	cp := &checkpointFile{File: part}
+
+	// The connection drops during the fourth chunk
+	transport := &cuttingTransport{cutAfter: 3*crypto.StreamChunkSize + 100}
+	bob.HTTPClient = &http.Client{Transport: transport}
+	if _, err := bob.ResumeDownload(ctx, sent[0].ID, cp, nil); !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("Expected the download to be interrupted, got %v", err)
+	}
+	if cp.saved == nil {
+		t.Fatal("Expected the download's progress to be saved")
+	}
+
+	var logged []string
+	bob.Logf = func(format string, args ...any) { logged = append(logged, format) }
+	transport.cutAfter = 0
+	checked := false
+	d, err := bob.ResumeDownload(ctx, sent[0].ID, cp, func(d *Download) error {
+		checked = d.Info.Name == "big.bin"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ResumeDownload failed: %v", err)
+	}
+	if !checked || d.Metadata.Sender != "alice" {
+		t.Errorf("Expected the download to be checked before writing, got %+v", d)
+	}
+	if len(logged) != 1 || !strings.HasPrefix(logged[0], "Resuming download") {
+		t.Errorf("Expected the download to resume, got %v", logged)
+	}
+	if transport.ranges != 1 || transport.fromStart != 1 {
+		t.Errorf("Expected only the rest of the file to be fetched again, got %d ranges and %d full downloads", transport.ranges, transport.fromStart)
+	}
+	got, err := os.ReadFile(part.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Expected the resumed download to match, got %d of %d bytes", len(got), len(content))
+	}
+}
+
+// checkpointFile keeps the checkpoints of a partial file in memory.
+type checkpointFile struct {
+	*os.File
+	saved []byte
+}
+
+func (f *checkpointFile) LoadCheckpoint() ([]byte, error) { return f.saved, nil }
+
+func (f *checkpointFile) SaveCheckpoint(data []byte) error {
+	f.saved = data
+	return nil
+}
+
+// cuttingTransport counts file downloads and, while cutAfter is set, cuts
+// them off after that many bytes.
+type cuttingTransport struct {
+	cutAfter  int64
+	ranges    int
+	fromStart int
+}
+
+func (t *cuttingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil || !strings.HasSuffix(req.URL.Path, "/files/stream") {
+		return resp, err
+	}
+	if req.Header.Get("Range") != "" {
+		t.ranges++
+	} else {
+		t.fromStart++
+	}
+	if t.cutAfter > 0 {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(io.LimitReader(resp.Body, t.cutAfter), eofReader{}), resp.Body}
+	}
+	return resp, nil
+}
+
+// eofReader fails as a response body cut short does.
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.ErrUnexpectedEOF }
+
+func TestDownloadChecksSender(t *testing.T) {
+	ctx := context.Background()
+	url := newTestServer(t)
+	alice, _ := newTestClient(t, url, "alice")
+	bob, bobUser := newTestClient(t, url, "bob")
+
+	sent, err := alice.Send(ctx, []User{bobUser}, strings.NewReader("signed"), SendOptions{})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	// A sender whose keys do not match the signature is refused
+	bob.VerifySender = func(ctx context.Context, meta FileMetadata, ciphertextHash []byte) error {
+		other, _ := crypto.GenerateIdentityKeyPair()
+		if !VerifyManifest(other.Public, meta, ciphertextHash) {
+			return ErrBadSignature
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	if _, err := bob.Download(ctx, sent[0].ID, &buf); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected a bad signature, got %v", err)
+	}
+
+	// By default, the sender's keys on the server are used
+	bob.VerifySender = nil
+	buf.Reset()
+	if _, err := bob.Download(ctx, sent[0].ID, &buf); err != nil || buf.String() != "signed" {
+		t.Errorf("Expected the signature to verify, got %q (%v)", buf.String(), err)
+	}
+}
@@ -0,0 +1,484 @@
+package gosend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/transport"
+)
+
+// Download describes a file fetched by Client.Download or ResumeDownload.
+type Download struct {
+	Metadata FileMetadata
+	// Info is opened from the file's sealed envelope. Files sent before
+	// metadata was encrypted only have a name.
+	Info FileInfo
+}
+
+// Partial holds the plaintext of a download that was interrupted, such as
+// an *os.File.
+type Partial interface {
+	io.WriteSeeker
+	Truncate(size int64) error
+}
+
+// Checkpointer is implemented by a Partial that can also keep the progress
+// of its download, such as a file next to the partial file. The signed hash
+// covers the whole ciphertext, so a download can only resume where a
+// checkpoint was saved; without one, an interrupted download starts over.
+type Checkpointer interface {
+	// LoadCheckpoint returns the last checkpoint saved, or nil if there is
+	// none.
+	LoadCheckpoint() ([]byte, error)
+	SaveCheckpoint(data []byte) error
+}
+
+// Download downloads, decrypts and writes a file to w, then checks the
+// sender's signature. Each chunk is authenticated before it is written, but
+// the signature can only be checked at the end: on error, discard what was
+// written.
+func (c *Client) Download(ctx context.Context, id string, w io.Writer) (*Download, error) {
+	return c.download(ctx, id, w, nil, nil)
+}
+
+// ResumeDownload is like Download, but writes to part, which may hold
+// plaintext written by an earlier ResumeDownload of the same file that
+// failed with ErrInterrupted. If part is a Checkpointer, the download saves
+// its progress as it goes and resumes at the last checkpoint, using a Range
+// request. If check is not nil, it is called before any content is written,
+// and the download is abandoned if it returns an error.
+func (c *Client) ResumeDownload(ctx context.Context, id string, part Partial, check func(*Download) error) (*Download, error) {
+	return c.download(ctx, id, part, part, check)
+}
+
+func (c *Client) download(ctx context.Context, id string, w io.Writer, part Partial, check func(*Download) error) (*Download, error) {
+	p := &progress{hash: sha256.New(), logf: c.logf, states: map[uint64][]byte{}}
+	var resumeAt uint64
+	if part != nil {
+		size, err := part.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if cp, ok := part.(Checkpointer); ok {
+			p.to = cp
+			resumeAt = p.resume(size)
+		}
+	}
+
+	rangeHeader := ""
+	if resumeAt > 0 {
+		rangeHeader = fmt.Sprintf("bytes=%d-", p.header.ChunkOffset(resumeAt))
+	}
+	resp, meta, err := c.fetchFile(ctx, id, rangeHeader)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusPartialContent && resumeAt > 0 {
+		p.restart()
+		resumeAt = 0
+	}
+
+	keys, err := c.contentKeys(meta)
+	if err != nil {
+		return nil, err
+	}
+	info, keys, err := openInfo(meta, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt metadata: %w", err)
+	}
+	d := &Download{Metadata: meta, Info: info}
+	if check != nil {
+		if err := check(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if part != nil {
+		if err := part.Truncate(p.written); err != nil {
+			return nil, err
+		}
+		if _, err := part.Seek(p.written, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if resumeAt > 0 {
+			c.logf("Resuming download at %d bytes.", p.written)
+		}
+	}
+
+	content := io.TeeReader(cutShort{resp.Body}, ciphertextWriter{p})
+	err = decryptInto(plaintextWriter{w, p}, content, keys, p.header, resumeAt)
+	switch {
+	case err == nil:
+		// The whole file has arrived, so a sender that cannot be checked is
+		// not an interrupted download
+		if err := c.verifySender(ctx, meta, p.hash.Sum(nil)); err != nil {
+			return nil, err
+		}
+		return d, nil
+	case errors.Is(err, crypto.ErrStreamAuth) || errors.Is(err, crypto.ErrInvalidStream):
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrInterrupted, err)
+	}
+}
+
+// errCutShort is returned when a response ends before its Content-Length.
+var errCutShort = errors.New("connection closed before the end of the file")
+
+// cutShort reports a response body that ends early as an error of its own,
+// so that a dropped connection is not mistaken for the end of the stream.
+type cutShort struct{ io.Reader }
+
+func (r cutShort) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = errCutShort
+	}
+	return n, err
+}
+
+// checkpoint is the saved progress of a download: the state of the
+// ciphertext hash at the start of a chunk, all of whose plaintext was
+// written. The download resumes by fetching that chunk again, in case it
+// was the final one, whose flag must be checked.
+type checkpoint struct {
+	Chunk  uint64 `json:"chunk"`
+	Header []byte `json:"header"`
+	Hash   []byte `json:"hash"`
+}
+
+// progress hashes the ciphertext of a download as it arrives and, when it
+// has somewhere to save them, checkpoints the hash at chunk boundaries once
+// the chunk's plaintext is written.
+type progress struct {
+	to   Checkpointer
+	logf func(format string, args ...any)
+	hash hash.Hash
+
+	// header is the header of a streaming download once it is known, and
+	// zero until then or for the legacy format
+	header  crypto.StreamHeader
+	prefix  []byte
+	hashed  int64
+	written int64
+
+	// states holds the hash state at the start of each chunk from next
+	// onwards that has not yet been saved
+	states map[uint64][]byte
+	next   uint64
+	saved  uint64
+}
+
+// resume restores the checkpoint of an earlier download into a partial
+// file of the given size, and returns the chunk to resume at, or 0 if the
+// download has to start over.
+func (p *progress) resume(size int64) uint64 {
+	data, err := p.to.LoadCheckpoint()
+	if err != nil || data == nil {
+		return 0
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil || cp.Chunk == 0 {
+		return 0
+	}
+	header, err := crypto.ParseStreamHeader(cp.Header)
+	if err != nil || size < int64(cp.Chunk)*int64(header.ChunkSize) {
+		return 0
+	}
+	if err := p.hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(cp.Hash); err != nil {
+		p.hash.Reset()
+		return 0
+	}
+	p.header = header
+	p.hashed = header.ChunkOffset(cp.Chunk)
+	p.written = int64(cp.Chunk) * int64(header.ChunkSize)
+	p.next, p.saved = cp.Chunk+1, cp.Chunk
+	return cp.Chunk
+}
+
+// restart forgets a restored checkpoint.
+func (p *progress) restart() {
+	p.hash.Reset()
+	p.header = crypto.StreamHeader{}
+	p.prefix = nil
+	p.hashed, p.written = 0, 0
+	p.next, p.saved = 0, 0
+	clear(p.states)
+}
+
+// ciphertextWriter hashes the ciphertext of a download, keeping the state
+// of the hash at each chunk boundary.
+type ciphertextWriter struct{ *progress }
+
+func (w ciphertextWriter) Write(b []byte) (int, error) {
+	p, n := w.progress, len(b)
+	for len(b) > 0 {
+		step := len(b)
+		switch {
+		case p.to == nil:
+		case p.header.ChunkSize == 0:
+			step = min(step, crypto.StreamHeaderSize-len(p.prefix))
+			p.prefix = append(p.prefix, b[:step]...)
+		default:
+			step = int(min(int64(step), p.header.ChunkOffset(p.next)-p.hashed))
+		}
+		_, _ = p.hash.Write(b[:step])
+		p.hashed += int64(step)
+		b = b[step:]
+
+		switch {
+		case p.to == nil:
+		case p.header.ChunkSize == 0:
+			if len(p.prefix) < crypto.StreamHeaderSize {
+				break
+			}
+			header, err := crypto.ParseStreamHeader(p.prefix)
+			if err != nil {
+				// Legacy files are not chunked and cannot resume
+				p.to = nil
+				break
+			}
+			p.header, p.next = header, 1
+		case p.hashed == p.header.ChunkOffset(p.next):
+			if state, err := p.hash.(encoding.BinaryMarshaler).MarshalBinary(); err == nil {
+				p.states[p.next] = state
+			}
+			p.next++
+		}
+	}
+	return n, nil
+}
+
+// plaintextWriter writes the plaintext of a download to w, saving a
+// checkpoint whenever another whole chunk has been written.
+type plaintextWriter struct {
+	w io.Writer
+	*progress
+}
+
+func (w plaintextWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	p := w.progress
+	p.written += int64(n)
+	if p.to == nil || p.header.ChunkSize == 0 {
+		return n, err
+	}
+
+	chunks := uint64(p.written / int64(p.header.ChunkSize))
+	if chunks < 2 || chunks-1 <= p.saved {
+		return n, err
+	}
+	chunk := chunks - 1
+	state, ok := p.states[chunk]
+	if !ok {
+		return n, err
+	}
+	data, _ := json.Marshal(checkpoint{Chunk: chunk, Header: p.header.Bytes(), Hash: state})
+	if serr := p.to.SaveCheckpoint(data); serr != nil {
+		p.logf("Cannot save download progress: %v", serr)
+		p.to = nil
+		return n, err
+	}
+	p.saved = chunk
+	for k := range p.states {
+		if k <= chunk {
+			delete(p.states, k)
+		}
+	}
+	return n, err
+}
+
+// fetchFile requests a file from the streaming endpoint, with an optional
+// Range header, and decodes its metadata.
+func (c *Client) fetchFile(ctx context.Context, id, rangeHeader string) (*http.Response, FileMetadata, error) {
+	req, err := c.request(ctx, "GET", "/files/stream?id="+url.QueryEscape(id), nil)
+	if err != nil {
+		return nil, FileMetadata{}, err
+	}
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, FileMetadata{}, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		err := responseError(resp)
+		_ = resp.Body.Close()
+		return nil, FileMetadata{}, err
+	}
+
+	meta, err := transport.DecodeMetadata(resp.Header.Get(transport.MetadataHeader))
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, FileMetadata{}, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	return resp, meta, nil
+}
+
+// contentKeys recovers the keys a file's content and envelope may be
+// encrypted with, using the client user's keys. Files sent to several
+// recipients carry a random content key wrapped to each of them. Older
+// files are encrypted to the recipient directly, with whichever exchange
+// key they had at the time, so there is one candidate per key.
+func (c *Client) contentKeys(meta FileMetadata) ([]*[32]byte, error) {
+	if len(meta.EncryptedKey) != 32 {
+		return nil, fmt.Errorf("invalid ephemeral public key length in metadata")
+	}
+	var senderPub [32]byte
+	copy(senderPub[:], meta.EncryptedKey)
+	if len(meta.WrappedKey) == 0 {
+		keys, err := c.Keys.StreamKeys(c.Username, &senderPub)
+		if err == nil && len(keys) == 0 {
+			err = fmt.Errorf("exchange private key not found for user %s", c.Username)
+		}
+		return keys, err
+	}
+	key, err := c.Keys.UnwrapKey(c.Username, meta.WrappedKey, &senderPub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap content key: %w", err)
+	}
+	return []*[32]byte{key}, nil
+}
+
+// FileInfo opens the sealed envelope of a listed file with the client
+// user's keys.
+func (c *Client) FileInfo(meta FileMetadata) (FileInfo, error) {
+	if len(meta.EncryptedMetadata) == 0 {
+		return FileInfo{Name: meta.FileName}, nil
+	}
+	keys, err := c.contentKeys(meta)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	info, _, err := openInfo(meta, keys)
+	return info, err
+}
+
+// openInfo returns the metadata of a file, opening its sealed envelope with
+// the first of keys that opens it, and the keys its content may still be
+// encrypted with. Files sent before metadata was encrypted only carry a
+// plaintext name.
+func openInfo(meta FileMetadata, keys []*[32]byte) (FileInfo, []*[32]byte, error) {
+	if len(meta.EncryptedMetadata) == 0 {
+		return FileInfo{Name: meta.FileName}, keys, nil
+	}
+	var err error
+	for _, key := range keys {
+		var info FileInfo
+		if info, err = crypto.OpenFileInfo(meta.EncryptedMetadata, key); err == nil {
+			return info, []*[32]byte{key}, nil
+		}
+	}
+	return FileInfo{}, nil, err
+}
+
+// verifySender checks the sender's signature on a downloaded file with
+// the client's VerifySender, or against the sender's keys on the server.
+func (c *Client) verifySender(ctx context.Context, meta FileMetadata, ciphertextHash []byte) error {
+	if c.VerifySender != nil {
+		return c.VerifySender(ctx, meta, ciphertextHash)
+	}
+	if len(meta.Signature) == 0 {
+		return fmt.Errorf("%w: file is not signed", ErrBadSignature)
+	}
+	sender, err := c.LookupUser(ctx, meta.Sender)
+	if err != nil {
+		return fmt.Errorf("cannot verify sender '%s': %w", meta.Sender, err)
+	}
+	if !VerifyManifest(sender.IdentityPublicKey, meta, ciphertextHash) {
+		return fmt.Errorf("%w: file claims to be from '%s'", ErrBadSignature, meta.Sender)
+	}
+	return nil
+}
+
+// VerifyManifest reports whether the signature on a file is a signature
+// by identityKey over its transfer manifest.
+func VerifyManifest(identityKey ed25519.PublicKey, meta FileMetadata, ciphertextHash []byte) bool {
+	return crypto.VerifyManifest(identityKey, crypto.Manifest{
+		Sender:         meta.Sender,
+		Recipient:      meta.Recipient,
+		Metadata:       meta.EncryptedMetadata,
+		EncryptedKey:   meta.EncryptedKey,
+		WrappedKey:     meta.WrappedKey,
+		CiphertextHash: ciphertextHash,
+		SignedAt:       meta.SignedAt,
+	}, meta.Signature)
+}
+
+// decryptInto writes the plaintext of content to dst, decrypted with
+// whichever of keys opens it. When resumeAt is non-zero, content starts at
+// that chunk of a stream with the given header. Otherwise the header is
+// read from content, and content in the legacy single-box format is still
+// accepted.
+func decryptInto(dst io.Writer, content io.Reader, keys []*[32]byte, header crypto.StreamHeader, resumeAt uint64) error {
+	// Room for the header and a whole default-sized chunk, so that the
+	// first chunk can be tried with each key
+	br := bufio.NewReaderSize(content, crypto.StreamHeaderSize+crypto.StreamChunkSize+crypto.StreamOverhead+1)
+	open := func(src io.Reader, key *[32]byte) (io.Reader, error) {
+		return crypto.NewDecryptReaderAt(src, key, header, resumeAt)
+	}
+	if resumeAt == 0 {
+		magic, _ := br.Peek(crypto.StreamHeaderSize)
+		if !crypto.IsStream(magic) {
+			return decryptLegacy(dst, br, keys)
+		}
+		open = crypto.NewDecryptReader
+	}
+
+	r, err := open(br, firstChunkKey(br, keys, open))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, r)
+	return err
+}
+
+// firstChunkKey returns the first of keys that opens the chunk at the start
+// of br, or the first key if none does.
+func firstChunkKey(br *bufio.Reader, keys []*[32]byte, open func(io.Reader, *[32]byte) (io.Reader, error)) *[32]byte {
+	if len(keys) > 1 {
+		peeked, _ := br.Peek(br.Size())
+		for _, key := range keys {
+			r, err := open(bytes.NewReader(peeked), key)
+			if err != nil {
+				continue
+			}
+			if _, err := r.Read(make([]byte, 1)); err == nil || err == io.EOF {
+				return key
+			}
+		}
+	}
+	return keys[0]
+}
+
+// decryptLegacy writes the plaintext of content in the legacy single-box
+// format to dst.
+func decryptLegacy(dst io.Writer, content io.Reader, keys []*[32]byte) error {
+	legacy, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		var decrypted []byte
+		if decrypted, err = crypto.DecryptWithSharedKey(legacy, key); err == nil {
+			_, err = dst.Write(decrypted)
+			return err
+		}
+	}
+	return fmt.Errorf("%w: %v", crypto.ErrStreamAuth, err)
+}
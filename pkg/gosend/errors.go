@@ -0,0 +1,96 @@
+package gosend
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Errors that an *Error from the server matches with errors.Is, by status
+// code.
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrRateLimited  = errors.New("too many requests")
+)
+
+// Errors returned by the client.
+var (
+	// ErrSessionEnded is returned when the server no longer accepts the
+	// session's refresh token, because it expired or was revoked.
+	ErrSessionEnded = errors.New("session has ended; log in again")
+	// ErrBadSignature is returned for downloads whose manifest signature
+	// does not verify against the sender's identity key.
+	ErrBadSignature = errors.New("sender signature does not verify")
+	// ErrCorrupt is returned for downloads whose content fails
+	// authentication, so that nothing after the failure can be trusted.
+	ErrCorrupt = errors.New("file content does not authenticate")
+	// ErrInterrupted is returned for downloads that failed in a way that
+	// ResumeDownload can recover from.
+	ErrInterrupted = errors.New("download interrupted")
+)
+
+// Error is an error response from the server.
+type Error struct {
+	StatusCode int
+	// Code is the machine-readable error code, such as "not_found". It is
+	// empty in plain text responses.
+	Code    string
+	Message string
+	// RequestID names the request in the server's logs.
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return "server returned error: " + http.StatusText(e.StatusCode)
+	}
+	return "server returned error: " + e.Message
+}
+
+// Is matches the error for the response's status code.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// responseError returns an *Error for resp, from its JSON error body or its
+// plain text. It reads but does not close the body.
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	e := &Error{StatusCode: resp.StatusCode, RequestID: resp.Header.Get("X-Request-ID")}
+
+	var envelope struct {
+		Error struct {
+			Code      string `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+		} `json:"error"`
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/json" && json.Unmarshal(body, &envelope) == nil && envelope.Error.Code != "" {
+		e.Code, e.Message = envelope.Error.Code, envelope.Error.Message
+		if envelope.Error.RequestID != "" {
+			e.RequestID = envelope.Error.RequestID
+		}
+		return e
+	}
+	e.Message = strings.TrimSpace(string(body))
+	return e
+}
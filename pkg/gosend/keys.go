@@ -0,0 +1,48 @@
+package gosend
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+)
+
+// Keys performs the operations that need a user's private keys, so that
+// they can be kept elsewhere, such as in an agent.
+type Keys interface {
+	// Sign signs message with the user's Ed25519 identity key.
+	Sign(username string, message []byte) ([]byte, error)
+	// StreamKeys derives the keys shared by peerPub and each of the user's
+	// X25519 exchange keys, current first, which files from older clients
+	// are encrypted with. A file may predate a change of the user's keys.
+	StreamKeys(username string, peerPub *[32]byte) ([]*[32]byte, error)
+	// UnwrapKey recovers a content key wrapped to the user's exchange key.
+	UnwrapKey(username string, wrapped []byte, ephemeralPub *[32]byte) (*[32]byte, error)
+}
+
+// PrivateKeys holds one user's private keys in memory.
+type PrivateKeys struct {
+	Identity ed25519.PrivateKey
+	Exchange *[32]byte // X25519 private key
+}
+
+func (k PrivateKeys) Sign(username string, message []byte) ([]byte, error) {
+	if len(k.Identity) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("identity private key not found for user %s", username)
+	}
+	return crypto.Sign(k.Identity, message), nil
+}
+
+func (k PrivateKeys) StreamKeys(username string, peerPub *[32]byte) ([]*[32]byte, error) {
+	if k.Exchange == nil {
+		return nil, fmt.Errorf("exchange private key not found for user %s", username)
+	}
+	return []*[32]byte{crypto.StreamKey(peerPub, k.Exchange)}, nil
+}
+
+func (k PrivateKeys) UnwrapKey(username string, wrapped []byte, ephemeralPub *[32]byte) (*[32]byte, error) {
+	if k.Exchange == nil {
+		return nil, fmt.Errorf("exchange private key not found for user %s", username)
+	}
+	return crypto.UnwrapKey(wrapped, ephemeralPub, k.Exchange)
+}
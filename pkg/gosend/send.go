@@ -0,0 +1,399 @@
+package gosend
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VinMeld/go-send/internal/crypto"
+	"github.com/VinMeld/go-send/internal/models"
+)
+
+const (
+	// uploadChunkSize is the chunk size requested for upload sessions.
+	uploadChunkSize = 4 * 1024 * 1024
+	// chunkAttempts is how many times a chunk upload is tried before the
+	// upload is abandoned for a later resume.
+	chunkAttempts = 3
+	// defaultRetryDelay is used when Client.RetryDelay is zero.
+	defaultRetryDelay = time.Second
+)
+
+// SendOptions describe a file being sent.
+type SendOptions struct {
+	// Info is sealed with the content key in an envelope that only the
+	// sender and recipients can open; the server never sees it.
+	Info FileInfo
+	// ExpiresAt asks the server to delete the file earlier than it would.
+	ExpiresAt time.Time
+	// MaxDownloads is how many acknowledged downloads are allowed before
+	// the file is deleted; zero means no limit.
+	MaxDownloads int
+	AutoDelete   bool // Same as a MaxDownloads of 1
+}
+
+// PendingUpload records an unfinished upload session so that sending the
+// same content to the same recipients again resumes it. The stream header
+// and the seed of the content key are kept so the ciphertext can be
+// reproduced byte for byte; the key itself is derived from the seed with the
+// sender's identity key, and never stored. Entries without Recipients or a
+// KeySeed predate this and are started over.
+type PendingUpload struct {
+	SessionID    string         `json:"session_id"`
+	Recipients   []RecipientKey `json:"recipients"`
+	KeySeed      []byte         `json:"key_seed"`
+	StreamHeader []byte         `json:"stream_header"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+// UploadStore keeps pending uploads by a key naming the sender, the
+// recipients and the content.
+type UploadStore interface {
+	LoadUpload(key string) (PendingUpload, bool)
+	SaveUpload(key string, upload PendingUpload) error
+	DeleteUpload(key string) error
+}
+
+// pendingUploadKey identifies an upload by the sender, the recipients and a
+// hash of the plaintext.
+func pendingUploadKey(sender string, recipients []User, digest []byte) string {
+	names := make([]string, len(recipients))
+	for i, u := range recipients {
+		names[i] = u.Username
+	}
+	slices.Sort(names)
+	return sender + ":" + strings.Join(names, ",") + ":" + hex.EncodeToString(digest)
+}
+
+// Send encrypts the content of r once under a random content key and
+// uploads it through an upload session. Each recipient gets the content key
+// and opts.Info wrapped to their exchange key, and a transfer manifest
+// signed with the client user's identity key. It returns the file created
+// for each recipient.
+//
+// The recipients' keys are used as given, so callers should check them
+// first. If the client has an UploadStore and r is an io.Seeker, a failed
+// upload is kept there, and sending the same content to the same recipients
+// again resumes it.
+func (c *Client) Send(ctx context.Context, recipients []User, r io.Reader, opts SendOptions) ([]FileMetadata, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients")
+	}
+	meta := FileMetadata{
+		Sender:       c.Username,
+		AutoDelete:   opts.AutoDelete,
+		MaxDownloads: opts.MaxDownloads,
+		ExpiresAt:    opts.ExpiresAt,
+	}
+
+	var key string
+	seeker, resumable := r.(io.Seeker)
+	resumable = resumable && c.Uploads != nil
+	if resumable {
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		key = pendingUploadKey(c.Username, recipients, h.Sum(nil))
+	}
+
+	var (
+		session   *UploadSession
+		pending   PendingUpload
+		streamKey *[32]byte
+		ok        bool
+	)
+	if resumable {
+		pending, ok = c.Uploads.LoadUpload(key)
+		if ok && (len(pending.Recipients) == 0 || len(pending.KeySeed) == 0) {
+			ok = false
+		}
+	}
+	if ok {
+		var err error
+		streamKey, err = c.uploadKey(pending)
+		if err == nil {
+			session, err = c.uploadSession(ctx, pending.SessionID)
+		}
+		if err != nil {
+			c.logf("Previous upload could not be resumed, starting over.")
+			ok = false
+		} else {
+			c.logf("Resuming upload: %d chunk(s) already on server.", len(session.Chunks))
+		}
+	}
+
+	if !ok {
+		seed, err := crypto.GenerateSymmetricKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate content key: %w", err)
+		}
+		header, err := crypto.NewStreamHeader()
+		if err != nil {
+			return nil, err
+		}
+		pending = PendingUpload{KeySeed: seed, StreamHeader: header.Bytes()}
+		if streamKey, err = c.uploadKey(pending); err != nil {
+			return nil, err
+		}
+		keys, err := wrapForRecipients(streamKey, opts.Info, recipients)
+		if err != nil {
+			return nil, err
+		}
+
+		session, err = c.createUploadSession(ctx, models.CreateUploadRequest{
+			Metadata:   meta,
+			Recipients: keys,
+			ChunkSize:  uploadChunkSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		pending.SessionID, pending.Recipients, pending.CreatedAt = session.ID, keys, time.Now()
+		if resumable {
+			if err := c.Uploads.SaveUpload(key, pending); err != nil {
+				c.logf("Warning: Failed to save upload state, it will not be resumable: %v", err)
+			}
+		}
+	}
+
+	header, err := crypto.ParseStreamHeader(pending.StreamHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	uploader := newChunkUploader(session, c.retryDelay(), func(index int64, data []byte) error {
+		return c.putUploadChunk(ctx, session.ID, index, data)
+	})
+	ciphertextHash := sha256.New()
+	enc, err := crypto.NewEncryptWriterWithHeader(io.MultiWriter(uploader, ciphertextHash), streamKey, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(enc, r); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	if err := uploader.Close(); err != nil {
+		return nil, err
+	}
+
+	signedAt := time.Now()
+	digest := ciphertextHash.Sum(nil)
+	signatures := make(map[string][]byte, len(pending.Recipients))
+	for _, rk := range pending.Recipients {
+		manifest := crypto.Manifest{
+			Sender:         c.Username,
+			Recipient:      rk.Recipient,
+			Metadata:       rk.EncryptedMetadata,
+			EncryptedKey:   rk.EncryptedKey,
+			WrappedKey:     rk.WrappedKey,
+			CiphertextHash: digest,
+			SignedAt:       signedAt,
+		}
+		signature, err := c.Keys.Sign(c.Username, manifest.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign manifest: %w", err)
+		}
+		signatures[rk.Recipient] = signature
+	}
+
+	created, err := c.completeUploadSession(ctx, session.ID, models.CompleteUploadRequest{
+		SignedAt:   signedAt,
+		Signatures: signatures,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resumable {
+		if err := c.Uploads.DeleteUpload(key); err != nil {
+			c.logf("Warning: Failed to forget finished upload: %v", err)
+		}
+	}
+	return created, nil
+}
+
+// uploadKey derives the content key of an upload from its seed by signing
+// it. When resuming, the key is checked against the envelope sealed with it,
+// in case the client's keys have changed since.
+func (c *Client) uploadKey(pending PendingUpload) (*[32]byte, error) {
+	signature, err := c.Keys.Sign(c.Username, crypto.UploadKeyMessage(pending.KeySeed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive content key: %w", err)
+	}
+	key := crypto.UploadKey(signature)
+	if len(pending.Recipients) > 0 {
+		if _, err := crypto.OpenFileInfo(pending.Recipients[0].EncryptedMetadata, key); err != nil {
+			return nil, fmt.Errorf("content key does not match the upload: %w", err)
+		}
+	}
+	return key, nil
+}
+
+func (c *Client) retryDelay() time.Duration {
+	if c.RetryDelay > 0 {
+		return c.RetryDelay
+	}
+	return defaultRetryDelay
+}
+
+// wrapForRecipients seals the content key and the file info to each
+// recipient. The envelope is sealed once with the content key and shared.
+func wrapForRecipients(contentKey *[32]byte, info FileInfo, recipients []User) ([]RecipientKey, error) {
+	envelope, err := crypto.SealFileInfo(info, contentKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt metadata: %w", err)
+	}
+	keys := make([]RecipientKey, 0, len(recipients))
+	for _, u := range recipients {
+		if len(u.ExchangePublicKey) != 32 {
+			return nil, fmt.Errorf("invalid exchange public key for user %s", u.Username)
+		}
+		var pub [32]byte
+		copy(pub[:], u.ExchangePublicKey)
+		ephemeralPub, wrapped, err := crypto.WrapKey(contentKey[:], &pub)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap key for %s: %w", u.Username, err)
+		}
+		keys = append(keys, RecipientKey{
+			Recipient:         u.Username,
+			EncryptedKey:      ephemeralPub[:],
+			WrappedKey:        wrapped,
+			EncryptedMetadata: envelope,
+		})
+	}
+	return keys, nil
+}
+
+// chunkUploader cuts a byte stream into session-sized chunks and uploads
+// each one the server does not already have.
+type chunkUploader struct {
+	chunkSize  int
+	have       map[int64]int64 // chunk index -> size already on the server
+	upload     func(index int64, data []byte) error
+	retryDelay time.Duration
+	buf        []byte
+	index      int64
+}
+
+func newChunkUploader(session *UploadSession, retryDelay time.Duration, upload func(int64, []byte) error) *chunkUploader {
+	have := make(map[int64]int64, len(session.Chunks))
+	for _, c := range session.Chunks {
+		have[c.Index] = c.Size
+	}
+	return &chunkUploader{
+		chunkSize:  int(session.ChunkSize),
+		have:       have,
+		upload:     upload,
+		retryDelay: retryDelay,
+		buf:        make([]byte, 0, session.ChunkSize),
+	}
+}
+
+func (u *chunkUploader) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(u.buf) == u.chunkSize {
+			if err := u.flush(); err != nil {
+				return written, err
+			}
+		}
+		n := min(len(p), u.chunkSize-len(u.buf))
+		u.buf = append(u.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close uploads the final, possibly short, chunk.
+func (u *chunkUploader) Close() error {
+	if len(u.buf) == 0 {
+		return nil
+	}
+	return u.flush()
+}
+
+func (u *chunkUploader) flush() error {
+	if size, ok := u.have[u.index]; !ok || size != int64(len(u.buf)) {
+		var err error
+		for attempt := 1; attempt <= chunkAttempts; attempt++ {
+			if err = u.upload(u.index, u.buf); err == nil {
+				break
+			}
+			if attempt < chunkAttempts {
+				time.Sleep(u.retryDelay * time.Duration(attempt))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("chunk %d: %w", u.index, err)
+		}
+	}
+	u.index++
+	u.buf = u.buf[:0]
+	return nil
+}
+
+func (c *Client) createUploadSession(ctx context.Context, req models.CreateUploadRequest) (*UploadSession, error) {
+	resp, err := c.sendJSON(ctx, "POST", "/uploads", req)
+	if err != nil {
+		return nil, err
+	}
+	var session UploadSession
+	if err := decodeResponse(resp, http.StatusCreated, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (c *Client) uploadSession(ctx context.Context, id string) (*UploadSession, error) {
+	resp, err := c.sendJSON(ctx, "GET", "/uploads?id="+url.QueryEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	var session UploadSession
+	if err := decodeResponse(resp, http.StatusOK, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (c *Client) putUploadChunk(ctx context.Context, id string, index int64, data []byte) error {
+	path := "/uploads/chunk?id=" + url.QueryEscape(id) + "&index=" + strconv.FormatInt(index, 10)
+	req, err := c.request(ctx, "PUT", path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, http.StatusOK, nil)
+}
+
+func (c *Client) completeUploadSession(ctx context.Context, id string, req models.CompleteUploadRequest) ([]FileMetadata, error) {
+	resp, err := c.sendJSON(ctx, "POST", "/uploads/complete?id="+url.QueryEscape(id), req)
+	if err != nil {
+		return nil, err
+	}
+	var files []FileMetadata
+	if err := decodeResponse(resp, http.StatusCreated, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
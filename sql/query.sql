@@ -7,8 +7,8 @@ SELECT * FROM users
 WHERE username = ? LIMIT 1;
 
 -- name: CreateFile :exec
-INSERT INTO files (id, sender, recipient, file_name, encrypted_key, auto_delete, timestamp)
-VALUES (?, ?, ?, ?, ?, ?, ?);
+INSERT INTO files (id, sender, recipient, file_name, encrypted_key, auto_delete, timestamp, signature, signed_at, encrypted_metadata, blob_id, wrapped_key, expires_at, downloads_remaining)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
 
 -- name: GetFile :one
 SELECT * FROM files
@@ -23,17 +23,46 @@ ORDER BY timestamp DESC;
 DELETE FROM files
 WHERE id = ?;
 
+-- name: ListExpiredFiles :many
+SELECT id FROM files
+WHERE expires_at < ? OR downloads_remaining = 0;
+
+-- name: DecrementDownloads :one
+UPDATE files SET downloads_remaining = downloads_remaining - 1
+WHERE id = ? AND downloads_remaining > 0
+RETURNING downloads_remaining;
+
+-- name: CountFilesByBlob :one
+SELECT COUNT(*) FROM files
+WHERE blob_id = ?;
+
 -- name: CreateSession :exec
-INSERT INTO sessions (token, username, expires_at)
-VALUES (?, ?, ?);
+INSERT INTO sessions (refresh_hash, id, username, device, expires_at, last_used_at)
+VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: RefreshSession :one
+UPDATE sessions
+SET refresh_hash = sqlc.arg(new_refresh_hash), expires_at = ?, last_used_at = ?
+WHERE refresh_hash = sqlc.arg(refresh_hash) AND expires_at > sqlc.arg(now)
+  AND EXISTS (SELECT 1 FROM users WHERE users.username = sessions.username)
+RETURNING *;
 
--- name: GetSession :one
+-- name: DeleteUserSessions :exec
+DELETE FROM sessions
+WHERE username = ?;
+
+-- name: ListUserSessions :many
 SELECT * FROM sessions
-WHERE token = ? LIMIT 1;
+WHERE username = ?
+ORDER BY created_at;
+
+-- name: DeleteUserSession :execrows
+DELETE FROM sessions
+WHERE username = ? AND id = ?;
 
--- name: DeleteSession :exec
+-- name: DeleteExpiredSessions :execrows
 DELETE FROM sessions
-WHERE token = ?;
+WHERE expires_at < ?;
 
 -- name: ListAllUsers :many
 SELECT username, identity_public_key, exchange_public_key
@@ -44,15 +73,106 @@ ORDER BY username;
 DELETE FROM users
 WHERE username = ?;
 
+-- name: UpdateUserKeys :exec
+UPDATE users SET identity_public_key = ?, exchange_public_key = ?
+WHERE username = ?;
+
+-- name: CreateKeyRotation :exec
+INSERT INTO key_rotations (username, version, old_identity_public_key, identity_public_key, exchange_public_key, rotated_at, signature)
+VALUES (?, ?, ?, ?, ?, ?, ?);
+
+-- name: ListKeyRotations :many
+SELECT * FROM key_rotations
+WHERE username = ?
+ORDER BY version;
+
+-- name: DeleteKeyRotations :exec
+DELETE FROM key_rotations
+WHERE username = ?;
+
 -- name: CreateChallenge :exec
-INSERT INTO challenges (username, nonce)
-VALUES (?, ?)
-ON CONFLICT(username) DO UPDATE SET nonce = excluded.nonce;
+INSERT INTO challenges (username, nonce, expires_at)
+VALUES (?, ?, ?)
+ON CONFLICT(username) DO UPDATE SET nonce = excluded.nonce, expires_at = excluded.expires_at;
 
--- name: GetChallenge :one
-SELECT nonce FROM challenges
-WHERE username = ? LIMIT 1;
+-- name: TakeChallenge :one
+DELETE FROM challenges
+WHERE username = ? AND nonce = ?
+RETURNING expires_at;
 
 -- name: DeleteChallenge :exec
 DELETE FROM challenges
 WHERE username = ?;
+
+-- name: DeleteExpiredChallenges :execrows
+DELETE FROM challenges
+WHERE expires_at IS NULL OR expires_at < ?;
+
+-- name: CreateUploadSession :exec
+INSERT INTO upload_sessions (id, sender, metadata, chunk_size, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: GetUploadSession :one
+SELECT * FROM upload_sessions
+WHERE id = ? LIMIT 1;
+
+-- name: TouchUploadSession :exec
+UPDATE upload_sessions SET updated_at = ?
+WHERE id = ?;
+
+-- name: DeleteUploadSession :execrows
+DELETE FROM upload_sessions
+WHERE id = ?;
+
+-- name: ListStaleUploadSessions :many
+SELECT id FROM upload_sessions
+WHERE updated_at < ?;
+
+-- name: UpsertUploadChunk :exec
+INSERT INTO upload_chunks (session_id, idx, size)
+VALUES (?, ?, ?)
+ON CONFLICT(session_id, idx) DO UPDATE SET size = excluded.size;
+
+-- name: ListUploadChunks :many
+SELECT idx, size FROM upload_chunks
+WHERE session_id = ?
+ORDER BY idx;
+
+-- name: DeleteUploadChunks :exec
+DELETE FROM upload_chunks
+WHERE session_id = ?;
+
+-- name: CreateCompletedUpload :exec
+INSERT INTO completed_uploads (upload_id, file_id, sender, completed_at)
+VALUES (?, ?, ?, ?);
+
+-- name: ListCompletedUploadFiles :many
+SELECT files.* FROM files
+JOIN completed_uploads ON completed_uploads.file_id = files.id
+WHERE completed_uploads.upload_id = ? AND completed_uploads.sender = ?
+ORDER BY completed_uploads.rowid;
+
+-- name: DeleteStaleCompletedUploads :execrows
+DELETE FROM completed_uploads
+WHERE completed_at < ?;
+
+-- name: AppendKeyLogEntry :exec
+INSERT INTO key_log (idx, username, version, identity_public_key, exchange_public_key, leaf_hash)
+VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: CountKeyLogEntries :one
+SELECT COUNT(*) FROM key_log;
+
+-- name: ListKeyLogHashes :many
+SELECT leaf_hash FROM key_log
+ORDER BY idx;
+
+-- name: GetLatestKeyLogEntry :one
+SELECT * FROM key_log
+WHERE username = ?
+ORDER BY idx DESC LIMIT 1;
+
+-- name: ListUsersMissingFromKeyLog :many
+SELECT username, identity_public_key, exchange_public_key FROM users
+WHERE username NOT IN (SELECT username FROM key_log)
+ORDER BY username;
@@ -13,21 +13,84 @@ CREATE TABLE files (
     encrypted_key BLOB NOT NULL,
     auto_delete BOOLEAN NOT NULL DEFAULT 0,
     timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    signature BLOB,
+    signed_at DATETIME,
+    encrypted_metadata BLOB,
+    blob_id TEXT NOT NULL,
+    wrapped_key BLOB,
+    expires_at DATETIME,
+    downloads_remaining INTEGER,
     FOREIGN KEY(sender) REFERENCES users(username),
     FOREIGN KEY(recipient) REFERENCES users(username)
 );
 
 CREATE TABLE sessions (
-    token TEXT PRIMARY KEY,
+    refresh_hash TEXT PRIMARY KEY,
     username TEXT NOT NULL,
     expires_at DATETIME NOT NULL,
     created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    id TEXT NOT NULL DEFAULT '',
+    device TEXT NOT NULL DEFAULT '',
+    last_used_at DATETIME,
     FOREIGN KEY(username) REFERENCES users(username)
 );
 
+CREATE UNIQUE INDEX sessions_id ON sessions(id);
+
 CREATE TABLE challenges (
     username TEXT PRIMARY KEY,
     nonce TEXT NOT NULL,
     created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME,
+    FOREIGN KEY(username) REFERENCES users(username)
+);
+
+CREATE TABLE upload_sessions (
+    id TEXT PRIMARY KEY,
+    sender TEXT NOT NULL,
+    metadata TEXT NOT NULL,
+    chunk_size INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(sender) REFERENCES users(username)
+);
+
+CREATE TABLE upload_chunks (
+    session_id TEXT NOT NULL,
+    idx INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    PRIMARY KEY(session_id, idx),
+    FOREIGN KEY(session_id) REFERENCES upload_sessions(id)
+);
+
+CREATE TABLE completed_uploads (
+    upload_id TEXT NOT NULL,
+    file_id TEXT NOT NULL,
+    sender TEXT NOT NULL,
+    completed_at DATETIME NOT NULL,
+    PRIMARY KEY(upload_id, file_id)
+);
+
+CREATE TABLE key_rotations (
+    username TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    old_identity_public_key BLOB NOT NULL,
+    identity_public_key BLOB NOT NULL,
+    exchange_public_key BLOB NOT NULL,
+    rotated_at DATETIME NOT NULL,
+    signature BLOB NOT NULL,
+    PRIMARY KEY(username, version),
     FOREIGN KEY(username) REFERENCES users(username)
 );
+
+CREATE TABLE key_log (
+    idx INTEGER PRIMARY KEY,
+    username TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    identity_public_key BLOB NOT NULL,
+    exchange_public_key BLOB NOT NULL,
+    leaf_hash BLOB NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX key_log_username ON key_log(username, idx);